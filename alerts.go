@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// webhookClient delivers price alerts; the timeout keeps a hung endpoint from
+// piling up goroutines
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// SetWebhookURL sets where target price alerts are POSTed. An empty URL
+// disables alerts.
+func (pt *PriceTracker) SetWebhookURL(webhookURL string) {
+    pt.statusMu.Lock()
+    defer pt.statusMu.Unlock()
+
+    pt.webhookURL = webhookURL
+}
+
+// SetMedianAlerts makes target price alerts compare the de-noised median
+// price with the target instead of the latest fetch, so a one-off blip below
+// the target doesn't alert. Off by default.
+func (pt *PriceTracker) SetMedianAlerts(enabled bool) {
+    pt.statusMu.Lock()
+    defer pt.statusMu.Unlock()
+
+    pt.medianAlerts = enabled
+}
+
+// checkTargetPrice sends an alert when a product's price reaches its target.
+// It only alerts on the transition from above the target to at or below it,
+// so a price that stays low doesn't trigger an alert every cycle.
+func (pt *PriceTracker) checkTargetPrice(product Product, price float64) {
+    if product.TargetPrice == nil {
+        return
+    }
+    pt.statusMu.Lock()
+    useMedian := pt.medianAlerts
+    pt.statusMu.Unlock()
+    if useMedian {
+        median, err := pt.medianPrice(product.ID)
+        if err != nil {
+            log.Printf("Failed to compute median price for %s, alerting on the latest price: %v", product.ID, err)
+        } else if median != nil {
+            price = *median
+        }
+    }
+    below := price <= *product.TargetPrice
+
+    pt.alertsMu.Lock()
+    wasBelow := pt.belowTarget[product.ID]
+    pt.belowTarget[product.ID] = below
+    pt.alertsMu.Unlock()
+
+    if !below || wasBelow {
+        return
+    }
+
+    pt.statusMu.Lock()
+    webhookURL := pt.webhookURL
+    pt.statusMu.Unlock()
+
+    log.Printf("%s reached its target price: %s <= %s", product.ID,
+        formatPrice(price, product.Currency), formatPrice(*product.TargetPrice, product.Currency))
+    if webhookURL == "" {
+        return
+    }
+
+    alert := PriceAlert{
+        Type:        "target_price",
+        ProductID:   product.ID,
+        Name:        product.Name,
+        Price:       price,
+        TargetPrice: *product.TargetPrice,
+        Currency:    product.Currency,
+        URL:         product.URL,
+    }
+    key := fmt.Sprintf("target_price:%s:%g", product.ID, *product.TargetPrice)
+    pt.notify(webhookURL, alert.Type, product.ID, key, alert)
+}
+
+// resetTargetAlert forgets a product's alert state, e.g. after its target changes
+func (pt *PriceTracker) resetTargetAlert(productID string) {
+    pt.alertsMu.Lock()
+    defer pt.alertsMu.Unlock()
+
+    delete(pt.belowTarget, productID)
+}
+
+// postWebhook POSTs a queued alert's JSON payload. The notification ID goes
+// in the X-Notification-ID header, the same on every attempt, so an endpoint
+// can drop an alert it already received before a crash stopped the tracker
+// hearing back.
+func postWebhook(webhookURL string, id int64, payload []byte) error {
+    req, err := http.NewRequest(http.MethodPost, webhookURL, bytes.NewReader(payload))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("X-Notification-ID", strconv.FormatInt(id, 10))
+
+    resp, err := webhookClient.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return fmt.Errorf("webhook returned %s", resp.Status)
+    }
+    return nil
+}
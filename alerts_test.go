@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// cyclePrices is a fetcher returning the next price in the list each cycle
+func cyclePrices(prices ...float64) PriceFetcher {
+    var next int32
+    return PriceFetcherFunc(func(ctx context.Context, product Product) (float64, error) {
+        i := atomic.AddInt32(&next, 1) - 1
+        return prices[int(i)%len(prices)], nil
+    })
+}
+
+func addTargetProduct(t *testing.T, tracker *PriceTracker, id string, target float64) {
+    t.Helper()
+    if _, err := tracker.CreateProduct(Product{ID: id, Name: "Kettle", URL: "https://shop.test/" + id, TargetPrice: &target}); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestTargetPriceAlertsOnce(t *testing.T) {
+    tracker := newTestTracker(t, cyclePrices(120, 45, 40, 60, 30))
+    tracker.SetRetryPolicy(1, 0)
+    webhookURL, received := newWebhookRecorder(t)
+    tracker.SetWebhookURL(webhookURL)
+    addTargetProduct(t, tracker, "alert-kettle", 50)
+
+    // above the target, then two cycles below it: one alert
+    for i := 0; i < 3; i++ {
+        tracker.trackProducts(context.Background(), tracker.snapshotProducts(), nil)
+    }
+    payloads := waitForPayloads(t, received, 2)
+    if len(payloads) != 1 {
+        t.Fatalf("sent %d alerts across two cycles below the target, want 1: %v", len(payloads), payloads)
+    }
+    want := map[string]interface{}{
+        "product_id":   "alert-kettle",
+        "name":         "Kettle",
+        "price":        45.0,
+        "target_price": 50.0,
+        "url":          "https://shop.test/alert-kettle",
+    }
+    for field, value := range want {
+        if payloads[0][field] != value {
+            t.Errorf("%s = %v, want %v", field, payloads[0][field], value)
+        }
+    }
+
+    // back above the target and down again: a new alert
+    for i := 0; i < 2; i++ {
+        tracker.trackProducts(context.Background(), tracker.snapshotProducts(), nil)
+    }
+    payloads = waitForPayloads(t, received, 2)
+    if len(payloads) != 2 || payloads[1]["price"] != 30.0 {
+        t.Errorf("payloads = %v, want a second alert at 30", payloads)
+    }
+}
+
+func TestTargetPriceAlertDoesNotStall(t *testing.T) {
+    // the endpoint doesn't answer until the test ends
+    hung := make(chan struct{})
+    endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        <-hung
+    }))
+    t.Cleanup(endpoint.Close)
+    t.Cleanup(func() { close(hung) })
+
+    tracker := newTestTracker(t, cyclePrices(45))
+    tracker.SetRetryPolicy(1, 0)
+    tracker.SetWebhookURL(endpoint.URL)
+    addTargetProduct(t, tracker, "alert-hung", 50)
+
+    done := make(chan struct{})
+    go func() {
+        tracker.trackProducts(context.Background(), tracker.snapshotProducts(), nil)
+        close(done)
+    }()
+    select {
+    case <-done:
+    case <-time.After(2 * time.Second):
+        t.Fatal("the cycle waited on the webhook")
+    }
+    if history, _ := tracker.GetPriceHistory("alert-hung", 10, false); len(history) != 1 {
+        t.Errorf("stored %d prices, want 1", len(history))
+    }
+}
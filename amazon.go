@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+    amazonAPIPath    = "/paapi5/getitems"
+    amazonAPITarget  = "com.amazon.paapi5.v1.ProductAdvertisingAPIv1.GetItems"
+    amazonAPIService = "ProductAdvertisingAPI"
+)
+
+// amazonMarketplace is the Product Advertising API host and AWS region
+// serving one Amazon store
+type amazonMarketplace struct {
+    host   string
+    region string
+}
+
+// amazonMarketplaces are keyed by store domain, without "www."
+var amazonMarketplaces = map[string]amazonMarketplace{
+    "amazon.com":    {"webservices.amazon.com", "us-east-1"},
+    "amazon.ca":     {"webservices.amazon.ca", "us-east-1"},
+    "amazon.com.mx": {"webservices.amazon.com.mx", "us-east-1"},
+    "amazon.com.br": {"webservices.amazon.com.br", "us-east-1"},
+    "amazon.co.uk":  {"webservices.amazon.co.uk", "eu-west-1"},
+    "amazon.de":     {"webservices.amazon.de", "eu-west-1"},
+    "amazon.fr":     {"webservices.amazon.fr", "eu-west-1"},
+    "amazon.it":     {"webservices.amazon.it", "eu-west-1"},
+    "amazon.es":     {"webservices.amazon.es", "eu-west-1"},
+    "amazon.nl":     {"webservices.amazon.nl", "eu-west-1"},
+    "amazon.se":     {"webservices.amazon.se", "eu-west-1"},
+    "amazon.pl":     {"webservices.amazon.pl", "eu-west-1"},
+    "amazon.com.be": {"webservices.amazon.com.be", "eu-west-1"},
+    "amazon.com.tr": {"webservices.amazon.com.tr", "eu-west-1"},
+    "amazon.in":     {"webservices.amazon.in", "eu-west-1"},
+    "amazon.ae":     {"webservices.amazon.ae", "eu-west-1"},
+    "amazon.sa":     {"webservices.amazon.sa", "eu-west-1"},
+    "amazon.eg":     {"webservices.amazon.eg", "eu-west-1"},
+    "amazon.co.jp":  {"webservices.amazon.co.jp", "us-west-2"},
+    "amazon.com.au": {"webservices.amazon.com.au", "us-west-2"},
+    "amazon.sg":     {"webservices.amazon.sg", "us-west-2"},
+}
+
+// asinPattern finds the ASIN in the product URL forms Amazon uses:
+// /dp/ASIN, /gp/product/ASIN, /gp/aw/d/ASIN and /exec/obidos/ASIN/ASIN
+var asinPattern = regexp.MustCompile(`(?i)/(?:dp|gp/product|gp/aw/d|exec/obidos/asin|o/asin)/([a-z0-9]{10})(?:[/?#]|$)`)
+
+// errNotAmazonItem is returned for products whose URL isn't an Amazon
+// product page
+var errNotAmazonItem = errors.New("not an Amazon product URL")
+
+// AmazonFetcher gets prices from the Amazon Product Advertising API 5.0
+// rather than scraping, for products whose URL is an Amazon product page.
+// The price is the buy box offer's, or with PreferPrime the cheapest
+// Prime-eligible offer's when there is one.
+type AmazonFetcher struct {
+    AccessKey   string
+    SecretKey   string
+    PartnerTag  string
+    PreferPrime bool
+
+    // baseURL replaces the marketplace's API host, for pointing the
+    // fetcher at a stub
+    baseURL string
+}
+
+// amazonItem returns the ASIN and marketplace of an Amazon product URL
+func amazonItem(rawURL string) (string, string, bool) {
+    parsed, err := url.Parse(rawURL)
+    if err != nil {
+        return "", "", false
+    }
+    domain := strings.ToLower(parsed.Hostname())
+    for _, prefix := range []string{"www.", "smile.", "m."} {
+        domain = strings.TrimPrefix(domain, prefix)
+    }
+    if _, ok := amazonMarketplaces[domain]; !ok {
+        return "", "", false
+    }
+    match := asinPattern.FindStringSubmatch(parsed.EscapedPath())
+    if match == nil {
+        return "", "", false
+    }
+    return strings.ToUpper(match[1]), domain, true
+}
+
+func (f *AmazonFetcher) handles(product Product) bool {
+    if f == nil {
+        return false
+    }
+    _, _, ok := amazonItem(product.URL)
+    return ok
+}
+
+func (f *AmazonFetcher) FetchPrice(ctx context.Context, product Product) (float64, error) {
+    reading, err := f.FetchReading(ctx, product)
+    return reading.Price, err
+}
+
+func (f *AmazonFetcher) FetchReading(ctx context.Context, product Product) (PriceReading, error) {
+    asin, domain, ok := amazonItem(product.URL)
+    if !ok {
+        return PriceReading{}, fmt.Errorf("%w: %s", errNotAmazonItem, product.URL)
+    }
+    marketplace := amazonMarketplaces[domain]
+
+    currency := product.Currency
+    if currency == "" {
+        currency = DefaultCurrency
+    }
+    payload, err := json.Marshal(map[string]interface{}{
+        "ItemIds":              []string{asin},
+        "ItemIdType":           "ASIN",
+        "PartnerTag":           f.PartnerTag,
+        "PartnerType":          "Associates",
+        "Marketplace":          "www." + domain,
+        "CurrencyOfPreference": currency,
+        "Resources": []string{
+            "Offers.Listings.Price",
+            "Offers.Listings.SavingBasis",
+            "Offers.Listings.Availability.Type",
+            "Offers.Listings.DeliveryInfo.IsPrimeEligible",
+            "Offers.Listings.IsBuyBoxWinner",
+        },
+    })
+    if err != nil {
+        return PriceReading{}, err
+    }
+
+    base := "https://" + marketplace.host
+    if f.baseURL != "" {
+        base = f.baseURL
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, base+amazonAPIPath, bytes.NewReader(payload))
+    if err != nil {
+        return PriceReading{}, err
+    }
+    req.Header.Set("Content-Encoding", "amz-1.0")
+    req.Header.Set("Content-Type", "application/json; charset=utf-8")
+    req.Header.Set("X-Amz-Target", amazonAPITarget)
+    f.sign(req, marketplace.host, marketplace.region, payload, time.Now().UTC())
+
+    resp, err := apiClient.Do(req)
+    if err != nil {
+        return PriceReading{}, retryableError{err}
+    }
+    defer resp.Body.Close()
+
+    body, err := io.ReadAll(io.LimitReader(resp.Body, maxAPIResponseBytes))
+    if err != nil {
+        return PriceReading{}, retryableError{err}
+    }
+
+    var result amazonGetItemsResponse
+    if err := json.Unmarshal(body, &result); err != nil && resp.StatusCode == http.StatusOK {
+        return PriceReading{}, fmt.Errorf("decoding Amazon API response for %s: %w", asin, err)
+    }
+    if resp.StatusCode != http.StatusOK {
+        msg := resp.Status
+        if len(result.Errors) > 0 {
+            msg = result.Errors[0].Code + ": " + result.Errors[0].Message
+        }
+        err := statusError{code: resp.StatusCode, err: fmt.Errorf("Amazon API request for %s failed: %s", asin, msg)}
+        if isRetryableStatus(resp.StatusCode) {
+            return PriceReading{}, retryableError{err}
+        }
+        return PriceReading{}, err
+    }
+    if len(result.ItemsResult.Items) == 0 {
+        if len(result.Errors) > 0 {
+            return PriceReading{}, fmt.Errorf("Amazon API has no item %s: %s: %s", asin, result.Errors[0].Code, result.Errors[0].Message)
+        }
+        return PriceReading{}, fmt.Errorf("Amazon API has no item %s", asin)
+    }
+
+    listing, ok := f.pickListing(result.ItemsResult.Items[0].Offers.Listings)
+    if !ok {
+        return PriceReading{}, fmt.Errorf("Amazon item %s has no offers", asin)
+    }
+    if !strings.EqualFold(listing.Price.Currency, currency) {
+        return PriceReading{}, fmt.Errorf("Amazon item %s is priced in %s, but the product is tracked in %s", asin, listing.Price.Currency, currency)
+    }
+
+    reading := PriceReading{Price: listing.Price.Amount}
+    if basis := listing.SavingBasis; basis != nil && basis.Amount > listing.Price.Amount && strings.EqualFold(basis.Currency, currency) {
+        reading.ListPrice = &basis.Amount
+    }
+    switch listing.Availability.Type {
+    case "Now":
+        inStock := true
+        reading.InStock = &inStock
+    case "OutOfStock":
+        inStock := false
+        reading.InStock = &inStock
+    }
+    return reading, nil
+}
+
+// pickListing chooses the offer to track: the cheapest Prime-eligible one
+// with PreferPrime, otherwise (or when none is) the buy box winner, falling
+// back to the first offer
+func (f *AmazonFetcher) pickListing(listings []amazonListing) (amazonListing, bool) {
+    var priced []amazonListing
+    for _, listing := range listings {
+        if listing.Price != nil {
+            priced = append(priced, listing)
+        }
+    }
+    if len(priced) == 0 {
+        return amazonListing{}, false
+    }
+
+    if f.PreferPrime {
+        var best *amazonListing
+        for i, listing := range priced {
+            if listing.DeliveryInfo.IsPrimeEligible && (best == nil || listing.Price.Amount < best.Price.Amount) {
+                best = &priced[i]
+            }
+        }
+        if best != nil {
+            return *best, true
+        }
+    }
+    for _, listing := range priced {
+        if listing.IsBuyBoxWinner {
+            return listing, true
+        }
+    }
+    return priced[0], true
+}
+
+// sign adds AWS Signature Version 4 headers to a PA-API request
+func (f *AmazonFetcher) sign(req *http.Request, host, region string, payload []byte, now time.Time) {
+    amzDate := now.Format("20060102T150405Z")
+    date := now.Format("20060102")
+    req.Host = host
+    req.Header.Set("X-Amz-Date", amzDate)
+
+    signedHeaders := "content-encoding;content-type;host;x-amz-date;x-amz-target"
+    canonicalRequest := strings.Join([]string{
+        req.Method,
+        amazonAPIPath,
+        "",
+        "content-encoding:" + req.Header.Get("Content-Encoding"),
+        "content-type:" + req.Header.Get("Content-Type"),
+        "host:" + host,
+        "x-amz-date:" + amzDate,
+        "x-amz-target:" + req.Header.Get("X-Amz-Target"),
+        "",
+        signedHeaders,
+        sha256Hex(payload),
+    }, "\n")
+
+    scope := date + "/" + region + "/" + amazonAPIService + "/aws4_request"
+    stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+    key := hmacSHA256([]byte("AWS4"+f.SecretKey), date)
+    for _, part := range []string{region, amazonAPIService, "aws4_request"} {
+        key = hmacSHA256(key, part)
+    }
+    signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+    req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+        f.AccessKey, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+    sum := sha256.Sum256(data)
+    return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+    mac := hmac.New(sha256.New, key)
+    mac.Write([]byte(data))
+    return mac.Sum(nil)
+}
+
+// amazonGetItemsResponse is the part of a GetItems response the fetcher
+// reads
+type amazonGetItemsResponse struct {
+    ItemsResult struct {
+        Items []struct {
+            ASIN   string `json:"ASIN"`
+            Offers struct {
+                Listings []amazonListing `json:"Listings"`
+            } `json:"Offers"`
+        } `json:"Items"`
+    } `json:"ItemsResult"`
+    Errors []struct {
+        Code    string `json:"Code"`
+        Message string `json:"Message"`
+    } `json:"Errors"`
+}
+
+type amazonListing struct {
+    Price        *amazonMoney `json:"Price"`
+    SavingBasis  *amazonMoney `json:"SavingBasis"`
+    Availability struct {
+        Type string `json:"Type"`
+    } `json:"Availability"`
+    DeliveryInfo struct {
+        IsPrimeEligible bool `json:"IsPrimeEligible"`
+    } `json:"DeliveryInfo"`
+    IsBuyBoxWinner bool `json:"IsBuyBoxWinner"`
+}
+
+type amazonMoney struct {
+    Amount   float64 `json:"Amount"`
+    Currency string  `json:"Currency"`
+}
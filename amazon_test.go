@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// amazonItemsResponse is a GetItems answer with a buy box offer, two Prime
+// offers and an offer without a price
+const amazonItemsResponse = `{
+    "ItemsResult": {"Items": [{"ASIN": "B01N5IB20Q", "Offers": {"Listings": [
+        {"Price": {"Amount": 26.50, "Currency": "USD"}, "DeliveryInfo": {"IsPrimeEligible": true}, "Availability": {"Type": "Now"}},
+        {"Price": {"Amount": 24.99, "Currency": "USD"}, "SavingBasis": {"Amount": 39.99, "Currency": "USD"}, "IsBuyBoxWinner": true, "Availability": {"Type": "Now"}},
+        {"Availability": {"Type": "Now"}, "DeliveryInfo": {"IsPrimeEligible": true}},
+        {"Price": {"Amount": 25.75, "Currency": "USD"}, "DeliveryInfo": {"IsPrimeEligible": true}, "Availability": {"Type": "OutOfStock"}}
+    ]}}]}
+}`
+
+func TestAmazonItem(t *testing.T) {
+    tests := []struct {
+        url    string
+        asin   string
+        domain string
+        ok     bool
+    }{
+        {"https://www.amazon.com/Echo-Dot/dp/B01N5IB20Q/ref=sr_1_1?keywords=echo", "B01N5IB20Q", "amazon.com", true},
+        {"https://amazon.com/dp/b01n5ib20q", "B01N5IB20Q", "amazon.com", true},
+        {"https://www.amazon.co.uk/gp/product/B07XJ8C8F5?psc=1", "B07XJ8C8F5", "amazon.co.uk", true},
+        {"https://m.amazon.de/gp/aw/d/B07XJ8C8F5", "B07XJ8C8F5", "amazon.de", true},
+        {"https://www.amazon.com/exec/obidos/ASIN/B01N5IB20Q/", "B01N5IB20Q", "amazon.com", true},
+        {"https://amzn.to/3xYzAbC", "", "", false},
+        {"https://www.amazon.com/s?k=echo+dot", "", "", false},
+        {"https://www.amazon.com/dp/B01N5IB20QX", "", "", false},
+        {"https://shop.test/dp/B01N5IB20Q", "", "", false},
+    }
+    for _, tt := range tests {
+        asin, domain, ok := amazonItem(tt.url)
+        if asin != tt.asin || domain != tt.domain || ok != tt.ok {
+            t.Errorf("amazonItem(%q) = %q, %q, %v; want %q, %q, %v", tt.url, asin, domain, ok, tt.asin, tt.domain, tt.ok)
+        }
+    }
+}
+
+func TestAmazonSignature(t *testing.T) {
+    // the signature was computed independently from the PA-API signing
+    // steps for these credentials, headers, payload and time
+    f := &AmazonFetcher{AccessKey: "AKIDEXAMPLE", SecretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+    payload := []byte(`{"ItemIds":["B01N5IB20Q"]}`)
+    req := httptest.NewRequest(http.MethodPost, "https://webservices.amazon.com"+amazonAPIPath, nil)
+    req.Header.Set("Content-Encoding", "amz-1.0")
+    req.Header.Set("Content-Type", "application/json; charset=utf-8")
+    req.Header.Set("X-Amz-Target", amazonAPITarget)
+    f.sign(req, "webservices.amazon.com", "us-east-1", payload, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+
+    if got := req.Header.Get("X-Amz-Date"); got != "20240102T030405Z" {
+        t.Errorf("X-Amz-Date = %q", got)
+    }
+    if req.Host != "webservices.amazon.com" {
+        t.Errorf("Host = %q", req.Host)
+    }
+    want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20240102/us-east-1/ProductAdvertisingAPI/aws4_request, " +
+        "SignedHeaders=content-encoding;content-type;host;x-amz-date;x-amz-target, " +
+        "Signature=54379f7b32c0ce48608b14fa57aea978855ecd00edef65be7405104f7c55dbbd"
+    if got := req.Header.Get("Authorization"); got != want {
+        t.Errorf("Authorization = %q\nwant %q", got, want)
+    }
+}
+
+// newAmazonAPI starts a stub GetItems endpoint answering with the response
+// and status, and records the last request's headers and body
+func newAmazonAPI(t *testing.T, status int, response string) (*httptest.Server, *http.Header, *map[string]interface{}) {
+    t.Helper()
+    var header http.Header
+    var body map[string]interface{}
+    api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPost || r.URL.Path != amazonAPIPath {
+            http.NotFound(w, r)
+            return
+        }
+        header = r.Header.Clone()
+        header.Set("Host", r.Host)
+        json.NewDecoder(r.Body).Decode(&body)
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(status)
+        w.Write([]byte(response))
+    }))
+    t.Cleanup(api.Close)
+    return api, &header, &body
+}
+
+func TestAmazonFetchReading(t *testing.T) {
+    api, header, body := newAmazonAPI(t, http.StatusOK, amazonItemsResponse)
+    f := &AmazonFetcher{AccessKey: "AKIDEXAMPLE", SecretKey: "secret", PartnerTag: "tracker-20", baseURL: api.URL}
+    product := Product{ID: "amazon-echo", URL: "https://www.amazon.com/Echo-Dot/dp/B01N5IB20Q"}
+
+    reading, err := f.FetchReading(context.Background(), product)
+    if err != nil {
+        t.Fatal(err)
+    }
+    // the buy box winner, with its saving basis as the list price
+    if reading.Price != 24.99 || reading.ListPrice == nil || *reading.ListPrice != 39.99 || reading.InStock == nil || !*reading.InStock {
+        t.Errorf("reading = %+v, want the buy box offer", reading)
+    }
+
+    if got := (*header).Get("Host"); got != "webservices.amazon.com" {
+        t.Errorf("Host = %q, want the marketplace's API host", got)
+    }
+    if got := (*header).Get("X-Amz-Target"); got != amazonAPITarget {
+        t.Errorf("X-Amz-Target = %q", got)
+    }
+    amzDate := (*header).Get("X-Amz-Date")
+    if len(amzDate) != len("20060102T150405Z") {
+        t.Fatalf("X-Amz-Date = %q", amzDate)
+    }
+    if got := (*header).Get("Authorization"); !strings.HasPrefix(got, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/"+amzDate[:8]+"/us-east-1/ProductAdvertisingAPI/aws4_request, ") {
+        t.Errorf("Authorization = %q", got)
+    }
+    if (*body)["PartnerTag"] != "tracker-20" || (*body)["Marketplace"] != "www.amazon.com" || (*body)["CurrencyOfPreference"] != "USD" {
+        t.Errorf("request body = %v", *body)
+    }
+    if ids, _ := (*body)["ItemIds"].([]interface{}); len(ids) != 1 || ids[0] != "B01N5IB20Q" {
+        t.Errorf("ItemIds = %v", (*body)["ItemIds"])
+    }
+
+    // preferring Prime takes the cheapest Prime offer
+    f.PreferPrime = true
+    reading, err = f.FetchReading(context.Background(), product)
+    if err != nil || reading.Price != 25.75 || reading.ListPrice != nil || reading.InStock == nil || *reading.InStock {
+        t.Errorf("prime reading = %+v, %v; want the 25.75 offer, out of stock", reading, err)
+    }
+
+    // the item in another currency than the product's is refused
+    product.Currency = "EUR"
+    if _, err := f.FetchReading(context.Background(), product); err == nil || !strings.Contains(err.Error(), "priced in USD") {
+        t.Errorf("currency mismatch = %v", err)
+    }
+}
+
+func TestAmazonFetchErrors(t *testing.T) {
+    product := Product{ID: "amazon-echo", URL: "https://www.amazon.com/dp/B01N5IB20Q"}
+
+    api, _, _ := newAmazonAPI(t, http.StatusNotFound, `{"Errors": [{"Code": "InvalidParameterValue", "Message": "The ItemId is not accessible"}]}`)
+    _, err := (&AmazonFetcher{baseURL: api.URL}).FetchReading(context.Background(), product)
+    if err == nil || isRetryable(err) || !strings.Contains(err.Error(), "InvalidParameterValue") {
+        t.Errorf("404 = %v, want a permanent error with the API's code", err)
+    }
+
+    api, _, _ = newAmazonAPI(t, http.StatusOK, `{"ItemsResult": {"Items": [{"ASIN": "B01N5IB20Q", "Offers": {"Listings": []}}]}}`)
+    if _, err := (&AmazonFetcher{baseURL: api.URL}).FetchReading(context.Background(), product); err == nil || !strings.Contains(err.Error(), "no offers") {
+        t.Errorf("no offers = %v", err)
+    }
+
+    if _, err := (&AmazonFetcher{}).FetchReading(context.Background(), Product{URL: "https://shop.test/item"}); !errors.Is(err, errNotAmazonItem) {
+        t.Errorf("non-Amazon URL = %v", err)
+    }
+}
+
+func TestAmazonThrottling(t *testing.T) {
+    var hits int32
+    api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if atomic.AddInt32(&hits, 1) == 1 {
+            w.WriteHeader(http.StatusTooManyRequests)
+            w.Write([]byte(`{"Errors": [{"Code": "TooManyRequests", "Message": "The request was denied due to request throttling."}]}`))
+            return
+        }
+        w.Write([]byte(amazonItemsResponse))
+    }))
+    t.Cleanup(api.Close)
+    amazon := &AmazonFetcher{baseURL: api.URL}
+
+    // a throttled request is retryable and says why
+    _, err := amazon.FetchReading(context.Background(), Product{URL: "https://www.amazon.com/dp/B01N5IB20Q"})
+    var status statusError
+    if !isRetryable(err) || !errors.As(err, &status) || status.code != http.StatusTooManyRequests || !strings.Contains(err.Error(), "TooManyRequests") {
+        t.Fatalf("throttled = %v, want a retryable 429", err)
+    }
+
+    // so the tracker backs off and tries again
+    atomic.StoreInt32(&hits, 0)
+    tracker := newTestTracker(t, DefaultFetcher{Amazon: amazon})
+    tracker.SetRetryPolicy(2, time.Millisecond)
+    if _, err := tracker.CreateProduct(Product{ID: "amazon-throttled", Name: "Echo", URL: "https://www.amazon.com/dp/B01N5IB20Q"}); err != nil {
+        t.Fatal(err)
+    }
+    tracker.trackProducts(context.Background(), tracker.snapshotProducts(), nil)
+    if n := atomic.LoadInt32(&hits); n != 2 {
+        t.Errorf("%d API requests, want 2", n)
+    }
+    if history, _ := tracker.GetPriceHistory("amazon-throttled", 10, true); len(history) != 1 || history[0].Price != 24.99 {
+        t.Errorf("history = %v, want the price from the retry", history)
+    }
+}
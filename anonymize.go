@@ -0,0 +1,37 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// anonymizer replaces product IDs in exports with tokens, so a shared export
+// keeps every price series without saying what was tracked. The same ID and
+// key always give the same token, across exports and restarts.
+type anonymizer struct {
+    key []byte
+}
+
+// token returns the stand-in for a product ID: a keyed hash, so without the
+// key IDs can't be recovered by hashing likely guesses
+func (a anonymizer) token(productID string) string {
+    mac := hmac.New(sha256.New, a.key)
+    mac.Write([]byte(productID))
+    return "product-" + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// keyed reports whether there is a key to hash with; tokens made without
+// one don't hide anything
+func (a anonymizer) keyed() bool {
+    return len(a.key) > 0
+}
+
+// SetExportAnonymization sets the key product IDs in anonymized exports are
+// hashed with, and whether exports are anonymized unless a request asks
+// otherwise with anonymize=false. Without a key anonymized exports are
+// refused.
+func (s *APIServer) SetExportAnonymization(key string, byDefault bool) {
+    s.anonymizer = anonymizer{key: []byte(key)}
+    s.anonymizeExports = byDefault
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// exportCSV downloads a CSV export and returns its rows without the header
+func exportCSV(t *testing.T, server *APIServer, path string) ([][]string, http.Header) {
+    t.Helper()
+    rec := serve(t, server, "GET", path, "", nil)
+    if rec.Code != http.StatusOK {
+        t.Fatalf("%s = %d: %s", path, rec.Code, rec.Body)
+    }
+    rows, err := csv.NewReader(rec.Body).ReadAll()
+    if err != nil {
+        t.Fatal(err)
+    }
+    return rows[1:], rec.Header()
+}
+
+func TestAnonymizedExport(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    server.SetExportAnonymization("test-key", false)
+    addTestProduct(t, server.tracker, "anon-laptop", 999, 949, 979)
+    addTestProduct(t, server.tracker, "anon-phone", 599, 579)
+
+    plain, _ := exportCSV(t, server, "/api/v1/history.csv")
+    anonymized, _ := exportCSV(t, server, "/api/v1/history.csv?anonymize=true")
+    if len(anonymized) != 5 || len(plain) != len(anonymized) {
+        t.Fatalf("anonymized export has %d rows, want the plain export's %d", len(anonymized), len(plain))
+    }
+
+    // the series is unchanged apart from the product IDs, which map one to one
+    tokens := map[string]string{}
+    for i, row := range anonymized {
+        original := plain[i]
+        if row[0] != original[0] || row[2] != original[2] || row[3] != original[3] || row[4] != original[4] {
+            t.Errorf("row %d = %v, want %v with only the ID replaced", i, row, original)
+        }
+        if token, ok := tokens[original[1]]; ok && token != row[1] {
+            t.Errorf("%s exported as both %s and %s", original[1], token, row[1])
+        }
+        tokens[original[1]] = row[1]
+    }
+    if len(tokens) != 2 || tokens["anon-laptop"] == tokens["anon-phone"] {
+        t.Fatalf("tokens = %v, want one per product", tokens)
+    }
+    for _, row := range anonymized {
+        if strings.Contains(strings.Join(row, ","), "anon-") || !strings.HasPrefix(row[1], "product-") {
+            t.Errorf("row %v still identifies the product", row)
+        }
+    }
+
+    // a single product's export uses the same token, in its file name too
+    rows, header := exportCSV(t, server, "/api/v1/products/anon-phone/history.csv?anonymize=1")
+    if len(rows) != 2 || rows[0][1] != tokens["anon-phone"] {
+        t.Errorf("phone export = %v, want token %s", rows, tokens["anon-phone"])
+    }
+    if got := header.Get("Content-Disposition"); strings.Contains(got, "anon-phone") || !strings.Contains(got, tokens["anon-phone"]) {
+        t.Errorf("Content-Disposition = %q", got)
+    }
+
+    if rec := serve(t, server, "GET", "/api/v1/history.csv?anonymize=maybe", "", nil); rec.Code != http.StatusBadRequest {
+        t.Errorf("invalid anonymize = %d, want 400", rec.Code)
+    }
+}
+
+func TestAnonymizationDeterministic(t *testing.T) {
+    a := anonymizer{key: []byte("one key")}
+    if a.token("laptop-1") != a.token("laptop-1") {
+        t.Error("the same ID gave different tokens")
+    }
+    if a.token("laptop-1") == a.token("laptop-2") {
+        t.Error("different IDs gave the same token")
+    }
+    if a.token("laptop-1") == (anonymizer{key: []byte("another key")}).token("laptop-1") {
+        t.Error("the token doesn't depend on the key")
+    }
+
+    // anonymized by default, unless a request opts out
+    server := NewAPIServer(newTestTracker(t, nil))
+    server.SetExportAnonymization("one key", true)
+    addTestProduct(t, server.tracker, "laptop-1", 10)
+    if rows, _ := exportCSV(t, server, "/api/v1/history.csv"); len(rows) != 1 || rows[0][1] != a.token("laptop-1") {
+        t.Errorf("default export = %v, want anonymized", rows)
+    }
+    if rows, _ := exportCSV(t, server, "/api/v1/history.csv?anonymize=false"); len(rows) != 1 || rows[0][1] != "laptop-1" {
+        t.Errorf("export opting out = %v, want the real ID", rows)
+    }
+}
+
+func TestAnonymizationNeedsKey(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    addTestProduct(t, server.tracker, "anon-unkeyed", 10)
+
+    // without a key tokens could be reversed by hashing guesses
+    for _, path := range []string{"/api/v1/history.csv?anonymize=true", "/api/v1/products/anon-unkeyed/history.csv?anonymize=true"} {
+        if rec := serve(t, server, "GET", path, "", nil); rec.Code != http.StatusBadRequest || strings.Contains(rec.Body.String(), "anon-unkeyed") {
+            t.Errorf("%s = %d %q, want 400", path, rec.Code, rec.Body)
+        }
+    }
+    if rows, _ := exportCSV(t, server, "/api/v1/history.csv"); len(rows) != 1 || rows[0][1] != "anon-unkeyed" {
+        t.Errorf("plain export = %v", rows)
+    }
+
+    t.Setenv("ANONYMIZE_EXPORTS", "true")
+    t.Setenv("ANONYMIZATION_KEY", "")
+    if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "ANONYMIZATION_KEY") {
+        t.Errorf("anonymizing without a key: err = %v, want an error naming ANONYMIZATION_KEY", err)
+    }
+    t.Setenv("ANONYMIZATION_KEY", "secret")
+    if cfg, err := LoadConfig(); err != nil || !cfg.AnonymizeExports {
+        t.Errorf("with a key = %+v, %v", cfg, err)
+    }
+}
@@ -1,85 +1,1658 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"math"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
+	graphql "github.com/graph-gophers/graphql-go"
 )
 
 type APIServer struct {
     tracker *PriceTracker
     router  *mux.Router
+
+    // apiKey guards mutating requests; empty leaves them open
+    apiKey string
+
+    // tenantKeys are the API keys of tenants, by tenant name; see
+    // SetTenantKeys
+    tenantKeys map[string]string
+
+    // rates converts prices for the currency query parameter; nil rejects
+    // conversions
+    rates *ExchangeRates
+
+    // graphQL is the schema served at /graphql, nil until EnableGraphQL
+    graphQL *graphql.Schema
+
+    // anonymizer hides product IDs in CSV exports, which are anonymized by
+    // default when anonymizeExports is set; see SetExportAnonymization
+    anonymizer       anonymizer
+    anonymizeExports bool
+}
+
+func NewAPIServer(tracker *PriceTracker) *APIServer {
+    server := &APIServer{
+        tracker: tracker,
+        router:  mux.NewRouter(),
+    }
+
+    server.setupRoutes()
+    return server
+}
+
+func (s *APIServer) setupRoutes() {
+    api := s.router.PathPrefix("/api/v1").Subrouter()
+    api.Use(s.tenantMiddleware)
+
+    api.HandleFunc("/products", s.handleGetProducts).Methods("GET")
+    api.HandleFunc("/products", s.handleAddProduct).Methods("POST")
+    api.HandleFunc("/products/import", s.handleImportProducts).Methods("POST")
+    api.HandleFunc("/products/search", s.handleSearchProducts).Methods("GET")
+    api.HandleFunc("/products/{id}", s.handleGetProduct).Methods("GET")
+    api.HandleFunc("/products/{id}", s.handleReplaceProduct).Methods("PUT")
+    api.HandleFunc("/products/{id}", s.handleUpdateProduct).Methods("PATCH")
+    api.HandleFunc("/products/{id}", s.handleDeleteProduct).Methods("DELETE")
+    api.HandleFunc("/products/{id}/history", s.handleGetPriceHistory).Methods("GET")
+    api.HandleFunc("/products/{id}/history.csv", s.handleExportPriceHistory).Methods("GET")
+    api.HandleFunc("/history.csv", s.handleExportAllPriceHistory).Methods("GET")
+    api.HandleFunc("/history/batch", s.handleGetBatchHistory).Methods("GET")
+    api.HandleFunc("/products/{id}/scrape-errors", s.handleGetScrapeErrors).Methods("GET")
+    api.HandleFunc("/products/{id}/sale-windows", s.handleGetSaleWindows).Methods("GET")
+    api.HandleFunc("/products/{id}/sale-windows", s.handleAddSaleWindow).Methods("POST")
+    api.HandleFunc("/products/{id}/image", s.handleProductImage).Methods("GET")
+    api.HandleFunc("/products/{id}/widget.html", s.handleWidget).Methods("GET")
+    api.HandleFunc("/products/{id}/stats", s.handleGetPriceStats).Methods("GET")
+    api.HandleFunc("/products/{id}/best-deal", s.handleGetBestDeal).Methods("GET")
+    api.HandleFunc("/products/{id}/extremes", s.handleGetPriceExtremes).Methods("GET")
+    api.HandleFunc("/products/{id}/best-time", s.handleGetBestTime).Methods("GET")
+    api.HandleFunc("/products/{id}/elasticity", s.handleGetElasticity).Methods("GET")
+    api.HandleFunc("/products/{id}/price-script", s.handleSetPriceScript).Methods("PUT")
+    api.HandleFunc("/archive/{id}/history", s.handleGetArchivedHistory).Methods("GET")
+    api.HandleFunc("/products/{id}/price-script/try", s.handleTryPriceScript).Methods("POST")
+    api.HandleFunc("/products/{id}/check", s.handleCheckProduct).Methods("POST")
+    api.HandleFunc("/best-deals", s.handleGetBestDeals).Methods("GET")
+    api.HandleFunc("/entries/{id}/outlier", s.handleSetOutlier).Methods("PUT")
+    api.HandleFunc("/stream", s.handleStream).Methods("GET")
+    api.HandleFunc("/velocity", s.handleGetVelocity).Methods("GET")
+    api.HandleFunc("/deals", s.handleGetPriceDrops).Methods("GET")
+    api.HandleFunc("/basket", s.handleAnalyzeBasket).Methods("POST")
+    api.HandleFunc("/robots-blocked", s.handleGetRobotsBlocked).Methods("GET")
+    api.HandleFunc("/page-changes", s.handleGetPageChanges).Methods("GET")
+    api.HandleFunc("/circuit-breakers", s.handleGetCircuitBreakers).Methods("GET")
+    api.HandleFunc("/diagnostics/blocks", s.handleGetBlockStats).Methods("GET")
+    api.HandleFunc("/fetchers", s.handleGetFetchers).Methods("GET")
+    api.HandleFunc("/check-all", s.handleCheckAll).Methods("POST")
+    api.HandleFunc("/validate-all", s.handleValidateAll).Methods("POST")
+    api.HandleFunc("/catalog/preview", s.handlePreviewCatalog).Methods("POST")
+    api.HandleFunc("/catalog/import", s.handleImportCatalog).Methods("POST")
+    api.HandleFunc("/jobs/{id}", s.handleGetJob).Methods("GET")
+    api.HandleFunc("/metrics", s.handleMetrics).Methods("GET")
+    api.HandleFunc("/health", s.handleHealth).Methods("GET")
+
+    // admin endpoints need a key whatever the method
+    admin := api.PathPrefix("/admin").Subrouter()
+    admin.Use(s.adminMiddleware)
+    admin.HandleFunc("/products/{id}/snapshots", s.handleGetSnapshots).Methods("GET")
+    admin.HandleFunc("/snapshots/{id}", s.handleGetSnapshotPage).Methods("GET")
+    admin.HandleFunc("/notifications", s.handleGetNotifications).Methods("GET")
+
+    // serve a simple HTML page at root
+    s.router.HandleFunc("/", s.handleRoot).Methods("GET")
+
+    // Prometheus scrapes /metrics by default
+    s.router.Handle("/metrics", s.tracker.MetricsHandler()).Methods("GET")
+
+    // match CORS preflight requests for any path so the middleware below
+    // runs and answers them; without a route mux replies 404 directly
+    s.router.MatcherFunc(func(r *http.Request, _ *mux.RouteMatch) bool {
+        return r.Method == http.MethodOptions
+    }).HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+    // add middleware; CORS answers preflights before auth sees them
+    s.router.Use(s.loggingMiddleware)
+    s.router.Use(s.corsMiddleware)
+    s.router.Use(s.authMiddleware)
+}
+
+// SetAPIKey requires key on POST, PUT, PATCH and DELETE requests, given as
+// "Authorization: Bearer <key>" or "X-API-Key: <key>", and on every request
+// to the admin endpoints. Other GET requests stay public. An empty key
+// leaves every endpoint open.
+func (s *APIServer) SetAPIKey(key string) {
+    if key == "" {
+        log.Println("WARNING: no API key configured, write endpoints are open to anyone")
+    }
+    s.apiKey = key
+}
+
+// SetExchangeRates lets price endpoints convert to the currency given in
+// their currency query parameter
+func (s *APIServer) SetExchangeRates(rates *ExchangeRates) {
+    s.rates = rates
+}
+
+// displayCurrency reads the optional currency query parameter; empty
+// leaves prices in the currencies they were recorded in
+func (s *APIServer) displayCurrency(r *http.Request) (string, error) {
+    currency := strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("currency")))
+    if currency == "" {
+        return "", nil
+    }
+    if !isCurrencyCode(currency) {
+        return "", fmt.Errorf("Invalid currency %q: must be a three-letter ISO 4217 code", currency)
+    }
+    if s.rates == nil {
+        return "", errors.New("Currency conversion isn't available")
+    }
+    return currency, nil
+}
+
+// writeConversionError reports a failed currency conversion
+func (s *APIServer) writeConversionError(w http.ResponseWriter, err error) {
+    switch {
+    case errors.Is(err, ErrUnknownCurrency):
+        s.writeError(w, http.StatusBadRequest, err.Error())
+    case errors.Is(err, ErrRatesUnavailable):
+        s.writeError(w, http.StatusBadGateway, err.Error())
+    default:
+        s.writeError(w, http.StatusInternalServerError, err.Error())
+    }
+}
+
+const (
+    // defaultProductsLimit is the products page size when none is given
+    defaultProductsLimit = 50
+    // maxProductsLimit caps the products page size
+    maxProductsLimit = 200
+)
+
+func (s *APIServer) handleGetProducts(w http.ResponseWriter, r *http.Request) {
+    filter, err := parseProductFilter(r)
+    if err != nil {
+        s.writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    s.writeProductPage(w, r, filter)
+}
+
+func (s *APIServer) handleSearchProducts(w http.ResponseWriter, r *http.Request) {
+    filter, err := parseProductFilter(r)
+    if err != nil {
+        s.writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    // q is full-text search text here rather than a name substring
+    filter.Search, filter.Query = filter.Query, ""
+    if filter.Search == "" {
+        s.writeError(w, http.StatusBadRequest, "q is required")
+        return
+    }
+    s.writeProductPage(w, r, filter)
+}
+
+// writeProductPage writes the page of products matching a filter that the
+// request's limit and offset select
+func (s *APIServer) writeProductPage(w http.ResponseWriter, r *http.Request, filter ProductFilter) {
+    limit := defaultProductsLimit
+    if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+        parsed, err := strconv.Atoi(limitStr)
+        if err != nil || parsed < 1 {
+            s.writeError(w, http.StatusBadRequest, "Invalid limit: must be a positive integer")
+            return
+        }
+        limit = parsed
+    }
+    if limit > maxProductsLimit {
+        limit = maxProductsLimit
+    }
+
+    offset := 0
+    if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+        parsed, err := strconv.Atoi(offsetStr)
+        if err != nil || parsed < 0 {
+            s.writeError(w, http.StatusBadRequest, "Invalid offset: must be a non-negative integer")
+            return
+        }
+        offset = parsed
+    }
+
+    currency, err := s.displayCurrency(r)
+    if err != nil {
+        s.writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    page, err := s.tracker.GetProducts(filter, limit, offset)
+    if errors.Is(err, ErrInvalidSort) {
+        s.writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    if err != nil {
+        s.writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    if currency != "" {
+        if err := s.rates.ConvertProducts(r.Context(), page.Items, currency); err != nil {
+            s.writeConversionError(w, err)
+            return
+        }
+    }
+    setPageLinks(w, r, page)
+    s.writeJSON(w, http.StatusOK, page)
+}
+
+// parseProductFilter reads the products listing's optional q, min_price,
+// max_price, updated_since, sort and order query parameters
+func parseProductFilter(r *http.Request) (ProductFilter, error) {
+    query := r.URL.Query()
+    filter := ProductFilter{
+        Query:  strings.TrimSpace(query.Get("q")),
+        Sort:   query.Get("sort"),
+        Tenant: requestTenant(r),
+    }
+
+    for name, bound := range map[string]**float64{"min_price": &filter.MinPrice, "max_price": &filter.MaxPrice} {
+        raw := query.Get(name)
+        if raw == "" {
+            continue
+        }
+        price, err := strconv.ParseFloat(raw, 64)
+        if err != nil || !(price >= 0) || math.IsInf(price, 0) {
+            return filter, fmt.Errorf("Invalid %s: must be a non-negative number", name)
+        }
+        *bound = &price
+    }
+    if filter.MinPrice != nil && filter.MaxPrice != nil && *filter.MinPrice > *filter.MaxPrice {
+        return filter, errors.New("min_price must not be above max_price")
+    }
+
+    if raw := query.Get("updated_since"); raw != "" {
+        since, err := time.Parse(time.RFC3339, raw)
+        if err != nil {
+            return filter, errors.New("Invalid updated_since: must be an RFC3339 timestamp")
+        }
+        filter.UpdatedSince = &since
+    }
+
+    switch order := query.Get("order"); order {
+    case "", "asc":
+    case "desc":
+        filter.Descending = true
+    default:
+        return filter, fmt.Errorf("Invalid order %q: must be asc or desc", order)
+    }
+
+    return filter, nil
+}
+
+// setPageLinks sets the Link header to the first, previous, next and last
+// pages of a listing, keeping the request's other query parameters
+func setPageLinks(w http.ResponseWriter, r *http.Request, page ProductPage) {
+    link := func(offset int, rel string) string {
+        query := r.URL.Query()
+        query.Set("limit", strconv.Itoa(page.Limit))
+        query.Set("offset", strconv.Itoa(offset))
+        return fmt.Sprintf("<%s?%s>; rel=%q", r.URL.Path, query.Encode(), rel)
+    }
+
+    last := 0
+    if page.Total > 0 {
+        last = (page.Total - 1) / page.Limit * page.Limit
+    }
+    links := []string{link(0, "first")}
+    if page.Offset > 0 {
+        // past the end, the previous page is the last one
+        prev := min(page.Offset-page.Limit, last)
+        if prev < 0 {
+            prev = 0
+        }
+        links = append(links, link(prev, "prev"))
+    }
+    if page.Offset+page.Limit < page.Total {
+        links = append(links, link(page.Offset+page.Limit, "next"))
+    }
+    links = append(links, link(last, "last"))
+
+    w.Header().Set("Link", strings.Join(links, ", "))
+    w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+}
+
+func (s *APIServer) handleAddProduct(w http.ResponseWriter, r *http.Request) {
+    var product Product
+    if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
+        s.writeError(w, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+        return
+    }
+
+    // a product posted with just a URL is named from its page
+    product, err := s.tracker.DiscoverProduct(r.Context(), product)
+    if errors.Is(err, ErrDiscoveryFailed) {
+        s.writeError(w, http.StatusBadGateway, err.Error())
+        return
+    }
+    if err != nil {
+        s.writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    product.Tenant = requestTenant(r)
+    created, err := s.tracker.CreateProduct(product)
+    if errors.Is(err, ErrProductExists) {
+        s.writeError(w, http.StatusConflict, err.Error())
+        return
+    }
+    if err != nil {
+        s.writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    s.writeJSON(w, http.StatusCreated, created)
+}
+
+func (s *APIServer) handleGetProduct(w http.ResponseWriter, r *http.Request) {
+    product, err := s.tracker.GetProduct(mux.Vars(r)["id"])
+    if err != nil {
+        s.writeError(w, http.StatusNotFound, err.Error())
+        return
+    }
+    s.writeJSON(w, http.StatusOK, product)
+}
+
+// handleReplaceProduct replaces every setting of a product; fields left
+// out are reset to their defaults
+func (s *APIServer) handleReplaceProduct(w http.ResponseWriter, r *http.Request) {
+    var product Product
+    if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
+        s.writeError(w, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+        return
+    }
+    s.saveProductUpdate(w, mux.Vars(r)["id"], product)
+}
+
+// handleUpdateProduct changes only the fields present in the body; null
+// clears an optional one such as target_price
+func (s *APIServer) handleUpdateProduct(w http.ResponseWriter, r *http.Request) {
+    productID := mux.Vars(r)["id"]
+    current, err := s.tracker.GetProduct(productID)
+    if err != nil {
+        s.writeError(w, http.StatusNotFound, err.Error())
+        return
+    }
+
+    // decoding over a copy of the current product keeps the fields the body
+    // omits; the copy goes through JSON so the tracked product's pointers
+    // aren't written through
+    var product Product
+    encoded, err := json.Marshal(current)
+    if err == nil {
+        err = json.Unmarshal(encoded, &product)
+    }
+    if err != nil {
+        s.writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
+        s.writeError(w, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+        return
+    }
+    s.saveProductUpdate(w, productID, product)
+}
+
+func (s *APIServer) saveProductUpdate(w http.ResponseWriter, productID string, product Product) {
+    if product.ID != "" && product.ID != productID {
+        s.writeError(w, http.StatusBadRequest, "id can't be changed; add a new product instead")
+        return
+    }
+    product.ID = productID
+
+    updated, err := s.tracker.UpdateProduct(product)
+    if errors.Is(err, ErrProductNotFound) {
+        s.writeError(w, http.StatusNotFound, err.Error())
+        return
+    }
+    if err != nil {
+        s.writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    s.writeJSON(w, http.StatusOK, updated)
+}
+
+func (s *APIServer) handleDeleteProduct(w http.ResponseWriter, r *http.Request) {
+    productID := mux.Vars(r)["id"]
+
+    // without purge the price history is archived
+    purge := false
+    if raw := r.URL.Query().Get("purge"); raw != "" {
+        var err error
+        if purge, err = strconv.ParseBool(raw); err != nil {
+            s.writeError(w, http.StatusBadRequest, "purge must be true or false")
+            return
+        }
+    }
+
+    if err := s.tracker.DeleteProduct(productID, purge); err != nil {
+        if errors.Is(err, ErrProductNotFound) {
+            s.writeError(w, http.StatusNotFound, err.Error())
+            return
+        }
+        s.writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *APIServer) handleGetPriceHistory(w http.ResponseWriter, r *http.Request) {
+    vars := mux.Vars(r)
+    productID := vars["id"]
+
+    if productID == "" {
+        s.writeError(w, http.StatusBadRequest, "Product ID is required")
+        return
+    }
+
+    query, err := s.parseHistoryQuery(r)
+    if err != nil {
+        s.writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    result, err := s.productHistory(r.Context(), productID, query)
+    if errors.Is(err, ErrProductNotFound) {
+        s.writeError(w, http.StatusNotFound, err.Error())
+        return
+    }
+    if err != nil {
+        s.writeConversionError(w, err)
+        return
+    }
+    s.writeJSON(w, http.StatusOK, result)
+}
+
+// maxBatchHistoryProducts caps how many products one batch history request
+// can ask for
+const maxBatchHistoryProducts = 50
+
+// handleGetBatchHistory returns the history of several products, given as
+// ids=a,b,c, in one response. Products that don't exist get an error in
+// their place rather than failing the request.
+func (s *APIServer) handleGetBatchHistory(w http.ResponseWriter, r *http.Request) {
+    var productIDs []string
+    seen := make(map[string]bool)
+    for _, id := range strings.Split(r.URL.Query().Get("ids"), ",") {
+        if id = strings.TrimSpace(id); id != "" && !seen[id] {
+            seen[id] = true
+            productIDs = append(productIDs, id)
+        }
+    }
+    if len(productIDs) == 0 {
+        s.writeError(w, http.StatusBadRequest, "ids is required: a comma-separated list of product IDs")
+        return
+    }
+    if len(productIDs) > maxBatchHistoryProducts {
+        s.writeError(w, http.StatusBadRequest, fmt.Sprintf("Too many ids: at most %d are allowed", maxBatchHistoryProducts))
+        return
+    }
+
+    query, err := s.parseHistoryQuery(r)
+    if err != nil {
+        s.writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    results := make([]map[string]interface{}, 0, len(productIDs))
+    for _, productID := range productIDs {
+        var result map[string]interface{}
+        var err error
+        if s.foreignProduct(r, productID) {
+            err = fmt.Errorf("%w: %s", ErrProductNotFound, productID)
+        } else {
+            result, err = s.productHistory(r.Context(), productID, query)
+        }
+        if errors.Is(err, ErrProductNotFound) {
+            result = map[string]interface{}{"product_id": productID, "error": err.Error()}
+        } else if err != nil {
+            s.writeConversionError(w, err)
+            return
+        }
+        results = append(results, result)
+    }
+
+    s.writeJSON(w, http.StatusOK, map[string]interface{}{
+        "products": results,
+        "count":    len(results),
+    })
+}
+
+// historyQuery is the parsed query of a price history request
+type historyQuery struct {
+    // limit is the most entries to return, 50 unless given; bucketed
+    // history only applies a limit that was given
+    limit      int
+    limitGiven bool
+
+    from, to        time.Time
+    ranged          bool
+    includeOutliers bool
+    changesOnly     bool
+    interval        string
+    currency        string
+}
+
+// parseHistoryQuery reads a price history request's parameters
+func (s *APIServer) parseHistoryQuery(r *http.Request) (historyQuery, error) {
+    params := r.URL.Query()
+    query := historyQuery{limit: 50, interval: params.Get("interval")}
+
+    if limitStr := params.Get("limit"); limitStr != "" {
+        if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
+            query.limit = parsedLimit
+            query.limitGiven = true
+        }
+    }
+    query.includeOutliers, _ = strconv.ParseBool(params.Get("include_outliers"))
+    query.changesOnly, _ = strconv.ParseBool(params.Get("changes_only"))
+
+    var err error
+    if query.from, query.to, query.ranged, err = parseTimeRange(r); err != nil {
+        return query, err
+    }
+    if query.currency, err = s.displayCurrency(r); err != nil {
+        return query, err
+    }
+
+    if query.interval != "" {
+        if _, ok := historyBucketStarts[query.interval]; !ok {
+            return query, fmt.Errorf("Invalid interval %q: must be hour, day or week", query.interval)
+        }
+        if query.changesOnly {
+            return query, errors.New("changes_only can't be combined with interval")
+        }
+    }
+    return query, nil
+}
+
+// productHistory returns the response body for one product's history: its
+// entries, or with an interval its buckets
+func (s *APIServer) productHistory(ctx context.Context, productID string, query historyQuery) (map[string]interface{}, error) {
+    if query.interval != "" {
+        return s.productHistoryBuckets(ctx, productID, query)
+    }
+
+    var history []PriceEntry
+    var err error
+    if !query.ranged {
+        history, err = s.tracker.GetPriceHistory(productID, query.limit, query.includeOutliers)
+    } else {
+        history, err = s.tracker.GetPriceHistoryRange(productID, query.from, query.to, query.limit, query.includeOutliers)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    // optionally collapse flat stretches down to change-points
+    if query.changesOnly {
+        history = s.tracker.ChangePoints(productID, history)
+    }
+    if query.currency != "" {
+        if err := s.rates.ConvertEntries(ctx, history, query.currency); err != nil {
+            return nil, err
+        }
+    }
+
+    return map[string]interface{}{
+        "product_id": productID,
+        "history":    history,
+        "count":      len(history),
+    }, nil
+}
+
+// productHistoryBuckets groups a product's prices into buckets of the
+// query's interval. Only a given limit applies, keeping the newest buckets;
+// otherwise the time range bounds them.
+func (s *APIServer) productHistoryBuckets(ctx context.Context, productID string, query historyQuery) (map[string]interface{}, error) {
+    limit := 0
+    if query.limitGiven {
+        limit = query.limit
+    }
+
+    buckets, err := s.tracker.GetPriceHistoryBuckets(productID, query.interval, query.from, query.to, limit, query.includeOutliers)
+    if err != nil {
+        return nil, err
+    }
+    if query.currency != "" {
+        if err := s.rates.ConvertBuckets(ctx, buckets, query.currency); err != nil {
+            return nil, err
+        }
+    }
+
+    return map[string]interface{}{
+        "product_id": productID,
+        "interval":   query.interval,
+        "buckets":    buckets,
+        "count":      len(buckets),
+    }, nil
+}
+
+func (s *APIServer) handleGetArchivedHistory(w http.ResponseWriter, r *http.Request) {
+    productID := mux.Vars(r)["id"]
+
+    limit := 50
+    if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+        if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
+            limit = parsedLimit
+        }
+    }
+
+    history, err := s.tracker.GetArchivedHistory(productID, requestTenant(r), limit)
+    if err != nil {
+        s.writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    s.writeJSON(w, http.StatusOK, map[string]interface{}{
+        "product_id": productID,
+        "history":    history,
+        "count":      len(history),
+    })
+}
+
+// parseTimeRange reads the optional from and to query parameters, or their
+// aliases since and until. to defaults to now and from to the zero time;
+// ranged reports whether either was given. See parseTimeParam for the
+// accepted values.
+func parseTimeRange(r *http.Request) (from, to time.Time, ranged bool, err error) {
+    now := time.Now()
+    fromName, fromStr, err := timeParam(r, "from", "since")
+    if err != nil {
+        return from, to, false, err
+    }
+    toName, toStr, err := timeParam(r, "to", "until")
+    if err != nil {
+        return from, to, false, err
+    }
+
+    if fromStr != "" {
+        if from, err = parseTimeParam(fromStr, now); err != nil {
+            return from, to, false, fmt.Errorf("Invalid %s: %w", fromName, err)
+        }
+    }
+    to = now
+    if toStr != "" {
+        if to, err = parseTimeParam(toStr, now); err != nil {
+            return from, to, false, fmt.Errorf("Invalid %s: %w", toName, err)
+        }
+    }
+    if from.After(to) {
+        return from, to, false, fmt.Errorf("%s must not be after %s", fromName, toName)
+    }
+
+    return from, to, fromStr != "" || toStr != "", nil
+}
+
+// timeParam returns the name and value of whichever of a query parameter
+// and its alias was given, rejecting both
+func timeParam(r *http.Request, name, alias string) (string, string, error) {
+    value, aliased := r.URL.Query().Get(name), r.URL.Query().Get(alias)
+    switch {
+    case value != "" && aliased != "":
+        return "", "", fmt.Errorf("%s and %s can't both be given", name, alias)
+    case aliased != "":
+        return alias, aliased, nil
+    }
+    return name, value, nil
+}
+
+// errInvalidTimeParam explains a time query parameter that can't be read
+var errInvalidTimeParam = errors.New("must be an RFC3339 timestamp or a relative time such as -7d")
+
+// parseTimeParam reads a time query parameter: an RFC3339 timestamp, "now",
+// or a time relative to now such as -7d, -2w or -90m. Relative times count
+// back with s, m, h, d (24 hours) or w (7 days) units, or take any Go
+// duration such as -1h30m.
+func parseTimeParam(value string, now time.Time) (time.Time, error) {
+    if value == "now" {
+        return now, nil
+    }
+    if !strings.HasPrefix(value, "-") {
+        t, err := time.Parse(time.RFC3339, value)
+        if err != nil {
+            return t, errInvalidTimeParam
+        }
+        return t, nil
+    }
+
+    ago, err := parseLookback(value[1:])
+    if err != nil {
+        return time.Time{}, errInvalidTimeParam
+    }
+    return now.Add(-ago), nil
+}
+
+// parseLookback parses a non-negative span of time such as 7d, 2w or 90m:
+// any Go duration, or a whole number of d (24 hours) or w (7 days) units
+func parseLookback(value string) (time.Duration, error) {
+    units := map[byte]time.Duration{'d': 24 * time.Hour, 'w': 7 * 24 * time.Hour}
+    if value != "" {
+        if unit, ok := units[value[len(value)-1]]; ok {
+            n, err := strconv.Atoi(value[:len(value)-1])
+            if err != nil || n < 0 {
+                return 0, fmt.Errorf("invalid duration %q", value)
+            }
+            return time.Duration(n) * unit, nil
+        }
+    }
+    span, err := time.ParseDuration(value)
+    if err != nil {
+        return 0, err
+    }
+    if span < 0 {
+        return 0, fmt.Errorf("invalid duration %q", value)
+    }
+    return span, nil
+}
+
+func (s *APIServer) handleExportPriceHistory(w http.ResponseWriter, r *http.Request) {
+    productID := mux.Vars(r)["id"]
+
+    if _, err := s.tracker.GetProduct(productID); err != nil {
+        s.writeError(w, http.StatusNotFound, err.Error())
+        return
+    }
+
+    from, to, _, err := parseTimeRange(r)
+    if err != nil {
+        s.writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    s.writeHistoryCSV(w, r, productID, productID+"-history.csv", from, to)
+}
+
+// handleExportAllPriceHistory downloads every product's price history as one CSV file
+func (s *APIServer) handleExportAllPriceHistory(w http.ResponseWriter, r *http.Request) {
+    from, to, _, err := parseTimeRange(r)
+    if err != nil {
+        s.writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    s.writeHistoryCSV(w, r, "", "price-history.csv", from, to)
+}
+
+// writeHistoryCSV streams price entries between from and to as a CSV
+// attachment, for one product or, when productID is empty, every product of
+// the request's tenant
+func (s *APIServer) writeHistoryCSV(w http.ResponseWriter, r *http.Request, productID, filename string, from, to time.Time) {
+    includeOutliers, _ := strconv.ParseBool(r.URL.Query().Get("include_outliers"))
+    tenant := requestTenant(r)
+
+    anonymize := s.anonymizeExports
+    if v := r.URL.Query().Get("anonymize"); v != "" {
+        parsed, err := strconv.ParseBool(v)
+        if err != nil {
+            s.writeError(w, http.StatusBadRequest, "Invalid anonymize: must be true or false")
+            return
+        }
+        anonymize = parsed
+    }
+    // without a key the tokens of guessable IDs could be matched by hashing
+    // them, so anonymizing would only pretend to hide the IDs
+    if anonymize && !s.anonymizer.keyed() {
+        s.writeError(w, http.StatusBadRequest, "Anonymized exports need ANONYMIZATION_KEY to be set")
+        return
+    }
+    exportedID := func(id string) string { return id }
+    if anonymize {
+        exportedID = s.anonymizer.token
+        if productID != "" {
+            filename = exportedID(productID) + "-history.csv"
+        }
+    }
+
+    w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+    w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+    // rows are written as they are read, a page at a time, so long
+    // histories aren't held in memory
+    cw := csv.NewWriter(w)
+    cw.Write([]string{"id", "product_id", "price", "currency", "timestamp"})
+
+    err := s.tracker.ExportPriceHistory(productID, from, to, includeOutliers, func(entry PriceEntry) error {
+        if !s.tracker.ownsProduct(tenant, entry.ProductID) {
+            return nil
+        }
+        return cw.Write([]string{
+            strconv.Itoa(entry.ID),
+            exportedID(entry.ProductID),
+            strconv.FormatFloat(entry.Price, 'f', -1, 64),
+            entry.Currency,
+            entry.Timestamp.UTC().Format(time.RFC3339),
+        })
+    })
+    cw.Flush()
+
+    // headers are already sent, so a failure can only be logged
+    if err == nil {
+        err = cw.Error()
+    }
+    if err != nil {
+        log.Printf("CSV export %s failed: %v", filename, err)
+    }
+}
+
+func (s *APIServer) handleGetScrapeErrors(w http.ResponseWriter, r *http.Request) {
+    productID := mux.Vars(r)["id"]
+
+    limit := 50 // default
+    if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+        parsed, err := strconv.Atoi(limitStr)
+        if err != nil || parsed < 1 {
+            s.writeError(w, http.StatusBadRequest, "Invalid limit: must be a positive integer")
+            return
+        }
+        limit = parsed
+    }
+
+    scrapeErrors, err := s.tracker.GetScrapeErrors(productID, limit)
+    if errors.Is(err, ErrProductNotFound) {
+        s.writeError(w, http.StatusNotFound, err.Error())
+        return
+    }
+    if err != nil {
+        s.writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    s.writeJSON(w, http.StatusOK, map[string]interface{}{
+        "product_id": productID,
+        "errors":     scrapeErrors,
+        "count":      len(scrapeErrors),
+    })
+}
+
+func (s *APIServer) handleGetSnapshots(w http.ResponseWriter, r *http.Request) {
+    productID := mux.Vars(r)["id"]
+
+    snapshots, err := s.tracker.GetSnapshots(productID)
+    if errors.Is(err, ErrProductNotFound) {
+        s.writeError(w, http.StatusNotFound, err.Error())
+        return
+    }
+    if err != nil {
+        s.writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    s.writeJSON(w, http.StatusOK, map[string]interface{}{
+        "product_id": productID,
+        "snapshots":  snapshots,
+        "count":      len(snapshots),
+    })
+}
+
+// handleGetSnapshotPage serves a snapshot's page as plain text, so the
+// retailer's markup is never rendered or run on the tracker's origin
+func (s *APIServer) handleGetSnapshotPage(w http.ResponseWriter, r *http.Request) {
+    id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+    if err != nil {
+        s.writeError(w, http.StatusBadRequest, "Invalid snapshot ID")
+        return
+    }
+
+    snapshot, page, err := s.tracker.GetSnapshotPage(id)
+    if err == nil && s.foreignProduct(r, snapshot.ProductID) {
+        err = fmt.Errorf("%w: %d", ErrSnapshotNotFound, id)
+    }
+    if errors.Is(err, ErrSnapshotNotFound) {
+        s.writeError(w, http.StatusNotFound, err.Error())
+        return
+    }
+    if err != nil {
+        s.writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+    w.Header().Set("X-Content-Type-Options", "nosniff")
+    w.Header().Set("Content-Security-Policy", "sandbox")
+    w.WriteHeader(http.StatusOK)
+    w.Write(page)
+}
+
+func (s *APIServer) handleGetSaleWindows(w http.ResponseWriter, r *http.Request) {
+    productID := mux.Vars(r)["id"]
+
+    windows, err := s.tracker.GetSaleWindows(productID)
+    if err != nil {
+        s.writeError(w, http.StatusNotFound, err.Error())
+        return
+    }
+
+    s.writeJSON(w, http.StatusOK, map[string]interface{}{
+        "product_id":   productID,
+        "sale_windows": windows,
+        "count":        len(windows),
+    })
+}
+
+func (s *APIServer) handleAddSaleWindow(w http.ResponseWriter, r *http.Request) {
+    var window SaleWindow
+    if err := json.NewDecoder(r.Body).Decode(&window); err != nil {
+        s.writeError(w, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+        return
+    }
+    window.ProductID = mux.Vars(r)["id"]
+
+    created, err := s.tracker.AddSaleWindow(window)
+    if errors.Is(err, ErrProductNotFound) {
+        s.writeError(w, http.StatusNotFound, err.Error())
+        return
+    }
+    if err != nil {
+        s.writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    s.writeJSON(w, http.StatusCreated, created)
+}
+
+func (s *APIServer) handleProductImage(w http.ResponseWriter, r *http.Request) {
+    productID := mux.Vars(r)["id"]
+
+    contentType, data, err := s.tracker.ProductImage(r.Context(), productID)
+    if err != nil {
+        if errors.Is(err, ErrProductNotFound) || errors.Is(err, ErrNoImage) {
+            s.writeError(w, http.StatusNotFound, err.Error())
+            return
+        }
+        s.writeError(w, http.StatusBadGateway, err.Error())
+        return
+    }
+
+    w.Header().Set("Content-Type", contentType)
+    w.Header().Set("Cache-Control", "public, max-age=3600")
+    w.Write(data)
+}
+
+func (s *APIServer) handleWidget(w http.ResponseWriter, r *http.Request) {
+    productID := mux.Vars(r)["id"]
+
+    // render into a buffer so a failure can still be reported as JSON
+    var page bytes.Buffer
+    if err := s.tracker.RenderWidget(productID, r.URL.Query().Get("theme"), &page); err != nil {
+        if errors.Is(err, ErrProductNotFound) {
+            s.writeError(w, http.StatusNotFound, err.Error())
+            return
+        }
+        s.writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    // the widget is meant to be embedded in other sites' iframes
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    w.Header().Set("Content-Security-Policy", "frame-ancestors *")
+    w.Write(page.Bytes())
+}
+
+func (s *APIServer) handleGetBestDeal(w http.ResponseWriter, r *http.Request) {
+    productID := mux.Vars(r)["id"]
+
+    deal, err := s.tracker.GetBestDeal(productID)
+    if err != nil {
+        s.writeError(w, http.StatusNotFound, err.Error())
+        return
+    }
+
+    s.writeJSON(w, http.StatusOK, deal)
+}
+
+func (s *APIServer) handleGetPriceExtremes(w http.ResponseWriter, r *http.Request) {
+    productID := mux.Vars(r)["id"]
+
+    currency, err := s.displayCurrency(r)
+    if err != nil {
+        s.writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    extremes, err := s.tracker.GetPriceExtremes(productID)
+    if errors.Is(err, ErrProductNotFound) {
+        s.writeError(w, http.StatusNotFound, err.Error())
+        return
+    }
+    if err != nil {
+        s.writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    if currency != "" {
+        if err := s.rates.ConvertExtremes(r.Context(), &extremes, currency); err != nil {
+            s.writeConversionError(w, err)
+            return
+        }
+    }
+
+    s.writeJSON(w, http.StatusOK, extremes)
+}
+
+func (s *APIServer) handleGetPriceStats(w http.ResponseWriter, r *http.Request) {
+    productID := mux.Vars(r)["id"]
+
+    days := defaultStatsDays
+    if daysStr := r.URL.Query().Get("days"); daysStr != "" {
+        parsed, err := strconv.Atoi(daysStr)
+        if err != nil || parsed <= 0 {
+            s.writeError(w, http.StatusBadRequest, "Invalid days: "+daysStr)
+            return
+        }
+        days = parsed
+    }
+    currency, err := s.displayCurrency(r)
+    if err != nil {
+        s.writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    stats, err := s.tracker.GetPriceStats(productID, days)
+    if err != nil {
+        if errors.Is(err, ErrProductNotFound) {
+            s.writeError(w, http.StatusNotFound, err.Error())
+            return
+        }
+        s.writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    if currency != "" {
+        if err := s.rates.ConvertStats(r.Context(), &stats, currency); err != nil {
+            s.writeConversionError(w, err)
+            return
+        }
+    }
+
+    s.writeJSON(w, http.StatusOK, stats)
+}
+
+func (s *APIServer) handleGetBestTime(w http.ResponseWriter, r *http.Request) {
+    productID := mux.Vars(r)["id"]
+
+    analysis, err := s.tracker.GetBestTime(productID)
+    if err != nil {
+        if errors.Is(err, ErrProductNotFound) {
+            s.writeError(w, http.StatusNotFound, err.Error())
+            return
+        }
+        s.writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    s.writeJSON(w, http.StatusOK, analysis)
+}
+
+func (s *APIServer) handleGetElasticity(w http.ResponseWriter, r *http.Request) {
+    productID := mux.Vars(r)["id"]
+
+    hint, err := s.tracker.GetElasticity(productID)
+    if err != nil {
+        if errors.Is(err, ErrProductNotFound) {
+            s.writeError(w, http.StatusNotFound, err.Error())
+            return
+        }
+        s.writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    s.writeJSON(w, http.StatusOK, hint)
+}
+
+func (s *APIServer) handleGetBestDeals(w http.ResponseWriter, r *http.Request) {
+    deals, err := s.tracker.GetBestDeals(requestTenant(r))
+    if err != nil {
+        s.writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    s.writeJSON(w, http.StatusOK, map[string]interface{}{
+        "deals": deals,
+        "count": len(deals),
+    })
+}
+
+func (s *APIServer) handleGetRobotsBlocked(w http.ResponseWriter, r *http.Request) {
+    blocked := []RobotsBlock{}
+    for _, block := range s.tracker.RobotsBlocked() {
+        if !s.foreignProduct(r, block.ProductID) {
+            blocked = append(blocked, block)
+        }
+    }
+    s.writeJSON(w, http.StatusOK, map[string]interface{}{
+        "products": blocked,
+        "count":    len(blocked),
+    })
+}
+
+func (s *APIServer) handleGetPageChanges(w http.ResponseWriter, r *http.Request) {
+    all, err := s.tracker.PageChanges()
+    if err != nil {
+        s.writeError(w, http.StatusInternalServerError, "Failed to get page changes: "+err.Error())
+        return
+    }
+    changes := []PageFingerprint{}
+    for _, change := range all {
+        if !s.foreignProduct(r, change.ProductID) {
+            changes = append(changes, change)
+        }
+    }
+    s.writeJSON(w, http.StatusOK, map[string]interface{}{
+        "products": changes,
+        "count":    len(changes),
+    })
+}
+
+// handleGetNotifications lists queued webhook alerts, newest first, so
+// failed deliveries can be spotted
+func (s *APIServer) handleGetNotifications(w http.ResponseWriter, r *http.Request) {
+    status := r.URL.Query().Get("status")
+    switch status {
+    case "", "pending", "sending", "delivered", "failed":
+    default:
+        s.writeError(w, http.StatusBadRequest, "Invalid status: must be pending, sending, delivered or failed")
+        return
+    }
+
+    limit := 100
+    if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+        parsed, err := strconv.Atoi(limitStr)
+        if err != nil || parsed < 1 {
+            s.writeError(w, http.StatusBadRequest, "Invalid limit: must be a positive integer")
+            return
+        }
+        limit = min(parsed, 1000)
+    }
+
+    all, err := s.tracker.GetNotifications(status, limit)
+    if err != nil {
+        s.writeError(w, http.StatusInternalServerError, "Failed to get notifications: "+err.Error())
+        return
+    }
+    notifications := []Notification{}
+    for _, n := range all {
+        if !s.foreignProduct(r, n.ProductID) {
+            notifications = append(notifications, n)
+        }
+    }
+    s.writeJSON(w, http.StatusOK, map[string]interface{}{
+        "notifications": notifications,
+        "count":         len(notifications),
+    })
+}
+
+func (s *APIServer) handleGetCircuitBreakers(w http.ResponseWriter, r *http.Request) {
+    breakers := s.tracker.CircuitBreakers()
+    s.writeJSON(w, http.StatusOK, map[string]interface{}{
+        "hosts": breakers,
+        "count": len(breakers),
+    })
+}
+
+func (s *APIServer) handleGetFetchers(w http.ResponseWriter, r *http.Request) {
+    names := FetcherNames()
+    s.writeJSON(w, http.StatusOK, map[string]interface{}{
+        "fetchers": names,
+        "count":    len(names),
+    })
+}
+
+func (s *APIServer) handleGetBlockStats(w http.ResponseWriter, r *http.Request) {
+    stats := []BlockStats{}
+    total := 0
+    for _, st := range s.tracker.BlockStats() {
+        if !s.foreignProduct(r, st.ProductID) {
+            stats = append(stats, st)
+            total += st.Blocked
+        }
+    }
+    s.writeJSON(w, http.StatusOK, map[string]interface{}{
+        "products":     stats,
+        "count":        len(stats),
+        "total_blocks": total,
+    })
+}
+
+func (s *APIServer) handleSetOutlier(w http.ResponseWriter, r *http.Request) {
+    entryID, err := strconv.Atoi(mux.Vars(r)["id"])
+    if err != nil {
+        s.writeError(w, http.StatusBadRequest, "Invalid entry ID")
+        return
+    }
+
+    var req struct {
+        IsOutlier *bool `json:"is_outlier"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.IsOutlier == nil {
+        s.writeError(w, http.StatusBadRequest, `Body must be {"is_outlier": true|false}`)
+        return
+    }
+
+    if err := s.tracker.SetOutlier(entryID, requestTenant(r), *req.IsOutlier); err != nil {
+        if errors.Is(err, ErrEntryNotFound) {
+            s.writeError(w, http.StatusNotFound, err.Error())
+            return
+        }
+        s.writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    s.writeJSON(w, http.StatusOK, map[string]interface{}{
+        "id":         entryID,
+        "is_outlier": *req.IsOutlier,
+    })
+}
+
+func (s *APIServer) handleSetPriceScript(w http.ResponseWriter, r *http.Request) {
+    var req struct {
+        Script *string `json:"script"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Script == nil {
+        s.writeError(w, http.StatusBadRequest, `Body must be {"script": "..."}`)
+        return
+    }
+
+    product, err := s.tracker.SetPriceScript(mux.Vars(r)["id"], *req.Script)
+    if err != nil {
+        s.writeScriptError(w, err)
+        return
+    }
+    s.writeJSON(w, http.StatusOK, product)
+}
+
+func (s *APIServer) handleTryPriceScript(w http.ResponseWriter, r *http.Request) {
+    var req struct {
+        Script string `json:"script"`
+    }
+    if r.ContentLength != 0 {
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            s.writeError(w, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+            return
+        }
+    }
+
+    reading, err := s.tracker.TryPriceScript(r.Context(), mux.Vars(r)["id"], req.Script)
+    if err != nil {
+        s.writeScriptError(w, err)
+        return
+    }
+    s.writeJSON(w, http.StatusOK, map[string]interface{}{
+        "price":      reading.Price,
+        "in_stock":   reading.InStock,
+        "list_price": reading.ListPrice,
+        "shipping":   reading.Shipping,
+    })
+}
+
+// writeScriptError reports a price script that couldn't be set or tried
+func (s *APIServer) writeScriptError(w http.ResponseWriter, err error) {
+    switch {
+    case errors.Is(err, ErrProductNotFound):
+        s.writeError(w, http.StatusNotFound, err.Error())
+    case errors.Is(err, ErrInvalidScript):
+        s.writeError(w, http.StatusBadRequest, err.Error())
+    case errors.Is(err, ErrScriptFailed):
+        s.writeError(w, http.StatusUnprocessableEntity, err.Error())
+    case errors.Is(err, ErrScriptPageUnavailable):
+        s.writeError(w, http.StatusBadGateway, err.Error())
+    default:
+        s.writeError(w, http.StatusInternalServerError, err.Error())
+    }
+}
+
+// streamHeartbeat is how often an idle event stream sends a comment to keep
+// proxies from closing the connection
+const streamHeartbeat = 30 * time.Second
+
+// handleStream pushes every newly saved price entry to the client as a
+// Server-Sent Event until the client disconnects
+func (s *APIServer) handleStream(w http.ResponseWriter, r *http.Request) {
+    flusher, ok := w.(http.Flusher)
+    if !ok {
+        s.writeError(w, http.StatusInternalServerError, "Streaming is not supported")
+        return
+    }
+
+    entries, unsubscribe := s.tracker.Subscribe()
+    defer unsubscribe()
+
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.Header().Set("Connection", "keep-alive")
+    w.WriteHeader(http.StatusOK)
+    fmt.Fprint(w, ": connected\n\n")
+    flusher.Flush()
+
+    heartbeat := time.NewTicker(streamHeartbeat)
+    defer heartbeat.Stop()
+
+    for {
+        select {
+        case <-r.Context().Done():
+            return
+        case <-heartbeat.C:
+            fmt.Fprint(w, ": ping\n\n")
+            flusher.Flush()
+        case entry := <-entries:
+            if !s.tracker.ownsProduct(requestTenant(r), entry.ProductID) {
+                continue
+            }
+            data, err := json.Marshal(entry)
+            if err != nil {
+                log.Printf("Failed to encode price update: %v", err)
+                continue
+            }
+            fmt.Fprintf(w, "event: price\ndata: %s\n\n", data)
+            flusher.Flush()
+        }
+    }
+}
+
+func (s *APIServer) handleGetVelocity(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+
+    window := 6 * time.Hour // default
+    if windowStr := query.Get("window"); windowStr != "" {
+        parsed, err := time.ParseDuration(windowStr)
+        if err != nil || parsed <= 0 {
+            s.writeError(w, http.StatusBadRequest, "Invalid window: "+windowStr)
+            return
+        }
+        window = parsed
+    }
+
+    rising := query.Get("order") == "rising"
+    byDollars := query.Get("by") == "dollars"
+
+    velocities, err := s.tracker.GetVelocity(requestTenant(r), window, rising, byDollars)
+    if err != nil {
+        s.writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    s.writeJSON(w, http.StatusOK, map[string]interface{}{
+        "window":   window.String(),
+        "products": velocities,
+        "count":    len(velocities),
+    })
+}
+
+// defaultDealsLimit and maxDealsLimit bound how many products the price
+// drop leaderboard returns
+const (
+    defaultDealsLimit = 20
+    maxDealsLimit     = 100
+)
+
+func (s *APIServer) handleGetPriceDrops(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+
+    window := 24 * time.Hour // default
+    if windowStr := query.Get("window"); windowStr != "" {
+        parsed, err := parseLookback(windowStr)
+        if err != nil || parsed <= 0 {
+            s.writeError(w, http.StatusBadRequest, "Invalid window: "+windowStr)
+            return
+        }
+        window = parsed
+    }
+
+    limit := defaultDealsLimit
+    if limitStr := query.Get("limit"); limitStr != "" {
+        parsed, err := strconv.Atoi(limitStr)
+        if err != nil || parsed < 1 {
+            s.writeError(w, http.StatusBadRequest, "Invalid limit: must be a positive integer")
+            return
+        }
+        limit = min(parsed, maxDealsLimit)
+    }
+
+    drops, err := s.tracker.GetPriceDrops(requestTenant(r), window, limit)
+    if err != nil {
+        s.writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    s.writeJSON(w, http.StatusOK, map[string]interface{}{
+        "window":   window.String(),
+        "products": drops,
+        "count":    len(drops),
+    })
+}
+
+func (s *APIServer) handleAnalyzeBasket(w http.ResponseWriter, r *http.Request) {
+    var req struct {
+        Items []BasketItem `json:"items"`
+        Days  int          `json:"days"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        s.writeError(w, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+        return
+    }
+
+    for _, item := range req.Items {
+        if s.foreignProduct(r, item.ProductID) {
+            s.writeError(w, http.StatusNotFound, fmt.Sprintf("%v: %s", ErrProductNotFound, item.ProductID))
+            return
+        }
+    }
+
+    analysis, err := s.tracker.AnalyzeBasket(req.Items, req.Days)
+    if errors.Is(err, ErrProductNotFound) {
+        s.writeError(w, http.StatusNotFound, err.Error())
+        return
+    }
+    if err != nil {
+        s.writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    s.writeJSON(w, http.StatusOK, analysis)
+}
+
+func (s *APIServer) handleCheckAll(w http.ResponseWriter, r *http.Request) {
+    job := s.tracker.CheckAll(requestTenant(r))
+    s.writeJSON(w, http.StatusAccepted, job)
+}
+
+// handleCheckProduct fetches one product's price now and returns it, or
+// with ?async=true starts a job that does and returns the job
+func (s *APIServer) handleCheckProduct(w http.ResponseWriter, r *http.Request) {
+    productID := mux.Vars(r)["id"]
+
+    if async, _ := strconv.ParseBool(r.URL.Query().Get("async")); async {
+        job, err := s.tracker.StartProductCheck(productID)
+        if errors.Is(err, ErrProductNotFound) {
+            s.writeError(w, http.StatusNotFound, err.Error())
+            return
+        }
+        if err != nil {
+            s.writeError(w, http.StatusInternalServerError, err.Error())
+            return
+        }
+        s.writeJSON(w, http.StatusAccepted, job)
+        return
+    }
+
+    check, err := s.tracker.CheckProduct(r.Context(), productID)
+    switch {
+    case errors.Is(err, ErrProductNotFound):
+        s.writeError(w, http.StatusNotFound, err.Error())
+    case errors.Is(err, ErrCheckFailed):
+        s.writeError(w, http.StatusBadGateway, err.Error())
+    case err != nil:
+        s.writeError(w, http.StatusInternalServerError, err.Error())
+    default:
+        s.writeJSON(w, http.StatusOK, check)
+    }
+}
+
+func (s *APIServer) handleValidateAll(w http.ResponseWriter, r *http.Request) {
+    job := s.tracker.ValidateAll(requestTenant(r))
+    s.writeJSON(w, http.StatusAccepted, job)
+}
+
+func (s *APIServer) handlePreviewCatalog(w http.ResponseWriter, r *http.Request) {
+    var req CatalogRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        s.writeError(w, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+        return
+    }
+
+    preview, err := s.tracker.PreviewCatalog(r.Context(), req, requestTenant(r))
+    if err != nil {
+        s.writeCatalogError(w, err)
+        return
+    }
+    s.writeJSON(w, http.StatusOK, preview)
+}
+
+// maxImportBytes caps the size of a bulk product import
+const maxImportBytes = 10 << 20
+
+// handleImportProducts adds products in bulk from a JSON array of products,
+// a CSV body or a CSV file uploaded as the multipart field "file", and
+// reports the outcome of every row
+func (s *APIServer) handleImportProducts(w http.ResponseWriter, r *http.Request) {
+    r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+    mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+    var summary ImportSummary
+    var err error
+    switch mediaType {
+    case "application/json":
+        var products []Product
+        if err = json.NewDecoder(r.Body).Decode(&products); err != nil && !isTooLarge(err) {
+            s.writeError(w, http.StatusBadRequest, "Invalid JSON body: must be an array of products: "+err.Error())
+            return
+        }
+        summary = s.tracker.ImportProductList(products, requestTenant(r))
+    case "text/csv":
+        summary, err = s.tracker.ImportProducts(r.Body, requestTenant(r))
+    case "multipart/form-data":
+        var file multipart.File
+        if file, _, err = r.FormFile("file"); err != nil && !isTooLarge(err) {
+            s.writeError(w, http.StatusBadRequest, "Invalid upload: expected a CSV file in the \"file\" field")
+            return
+        }
+        if err == nil {
+            defer file.Close()
+            summary, err = s.tracker.ImportProducts(file, requestTenant(r))
+        }
+    default:
+        s.writeError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json, text/csv or multipart/form-data")
+        return
+    }
+
+    if isTooLarge(err) {
+        s.writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Import is larger than %d bytes", maxImportBytes))
+        return
+    }
+    if err != nil {
+        s.writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    s.writeJSON(w, http.StatusOK, summary)
+}
+
+// isTooLarge reports whether err came from reading past an
+// http.MaxBytesReader's limit
+func isTooLarge(err error) bool {
+    var tooLarge *http.MaxBytesError
+    return errors.As(err, &tooLarge)
 }
 
-func NewAPIServer(tracker *PriceTracker) *APIServer {
-    server := &APIServer{
-        tracker: tracker,
-        router:  mux.NewRouter(),
+func (s *APIServer) handleImportCatalog(w http.ResponseWriter, r *http.Request) {
+    var req CatalogRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        s.writeError(w, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+        return
     }
 
-    server.setupRoutes()
-    return server
+    job, err := s.tracker.ImportCatalog(r.Context(), req, requestTenant(r))
+    if err != nil {
+        s.writeCatalogError(w, err)
+        return
+    }
+    s.writeJSON(w, http.StatusAccepted, job)
 }
 
-func (s *APIServer) setupRoutes() {
-    api := s.router.PathPrefix("/api/v1").Subrouter()
+// writeCatalogError reports a catalog that couldn't be enumerated
+func (s *APIServer) writeCatalogError(w http.ResponseWriter, err error) {
+    switch {
+    case errors.Is(err, ErrInvalidCatalog):
+        s.writeError(w, http.StatusBadRequest, err.Error())
+    case errors.Is(err, ErrCatalogUnavailable):
+        s.writeError(w, http.StatusBadGateway, err.Error())
+    default:
+        s.writeError(w, http.StatusInternalServerError, err.Error())
+    }
+}
 
-    api.HandleFunc("/products", s.handleGetProducts).Methods("GET")
-    api.HandleFunc("/products/{id}/history", s.handleGetPriceHistory).Methods("GET")
-    api.HandleFunc("/health", s.handleHealth).Methods("GET")
+func (s *APIServer) handleGetJob(w http.ResponseWriter, r *http.Request) {
+    jobID := mux.Vars(r)["id"]
 
-    // serve a simple HTML page at root
-    s.router.HandleFunc("/", s.handleRoot).Methods("GET")
+    job, ok := s.tracker.GetJob(jobID)
+    if !ok || job.Tenant != requestTenant(r) {
+        s.writeError(w, http.StatusNotFound, "job not found: "+jobID)
+        return
+    }
 
-    // add middleware
-    s.router.Use(s.loggingMiddleware)
-    s.router.Use(s.corsMiddleware)
+    s.writeJSON(w, http.StatusOK, job)
 }
 
-func (s *APIServer) handleGetProducts(w http.ResponseWriter, r *http.Request) {
-    products := s.tracker.GetProducts()
-    s.writeJSON(w, http.StatusOK, products)
+func (s *APIServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+    s.writeJSON(w, http.StatusOK, map[string]interface{}{
+        "db_queries": s.tracker.QueryTimings(),
+    })
 }
 
-func (s *APIServer) handleGetPriceHistory(w http.ResponseWriter, r *http.Request) {
-    vars := mux.Vars(r)
-    productID := vars["id"]
+// healthTimeout caps how long the health check waits on the database
+const healthTimeout = 2 * time.Second
 
-    if productID == "" {
-        s.writeError(w, http.StatusBadRequest, "Product ID is required")
-        return
+// handleHealth reports 503 when the database can't be reached, so it can
+// serve as a readiness probe
+func (s *APIServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+    response := map[string]interface{}{
+        "status":   "ok",
+        "time":     time.Now().Format(time.RFC3339),
+        "products": s.tracker.ProductCount(),
+        "tracking": s.tracker.TrackingStatus(),
     }
 
-    // parse limit parameter
-    limit := 50 // default
-    if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
-        if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
-            limit = parsedLimit
-        }
-    }
+    ctx, cancel := context.WithTimeout(r.Context(), healthTimeout)
+    defer cancel()
 
-    history, err := s.tracker.GetPriceHistory(productID, limit)
+    err := s.tracker.Ping(ctx)
+    if err == nil {
+        var lastPrice *time.Time
+        lastPrice, err = s.tracker.LastPriceTime()
+        response["last_price_at"] = lastPrice
+    }
     if err != nil {
-        s.writeError(w, http.StatusNotFound, err.Error())
+        response["status"] = "unhealthy"
+        response["error"] = err.Error()
+        s.writeJSON(w, http.StatusServiceUnavailable, response)
         return
     }
 
-    s.writeJSON(w, http.StatusOK, map[string]interface{}{
-        "product_id": productID,
-        "history":    history,
-        "count":      len(history),
-    })
-}
-
-func (s *APIServer) handleHealth(w http.ResponseWriter, r *http.Request) {
-    s.writeJSON(w, http.StatusOK, map[string]string{
-        "status": "ok",
-        "time":   time.Now().Format(time.RFC3339),
-    })
+    s.writeJSON(w, http.StatusOK, response)
 }
 
 func (s *APIServer) handleRoot(w http.ResponseWriter, r *http.Request) {
@@ -91,22 +1664,87 @@ func (s *APIServer) handleRoot(w http.ResponseWriter, r *http.Request) {
         body { font-family: Arial, sans-serif; margin: 40px; }
         .endpoint { margin: 20px 0; padding: 10px; background: #f5f5f5; border-radius: 5px; }
         code { background: #e9e9e9; padding: 2px 6px; border-radius: 3px; }
+        .controls { margin: 10px 0; }
+        .controls input, .controls select { padding: 4px; margin-right: 8px; }
+        table { border-collapse: collapse; width: 100%; margin-bottom: 20px; }
+        th, td { text-align: left; padding: 6px 10px; border-bottom: 1px solid #e5e5e5; }
+        th { background: #fafafa; }
+        .down { color: #1a8f3c; }
+        .up { color: #c62828; }
+        .muted { color: #888; }
     </style>
 </head>
 <body>
-    <h1>Product Price Tracker API</h1>
+    <h1>Product Price Tracker</h1>
+
+    <h2>Live Prices</h2>
+    <div class="controls">
+        <input id="filter" type="text" placeholder="Filter by name or ID">
+        <select id="sort">
+            <option value="name">Sort by name</option>
+            <option value="price">Sort by price</option>
+            <option value="change">Sort by change</option>
+            <option value="updated">Sort by last update</option>
+        </select>
+        <span id="status" class="muted"></span>
+    </div>
+    <div id="dashboard"><p class="muted">Loading...</p></div>
+
+    <h2>API Endpoints</h2>
     <p>Welcome to the Price Tracker API. Available endpoints:</p>
 
     <div class="endpoint">
         <h3>GET /api/v1/products</h3>
-        <p>Get all tracked products with their latest prices</p>
+        <p>Get a page of tracked products with their latest prices (<code>limit</code> up to 200, default 50, and <code>offset</code>); <code>?currency=EUR</code> converts the prices. Filter with <code>q</code>, <code>min_price</code>, <code>max_price</code> and <code>updated_since</code>, and sort with <code>sort=latest_price&amp;order=desc</code></p>
         <p><a href="/api/v1/products">Try it</a></p>
     </div>
 
+    <div class="endpoint">
+        <h3>POST /api/v1/products</h3>
+        <p>Start tracking a new product: <code>{"id", "name", "url"}</code> plus optional <code>priority</code> and one of <code>price_selector</code>, <code>price_xpath</code> or <code>price_regex</code>, and <code>ignore_robots</code>. With only a <code>url</code> the name, image and currency are read from the page. Returns 409 if the ID is taken</p>
+    </div>
+
+    <div class="endpoint">
+        <h3>POST /api/v1/products/import</h3>
+        <p>Add products in bulk from a JSON array or a CSV file, with the outcome of every row</p>
+    </div>
+
+    <div class="endpoint">
+        <h3>GET /api/v1/products/search?q=laptop</h3>
+        <p>Full-text search over product names, URLs, tags and notes, best match first</p>
+        <p><a href="/api/v1/products/search?q=laptop">Try it</a></p>
+    </div>
+
+    <div class="endpoint">
+        <h3>GET /api/v1/products/{id}</h3>
+        <p>A product's settings</p>
+        <p><a href="/api/v1/products/laptop-1">laptop-1</a></p>
+    </div>
+
+    <div class="endpoint">
+        <h3>PUT /api/v1/products/{id}</h3>
+        <p>Replace a product's settings; they apply from its next check</p>
+    </div>
+
+    <div class="endpoint">
+        <h3>PATCH /api/v1/products/{id}</h3>
+        <p>Change only the settings in the body, e.g. <code>{"price_selector": ".price", "interval_seconds": 600}</code></p>
+    </div>
+
+    <div class="endpoint">
+        <h3>DELETE /api/v1/products/{id}</h3>
+        <p>Stop tracking a product, archiving its price history, or deleting it with <code>?purge=true</code></p>
+    </div>
+
+    <div class="endpoint">
+        <h3>GET /api/v1/archive/{id}/history</h3>
+        <p>Price history archived when a product was deleted</p>
+    </div>
+
     <div class="endpoint">
         <h3>GET /api/v1/products/{id}/history</h3>
         <p>Get price history for a specific product</p>
-        <p>Parameters: <code>?limit=N</code> (default: 50)</p>
+        <p>Parameters: <code>?limit=N</code> (default: 50), <code>?from=</code> / <code>?to=</code> (time range: RFC3339 or relative, like <code>-7d</code>; <code>since</code> / <code>until</code> also work), <code>?changes_only=true</code> (only points where the price changed), <code>?interval=day</code> (open/close/min/max/avg per hour, day or week), <code>?include_outliers=true</code>, <code>?currency=EUR</code> (convert prices)</p>
         <p>Examples:</p>
         <ul>
             <li><a href="/api/v1/products/laptop-1/history">laptop-1 history</a></li>
@@ -115,11 +1753,404 @@ func (s *APIServer) handleRoot(w http.ResponseWriter, r *http.Request) {
         </ul>
     </div>
 
+    <div class="endpoint">
+        <h3>GET /api/v1/products/{id}/history.csv</h3>
+        <p>Download the full price history as CSV; accepts <code>?from=</code> / <code>?to=</code>, <code>?include_outliers=true</code> and <code>?anonymize=true</code> (product IDs replaced by stable tokens)</p>
+        <p><a href="/api/v1/products/laptop-1/history.csv">laptop-1 history.csv</a></p>
+    </div>
+
+    <div class="endpoint">
+        <h3>GET /api/v1/history/batch?ids=laptop-1,phone-1</h3>
+        <p>Price history of several products in one response; takes the same parameters as a single product's history</p>
+        <p><a href="/api/v1/history/batch?ids=laptop-1,phone-1">laptop-1 and phone-1</a></p>
+    </div>
+
+    <div class="endpoint">
+        <h3>GET /api/v1/history.csv</h3>
+        <p>Download every product's price history as one CSV file; takes the same parameters</p>
+        <p><a href="/api/v1/history.csv">price-history.csv</a></p>
+    </div>
+
+    <div class="endpoint">
+        <h3>GET /api/v1/products/{id}/sale-windows</h3>
+        <p>Known sale periods for a product; <code>POST</code> a <code>{"name", "starts_at", "ends_at"}</code> body to add one</p>
+        <p><a href="/api/v1/products/laptop-1/sale-windows">laptop-1 sale windows</a></p>
+    </div>
+
+    <div class="endpoint">
+        <h3>GET /api/v1/products/{id}/image</h3>
+        <p>The product image, proxied and cached so pages don't hotlink the retailer</p>
+    </div>
+
+    <div class="endpoint">
+        <h3>GET /api/v1/products/{id}/widget.html</h3>
+        <p>Embeddable price widget with a mini chart. Parameters: <code>?theme=light|dark</code></p>
+        <p><a href="/api/v1/products/laptop-1/widget.html">laptop-1 widget</a></p>
+    </div>
+
+    <div class="endpoint">
+        <h3>GET /api/v1/products/{id}/extremes</h3>
+        <p>All-time lowest and highest prices with when they were recorded, and whether the current price is the all-time low</p>
+        <p><a href="/api/v1/products/laptop-1/extremes">laptop-1 extremes</a></p>
+    </div>
+
+    <div class="endpoint">
+        <h3>GET /api/v1/products/{id}/stats</h3>
+        <p>Minimum, maximum, average, median and standard deviation of the price over the last N days, the latest price and how far it is from the low, and its change over 7, 30 and 90 days</p>
+        <p>Parameters: <code>?days=N</code> (default: 30)</p>
+        <p><a href="/api/v1/products/laptop-1/stats?days=7">laptop-1 stats (7 days)</a></p>
+    </div>
+
+    <div class="endpoint">
+        <h3>GET /api/v1/products/{id}/scrape-errors</h3>
+        <p>A product's most recent failed fetches, after retries, newest first</p>
+        <p>Parameters: <code>?limit=N</code> (default: 50)</p>
+        <p><a href="/api/v1/products/laptop-1/scrape-errors">laptop-1 scrape errors</a></p>
+    </div>
+
+    <div class="endpoint">
+        <h3>GET /api/v1/products/{id}/best-deal</h3>
+        <p>Compare a product's current price with its all-time low</p>
+        <p><a href="/api/v1/products/laptop-1/best-deal">laptop-1 best deal</a></p>
+    </div>
+
+    <div class="endpoint">
+        <h3>GET /api/v1/products/{id}/best-time</h3>
+        <p>Which day of the week and part of the month have historically been cheapest</p>
+        <p><a href="/api/v1/products/laptop-1/best-time">laptop-1 best time to buy</a></p>
+    </div>
+
+    <div class="endpoint">
+        <h3>GET /api/v1/products/{id}/elasticity</h3>
+        <p>A rough hint of how often the product sold out soon after a price drop compared with after a rise</p>
+        <p><a href="/api/v1/products/laptop-1/elasticity">laptop-1 elasticity</a></p>
+    </div>
+
+    <div class="endpoint">
+        <h3>PUT /api/v1/products/{id}/price-script</h3>
+        <p>Set the price script that reads a product's page (<code>{"script": "..."}</code>); it applies from the next check</p>
+    </div>
+
+    <div class="endpoint">
+        <h3>POST /api/v1/products/{id}/price-script/try</h3>
+        <p>Fetch a product's page and run a price script against it without saving anything</p>
+    </div>
+
+    <div class="endpoint">
+        <h3>GET /api/v1/best-deals</h3>
+        <p>Products ranked by how close today's price is to their all-time low</p>
+        <p><a href="/api/v1/best-deals">Try it</a></p>
+    </div>
+
+    <div class="endpoint">
+        <h3>PUT /api/v1/entries/{id}/outlier</h3>
+        <p>Flag or unflag a price entry as an outlier with <code>{"is_outlier": true}</code></p>
+    </div>
+
+    <div class="endpoint">
+        <h3>GET /api/v1/velocity</h3>
+        <p>Products ranked by how fast their price is moving, fastest drops first</p>
+        <p>Parameters: <code>?window=6h</code>, <code>?order=rising</code>, <code>?by=dollars</code></p>
+        <p><a href="/api/v1/velocity?window=1h">Try it</a></p>
+    </div>
+
+    <div class="endpoint">
+        <h3>GET /api/v1/deals</h3>
+        <p>Products ranked by how far their price dropped over a window, largest percentage drop first</p>
+        <p>Parameters: <code>?window=24h</code> (or <code>7d</code>, <code>2w</code>), <code>?limit=20</code></p>
+        <p><a href="/api/v1/deals?window=24h">Try it</a></p>
+    </div>
+
+    <div class="endpoint">
+        <h3>GET /api/v1/stream</h3>
+        <p>Server-Sent Events stream with a <code>price</code> event for every saved price entry</p>
+    </div>
+
+    <div class="endpoint">
+        <h3>POST /api/v1/basket</h3>
+        <p>Current and cheapest total for a basket of products over the last N days</p>
+        <p>Body: <code>{"items": [{"product_id": "laptop-1", "quantity": 1}], "days": 30}</code></p>
+    </div>
+
+    <div class="endpoint">
+        <h3>GET /api/v1/robots-blocked</h3>
+        <p>Products that aren't being fetched because the site's robots.txt disallows their page</p>
+        <p><a href="/api/v1/robots-blocked">Try it</a></p>
+    </div>
+
+    <div class="endpoint">
+        <h3>GET /api/v1/page-changes</h3>
+        <p>Products whose fingerprinted page region changed markup, a sign their price rule may soon break</p>
+        <p><a href="/api/v1/page-changes">Try it</a></p>
+    </div>
+
+    <div class="endpoint">
+        <h3>GET /api/v1/circuit-breakers</h3>
+        <p>Hosts that have been refusing or throttling fetches, and whether their circuit breaker is open</p>
+        <p><a href="/api/v1/circuit-breakers">Try it</a></p>
+    </div>
+
+    <div class="endpoint">
+        <h3>GET /api/v1/diagnostics/blocks</h3>
+        <p>How often each product's fetches were refused by anti-bot protection (Cloudflare, Amazon robot check, CAPTCHAs) or HTTP 429</p>
+        <p><a href="/api/v1/diagnostics/blocks">Try it</a></p>
+    </div>
+
+    <div class="endpoint">
+        <h3>GET /api/v1/fetchers</h3>
+        <p>Names of the registered fetchers a product can select with its <code>fetcher</code> field</p>
+        <p><a href="/api/v1/fetchers">Try it</a></p>
+    </div>
+
+    <div class="endpoint">
+        <h3>POST /api/v1/products/{id}/check</h3>
+        <p>Fetch and store one product's price now and return it; <code>?async=true</code> returns a job to poll instead</p>
+    </div>
+
+    <div class="endpoint">
+        <h3>POST /api/v1/check-all</h3>
+        <p>Trigger an immediate price check for every product; returns a job to poll</p>
+    </div>
+
+    <div class="endpoint">
+        <h3>POST /api/v1/validate-all</h3>
+        <p>Dry-run fetch of every product without storing anything; poll the returned job for the report</p>
+    </div>
+
+    <div class="endpoint">
+        <h3>POST /api/v1/catalog/preview</h3>
+        <p>List the product links on a category page (<code>url</code> plus <code>link_selector</code>) or sitemap, optionally filtered by <code>url_pattern</code></p>
+    </div>
+
+    <div class="endpoint">
+        <h3>POST /api/v1/catalog/import</h3>
+        <p>Add the untracked products a catalog preview lists, with the settings in <code>template</code>; returns a job to poll</p>
+    </div>
+
+    <div class="endpoint">
+        <h3>GET /api/v1/jobs/{id}</h3>
+        <p>Progress of an on-demand job (checked, succeeded, failed, done)</p>
+    </div>
+
+    <div class="endpoint">
+        <h3>GET /api/v1/metrics</h3>
+        <p>Database query latency per query type</p>
+        <p><a href="/api/v1/metrics">Try it</a></p>
+    </div>
+
+    <div class="endpoint">
+        <h3>GET /api/v1/admin/products/{id}/snapshots</h3>
+        <p>Pages saved when no price could be read from them, newest first (needs the API key)</p>
+    </div>
+
+    <div class="endpoint">
+        <h3>GET /api/v1/admin/snapshots/{id}</h3>
+        <p>The saved page as plain text, for debugging a broken selector (needs the API key)</p>
+    </div>
+
+    <div class="endpoint">
+        <h3>GET /api/v1/admin/notifications</h3>
+        <p>Queued webhook alerts and whether they were delivered, newest first; filter with ?status=failed (needs the API key)</p>
+    </div>
+
+    <div class="endpoint">
+        <h3>POST /graphql</h3>
+        <p>Read-only GraphQL queries over products, their history and stats, and reached target prices, when <code>GRAPHQL_ENABLED</code> is set</p>
+    </div>
+
+    <div class="endpoint">
+        <h3>GET /metrics</h3>
+        <p>Prometheus metrics: fetch counts, failures, durations and tracked products</p>
+        <p><a href="/metrics">Try it</a></p>
+    </div>
+
     <div class="endpoint">
         <h3>GET /api/v1/health</h3>
         <p>Health check endpoint</p>
         <p><a href="/api/v1/health">Try it</a></p>
     </div>
+
+    <script>
+    // Live dashboard. Products are grouped by tag or category when the API
+    // provides them, and sparklines come from the history endpoint; both
+    // degrade to a plain list if unavailable. ?currency=EUR on the page
+    // converts prices and ?tz=Europe/Paris shows times in that zone.
+    var products = [];
+    var params = new URLSearchParams(window.location.search);
+    var currencyParam = params.get("currency") ? "&currency=" + encodeURIComponent(params.get("currency")) : "";
+    var timeZone = params.get("tz") || undefined;
+
+    function when(timestamp) {
+        try {
+            return new Date(timestamp).toLocaleString(undefined, {timeZone: timeZone});
+        } catch (e) {
+            return new Date(timestamp).toLocaleString();
+        }
+    }
+
+    function money(value, currency) {
+        if (value == null) {
+            return "-";
+        }
+        try {
+            return new Intl.NumberFormat(undefined, {style: "currency", currency: currency || "USD"}).format(value);
+        } catch (e) {
+            return value.toFixed(2) + " " + currency;
+        }
+    }
+
+    function groupOf(product) {
+        if (product.tags && product.tags.length) {
+            return product.tags[0];
+        }
+        return product.category || "All products";
+    }
+
+    function sparkline(history) {
+        var svgNS = "http://www.w3.org/2000/svg";
+        var svg = document.createElementNS(svgNS, "svg");
+        svg.setAttribute("width", "120");
+        svg.setAttribute("height", "24");
+        if (!history || history.length < 2) {
+            return svg;
+        }
+        var prices = history.map(function (e) { return e.price; }).reverse();
+        var low = Math.min.apply(null, prices), high = Math.max.apply(null, prices);
+        var spread = (high - low) || 1;
+        var points = prices.map(function (p, i) {
+            return (i / (prices.length - 1) * 120).toFixed(1) + "," + (1 + (high - p) / spread * 22).toFixed(1);
+        });
+        var line = document.createElementNS(svgNS, "polyline");
+        line.setAttribute("points", points.join(" "));
+        line.setAttribute("fill", "none");
+        line.setAttribute("stroke", prices[prices.length - 1] <= prices[0] ? "#1a8f3c" : "#c62828");
+        line.setAttribute("stroke-width", "1.5");
+        svg.appendChild(line);
+        return svg;
+    }
+
+    function cell(row, text, className) {
+        var td = document.createElement("td");
+        if (text instanceof Node) {
+            td.appendChild(text);
+        } else {
+            td.textContent = text;
+        }
+        if (className) {
+            td.className = className;
+        }
+        row.appendChild(td);
+    }
+
+    function render() {
+        var filter = document.getElementById("filter").value.toLowerCase();
+        var sortBy = document.getElementById("sort").value;
+
+        var visible = products.filter(function (p) {
+            return !filter || p.name.toLowerCase().indexOf(filter) >= 0 || p.id.toLowerCase().indexOf(filter) >= 0;
+        });
+        visible.sort(function (a, b) {
+            if (sortBy === "price") return (a.latest_price || 0) - (b.latest_price || 0);
+            if (sortBy === "change") return (a.change || 0) - (b.change || 0);
+            if (sortBy === "updated") return (b.last_updated || "").localeCompare(a.last_updated || "");
+            return a.name.localeCompare(b.name);
+        });
+
+        var groups = {};
+        visible.forEach(function (p) {
+            (groups[groupOf(p)] = groups[groupOf(p)] || []).push(p);
+        });
+
+        var dashboard = document.getElementById("dashboard");
+        dashboard.innerHTML = "";
+        Object.keys(groups).sort().forEach(function (name) {
+            var heading = document.createElement("h3");
+            heading.textContent = name;
+            dashboard.appendChild(heading);
+
+            var table = document.createElement("table");
+            var header = document.createElement("tr");
+            ["Product", "Price", "Change", "Trend", "Last updated"].forEach(function (title) {
+                var th = document.createElement("th");
+                th.textContent = title;
+                header.appendChild(th);
+            });
+            table.appendChild(header);
+
+            groups[name].forEach(function (p) {
+                var row = document.createElement("tr");
+                var link = document.createElement("a");
+                link.href = p.url;
+                link.textContent = p.name;
+                cell(row, link);
+                cell(row, money(p.latest_price, p.currency));
+                if (p.change == null || p.change === 0) {
+                    cell(row, "-", "muted");
+                } else {
+                    var sign = p.change > 0 ? "+" : "";
+                    cell(row, sign + p.change.toFixed(2) + " (" + sign + p.change_percent.toFixed(1) + "%)", p.change < 0 ? "down" : "up");
+                }
+                cell(row, sparkline(p.history));
+                cell(row, p.last_updated ? when(p.last_updated) : "-", "muted");
+                table.appendChild(row);
+            });
+            dashboard.appendChild(table);
+        });
+
+        if (!visible.length) {
+            dashboard.innerHTML = '<p class="muted">No products to show.</p>';
+        }
+    }
+
+    // fetch every page of the products listing
+    function loadProducts(offset, list) {
+        return fetch("/api/v1/products?limit=200&offset=" + offset + currencyParam)
+            .then(function (r) {
+                if (!r.ok) {
+                    throw new Error("products returned " + r.status);
+                }
+                return r.json();
+            })
+            .then(function (page) {
+                list = list.concat(page.items || []);
+                if (page.items && page.items.length && list.length < page.total) {
+                    return loadProducts(list.length, list);
+                }
+                return list;
+            });
+    }
+
+    function load() {
+        loadProducts(0, [])
+            .then(function (list) {
+                return Promise.all(list.map(function (p) {
+                    return fetch("/api/v1/products/" + encodeURIComponent(p.id) + "/history?limit=30")
+                        .then(function (r) { return r.ok ? r.json() : null; })
+                        .catch(function () { return null; })
+                        .then(function (h) {
+                            p.history = h ? h.history : null;
+                            if (p.previous_price != null && p.change_percent != null) {
+                                p.change = p.latest_price - p.previous_price;
+                            }
+                            return p;
+                        });
+                }));
+            })
+            .then(function (list) {
+                products = list;
+                document.getElementById("status").textContent = "Updated " + new Date().toLocaleTimeString();
+                render();
+            })
+            .catch(function (err) {
+                document.getElementById("status").textContent = "Failed to load products: " + err;
+            });
+    }
+
+    document.getElementById("filter").addEventListener("input", render);
+    document.getElementById("sort").addEventListener("change", render);
+    load();
+    setInterval(load, 30000);
+    </script>
 </body>
 </html>`
     w.Header().Set("Content-Type", "text/html")
@@ -146,11 +2177,83 @@ func (s *APIServer) loggingMiddleware(next http.Handler) http.Handler {
     })
 }
 
+func (s *APIServer) authMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if s.apiKey == "" && len(s.tenantKeys) == 0 {
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        // a key that is given must be valid, and says which tenant the
+        // request acts for
+        key := requestKey(r)
+        tenant, ok := s.keyTenant(key)
+        if key != "" && !ok {
+            s.writeError(w, http.StatusUnauthorized, "Invalid API key")
+            return
+        }
+        r = r.WithContext(context.WithValue(r.Context(), tenantContextKey{}, tenant))
+
+        // writes need a key; reads, including GraphQL queries, which can't
+        // write, are public apart from the admin endpoints (see
+        // adminMiddleware)
+        switch {
+        case r.URL.Path == "/graphql":
+            next.ServeHTTP(w, r)
+            return
+        case r.Method == http.MethodPost, r.Method == http.MethodPut, r.Method == http.MethodPatch, r.Method == http.MethodDelete:
+        default:
+            next.ServeHTTP(w, r)
+            return
+        }
+        if key == "" {
+            s.writeError(w, http.StatusUnauthorized, "API key required")
+            return
+        }
+
+        next.ServeHTTP(w, r)
+    })
+}
+
+// adminMiddleware guards the /api/v1/admin subrouter: every request needs
+// a valid key, whatever its method, unless no keys are configured
+func (s *APIServer) adminMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if s.apiKey == "" && len(s.tenantKeys) == 0 {
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        key := requestKey(r)
+        if key == "" {
+            s.writeError(w, http.StatusUnauthorized, "API key required")
+            return
+        }
+        if _, ok := s.keyTenant(key); !ok {
+            s.writeError(w, http.StatusUnauthorized, "Invalid API key")
+            return
+        }
+
+        next.ServeHTTP(w, r)
+    })
+}
+
+// requestKey returns the API key a request was sent with, from either
+// "Authorization: Bearer <key>" or "X-API-Key: <key>"; empty if none
+func requestKey(r *http.Request) string {
+    key := r.Header.Get("X-API-Key")
+    if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+        key = strings.TrimPrefix(auth, "Bearer ")
+    }
+    return key
+}
+
 func (s *APIServer) corsMiddleware(next http.Handler) http.Handler {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
         w.Header().Set("Access-Control-Allow-Origin", "*")
-        w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-        w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+        w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+        w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+        w.Header().Set("Access-Control-Expose-Headers", "Link, X-Total-Count")
 
         if r.Method == "OPTIONS" {
             w.WriteHeader(http.StatusOK)
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const newProductBody = `{"id": "auth-kettle", "name": "Kettle", "url": "https://shop.test/kettle"}`
+
+func TestAuthWriteEndpoints(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    server.SetAPIKey("secret")
+
+    // missing or wrong key: 401 with a JSON error, and nothing is written
+    for key, want := range map[string]string{"": "API key required", "wrong": "Invalid API key"} {
+        rec := serveWithKey(t, server, key, "POST", "/api/v1/products", newProductBody, nil)
+        var body map[string]string
+        json.Unmarshal(rec.Body.Bytes(), &body)
+        if rec.Code != http.StatusUnauthorized || body["error"] != want {
+            t.Errorf("key %q = %d %v, want 401 %q", key, rec.Code, body, want)
+        }
+    }
+    if _, err := server.tracker.GetProduct("auth-kettle"); err == nil {
+        t.Fatal("an unauthorized request added the product")
+    }
+
+    if rec := serveWithKey(t, server, "secret", "POST", "/api/v1/products", newProductBody, nil); rec.Code != http.StatusCreated {
+        t.Errorf("with X-API-Key = %d: %s", rec.Code, rec.Body)
+    }
+
+    // the key can also be a bearer token
+    req := httptest.NewRequest("DELETE", "/api/v1/products/auth-kettle", nil)
+    req.Header.Set("Authorization", "Bearer secret")
+    rec := httptest.NewRecorder()
+    server.router.ServeHTTP(rec, req)
+    if rec.Code != http.StatusNoContent {
+        t.Errorf("with a bearer token = %d: %s", rec.Code, rec.Body)
+    }
+}
+
+func TestAuthReadsArePublic(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    server.SetAPIKey("secret")
+    addTestProduct(t, server.tracker, "auth-mug", 10)
+
+    for _, path := range []string{"/api/v1/products", "/api/v1/products/auth-mug", "/api/v1/products/auth-mug/history"} {
+        if rec := serve(t, server, "GET", path, "", nil); rec.Code != http.StatusOK {
+            t.Errorf("GET %s without a key = %d, want 200", path, rec.Code)
+        }
+    }
+
+    // preflights are answered before auth
+    rec := serve(t, server, "OPTIONS", "/api/v1/products/auth-mug", "", nil)
+    if rec.Code != http.StatusOK || rec.Header().Get("Access-Control-Allow-Headers") == "" {
+        t.Errorf("preflight = %d %v, want 200 with CORS headers", rec.Code, rec.Header())
+    }
+}
+
+func TestAuthNoKeyConfigured(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    server.SetAPIKey("")
+
+    if rec := serve(t, server, "POST", "/api/v1/products", newProductBody, nil); rec.Code != http.StatusCreated {
+        t.Errorf("write without a configured key = %d, want it open", rec.Code)
+    }
+}
+
+func TestAuthAdminEndpoints(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    server.SetAPIKey("secret")
+    addTestProduct(t, server.tracker, "auth-admin", 10)
+
+    // every admin route, including reads, needs the key
+    paths := []string{
+        "/api/v1/admin/products/auth-admin/snapshots",
+        "/api/v1/admin/notifications",
+        "/api/v1/admin/snapshots/1",
+    }
+    for _, path := range paths {
+        for key, want := range map[string]int{"": http.StatusUnauthorized, "wrong": http.StatusUnauthorized} {
+            if rec := serveWithKey(t, server, key, "GET", path, "", nil); rec.Code != want {
+                t.Errorf("GET %s with key %q = %d, want %d", path, key, rec.Code, want)
+            }
+        }
+    }
+
+    for path, want := range map[string]int{
+        "/api/v1/admin/products/auth-admin/snapshots": http.StatusOK,
+        "/api/v1/admin/notifications":                 http.StatusOK,
+        "/api/v1/admin/snapshots/1":                   http.StatusNotFound,
+    } {
+        if rec := serveWithKey(t, server, "secret", "GET", path, "", nil); rec.Code != want {
+            t.Errorf("GET %s with the key = %d, want %d", path, rec.Code, want)
+        }
+    }
+
+    // a bearer token works too
+    req := httptest.NewRequest("GET", "/api/v1/admin/notifications", nil)
+    req.Header.Set("Authorization", "Bearer secret")
+    rec := httptest.NewRecorder()
+    server.router.ServeHTTP(rec, req)
+    if rec.Code != http.StatusOK {
+        t.Errorf("with a bearer token = %d", rec.Code)
+    }
+
+    // without a configured key the admin endpoints are open like the rest
+    open := NewAPIServer(newTestTracker(t, nil))
+    if rec := serve(t, open, "GET", "/api/v1/admin/notifications", "", nil); rec.Code != http.StatusOK {
+        t.Errorf("admin without a configured key = %d, want 200", rec.Code)
+    }
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// availabilityValues maps schema.org ItemAvailability names and the values
+// used by product meta tags to whether the item can be bought now. Values
+// that aren't listed, such as PreOrder, leave availability unknown.
+var availabilityValues = map[string]bool{
+    "instock":             true,
+    "in stock":            true,
+    "limitedavailability": true,
+    "instoreonly":         true,
+    "onlineonly":          true,
+    "madetoorder":         true,
+    "available for order": true,
+    "outofstock":          false,
+    "out of stock":        false,
+    "oos":                 false,
+    "soldout":             false,
+    "discontinued":        false,
+    "backorder":           false,
+}
+
+// parseAvailability reads an availability value such as
+// "https://schema.org/InStock" or "out of stock"
+func parseAvailability(value string) (bool, bool) {
+    value = strings.ToLower(strings.TrimSpace(value))
+    if i := strings.LastIndex(value, "/"); i >= 0 {
+        value = value[i+1:]
+    }
+    inStock, ok := availabilityValues[value]
+    return inStock, ok
+}
+
+// pageAvailability reads whether the product is in stock from the page's
+// structured data: JSON-LD offers first, then product meta tags, then
+// microdata. It returns nil when the page doesn't say.
+func pageAvailability(root *goquery.Document) *bool {
+    var found *bool
+    set := func(value string) bool {
+        if inStock, ok := parseAvailability(value); ok {
+            found = &inStock
+            return true
+        }
+        return false
+    }
+
+    for _, data := range jsonLDBlocks(root) {
+        for _, value := range findAvailability(data, false) {
+            if set(value) {
+                return found
+            }
+        }
+    }
+
+    for _, property := range []string{"product:availability", "og:availability"} {
+        if set(metaContent(root, property)) {
+            return found
+        }
+    }
+
+    root.Find(`[itemprop="availability"]`).EachWithBreak(func(_ int, n *goquery.Selection) bool {
+        value := itemValue(n)
+        if href, ok := n.Attr("href"); ok {
+            value = href
+        }
+        return !set(value)
+    })
+    return found
+}
+
+// findAvailability collects the availability of every offer in a JSON-LD
+// document, in the same order findOffers visits them
+func findAvailability(value interface{}, inOffer bool) []string {
+    var values []string
+    switch v := value.(type) {
+    case []interface{}:
+        for _, item := range v {
+            values = append(values, findAvailability(item, inOffer)...)
+        }
+    case map[string]interface{}:
+        if availability, ok := v["availability"].(string); ok && inOffer {
+            values = append(values, availability)
+        }
+        for _, key := range sortedKeys(v) {
+            values = append(values, findAvailability(v[key], inOffer || key == "offers")...)
+        }
+    }
+    return values
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// defaultBasketDays is the analysis window used when none is given
+const defaultBasketDays = 30
+
+// AnalyzeBasket works out what the basket costs at the latest prices and the
+// cheapest the whole basket has been over the last days. Products without
+// any price in the window are left out and the result is flagged as partial.
+func (pt *PriceTracker) AnalyzeBasket(items []BasketItem, days int) (*BasketAnalysis, error) {
+    if len(items) == 0 {
+        return nil, errors.New("basket must contain at least one item")
+    }
+    if days <= 0 {
+        days = defaultBasketDays
+    }
+
+    to := time.Now()
+    from := to.AddDate(0, 0, -days)
+
+    analysis := &BasketAnalysis{Days: days}
+    quantities := make(map[string]int)
+    var events []PriceEntry
+
+    for _, item := range items {
+        if item.Quantity < 0 {
+            return nil, fmt.Errorf("invalid quantity for %s: %d", item.ProductID, item.Quantity)
+        }
+        if item.Quantity == 0 {
+            item.Quantity = 1
+        }
+
+        exists, err := pt.db.ProductExists(item.ProductID)
+        if err != nil {
+            return nil, err
+        }
+        if !exists {
+            return nil, fmt.Errorf("%w: %s", ErrProductNotFound, item.ProductID)
+        }
+
+        history, err := pt.db.GetPriceHistoryRange(item.ProductID, from, to, 0, false)
+        if err != nil {
+            return nil, err
+        }
+
+        analysis.Items = append(analysis.Items, item)
+        if len(history) == 0 {
+            analysis.Partial = true
+            analysis.Missing = append(analysis.Missing, item.ProductID)
+            continue
+        }
+
+        quantities[item.ProductID] += item.Quantity
+        events = append(events, history...)
+    }
+
+    if len(quantities) == 0 {
+        return analysis, nil
+    }
+
+    // replay every price change in time order, pricing the basket once all
+    // products have a known price
+    sort.Slice(events, func(i, j int) bool {
+        return events[i].Timestamp.Before(events[j].Timestamp)
+    })
+
+    prices := make(map[string]float64)
+    for _, entry := range events {
+        prices[entry.ProductID] = entry.Price
+        if len(prices) < len(quantities) {
+            continue
+        }
+
+        total := basketTotal(prices, quantities)
+        if analysis.CheapestAt == nil || total < analysis.CheapestTotal {
+            at := entry.Timestamp
+            analysis.CheapestTotal = total
+            analysis.CheapestAt = &at
+        }
+    }
+
+    analysis.CurrentTotal = basketTotal(prices, quantities)
+    return analysis, nil
+}
+
+func basketTotal(prices map[string]float64, quantities map[string]int) float64 {
+    total := 0.0
+    for productID, quantity := range quantities {
+        total += prices[productID] * float64(quantity)
+    }
+    return total
+}
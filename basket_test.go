@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBasketTotals(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    addTestProduct(t, server.tracker, "basket-coffee", 10, 8, 12)
+    addTestProduct(t, server.tracker, "basket-filters", 5, 6, 4)
+
+    var analysis BasketAnalysis
+    body := `{"items": [{"product_id": "basket-coffee", "quantity": 2}, {"product_id": "basket-filters"}], "days": 7}`
+    if rec := serve(t, server, "POST", "/api/v1/basket", body, &analysis); rec.Code != http.StatusOK {
+        t.Fatalf("basket = %d: %s", rec.Code, rec.Body)
+    }
+
+    // 2 x 12 + 4 now; cheapest was 2 x 8 + 5, two hours ago
+    if analysis.CurrentTotal != 28 || analysis.CheapestTotal != 21 {
+        t.Errorf("current %v, cheapest %v; want 28 and 21", analysis.CurrentTotal, analysis.CheapestTotal)
+    }
+    if analysis.CheapestAt == nil || time.Since(*analysis.CheapestAt) < 2*time.Hour-time.Minute || time.Since(*analysis.CheapestAt) > 2*time.Hour+time.Minute {
+        t.Errorf("cheapest at %v, want two hours ago", analysis.CheapestAt)
+    }
+    if analysis.Partial || analysis.Days != 7 || len(analysis.Items) != 2 || analysis.Items[1].Quantity != 1 {
+        t.Errorf("analysis = %+v, want a complete 7 day analysis with the quantity defaulted", analysis)
+    }
+}
+
+func TestBasketPartial(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    addTestProduct(t, server.tracker, "basket-kettle", 30, 25)
+    addTestProduct(t, server.tracker, "basket-new")
+
+    var analysis BasketAnalysis
+    body := `{"items": [{"product_id": "basket-kettle", "quantity": 1}, {"product_id": "basket-new", "quantity": 3}]}`
+    if rec := serve(t, server, "POST", "/api/v1/basket", body, &analysis); rec.Code != http.StatusOK {
+        t.Fatalf("basket = %d: %s", rec.Code, rec.Body)
+    }
+    if !analysis.Partial || len(analysis.Missing) != 1 || analysis.Missing[0] != "basket-new" {
+        t.Errorf("analysis = %+v, want it partial without basket-new", analysis)
+    }
+    if analysis.CurrentTotal != 25 || analysis.CheapestTotal != 25 || analysis.Days != defaultBasketDays {
+        t.Errorf("analysis = %+v, want the kettle alone priced over the default window", analysis)
+    }
+}
+
+func TestBasketErrors(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    addTestProduct(t, server.tracker, "basket-mug", 8)
+
+    for _, tt := range []struct {
+        body string
+        want int
+    }{
+        {`{"items": []}`, http.StatusBadRequest},
+        {`{"items": [{"product_id": "basket-mug", "quantity": -1}]}`, http.StatusBadRequest},
+        {`{"items": [{"product_id": "no-such-product", "quantity": 1}]}`, http.StatusNotFound},
+        {`{"items": `, http.StatusBadRequest},
+    } {
+        if rec := serve(t, server, "POST", "/api/v1/basket", tt.body, nil); rec.Code != tt.want {
+            t.Errorf("%s = %d, want %d", tt.body, rec.Code, tt.want)
+        }
+    }
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const bestBuyAPIBase = "https://api.bestbuy.com"
+
+// bestBuySKUPattern finds the SKU in bestbuy.com product paths, which end
+// in /<sku>.p
+var bestBuySKUPattern = regexp.MustCompile(`/(\d{5,8})\.p$`)
+
+// errNotBestBuyItem is returned for products whose URL isn't a Best Buy
+// product page
+var errNotBestBuyItem = errors.New("not a Best Buy product URL")
+
+// BestBuyFetcher gets prices from the Best Buy Products API for products
+// whose URL is a bestbuy.com product page. Prices are in US dollars.
+type BestBuyFetcher struct {
+    APIKey string
+
+    // baseURL replaces the API host, for pointing the fetcher at a stub
+    baseURL string
+}
+
+// bestBuySKU returns the SKU of a bestbuy.com product URL, from its
+// skuId parameter or else its path
+func bestBuySKU(rawURL string) (string, bool) {
+    parsed, err := url.Parse(rawURL)
+    if err != nil {
+        return "", false
+    }
+    if strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.") != "bestbuy.com" {
+        return "", false
+    }
+    if sku := parsed.Query().Get("skuId"); sku != "" && isDigits(sku) {
+        return sku, true
+    }
+    if match := bestBuySKUPattern.FindStringSubmatch(parsed.Path); match != nil {
+        return match[1], true
+    }
+    return "", false
+}
+
+func (f *BestBuyFetcher) handles(product Product) bool {
+    if f == nil {
+        return false
+    }
+    _, ok := bestBuySKU(product.URL)
+    return ok
+}
+
+func (f *BestBuyFetcher) FetchPrice(ctx context.Context, product Product) (float64, error) {
+    reading, err := f.FetchReading(ctx, product)
+    return reading.Price, err
+}
+
+func (f *BestBuyFetcher) FetchReading(ctx context.Context, product Product) (PriceReading, error) {
+    sku, ok := bestBuySKU(product.URL)
+    if !ok {
+        return PriceReading{}, fmt.Errorf("%w: %s", errNotBestBuyItem, product.URL)
+    }
+    if product.Currency != "" && product.Currency != "USD" {
+        return PriceReading{}, fmt.Errorf("Best Buy prices are in USD, but the product is tracked in %s", product.Currency)
+    }
+
+    base := bestBuyAPIBase
+    if f.baseURL != "" {
+        base = f.baseURL
+    }
+    query := url.Values{
+        "apiKey": {f.APIKey},
+        "format": {"json"},
+        "show":   {"sku,salePrice,regularPrice,onlineAvailability"},
+    }
+    var item struct {
+        SalePrice          *float64 `json:"salePrice"`
+        RegularPrice       float64  `json:"regularPrice"`
+        OnlineAvailability *bool    `json:"onlineAvailability"`
+    }
+    if err := getAPIJSON(ctx, base+"/v1/products/"+sku+".json?"+query.Encode(), nil, &item); err != nil {
+        return PriceReading{}, fmt.Errorf("Best Buy SKU %s: %w", sku, err)
+    }
+    if item.SalePrice == nil {
+        return PriceReading{}, fmt.Errorf("Best Buy SKU %s has no price", sku)
+    }
+
+    reading := PriceReading{Price: *item.SalePrice, InStock: item.OnlineAvailability}
+    if item.RegularPrice > reading.Price {
+        reading.ListPrice = &item.RegularPrice
+    }
+    return reading, nil
+}
+
+func isDigits(s string) bool {
+    for _, r := range s {
+        if r < '0' || r > '9' {
+            return false
+        }
+    }
+    return s != ""
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBestBuyFetchReading(t *testing.T) {
+    var query string
+    api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        query = r.URL.RawQuery
+        w.Header().Set("Content-Type", "application/json")
+        switch r.URL.Path {
+        case "/v1/products/6525413.json":
+            w.Write([]byte(`{"sku": 6525413, "salePrice": 279.99, "regularPrice": 349.99, "onlineAvailability": true}`))
+        case "/v1/products/6401728.json":
+            w.Write([]byte(`{"sku": 6401728, "salePrice": 99.99, "regularPrice": 99.99, "onlineAvailability": false}`))
+        case "/v1/products/6000001.json":
+            w.Write([]byte(`{"sku": 6000001, "regularPrice": 19.99}`))
+        case "/v1/products/6000002.json":
+            w.WriteHeader(http.StatusServiceUnavailable)
+        default:
+            w.WriteHeader(http.StatusNotFound)
+            w.Write([]byte(`{"error": {"code": 404, "message": "Resource not found"}}`))
+        }
+    }))
+    t.Cleanup(api.Close)
+    f := &BestBuyFetcher{APIKey: "test-key", baseURL: api.URL}
+    ctx := context.Background()
+
+    reading, err := f.FetchReading(ctx, Product{URL: "https://www.bestbuy.com/site/sony-headphones/6525413.p?skuId=6525413", Currency: "USD"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if reading.Price != 279.99 || reading.ListPrice == nil || *reading.ListPrice != 349.99 || reading.InStock == nil || !*reading.InStock {
+        t.Errorf("reading = %+v, want 279.99 down from 349.99, in stock", reading)
+    }
+    if !strings.Contains(query, "apiKey=test-key") || !strings.Contains(query, "format=json") {
+        t.Errorf("query = %q", query)
+    }
+
+    // a regular price that isn't above the sale price isn't a list price
+    reading, err = f.FetchReading(ctx, Product{URL: "https://bestbuy.com/site/kettle/6401728.p"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if reading.Price != 99.99 || reading.ListPrice != nil || reading.InStock == nil || *reading.InStock {
+        t.Errorf("reading = %+v, want 99.99 with no list price, out of stock", reading)
+    }
+
+    // Best Buy only prices in dollars
+    if _, err := f.FetchReading(ctx, Product{URL: "https://www.bestbuy.com/site/kettle/6401728.p", Currency: "CAD"}); err == nil || !strings.Contains(err.Error(), "USD") {
+        t.Errorf("CAD product = %v", err)
+    }
+
+    _, err = f.FetchReading(ctx, Product{URL: "https://www.bestbuy.com/site/gone/6999999.p"})
+    var status statusError
+    if !errors.As(err, &status) || status.code != http.StatusNotFound || isRetryable(err) {
+        t.Errorf("missing SKU = %v, want a permanent 404", err)
+    }
+    if _, err := f.FetchReading(ctx, Product{URL: "https://www.bestbuy.com/site/down/6000002.p"}); !isRetryable(err) {
+        t.Errorf("API unavailable = %v, want a retryable error", err)
+    }
+    if _, err := f.FetchReading(ctx, Product{URL: "https://www.bestbuy.com/site/unpriced/6000001.p"}); err == nil || !strings.Contains(err.Error(), "has no price") {
+        t.Errorf("SKU without a sale price = %v", err)
+    }
+    if _, err := f.FetchReading(ctx, Product{URL: "https://www.bestbuy.com/site/searchpage.jsp?st=kettle"}); !errors.Is(err, errNotBestBuyItem) {
+        t.Errorf("search page = %v", err)
+    }
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"testing"
+)
+
+func TestBestDealProximity(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    addTestProduct(t, server.tracker, "deal-laptop", 100, 80, 95, 90)
+
+    var deal BestDeal
+    if rec := serve(t, server, "GET", "/api/v1/products/deal-laptop/best-deal", "", &deal); rec.Code != http.StatusOK {
+        t.Fatalf("best-deal = %d: %s", rec.Code, rec.Body)
+    }
+    if deal.LowestPrice != 80 || deal.CurrentPrice != 90 || deal.EntryCount != 4 {
+        t.Errorf("deal = %+v, want lowest 80 and current 90 of 4 entries", deal)
+    }
+    if math.Abs(deal.AboveLowPercent-12.5) > 1e-9 {
+        t.Errorf("above low = %v%%, want 12.5%%", deal.AboveLowPercent)
+    }
+    if !deal.LowestAt.Before(deal.CurrentAt) {
+        t.Errorf("lowest at %v, current at %v", deal.LowestAt, deal.CurrentAt)
+    }
+
+    if rec := serve(t, server, "GET", "/api/v1/products/no-such-product/best-deal", "", nil); rec.Code != http.StatusNotFound {
+        t.Errorf("unknown product = %d, want 404", rec.Code)
+    }
+}
+
+func TestBestDealsRanking(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    addTestProduct(t, server.tracker, "deal-near", 100, 90, 110, 105, 101)
+    addTestProduct(t, server.tracker, "deal-at-low", 50, 45, 40, 48, 40)
+    addTestProduct(t, server.tracker, "deal-far", 20, 10, 20, 30, 30)
+    addTestProduct(t, server.tracker, "deal-new", 10, 5) // too little history
+
+    // an outlier doesn't count as the all-time low
+    addTestProduct(t, server.tracker, "deal-outlier", 100, 1, 100, 100, 100, 102)
+    entries, err := server.tracker.GetPriceHistory("deal-outlier", 10, false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, entry := range entries {
+        if entry.Price == 1 {
+            if err := server.tracker.SetOutlier(entry.ID, "", true); err != nil {
+                t.Fatal(err)
+            }
+        }
+    }
+
+    var response struct {
+        Deals []BestDeal `json:"deals"`
+        Count int        `json:"count"`
+    }
+    serve(t, server, "GET", "/api/v1/best-deals", "", &response)
+
+    var ids []string
+    for _, deal := range response.Deals {
+        ids = append(ids, deal.ProductID)
+    }
+    want := []string{"deal-at-low", "deal-outlier", "deal-near", "deal-far"}
+    if len(ids) != len(want) || response.Count != len(want) {
+        t.Fatalf("ranking = %v, want %v", ids, want)
+    }
+    for i := range want {
+        if ids[i] != want[i] {
+            t.Fatalf("ranking = %v, want %v", ids, want)
+        }
+    }
+
+    proximity := map[string]float64{"deal-at-low": 0, "deal-outlier": 2, "deal-near": 101.0/90*100 - 100, "deal-far": 200}
+    for _, deal := range response.Deals {
+        if math.Abs(deal.AboveLowPercent-proximity[deal.ProductID]) > 1e-9 {
+            t.Errorf("%s is %v%% above its low, want %v%%", deal.ProductID, deal.AboveLowPercent, proximity[deal.ProductID])
+        }
+    }
+}
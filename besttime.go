@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+    // bestTimeLookback is how much history the best-time analysis considers
+    bestTimeLookback = 180 * 24 * time.Hour
+    // bestTimeMinEntries and bestTimeMinDays gate the analysis on having
+    // enough history to say anything
+    bestTimeMinEntries = 20
+    bestTimeMinDays    = 14
+    // bestTimeMinEffect is the smallest gap from average, in percent, worth
+    // recommending
+    bestTimeMinEffect = 0.5
+)
+
+// GetBestTime analyzes which day of the week and which part of the month
+// have historically had the lowest prices for a product. Days are taken in
+// UTC.
+func (pt *PriceTracker) GetBestTime(productID string) (*BestTimeAnalysis, error) {
+    exists, err := pt.db.ProductExists(productID)
+    if err != nil {
+        return nil, err
+    }
+    if !exists {
+        return nil, fmt.Errorf("%w: %s", ErrProductNotFound, productID)
+    }
+
+    to := time.Now()
+    history, err := pt.db.GetPriceHistoryRange(productID, to.Add(-bestTimeLookback), to, 0, false)
+    if err != nil {
+        return nil, err
+    }
+
+    analysis := &BestTimeAnalysis{
+        ProductID:  productID,
+        EntryCount: len(history),
+        Confidence: "none",
+    }
+
+    days := make(map[string]bool)
+    var total float64
+    weekdays := make([]priceAccumulator, 7)
+    periods := make([]priceAccumulator, 3)
+    for _, entry := range history {
+        ts := entry.Timestamp.UTC()
+        days[ts.Format("2006-01-02")] = true
+        total += entry.Price
+        weekdays[ts.Weekday()].add(entry.Price)
+        periods[monthPeriod(ts)].add(entry.Price)
+    }
+    analysis.DaysCovered = len(days)
+
+    if len(history) < bestTimeMinEntries || len(days) < bestTimeMinDays {
+        analysis.Recommendation = fmt.Sprintf("insufficient data: need at least %d prices over %d days",
+            bestTimeMinEntries, bestTimeMinDays)
+        return analysis, nil
+    }
+
+    analysis.Sufficient = true
+    analysis.AveragePrice = total / float64(len(history))
+    switch {
+    case len(days) >= 60:
+        analysis.Confidence = "high"
+    case len(days) >= 30:
+        analysis.Confidence = "medium"
+    default:
+        analysis.Confidence = "low"
+    }
+
+    for day, acc := range weekdays {
+        if acc.count > 0 {
+            analysis.ByWeekday = append(analysis.ByWeekday, acc.bucket(time.Weekday(day).String(), analysis.AveragePrice))
+        }
+    }
+    periodLabels := []string{"start of the month", "middle of the month", "end of the month"}
+    for period, acc := range periods {
+        if acc.count > 0 {
+            analysis.ByMonthPeriod = append(analysis.ByMonthPeriod, acc.bucket(periodLabels[period], analysis.AveragePrice))
+        }
+    }
+
+    analysis.Recommendation = bestTimeRecommendation(cheapestBucket(analysis.ByWeekday), cheapestBucket(analysis.ByMonthPeriod))
+    return analysis, nil
+}
+
+type priceAccumulator struct {
+    sum   float64
+    count int
+}
+
+func (a *priceAccumulator) add(price float64) {
+    a.sum += price
+    a.count++
+}
+
+func (a priceAccumulator) bucket(label string, overall float64) PriceBucket {
+    average := a.sum / float64(a.count)
+    return PriceBucket{
+        Label:            label,
+        AveragePrice:     average,
+        Count:            a.count,
+        VsAveragePercent: (average - overall) / overall * 100,
+    }
+}
+
+// monthPeriod splits the month into days 1-10, 11-20 and 21 onwards
+func monthPeriod(t time.Time) int {
+    switch {
+    case t.Day() <= 10:
+        return 0
+    case t.Day() <= 20:
+        return 1
+    default:
+        return 2
+    }
+}
+
+func cheapestBucket(buckets []PriceBucket) *PriceBucket {
+    var cheapest *PriceBucket
+    for i := range buckets {
+        if cheapest == nil || buckets[i].AveragePrice < cheapest.AveragePrice {
+            cheapest = &buckets[i]
+        }
+    }
+    return cheapest
+}
+
+func bestTimeRecommendation(weekday, period *PriceBucket) string {
+    var parts []string
+    if weekday != nil && math.Abs(weekday.VsAveragePercent) >= bestTimeMinEffect {
+        parts = append(parts, fmt.Sprintf("on %ss (%.1f%% below average)", weekday.Label, -weekday.VsAveragePercent))
+    }
+    if period != nil && math.Abs(period.VsAveragePercent) >= bestTimeMinEffect {
+        parts = append(parts, fmt.Sprintf("at the %s (%.1f%% below average)", period.Label, -period.VsAveragePercent))
+    }
+
+    if len(parts) == 0 {
+        return "prices don't vary meaningfully by day of week or time of month"
+    }
+    return "prices are typically lowest " + strings.Join(parts, " and ")
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// addDailyPrices adds a product with one price a day at noon UTC for the
+// given number of days up to yesterday, priced by day
+func addDailyPrices(t *testing.T, tracker *PriceTracker, id string, days int, price func(day time.Time) float64) {
+    t.Helper()
+    addTestProduct(t, tracker, id)
+    today := time.Now().UTC().Truncate(24 * time.Hour)
+    for i := days; i >= 1; i-- {
+        day := today.AddDate(0, 0, -i).Add(12 * time.Hour)
+        entry := PriceEntry{ProductID: id, Price: price(day), Currency: DefaultCurrency, Timestamp: day}
+        if _, err := tracker.db.InsertPriceEntry(entry); err != nil {
+            t.Fatal(err)
+        }
+    }
+}
+
+func getBestTime(t *testing.T, server *APIServer, id string) BestTimeAnalysis {
+    t.Helper()
+    var analysis BestTimeAnalysis
+    if rec := serve(t, server, "GET", "/api/v1/products/"+id+"/best-time", "", &analysis); rec.Code != http.StatusOK {
+        t.Fatalf("best-time = %d: %s", rec.Code, rec.Body)
+    }
+    return analysis
+}
+
+func TestBestTimeWeekends(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    addDailyPrices(t, server.tracker, "besttime-weekend", 70, func(day time.Time) float64 {
+        if day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+            return 80
+        }
+        return 100
+    })
+
+    analysis := getBestTime(t, server, "besttime-weekend")
+    if !analysis.Sufficient || analysis.Confidence != "high" || analysis.EntryCount != 70 || analysis.DaysCovered != 70 {
+        t.Fatalf("analysis = %+v, want sufficient with high confidence", analysis)
+    }
+    if len(analysis.ByWeekday) != 7 {
+        t.Fatalf("weekdays = %+v, want all 7", analysis.ByWeekday)
+    }
+    for _, bucket := range analysis.ByWeekday {
+        want := 100.0
+        if bucket.Label == "Saturday" || bucket.Label == "Sunday" {
+            want = 80
+        }
+        if bucket.AveragePrice != want || bucket.Count != 10 {
+            t.Errorf("%s = %+v, want an average of %v over 10 days", bucket.Label, bucket, want)
+        }
+    }
+    if !strings.Contains(analysis.Recommendation, "lowest on Sundays") {
+        t.Errorf("recommendation = %q, want weekends", analysis.Recommendation)
+    }
+}
+
+func TestBestTimeEndOfMonth(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    addDailyPrices(t, server.tracker, "besttime-payday", 45, func(day time.Time) float64 {
+        if day.Day() > 20 {
+            return 70
+        }
+        return 100
+    })
+
+    analysis := getBestTime(t, server, "besttime-payday")
+    if !analysis.Sufficient || analysis.Confidence != "medium" {
+        t.Fatalf("analysis = %+v, want sufficient with medium confidence", analysis)
+    }
+    for _, bucket := range analysis.ByMonthPeriod {
+        if want := bucket.Label == "end of the month"; (bucket.AveragePrice == 70) != want || bucket.VsAveragePercent < 0 != want {
+            t.Errorf("%s = %+v", bucket.Label, bucket)
+        }
+    }
+    if !strings.Contains(analysis.Recommendation, "at the end of the month") {
+        t.Errorf("recommendation = %q, want the end of the month", analysis.Recommendation)
+    }
+}
+
+func TestBestTimeFlatAndThin(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+
+    // steady prices: enough data, nothing to recommend
+    addDailyPrices(t, server.tracker, "besttime-flat", 25, func(time.Time) float64 { return 50 })
+    analysis := getBestTime(t, server, "besttime-flat")
+    if !analysis.Sufficient || analysis.Confidence != "low" || !strings.Contains(analysis.Recommendation, "don't vary") {
+        t.Errorf("flat analysis = %+v", analysis)
+    }
+
+    // too little history
+    addDailyPrices(t, server.tracker, "besttime-thin", 10, func(time.Time) float64 { return 50 })
+    analysis = getBestTime(t, server, "besttime-thin")
+    if analysis.Sufficient || analysis.Confidence != "none" || !strings.HasPrefix(analysis.Recommendation, "insufficient data") || analysis.ByWeekday != nil {
+        t.Errorf("thin analysis = %+v, want insufficient data", analysis)
+    }
+
+    if rec := serve(t, server, "GET", "/api/v1/products/no-such-product/best-time", "", nil); rec.Code != http.StatusNotFound {
+        t.Errorf("unknown product = %d, want 404", rec.Code)
+    }
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxBlockPageBytes caps how much of an error response is read when
+// checking it for a block page
+const maxBlockPageBytes = 64 << 10
+
+// errBlocked matches every fetch an anti-bot system refused
+var errBlocked = errors.New("blocked by anti-bot protection")
+
+// blockedError is a fetch that got a block page or challenge instead of the
+// product page
+type blockedError struct {
+    reason string
+}
+
+func (e blockedError) Error() string {
+    return fmt.Sprintf("%v: %s", errBlocked, e.reason)
+}
+
+func (e blockedError) Is(target error) bool {
+    return target == errBlocked
+}
+
+// blockMarkers are strings that only appear on the block and challenge pages
+// of common anti-bot systems
+var blockMarkers = []struct {
+    reason string
+    marker string
+}{
+    {"Cloudflare challenge", "_cf_chl_opt"},
+    {"Cloudflare challenge", "<title>Just a moment...</title>"},
+    {"Cloudflare block", "<title>Attention Required! | Cloudflare</title>"},
+    {"Amazon robot check", "api-services-support@amazon.com"},
+    {"Amazon robot check", "/errors/validateCaptcha"},
+    {"PerimeterX captcha", "px-captcha"},
+    {"DataDome captcha", "captcha-delivery.com"},
+}
+
+// detectBlock reports which anti-bot system served a response, if any.
+// header may be nil for pages that weren't fetched over plain HTTP.
+func detectBlock(header http.Header, page string) (string, bool) {
+    if header != nil && strings.EqualFold(header.Get("cf-mitigated"), "challenge") {
+        return "Cloudflare challenge", true
+    }
+    for _, m := range blockMarkers {
+        if strings.Contains(page, m.marker) {
+            return m.reason, true
+        }
+    }
+    return "", false
+}
+
+// blockReason reports whether a fetch error means the tracker was blocked:
+// a recognised block page, or a 429 from plain rate limiting
+func blockReason(err error) (string, bool) {
+    var blocked blockedError
+    if errors.As(err, &blocked) {
+        return blocked.reason, true
+    }
+    var status statusError
+    if errors.As(err, &status) && status.code == http.StatusTooManyRequests {
+        return "HTTP 429 rate limiting", true
+    }
+    return "", false
+}
+
+// noteBlock counts a fetch attempt that was blocked against its product
+func (pt *PriceTracker) noteBlock(product Product, err error) {
+    reason, ok := blockReason(err)
+    if !ok {
+        return
+    }
+    pt.metrics.fetchBlocks.WithLabelValues(product.ID).Inc()
+
+    pt.blocksMu.Lock()
+    defer pt.blocksMu.Unlock()
+
+    stats, ok := pt.blocks[product.ID]
+    if !ok {
+        stats = &BlockStats{ProductID: product.ID}
+        pt.blocks[product.ID] = stats
+    }
+    stats.Name = product.Name
+    stats.Blocked++
+    stats.LastBlockedAt = time.Now().UTC()
+    stats.LastReason = reason
+}
+
+// BlockStats lists how often each product's fetches have been blocked since
+// startup, most blocked first
+func (pt *PriceTracker) BlockStats() []BlockStats {
+    pt.blocksMu.Lock()
+    defer pt.blocksMu.Unlock()
+
+    stats := make([]BlockStats, 0, len(pt.blocks))
+    for _, s := range pt.blocks {
+        stats = append(stats, *s)
+    }
+    sort.Slice(stats, func(i, j int) bool {
+        if stats[i].Blocked != stats[j].Blocked {
+            return stats[i].Blocked > stats[j].Blocked
+        }
+        return stats[i].ProductID < stats[j].ProductID
+    })
+    return stats
+}
+
+func (pt *PriceTracker) forgetBlocks(productID string) {
+    pt.blocksMu.Lock()
+    defer pt.blocksMu.Unlock()
+
+    delete(pt.blocks, productID)
+}
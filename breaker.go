@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+    // defaultBreakerThreshold is how many blocking failures in a row open a
+    // host's breaker when no threshold is configured
+    defaultBreakerThreshold = 5
+    // defaultBreakerCooldown is how long an open breaker stays open when no
+    // cooldown is configured
+    defaultBreakerCooldown = 5 * time.Minute
+)
+
+// Breaker states
+const (
+    BreakerClosed   = "closed"
+    BreakerOpen     = "open"
+    BreakerHalfOpen = "half-open"
+)
+
+// errBreakerOpen is returned instead of fetching from a host whose breaker
+// is open
+var errBreakerOpen = errors.New("circuit breaker open")
+
+// breakerSet is a circuit breaker per host. A host that blocks the tracker,
+// answering 403 or 429 or timing out, threshold times in a row is left
+// alone for the cooldown. After that one probe fetch is let through: if it
+// succeeds the breaker closes, otherwise it opens for another cooldown.
+type breakerSet struct {
+    mu        sync.Mutex
+    threshold int
+    cooldown  time.Duration
+    hosts     map[string]*hostBreaker
+}
+
+type hostBreaker struct {
+    failures  int
+    openedAt  time.Time
+    retryAt   time.Time
+    lastError string
+
+    // probeUntil is set while the probe is out; a probe that never reports
+    // back, because its cycle was cancelled, expires with it
+    probeUntil time.Time
+}
+
+func newBreakerSet() *breakerSet {
+    return &breakerSet{
+        threshold: defaultBreakerThreshold,
+        cooldown:  defaultBreakerCooldown,
+        hosts:     make(map[string]*hostBreaker),
+    }
+}
+
+// SetCircuitBreaker sets how many blocking failures in a row open a host's
+// breaker and how long it then stays open. A zero threshold disables the
+// breakers.
+func (pt *PriceTracker) SetCircuitBreaker(threshold int, cooldown time.Duration) {
+    b := pt.breakers
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    b.threshold = threshold
+    b.cooldown = cooldown
+    b.hosts = make(map[string]*hostBreaker)
+}
+
+// urlHost returns the lower-cased host name of a URL, or "" if it doesn't
+// parse
+func urlHost(pageURL string) string {
+    parsed, err := url.Parse(pageURL)
+    if err != nil {
+        return ""
+    }
+    return strings.ToLower(parsed.Hostname())
+}
+
+// allow returns errBreakerOpen if host's breaker is open. Once the cooldown
+// is over it lets a single probe through.
+func (b *breakerSet) allow(host string) error {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    hb, ok := b.hosts[host]
+    if b.threshold <= 0 || !ok || hb.openedAt.IsZero() {
+        return nil
+    }
+    now := time.Now()
+    if now.Before(hb.retryAt) || now.Before(hb.probeUntil) {
+        return fmt.Errorf("%w for %s until %s", errBreakerOpen, host, hb.retryAt.Format(time.RFC3339))
+    }
+    hb.probeUntil = now.Add(b.cooldown)
+    return nil
+}
+
+// record feeds a fetch outcome into host's breaker. Only blocking failures
+// count; other errors, such as a page without a price, say nothing about
+// whether the host is refusing the tracker.
+func (b *breakerSet) record(host string, err error) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    if b.threshold <= 0 {
+        return
+    }
+    hb, ok := b.hosts[host]
+    if !isBlockingFailure(err) {
+        // a probe that failed for another reason still got an answer
+        if ok && (err == nil || !hb.probeUntil.IsZero()) {
+            if !hb.openedAt.IsZero() {
+                log.Printf("Circuit breaker for %s closed", host)
+            }
+            delete(b.hosts, host)
+        }
+        return
+    }
+
+    if !ok {
+        hb = &hostBreaker{}
+        b.hosts[host] = hb
+    }
+    hb.failures++
+    hb.lastError = err.Error()
+    if !hb.probeUntil.IsZero() || hb.failures >= b.threshold {
+        now := time.Now()
+        if hb.openedAt.IsZero() {
+            hb.openedAt = now
+        }
+        hb.retryAt = now.Add(b.cooldown)
+        hb.probeUntil = time.Time{}
+        log.Printf("Circuit breaker for %s open until %s after %d blocking failures: %v",
+            host, hb.retryAt.Format(time.RFC3339), hb.failures, err)
+    }
+}
+
+// isBlockingFailure reports whether err suggests the host is refusing or
+// throttling the tracker: a 403, a 429, a block page or a timeout
+func isBlockingFailure(err error) bool {
+    if err == nil {
+        return false
+    }
+    if errors.Is(err, errBlocked) {
+        return true
+    }
+    var status statusError
+    if errors.As(err, &status) {
+        return status.code == http.StatusForbidden || status.code == http.StatusTooManyRequests
+    }
+    if errors.Is(err, context.DeadlineExceeded) {
+        return true
+    }
+    var netErr net.Error
+    return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// CircuitBreakers reports every host whose breaker has seen blocking
+// failures, by host name
+func (pt *PriceTracker) CircuitBreakers() []BreakerStatus {
+    b := pt.breakers
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    now := time.Now()
+    statuses := make([]BreakerStatus, 0, len(b.hosts))
+    for host, hb := range b.hosts {
+        status := BreakerStatus{
+            Host:      host,
+            State:     BreakerClosed,
+            Failures:  hb.failures,
+            LastError: hb.lastError,
+        }
+        if !hb.openedAt.IsZero() {
+            openedAt, retryAt := hb.openedAt.UTC(), hb.retryAt.UTC()
+            status.OpenedAt = &openedAt
+            status.RetryAt = &retryAt
+            status.State = BreakerOpen
+            if !now.Before(hb.retryAt) {
+                status.State = BreakerHalfOpen
+            }
+        }
+        statuses = append(statuses, status)
+    }
+    sort.Slice(statuses, func(i, j int) bool {
+        return statuses[i].Host < statuses[j].Host
+    })
+    return statuses
+}
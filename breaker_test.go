@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBreakerStates(t *testing.T) {
+    const host = "shop.test"
+    blocked := statusError{code: http.StatusForbidden, err: errors.New("403 Forbidden")}
+    throttled := retryableError{statusError{code: http.StatusTooManyRequests, err: errors.New("429 Too Many Requests")}}
+    noPrice := errors.New("no element matches price selector")
+
+    // each step records an outcome, ends the cooldown or asks to fetch;
+    // "allow" and "refuse" say what asking should give
+    tests := []struct {
+        name      string
+        threshold int
+        steps     []string
+        state     string
+    }{
+        {"below the threshold", 3, []string{"block", "block", "allow"}, BreakerClosed},
+        {"threshold trips the breaker", 3, []string{"block", "throttle", "block", "refuse"}, BreakerOpen},
+        {"success resets the count", 3, []string{"block", "block", "ok", "block", "block", "allow"}, BreakerClosed},
+        {"other errors don't count", 2, []string{"block", "other", "other", "allow"}, BreakerClosed},
+        {"refused until retryAt", 1, []string{"block", "refuse", "refuse", "expire", "allow"}, BreakerHalfOpen},
+        {"one probe at a time", 1, []string{"block", "expire", "allow", "refuse", "refuse"}, BreakerHalfOpen},
+        {"failed probe reopens", 2, []string{"block", "block", "expire", "allow", "block", "refuse", "expire", "allow"}, BreakerHalfOpen},
+        {"successful probe closes", 1, []string{"block", "expire", "allow", "ok", "allow", "allow"}, ""},
+        {"non-blocking probe result closes", 2, []string{"block", "block", "expire", "allow", "other", "allow", "block", "allow"}, BreakerClosed},
+        {"disabled", 0, []string{"block", "block", "block", "allow"}, ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            b := newBreakerSet()
+            b.threshold = tt.threshold
+            b.cooldown = time.Hour
+
+            for i, step := range tt.steps {
+                switch step {
+                case "block":
+                    b.record(host, blocked)
+                case "throttle":
+                    b.record(host, throttled)
+                case "other":
+                    b.record(host, noPrice)
+                case "ok":
+                    b.record(host, nil)
+                case "expire":
+                    b.hosts[host].retryAt = time.Now().Add(-time.Second)
+                case "allow", "refuse":
+                    err := b.allow(host)
+                    if step == "allow" && err != nil {
+                        t.Fatalf("step %d: %v, want the fetch allowed", i, err)
+                    }
+                    if step == "refuse" && !errors.Is(err, errBreakerOpen) {
+                        t.Fatalf("step %d: %v, want the fetch refused", i, err)
+                    }
+                }
+            }
+
+            // other hosts are never held back
+            if err := b.allow("other.test"); err != nil {
+                t.Errorf("other host: %v", err)
+            }
+            if got := breakerState(b, host); got != tt.state {
+                t.Errorf("state = %q, want %q", got, tt.state)
+            }
+        })
+    }
+}
+
+func TestBreakerProbeExpires(t *testing.T) {
+    b := newBreakerSet()
+    b.threshold = 1
+    b.cooldown = time.Hour
+    b.record("shop.test", statusError{code: http.StatusTooManyRequests, err: errors.New("429")})
+    b.hosts["shop.test"].retryAt = time.Now().Add(-time.Second)
+    if err := b.allow("shop.test"); err != nil {
+        t.Fatal(err)
+    }
+
+    // the probe's cycle was cancelled and it never reported back
+    b.hosts["shop.test"].probeUntil = time.Now().Add(-time.Second)
+    if err := b.allow("shop.test"); err != nil {
+        t.Errorf("after a lost probe: %v, want another probe allowed", err)
+    }
+}
+
+func TestIsBlockingFailure(t *testing.T) {
+    tests := []struct {
+        err  error
+        want bool
+    }{
+        {nil, false},
+        {statusError{code: http.StatusForbidden, err: errors.New("403")}, true},
+        {retryableError{statusError{code: http.StatusTooManyRequests, err: errors.New("429")}}, true},
+        {fmt.Errorf("fetching: %w", blockedError{reason: "captcha"}), true},
+        {retryableError{fmt.Errorf("fetching: %w", context.DeadlineExceeded)}, true},
+        {statusError{code: http.StatusNotFound, err: errors.New("404")}, false},
+        {retryableError{statusError{code: http.StatusBadGateway, err: errors.New("502")}}, false},
+        {errors.New("no element matches price selector"), false},
+    }
+    for _, tt := range tests {
+        if got := isBlockingFailure(tt.err); got != tt.want {
+            t.Errorf("isBlockingFailure(%v) = %v, want %v", tt.err, got, tt.want)
+        }
+    }
+}
+
+// breakerState is the state CircuitBreakers reports for host, or "" when it
+// has nothing on it
+func breakerState(b *breakerSet, host string) string {
+    tracker := &PriceTracker{breakers: b}
+    for _, status := range tracker.CircuitBreakers() {
+        if status.Host == host {
+            return status.State
+        }
+    }
+    return ""
+}
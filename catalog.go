@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const (
+    // defaultCatalogLimit caps how many products a catalog yields when the
+    // request doesn't say
+    defaultCatalogLimit = 100
+    // maxCatalogLimit caps how many products one catalog request may add
+    maxCatalogLimit = 1000
+    // maxChildSitemaps caps how many sitemaps of a sitemap index are read
+    maxChildSitemaps = 20
+    // maxCatalogLinkName is the longest link text used as a product name;
+    // longer text is usually a whole product card
+    maxCatalogLinkName = 120
+)
+
+var (
+    // ErrInvalidCatalog is returned for a catalog request that can't be
+    // enumerated as given
+    ErrInvalidCatalog = errors.New("invalid catalog request")
+    // ErrCatalogUnavailable is returned when a catalog page or sitemap
+    // can't be fetched
+    ErrCatalogUnavailable = errors.New("could not fetch catalog")
+)
+
+// CatalogRequest names a category page or sitemap to enumerate product
+// links from. Template holds the settings every added product gets, such as
+// its price rule, priority and currency; its id, name and url are ignored.
+type CatalogRequest struct {
+    URL string `json:"url"`
+
+    // LinkSelector is the CSS selector of the product links on a category
+    // page; sitemaps don't need one
+    LinkSelector string `json:"link_selector,omitempty"`
+
+    // URLPattern is a regex product URLs must match, e.g. "/products/"
+    URLPattern string `json:"url_pattern,omitempty"`
+
+    // Limit caps how many products are enumerated; zero is 100
+    Limit int `json:"limit,omitempty"`
+
+    Template Product `json:"template"`
+}
+
+// CatalogItem is a product link found in a catalog. Name is the link's
+// text, and is left empty when the link has none worth using; it is then
+// discovered from the product page on import.
+type CatalogItem struct {
+    URL     string `json:"url"`
+    Name    string `json:"name,omitempty"`
+    Tracked bool   `json:"tracked"`
+}
+
+// CatalogPreview lists the products a catalog request would add
+type CatalogPreview struct {
+    URL   string        `json:"url"`
+    Items []CatalogItem `json:"items"`
+    Count int           `json:"count"`
+    New   int           `json:"new"`
+}
+
+// parsedCatalog is a validated catalog request
+type parsedCatalog struct {
+    CatalogRequest
+    selector cssSelector
+    pattern  *regexp.Regexp
+}
+
+func parseCatalogRequest(req CatalogRequest) (parsedCatalog, error) {
+    c := parsedCatalog{CatalogRequest: req}
+    c.URL = strings.TrimSpace(c.URL)
+    if err := validateProductURL(c.URL); err != nil {
+        return c, fmt.Errorf("%w: %v", ErrInvalidCatalog, err)
+    }
+
+    var err error
+    if c.LinkSelector != "" {
+        if c.selector, err = parseSelector(c.LinkSelector); err != nil {
+            return c, fmt.Errorf("%w: invalid link selector %q: %v", ErrInvalidCatalog, c.LinkSelector, err)
+        }
+    }
+    if c.URLPattern != "" {
+        if c.pattern, err = regexp.Compile(c.URLPattern); err != nil {
+            return c, fmt.Errorf("%w: invalid url pattern %q: %v", ErrInvalidCatalog, c.URLPattern, err)
+        }
+    }
+    if c.Limit < 0 {
+        return c, fmt.Errorf("%w: limit must not be negative", ErrInvalidCatalog)
+    }
+    if c.Limit == 0 {
+        c.Limit = defaultCatalogLimit
+    }
+    if c.Limit > maxCatalogLimit {
+        c.Limit = maxCatalogLimit
+    }
+
+    if err := validatePriceRule(c.Template); err != nil {
+        return c, fmt.Errorf("%w: template: %v", ErrInvalidCatalog, err)
+    }
+    if c.Template.FetchProfile != nil {
+        if err := c.Template.FetchProfile.validate(); err != nil {
+            return c, fmt.Errorf("%w: template: invalid fetch profile: %v", ErrInvalidCatalog, err)
+        }
+    }
+    return c, nil
+}
+
+// PreviewCatalog enumerates the products a catalog request would add to a
+// tenant without adding them. Links to products the tenant already tracks
+// are marked as such.
+func (pt *PriceTracker) PreviewCatalog(ctx context.Context, req CatalogRequest, tenant string) (CatalogPreview, error) {
+    req.Template.Tenant = tenant
+    c, err := parseCatalogRequest(req)
+    if err != nil {
+        return CatalogPreview{}, err
+    }
+    items, err := pt.enumerateCatalog(ctx, c)
+    if err != nil {
+        return CatalogPreview{}, err
+    }
+
+    preview := CatalogPreview{URL: c.URL, Items: items, Count: len(items)}
+    for _, item := range items {
+        if !item.Tracked {
+            preview.New++
+        }
+    }
+    return preview, nil
+}
+
+// ImportCatalog enumerates a catalog and adds the products a tenant doesn't
+// track yet in the background, returning the job tracking them. Products
+// without a name from their link are named from their page, as when a
+// product is added with only a URL.
+func (pt *PriceTracker) ImportCatalog(ctx context.Context, req CatalogRequest, tenant string) (Job, error) {
+    req.Template.Tenant = tenant
+    c, err := parseCatalogRequest(req)
+    if err != nil {
+        return Job{}, err
+    }
+    items, err := pt.enumerateCatalog(ctx, c)
+    if err != nil {
+        return Job{}, err
+    }
+
+    var untracked []CatalogItem
+    for _, item := range items {
+        if !item.Tracked {
+            untracked = append(untracked, item)
+        }
+    }
+    job := pt.newJob("catalog-import", tenant, len(untracked))
+
+    go func() {
+        for _, item := range untracked {
+            result := pt.importCatalogItem(context.Background(), c.Template, item)
+            pt.recordValidation(job.ID, result)
+        }
+        pt.finishJob(job.ID)
+    }()
+    return job, nil
+}
+
+func (pt *PriceTracker) importCatalogItem(ctx context.Context, template Product, item CatalogItem) ValidationResult {
+    result := ValidationResult{URL: item.URL}
+
+    product := template
+    product.ID = ""
+    product.URL = item.URL
+    product.Name = item.Name
+    product, err := pt.DiscoverProduct(ctx, product)
+    if err == nil {
+        product, err = pt.CreateProduct(product)
+    }
+    if err != nil {
+        log.Printf("Catalog import: skipping %s: %v", item.URL, err)
+        result.Error = err.Error()
+        return result
+    }
+
+    result.ProductID = product.ID
+    result.OK = true
+    return result
+}
+
+// enumerateCatalog fetches the catalog and returns its product links,
+// deduplicated, in the order they appear
+func (pt *PriceTracker) enumerateCatalog(ctx context.Context, c parsedCatalog) ([]CatalogItem, error) {
+    page, err := pt.loadCatalogPage(ctx, c, c.URL)
+    if err != nil {
+        return nil, err
+    }
+
+    var links []CatalogItem
+    if locs, children, ok := parseSitemap(page); ok {
+        links = urlItems(locs)
+        for i, child := range children {
+            if i >= maxChildSitemaps || len(links) >= c.Limit {
+                break
+            }
+            childPage, err := pt.loadCatalogPage(ctx, c, resolveURL(c.URL, child))
+            if err != nil {
+                return nil, err
+            }
+            if locs, _, ok := parseSitemap(childPage); ok {
+                links = append(links, urlItems(locs)...)
+            }
+        }
+    } else {
+        if c.selector == nil {
+            return nil, fmt.Errorf("%w: %s isn't a sitemap, so a link_selector is needed", ErrInvalidCatalog, c.URL)
+        }
+        links = pageLinks(parseHTML(page), c.selector)
+    }
+
+    tracked := make(map[string]bool)
+    for _, product := range pt.tenantProducts(c.Template.Tenant) {
+        tracked[product.URL] = true
+    }
+
+    seen := make(map[string]bool)
+    items := []CatalogItem{}
+    for _, link := range links {
+        if len(items) >= c.Limit {
+            break
+        }
+        link.URL = catalogURL(c.URL, link.URL)
+        if link.URL == "" || seen[link.URL] || (c.pattern != nil && !c.pattern.MatchString(link.URL)) {
+            continue
+        }
+        seen[link.URL] = true
+        link.Tracked = tracked[link.URL]
+        items = append(items, link)
+    }
+    return items, nil
+}
+
+// loadCatalogPage fetches a catalog page or sitemap like a product page,
+// with the template's fetch profile, robots.txt setting and rendering
+func (pt *PriceTracker) loadCatalogPage(ctx context.Context, c parsedCatalog, pageURL string) (string, error) {
+    ctx, cancel := context.WithTimeout(ctx, pt.fetchTimeoutOrDefault())
+    defer cancel()
+
+    page := Product{
+        URL:          pageURL,
+        FetchProfile: c.Template.FetchProfile,
+        IgnoreRobots: c.Template.IgnoreRobots,
+        RenderJS:     c.Template.RenderJS,
+    }
+    if err := pt.hostLimiter.wait(ctx, pageURL); err != nil {
+        return "", fmt.Errorf("%w: %v", ErrCatalogUnavailable, err)
+    }
+    body, _, err := loadPage(ctx, page, pageValidators{})
+    if err != nil {
+        return "", fmt.Errorf("%w %s: %v", ErrCatalogUnavailable, pageURL, err)
+    }
+    return body, nil
+}
+
+// parseSitemap reads the page URLs of a sitemap, or the sitemap URLs of a
+// sitemap index. ok is false if the page isn't a sitemap.
+func parseSitemap(page string) (locs, children []string, ok bool) {
+    var doc struct {
+        XMLName xml.Name
+        URLs    []struct {
+            Loc string `xml:"loc"`
+        } `xml:"url"`
+        Sitemaps []struct {
+            Loc string `xml:"loc"`
+        } `xml:"sitemap"`
+    }
+    if err := xml.Unmarshal([]byte(page), &doc); err != nil {
+        return nil, nil, false
+    }
+    switch doc.XMLName.Local {
+    case "urlset":
+        for _, u := range doc.URLs {
+            locs = append(locs, strings.TrimSpace(u.Loc))
+        }
+    case "sitemapindex":
+        for _, s := range doc.Sitemaps {
+            children = append(children, strings.TrimSpace(s.Loc))
+        }
+    default:
+        return nil, nil, false
+    }
+    return locs, children, true
+}
+
+func urlItems(locs []string) []CatalogItem {
+    items := make([]CatalogItem, len(locs))
+    for i, loc := range locs {
+        items[i] = CatalogItem{URL: loc}
+    }
+    return items
+}
+
+// pageLinks returns the links of the elements matching selector: their own
+// href, or that of the first link inside them, named by the link's title or
+// text
+func pageLinks(root *goquery.Document, selector cssSelector) []CatalogItem {
+    var items []CatalogItem
+    root.FindMatcher(selector).Each(func(_ int, n *goquery.Selection) {
+        link := n
+        if _, ok := n.Attr("href"); !ok {
+            link = n.Find("a[href]").First()
+        }
+        if link.Length() == 0 {
+            return
+        }
+
+        name, ok := link.Attr("title")
+        if !ok || strings.TrimSpace(name) == "" {
+            name = link.Text()
+        }
+        name = strings.Join(strings.Fields(name), " ")
+        if len(name) > maxCatalogLinkName {
+            name = ""
+        }
+        items = append(items, CatalogItem{URL: link.AttrOr("href", ""), Name: name})
+    })
+    return items
+}
+
+// catalogURL resolves a link against the catalog URL and drops its
+// fragment. Links that aren't http(s) come back empty.
+func catalogURL(base, link string) string {
+    link = strings.TrimSpace(link)
+    if link == "" {
+        return ""
+    }
+    parsed, err := url.Parse(resolveURL(base, link))
+    if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+        return ""
+    }
+    parsed.Fragment = ""
+    return parsed.String()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"math"
+)
+
+// SetMinSignificantChange sets the default smallest price movement, in
+// absolute terms and as a percentage, that counts as a price change.
+// Products can override it with their own thresholds. Zero disables a
+// threshold.
+func (pt *PriceTracker) SetMinSignificantChange(absolute, percent float64) {
+    pt.statusMu.Lock()
+    defer pt.statusMu.Unlock()
+
+    pt.minChange = absolute
+    pt.minChangePercent = percent
+}
+
+// ChangePoints collapses a newest-first history for a product down to the
+// points where the price changed significantly
+func (pt *PriceTracker) ChangePoints(productID string, entries []PriceEntry) []PriceEntry {
+    pt.statusMu.Lock()
+    absolute, percent := pt.minChange, pt.minChangePercent
+    pt.statusMu.Unlock()
+
+    pt.mu.RLock()
+    product, ok := pt.products[productID]
+    pt.mu.RUnlock()
+
+    if ok && (product.MinChange > 0 || product.MinChangePercent > 0) {
+        absolute, percent = product.MinChange, product.MinChangePercent
+    }
+
+    return collapsePriceChanges(entries, func(prev, next float64) bool {
+        return isSignificantChange(prev, next, absolute, percent)
+    })
+}
+
+// isSignificantChange reports whether moving from prev to next clears both
+// thresholds. Rounding and currency conversion drift below them is ignored.
+func isSignificantChange(prev, next, absolute, percent float64) bool {
+    diff := math.Abs(next - prev)
+    if diff == 0 {
+        return false
+    }
+    if absolute > 0 && diff < absolute {
+        return false
+    }
+    if percent > 0 && prev != 0 && diff/math.Abs(prev)*100 < percent {
+        return false
+    }
+    return true
+}
+
+// collapsePriceChanges reduces a newest-first history to the points where the
+// price changed, always keeping the oldest and newest entries. Each entry is
+// compared with the last kept point, so slow drift is still caught once it
+// adds up to a significant change.
+func collapsePriceChanges(entries []PriceEntry, significant func(prev, next float64) bool) []PriceEntry {
+    if len(entries) <= 2 {
+        return entries
+    }
+
+    // walk oldest to newest, keeping an entry whenever the price moves
+    last := len(entries) - 1
+    collapsed := []PriceEntry{entries[last]}
+    for i := last - 1; i > 0; i-- {
+        if significant(collapsed[len(collapsed)-1].Price, entries[i].Price) {
+            collapsed = append(collapsed, entries[i])
+        }
+    }
+    collapsed = append(collapsed, entries[0])
+
+    // restore newest-first ordering
+    for i, j := 0, len(collapsed)-1; i < j; i, j = i+1, j-1 {
+        collapsed[i], collapsed[j] = collapsed[j], collapsed[i]
+    }
+
+    return collapsed
+}
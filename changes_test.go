@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCollapsePriceChanges(t *testing.T) {
+    // newest first
+    var entries []PriceEntry
+    for i, price := range []float64{12, 12, 11, 11, 11, 10, 10, 10} {
+        entries = append(entries, PriceEntry{ID: 8 - i, Price: price})
+    }
+    collapsed := collapsePriceChanges(entries, func(prev, next float64) bool { return prev != next })
+
+    var ids []int
+    for _, entry := range collapsed {
+        ids = append(ids, entry.ID)
+    }
+    // the newest, the two changes and the oldest
+    want := []int{8, 7, 4, 1}
+    if len(ids) != len(want) {
+        t.Fatalf("kept %v, want %v", ids, want)
+    }
+    for i := range want {
+        if ids[i] != want[i] {
+            t.Fatalf("kept %v, want %v", ids, want)
+        }
+    }
+}
+
+func TestCollapsePriceChangesKeepsShortHistory(t *testing.T) {
+    entries := []PriceEntry{{ID: 2, Price: 5}, {ID: 1, Price: 5}}
+    if got := collapsePriceChanges(entries, func(prev, next float64) bool { return prev != next }); len(got) != 2 {
+        t.Errorf("kept %d entries, want 2", len(got))
+    }
+}
+
+func TestHistoryChangesOnly(t *testing.T) {
+    tracker := newTestTracker(t, nil)
+    addTestProduct(t, tracker, "flat", 20, 20, 20, 18, 18, 18, 18, 19)
+    server := NewAPIServer(tracker)
+
+    var all, changes struct {
+        History []PriceEntry `json:"history"`
+        Count   int          `json:"count"`
+    }
+    serve(t, server, http.MethodGet, "/api/v1/products/flat/history", "", &all)
+    rec := serve(t, server, http.MethodGet, "/api/v1/products/flat/history?changes_only=true", "", &changes)
+    if rec.Code != http.StatusOK {
+        t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+    }
+    if all.Count != 8 {
+        t.Fatalf("full history has %d entries, want 8", all.Count)
+    }
+
+    var prices []float64
+    for _, entry := range changes.History {
+        prices = append(prices, entry.Price)
+    }
+    // newest (19), the change to 18, the oldest 20; flat stretches dropped
+    want := []float64{19, 18, 20}
+    if len(prices) != len(want) || changes.Count != len(want) {
+        t.Fatalf("changes_only prices = %v, want %v", prices, want)
+    }
+    for i := range want {
+        if prices[i] != want[i] {
+            t.Fatalf("changes_only prices = %v, want %v", prices, want)
+        }
+    }
+    if changes.History[0].ID != all.History[0].ID || changes.History[2].ID != all.History[7].ID {
+        t.Error("the first and last points weren't preserved")
+    }
+    if changes.History[1].ID != all.History[4].ID {
+        t.Errorf("change-point is entry %d, want the first 18 (%d)", changes.History[1].ID, all.History[4].ID)
+    }
+}
+
+func TestHistoryChangesOnlyRejectsInterval(t *testing.T) {
+    tracker := newTestTracker(t, nil)
+    addTestProduct(t, tracker, "flat", 20, 20)
+    server := NewAPIServer(tracker)
+
+    rec := serve(t, server, http.MethodGet, "/api/v1/products/flat/history?changes_only=true&interval=day", "", nil)
+    if rec.Code != http.StatusBadRequest {
+        t.Errorf("status = %d, want 400", rec.Code)
+    }
+}
+
+func TestIsSignificantChange(t *testing.T) {
+    tests := []struct {
+        prev, next, absolute, percent float64
+        want                          bool
+    }{
+        {10, 10, 0, 0, false},
+        {10, 10.001, 0, 0, true},
+        {10, 10.004, 0.01, 0, false}, // rounding drift
+        {10, 10.02, 0.01, 0, true},
+        {100, 100.5, 0, 1, false}, // 0.5%
+        {100, 99, 0, 1, true},
+        {100, 102, 5, 1, false}, // clears the percent but not the amount
+        {100, 106, 5, 1, true},
+        {0, 1, 0, 1, true},
+    }
+    for _, tt := range tests {
+        if got := isSignificantChange(tt.prev, tt.next, tt.absolute, tt.percent); got != tt.want {
+            t.Errorf("isSignificantChange(%v, %v, %v, %v) = %v, want %v", tt.prev, tt.next, tt.absolute, tt.percent, got, tt.want)
+        }
+    }
+}
+
+func TestChangePointsThresholds(t *testing.T) {
+    tracker := newTestTracker(t, nil)
+    server := NewAPIServer(tracker)
+    tracker.SetMinSignificantChange(0.01, 0)
+    addTestProduct(t, tracker, "drift", 10, 10.004, 9.998, 10.002, 12)
+
+    changedPrices := func(id string) []float64 {
+        t.Helper()
+        var changes struct {
+            History []PriceEntry `json:"history"`
+        }
+        serve(t, server, http.MethodGet, "/api/v1/products/"+id+"/history?changes_only=true", "", &changes)
+        var prices []float64
+        for _, entry := range changes.History {
+            prices = append(prices, entry.Price)
+        }
+        return prices
+    }
+
+    // sub-cent drift isn't a change; the move to 12 is
+    if prices := changedPrices("drift"); len(prices) != 2 || prices[0] != 12 || prices[1] != 10 {
+        t.Errorf("changes = %v, want [12 10]", prices)
+    }
+
+    // a product's own threshold overrides the default
+    product := addTestProduct(t, tracker, "coarse", 100, 101, 106, 107, 108)
+    if prices := changedPrices("coarse"); len(prices) != 5 {
+        t.Errorf("changes = %v, want every move with the default threshold", prices)
+    }
+    product.MinChangePercent = 5
+    if _, err := tracker.UpdateProduct(product); err != nil {
+        t.Fatal(err)
+    }
+    if prices := changedPrices("coarse"); len(prices) != 3 || prices[0] != 108 || prices[1] != 106 || prices[2] != 100 {
+        t.Errorf("changes = %v, want [108 106 100]: the newest, the 6%% move and the oldest", prices)
+    }
+}
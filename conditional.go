@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// errNotModified is returned by a conditional fetch whose page hasn't
+// changed since the cached validators were issued
+var errNotModified = errors.New("page not modified")
+
+// pageValidators are the ETag and Last-Modified a page was served with
+type pageValidators struct {
+    etag         string
+    lastModified string
+}
+
+func responseValidators(resp *http.Response) pageValidators {
+    return pageValidators{
+        etag:         resp.Header.Get("ETag"),
+        lastModified: resp.Header.Get("Last-Modified"),
+    }
+}
+
+func (v pageValidators) empty() bool {
+    return v.etag == "" && v.lastModified == ""
+}
+
+// apply makes req conditional on the validators. Only GETs are made
+// conditional; a POST fetch profile always gets a full response.
+func (v pageValidators) apply(req *http.Request) {
+    if req.Method != http.MethodGet {
+        return
+    }
+    if v.etag != "" {
+        req.Header.Set("If-None-Match", v.etag)
+    }
+    if v.lastModified != "" {
+        req.Header.Set("If-Modified-Since", v.lastModified)
+    }
+}
+
+// conditionalEntry is a product's last successful fetch: the page's
+// validators and the reading taken from it
+type conditionalEntry struct {
+    key        string
+    validators pageValidators
+    reading    PriceReading
+}
+
+// conditionalCache remembers, per product, the validators of its page and
+// the reading taken from it, so an unchanged page can be answered with a
+// 304 and the reading reused. Entries are tied to the URL and price rule
+// they were made with, so editing either refetches the page in full.
+type conditionalCache struct {
+    mu      sync.Mutex
+    entries map[string]conditionalEntry
+}
+
+var conditional = &conditionalCache{entries: make(map[string]conditionalEntry)}
+
+// conditionalKey identifies what a cached reading was extracted with
+func conditionalKey(product Product) string {
+    return product.URL + "\x00" + product.PriceSelector + "\x00" + product.PriceXPath + "\x00" + product.PriceRegex + "\x00" + product.PriceScript + "\x00" + product.ListPriceSelector + "\x00" + product.ShippingSelector + "\x00" + product.FingerprintSelector + "\x00" + product.PriceLocale + "\x00" + product.Currency
+}
+
+// get returns the product's entry if it matches its current URL and rule
+func (c *conditionalCache) get(product Product) (conditionalEntry, bool) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    entry, ok := c.entries[product.ID]
+    if !ok || entry.key != conditionalKey(product) {
+        return conditionalEntry{}, false
+    }
+    return entry, true
+}
+
+// put caches a successful fetch, unless the page came without validators
+func (c *conditionalCache) put(product Product, validators pageValidators, reading PriceReading) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if validators.empty() {
+        delete(c.entries, product.ID)
+        return
+    }
+    c.entries[product.ID] = conditionalEntry{
+        key:        conditionalKey(product),
+        validators: validators,
+        reading:    reading,
+    }
+}
+
+func (c *conditionalCache) forget(productID string) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    delete(c.entries, productID)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestNotModifiedRecordsPrice(t *testing.T) {
+    var full, notModified int32
+    pages := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/robots.txt" {
+            http.NotFound(w, r)
+            return
+        }
+        if r.Header.Get("If-None-Match") == `"v1"` {
+            atomic.AddInt32(&notModified, 1)
+            w.WriteHeader(http.StatusNotModified)
+            return
+        }
+        atomic.AddInt32(&full, 1)
+        w.Header().Set("ETag", `"v1"`)
+        w.Header().Set("Content-Type", "text/html; charset=utf-8")
+        w.Write([]byte(productPage))
+    }))
+    t.Cleanup(pages.Close)
+
+    tracker := newTestTracker(t, nil)
+    tracker.SetRetryPolicy(1, 0)
+    tracker.SetDuplicateEpsilon(0.01)
+    if _, err := tracker.CreateProduct(Product{ID: "conditional-laptop", Name: "Laptop", URL: pages.URL + "/laptop", PriceSelector: ".price"}); err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { conditional.forget("conditional-laptop") })
+
+    for i := 0; i < 3; i++ {
+        tracker.trackProducts(context.Background(), tracker.snapshotProducts(), nil)
+    }
+    if full != 1 || notModified != 2 {
+        t.Fatalf("%d full fetches and %d 304s, want 1 and 2", full, notModified)
+    }
+
+    // each 304 re-records the unchanged price with a new timestamp
+    history, err := tracker.GetPriceHistory("conditional-laptop", 10, true)
+    if err != nil || len(history) != 3 {
+        t.Fatalf("history = %v, %v; want 3 entries", history, err)
+    }
+    for i, entry := range history {
+        if entry.Price != 1299.99 {
+            t.Errorf("entry %d = %v, want 1299.99", i, entry.Price)
+        }
+        if i > 0 && !entry.Timestamp.Before(history[i-1].Timestamp) {
+            t.Errorf("entry %d at %s isn't older than the next one", i, entry.Timestamp)
+        }
+    }
+}
+
+func TestUnchangedPageStillSkipped(t *testing.T) {
+    // a full fetch of an unchanged price is still a duplicate
+    pages := newPageServer(t, productPage)
+    tracker := newTestTracker(t, nil)
+    tracker.SetRetryPolicy(1, 0)
+    tracker.SetDuplicateEpsilon(0.01)
+    if _, err := tracker.CreateProduct(Product{ID: "conditional-plain", Name: "Laptop", URL: pages.URL + "/laptop", PriceSelector: ".price"}); err != nil {
+        t.Fatal(err)
+    }
+
+    for i := 0; i < 2; i++ {
+        tracker.trackProducts(context.Background(), tracker.snapshotProducts(), nil)
+    }
+    if history, _ := tracker.GetPriceHistory("conditional-plain", 10, true); len(history) != 1 {
+        t.Errorf("stored %d entries, want 1", len(history))
+    }
+}
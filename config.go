@@ -0,0 +1,486 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the settings read from the environment at startup
+type Config struct {
+    DBPath        string
+    ListenAddr    string
+    TrackInterval time.Duration
+    NumWorkers    int
+    FetchTimeout  time.Duration
+    FetchAttempts int
+    RetryDelay    time.Duration
+    WebhookURL    string
+    APIKey        string
+
+    // TenantKeys are API keys by tenant name; a tenant's key only reaches
+    // that tenant's products
+    TenantKeys map[string]string
+
+    // GraphQL serves the read-only GraphQL API at /graphql
+    GraphQL bool
+
+    // AnonymizeExports replaces product IDs in CSV exports with tokens
+    // hashed with AnonymizationKey unless a request sets anonymize=false
+    AnonymizeExports bool
+    AnonymizationKey string
+
+    // UserAgents is the pool page fetches rotate through; each domain keeps
+    // its User-Agent for UserAgentStickiness
+    UserAgents          []string
+    UserAgentStickiness time.Duration
+
+    // HostRateLimit caps fetches per minute to any one host; zero is
+    // unlimited. HostRateBurst is how many may go back to back.
+    HostRateLimit int
+    HostRateBurst int
+
+    // HostConcurrency caps how many fetches are in flight to one host at
+    // once; zero is unlimited
+    HostConcurrency int
+
+    // BreakerThreshold is how many blocking failures in a row open a host's
+    // circuit breaker, which stays open for BreakerCooldown; zero disables
+    // the breakers
+    BreakerThreshold int
+    BreakerCooldown  time.Duration
+
+    // Proxies are the proxies page fetches go through, assigned to domains
+    // by ProxyStrategy; none fetches directly
+    Proxies       []*url.URL
+    ProxyStrategy string
+
+    // DuplicateEpsilon is how far a fetched price must move from the last
+    // stored price before it is stored again
+    DuplicateEpsilon float64
+
+    // Retention is how long price entries are kept; zero keeps them forever
+    Retention time.Duration
+
+    // SlowQueryThreshold is how long a database query may take before it is
+    // logged; zero disables slow query logging
+    SlowQueryThreshold time.Duration
+
+    // SaleInterval is how often products inside a sale window are checked;
+    // zero means a quarter of the product's interval
+    SaleInterval time.Duration
+
+    // WatchdogThreshold is how long tracking may go without completing a
+    // cycle before the loop is restarted; zero means three TrackInterval
+    WatchdogThreshold time.Duration
+
+    // ListingSimilarity is how similar, from 0 to 1, the product name a page
+    // shows must stay to the one it first showed before a listing alert is
+    // sent; zero disables the check
+    ListingSimilarity float64
+
+    // NotifyAttempts is how many times a webhook alert is tried before it is
+    // given up on; NotifyRetryDelay is the backoff after the first failure,
+    // doubling after each
+    NotifyAttempts   int
+    NotifyRetryDelay time.Duration
+
+    // MedianAlerts compares the de-noised median price with target prices
+    // instead of the latest fetch
+    MedianAlerts bool
+
+    // MedianSamples and MedianWindow make up the de-noised price: the median
+    // of the last MedianSamples fetches within MedianWindow
+    MedianSamples int
+    MedianWindow  time.Duration
+
+    // Amazon Product Advertising API credentials; Amazon products are
+    // scraped when they are unset
+    AmazonAccessKey   string
+    AmazonSecretKey   string
+    AmazonPartnerTag  string
+    AmazonPreferPrime bool
+
+    // EBayToken is an eBay application access token for the Browse API;
+    // eBay items are scraped when it is unset
+    EBayToken string
+
+    // BestBuyAPIKey is a Best Buy Products API key; Best Buy products are
+    // scraped when it is unset
+    BestBuyAPIKey string
+
+    // Walmart Affiliate API consumer ID, key version and PEM private key
+    // file; Walmart products are scraped when they are unset
+    WalmartConsumerID     string
+    WalmartKeyVersion     string
+    WalmartPrivateKeyFile string
+
+    // Google Shopping results API key and endpoint; Google Shopping
+    // searches can't be priced without a key
+    GoogleShoppingAPIKey   string
+    GoogleShoppingEndpoint string
+
+    // OpenExchangeRatesAppID selects Open Exchange Rates over the ECB for
+    // display currency conversion; rates are cached for ExchangeRatesTTL
+    OpenExchangeRatesAppID string
+    ExchangeRatesTTL       time.Duration
+}
+
+// LoadConfig reads the configuration from environment variables, using
+// defaults for any that are unset. Values that are set but invalid are
+// reported as errors rather than silently replaced by defaults.
+func LoadConfig() (Config, error) {
+    cfg := Config{
+        DBPath:        "prices.db",
+        ListenAddr:    ":8080",
+        TrackInterval: 30 * time.Second,
+        NumWorkers:    defaultNumWorkers,
+        FetchTimeout:  defaultFetchTimeout,
+        FetchAttempts: defaultFetchAttempts,
+        RetryDelay:    defaultRetryDelay,
+        WebhookURL:    os.Getenv("PRICE_ALERT_WEBHOOK_URL"),
+        APIKey:        os.Getenv("API_KEY"),
+
+        NotifyAttempts:   defaultNotifyAttempts,
+        NotifyRetryDelay: defaultNotifyRetryDelay,
+
+        MedianSamples: defaultMedianSamples,
+        MedianWindow:  defaultMedianWindow,
+
+        AnonymizationKey: os.Getenv("ANONYMIZATION_KEY"),
+
+        AmazonAccessKey:  os.Getenv("AMAZON_ACCESS_KEY"),
+        AmazonSecretKey:  os.Getenv("AMAZON_SECRET_KEY"),
+        AmazonPartnerTag: os.Getenv("AMAZON_PARTNER_TAG"),
+        EBayToken:        os.Getenv("EBAY_APP_TOKEN"),
+        BestBuyAPIKey:    os.Getenv("BESTBUY_API_KEY"),
+
+        WalmartConsumerID:     os.Getenv("WALMART_CONSUMER_ID"),
+        WalmartKeyVersion:     "1",
+        WalmartPrivateKeyFile: os.Getenv("WALMART_PRIVATE_KEY_FILE"),
+
+        GoogleShoppingAPIKey:   os.Getenv("GOOGLE_SHOPPING_API_KEY"),
+        GoogleShoppingEndpoint: os.Getenv("GOOGLE_SHOPPING_API_URL"),
+
+        OpenExchangeRatesAppID: os.Getenv("OPENEXCHANGERATES_APP_ID"),
+        ExchangeRatesTTL:       defaultExchangeRatesTTL,
+
+        UserAgentStickiness: defaultUserAgentStickiness,
+        ProxyStrategy:       ProxyRoundRobin,
+        HostRateLimit:       defaultHostRateLimit,
+        HostRateBurst:       defaultHostRateBurst,
+        HostConcurrency:     defaultHostConcurrency,
+        BreakerThreshold:    defaultBreakerThreshold,
+        BreakerCooldown:     defaultBreakerCooldown,
+        ListingSimilarity:   defaultListingSimilarity,
+        WatchdogThreshold:   defaultWatchdogThreshold,
+        SlowQueryThreshold:  defaultSlowQueryThreshold,
+        DuplicateEpsilon:    defaultDuplicateEpsilon,
+    }
+
+    if v := os.Getenv("TENANT_API_KEYS"); v != "" {
+        keys, err := parseTenantKeys(v)
+        if err != nil {
+            return Config{}, fmt.Errorf("invalid TENANT_API_KEYS: %w", err)
+        }
+        cfg.TenantKeys = keys
+    }
+
+    if v := os.Getenv("DB_PATH"); v != "" {
+        cfg.DBPath = v
+    }
+    if v := os.Getenv("LISTEN_ADDR"); v != "" {
+        cfg.ListenAddr = v
+    }
+
+    if v := os.Getenv("TRACK_INTERVAL"); v != "" {
+        interval, err := time.ParseDuration(v)
+        if err != nil {
+            return Config{}, fmt.Errorf("invalid TRACK_INTERVAL %q: %w", v, err)
+        }
+        if interval <= 0 {
+            return Config{}, fmt.Errorf("invalid TRACK_INTERVAL %q: must be positive", v)
+        }
+        cfg.TrackInterval = interval
+    }
+
+    if v := os.Getenv("SALE_INTERVAL"); v != "" {
+        interval, err := time.ParseDuration(v)
+        if err != nil {
+            return Config{}, fmt.Errorf("invalid SALE_INTERVAL %q: %w", v, err)
+        }
+        if interval <= 0 {
+            return Config{}, fmt.Errorf("invalid SALE_INTERVAL %q: must be positive", v)
+        }
+        cfg.SaleInterval = interval
+    }
+
+    if v := os.Getenv("WATCHDOG_THRESHOLD"); v != "" {
+        threshold, err := time.ParseDuration(v)
+        if err != nil {
+            return Config{}, fmt.Errorf("invalid WATCHDOG_THRESHOLD %q: %w", v, err)
+        }
+        if threshold < 0 {
+            return Config{}, fmt.Errorf("invalid WATCHDOG_THRESHOLD %q: must not be negative", v)
+        }
+        cfg.WatchdogThreshold = threshold
+    }
+
+    if v := os.Getenv("SLOW_QUERY_THRESHOLD"); v != "" {
+        threshold, err := time.ParseDuration(v)
+        if err != nil {
+            return Config{}, fmt.Errorf("invalid SLOW_QUERY_THRESHOLD %q: %w", v, err)
+        }
+        if threshold < 0 {
+            return Config{}, fmt.Errorf("invalid SLOW_QUERY_THRESHOLD %q: must not be negative", v)
+        }
+        cfg.SlowQueryThreshold = threshold
+    }
+
+    if v := os.Getenv("FETCH_TIMEOUT"); v != "" {
+        timeout, err := time.ParseDuration(v)
+        if err != nil {
+            return Config{}, fmt.Errorf("invalid FETCH_TIMEOUT %q: %w", v, err)
+        }
+        if timeout <= 0 {
+            return Config{}, fmt.Errorf("invalid FETCH_TIMEOUT %q: must be positive", v)
+        }
+        cfg.FetchTimeout = timeout
+    }
+
+    if v := os.Getenv("FETCH_ATTEMPTS"); v != "" {
+        attempts, err := strconv.Atoi(v)
+        if err != nil || attempts <= 0 {
+            return Config{}, fmt.Errorf("invalid FETCH_ATTEMPTS %q: must be a positive integer", v)
+        }
+        cfg.FetchAttempts = attempts
+    }
+
+    if v := os.Getenv("RETRY_DELAY"); v != "" {
+        delay, err := time.ParseDuration(v)
+        if err != nil {
+            return Config{}, fmt.Errorf("invalid RETRY_DELAY %q: %w", v, err)
+        }
+        if delay <= 0 {
+            return Config{}, fmt.Errorf("invalid RETRY_DELAY %q: must be positive", v)
+        }
+        cfg.RetryDelay = delay
+    }
+
+    if v := os.Getenv("RETENTION_DAYS"); v != "" {
+        days, err := strconv.Atoi(v)
+        if err != nil || days < 0 {
+            return Config{}, fmt.Errorf("invalid RETENTION_DAYS %q: must be a whole number of days, 0 to keep everything", v)
+        }
+        cfg.Retention = time.Duration(days) * 24 * time.Hour
+    }
+
+    if v := os.Getenv("DUPLICATE_EPSILON"); v != "" {
+        epsilon, err := strconv.ParseFloat(v, 64)
+        if err != nil || !(epsilon >= 0) || math.IsInf(epsilon, 1) {
+            return Config{}, fmt.Errorf("invalid DUPLICATE_EPSILON %q: must be a finite number, 0 or more", v)
+        }
+        cfg.DuplicateEpsilon = epsilon
+    }
+
+    if v := os.Getenv("LISTING_SIMILARITY"); v != "" {
+        similarity, err := strconv.ParseFloat(v, 64)
+        if err != nil || !(similarity >= 0 && similarity <= 1) {
+            return Config{}, fmt.Errorf("invalid LISTING_SIMILARITY %q: must be a number from 0 to 1, 0 to disable", v)
+        }
+        cfg.ListingSimilarity = similarity
+    }
+
+    // User-Agents contain commas and spaces, so the list is split on | or
+    // newlines
+    if v := os.Getenv("USER_AGENTS"); v != "" {
+        for _, agent := range strings.FieldsFunc(v, func(r rune) bool { return r == '|' || r == '\n' }) {
+            if agent = strings.TrimSpace(agent); agent != "" {
+                cfg.UserAgents = append(cfg.UserAgents, agent)
+            }
+        }
+    }
+
+    if v := os.Getenv("EXCHANGE_RATES_TTL"); v != "" {
+        ttl, err := time.ParseDuration(v)
+        if err != nil {
+            return Config{}, fmt.Errorf("invalid EXCHANGE_RATES_TTL %q: %w", v, err)
+        }
+        if ttl <= 0 {
+            return Config{}, fmt.Errorf("invalid EXCHANGE_RATES_TTL %q: must be positive", v)
+        }
+        cfg.ExchangeRatesTTL = ttl
+    }
+
+    if v := os.Getenv("USER_AGENT_STICKINESS"); v != "" {
+        stickiness, err := time.ParseDuration(v)
+        if err != nil {
+            return Config{}, fmt.Errorf("invalid USER_AGENT_STICKINESS %q: %w", v, err)
+        }
+        if stickiness < 0 {
+            return Config{}, fmt.Errorf("invalid USER_AGENT_STICKINESS %q: must not be negative", v)
+        }
+        cfg.UserAgentStickiness = stickiness
+    }
+
+    if v := os.Getenv("HOST_RATE_LIMIT"); v != "" {
+        limit, err := strconv.Atoi(v)
+        if err != nil || limit < 0 {
+            return Config{}, fmt.Errorf("invalid HOST_RATE_LIMIT %q: must be a whole number of requests per minute, 0 for no limit", v)
+        }
+        cfg.HostRateLimit = limit
+    }
+    if v := os.Getenv("HOST_RATE_BURST"); v != "" {
+        burst, err := strconv.Atoi(v)
+        if err != nil || burst <= 0 {
+            return Config{}, fmt.Errorf("invalid HOST_RATE_BURST %q: must be a positive integer", v)
+        }
+        cfg.HostRateBurst = burst
+    }
+    if v := os.Getenv("HOST_CONCURRENCY"); v != "" {
+        limit, err := strconv.Atoi(v)
+        if err != nil || limit < 0 {
+            return Config{}, fmt.Errorf("invalid HOST_CONCURRENCY %q: must be a whole number of fetches, 0 for no limit", v)
+        }
+        cfg.HostConcurrency = limit
+    }
+
+    if v := os.Getenv("BREAKER_THRESHOLD"); v != "" {
+        threshold, err := strconv.Atoi(v)
+        if err != nil || threshold < 0 {
+            return Config{}, fmt.Errorf("invalid BREAKER_THRESHOLD %q: must be a whole number of failures, 0 to disable", v)
+        }
+        cfg.BreakerThreshold = threshold
+    }
+
+    if v := os.Getenv("BREAKER_COOLDOWN"); v != "" {
+        cooldown, err := time.ParseDuration(v)
+        if err != nil {
+            return Config{}, fmt.Errorf("invalid BREAKER_COOLDOWN %q: %w", v, err)
+        }
+        if cooldown <= 0 {
+            return Config{}, fmt.Errorf("invalid BREAKER_COOLDOWN %q: must be positive", v)
+        }
+        cfg.BreakerCooldown = cooldown
+    }
+
+    if v := os.Getenv("PROXIES"); v != "" {
+        list, err := ParseProxies(v)
+        if err != nil {
+            return Config{}, fmt.Errorf("invalid PROXIES: %w", err)
+        }
+        cfg.Proxies = list
+    }
+
+    if v := os.Getenv("PROXY_STRATEGY"); v != "" {
+        if v != ProxyRoundRobin && v != ProxyRandom {
+            return Config{}, fmt.Errorf("invalid PROXY_STRATEGY %q: must be %s or %s", v, ProxyRoundRobin, ProxyRandom)
+        }
+        cfg.ProxyStrategy = v
+    }
+
+    if cfg.AmazonAccessKey != "" || cfg.AmazonSecretKey != "" || cfg.AmazonPartnerTag != "" {
+        if cfg.AmazonAccessKey == "" || cfg.AmazonSecretKey == "" || cfg.AmazonPartnerTag == "" {
+            return Config{}, fmt.Errorf("AMAZON_ACCESS_KEY, AMAZON_SECRET_KEY and AMAZON_PARTNER_TAG must be set together")
+        }
+    }
+
+    if v := os.Getenv("ANONYMIZE_EXPORTS"); v != "" {
+        enabled, err := strconv.ParseBool(v)
+        if err != nil {
+            return Config{}, fmt.Errorf("invalid ANONYMIZE_EXPORTS %q: must be true or false", v)
+        }
+        cfg.AnonymizeExports = enabled
+    }
+    if cfg.AnonymizeExports && cfg.AnonymizationKey == "" {
+        return Config{}, fmt.Errorf("ANONYMIZE_EXPORTS needs ANONYMIZATION_KEY to be set")
+    }
+
+    if v := os.Getenv("GRAPHQL_ENABLED"); v != "" {
+        enabled, err := strconv.ParseBool(v)
+        if err != nil {
+            return Config{}, fmt.Errorf("invalid GRAPHQL_ENABLED %q: must be true or false", v)
+        }
+        cfg.GraphQL = enabled
+    }
+
+    if v := os.Getenv("NOTIFY_ATTEMPTS"); v != "" {
+        attempts, err := strconv.Atoi(v)
+        if err != nil || attempts <= 0 {
+            return Config{}, fmt.Errorf("invalid NOTIFY_ATTEMPTS %q: must be a positive integer", v)
+        }
+        cfg.NotifyAttempts = attempts
+    }
+
+    if v := os.Getenv("NOTIFY_RETRY_DELAY"); v != "" {
+        delay, err := time.ParseDuration(v)
+        if err != nil {
+            return Config{}, fmt.Errorf("invalid NOTIFY_RETRY_DELAY %q: %w", v, err)
+        }
+        if delay <= 0 {
+            return Config{}, fmt.Errorf("invalid NOTIFY_RETRY_DELAY %q: must be positive", v)
+        }
+        cfg.NotifyRetryDelay = delay
+    }
+
+    if v := os.Getenv("MEDIAN_ALERTS"); v != "" {
+        enabled, err := strconv.ParseBool(v)
+        if err != nil {
+            return Config{}, fmt.Errorf("invalid MEDIAN_ALERTS %q: must be true or false", v)
+        }
+        cfg.MedianAlerts = enabled
+    }
+
+    if v := os.Getenv("MEDIAN_SAMPLES"); v != "" {
+        samples, err := strconv.Atoi(v)
+        if err != nil || samples <= 0 {
+            return Config{}, fmt.Errorf("invalid MEDIAN_SAMPLES %q: must be a positive integer", v)
+        }
+        cfg.MedianSamples = samples
+    }
+
+    if v := os.Getenv("MEDIAN_WINDOW"); v != "" {
+        window, err := time.ParseDuration(v)
+        if err != nil {
+            return Config{}, fmt.Errorf("invalid MEDIAN_WINDOW %q: %w", v, err)
+        }
+        if window <= 0 {
+            return Config{}, fmt.Errorf("invalid MEDIAN_WINDOW %q: must be positive", v)
+        }
+        cfg.MedianWindow = window
+    }
+
+    if v := os.Getenv("AMAZON_PREFER_PRIME"); v != "" {
+        prefer, err := strconv.ParseBool(v)
+        if err != nil {
+            return Config{}, fmt.Errorf("invalid AMAZON_PREFER_PRIME %q: must be true or false", v)
+        }
+        cfg.AmazonPreferPrime = prefer
+    }
+
+    if (cfg.WalmartConsumerID == "") != (cfg.WalmartPrivateKeyFile == "") {
+        return Config{}, fmt.Errorf("WALMART_CONSUMER_ID and WALMART_PRIVATE_KEY_FILE must be set together")
+    }
+    if v := os.Getenv("WALMART_KEY_VERSION"); v != "" {
+        cfg.WalmartKeyVersion = v
+    }
+    if cfg.GoogleShoppingEndpoint != "" {
+        if err := validateProductURL(cfg.GoogleShoppingEndpoint); err != nil {
+            return Config{}, fmt.Errorf("invalid GOOGLE_SHOPPING_API_URL: %w", err)
+        }
+    }
+
+    if v := os.Getenv("NUM_WORKERS"); v != "" {
+        workers, err := strconv.Atoi(v)
+        if err != nil || workers <= 0 {
+            return Config{}, fmt.Errorf("invalid NUM_WORKERS %q: must be a positive integer", v)
+        }
+        cfg.NumWorkers = workers
+    }
+
+    return cfg, nil
+}
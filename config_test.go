@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigDefaults(t *testing.T) {
+    for _, name := range []string{"DB_PATH", "LISTEN_ADDR", "TRACK_INTERVAL", "NUM_WORKERS", "FETCH_TIMEOUT", "DUPLICATE_EPSILON", "MEDIAN_SAMPLES", "MEDIAN_WINDOW", "SALE_INTERVAL", "HOST_RATE_BURST"} {
+        t.Setenv(name, "")
+    }
+
+    cfg, err := LoadConfig()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if cfg.DBPath != "prices.db" || cfg.ListenAddr != ":8080" || cfg.TrackInterval != 30*time.Second || cfg.NumWorkers != defaultNumWorkers || cfg.FetchTimeout != defaultFetchTimeout || cfg.DuplicateEpsilon != defaultDuplicateEpsilon {
+        t.Errorf("defaults = %+v", cfg)
+    }
+    if cfg.MedianSamples != 5 || cfg.MedianWindow != time.Hour {
+        t.Errorf("median sampling = %d within %v, want 5 within an hour", cfg.MedianSamples, cfg.MedianWindow)
+    }
+    if cfg.HostRateBurst != defaultHostRateBurst {
+        t.Errorf("host rate burst = %d, want %d", cfg.HostRateBurst, defaultHostRateBurst)
+    }
+    // no sale interval: a quarter of each product's interval
+    if cfg.SaleInterval != 0 {
+        t.Errorf("sale interval = %v, want 0", cfg.SaleInterval)
+    }
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+    t.Setenv("DB_PATH", "/var/lib/tracker/prices.db")
+    t.Setenv("LISTEN_ADDR", "127.0.0.1:9090")
+    t.Setenv("TRACK_INTERVAL", "1m30s")
+    t.Setenv("NUM_WORKERS", "12")
+    t.Setenv("FETCH_TIMEOUT", "5s")
+    t.Setenv("GRAPHQL_ENABLED", "true")
+    t.Setenv("DUPLICATE_EPSILON", "0.5")
+    t.Setenv("MEDIAN_SAMPLES", "9")
+    t.Setenv("MEDIAN_WINDOW", "3h")
+    t.Setenv("SALE_INTERVAL", "2m")
+    t.Setenv("HOST_RATE_BURST", "10")
+
+    cfg, err := LoadConfig()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if cfg.DBPath != "/var/lib/tracker/prices.db" || cfg.ListenAddr != "127.0.0.1:9090" {
+        t.Errorf("paths = %q %q", cfg.DBPath, cfg.ListenAddr)
+    }
+    if cfg.TrackInterval != 90*time.Second || cfg.NumWorkers != 12 || cfg.FetchTimeout != 5*time.Second || !cfg.GraphQL || cfg.DuplicateEpsilon != 0.5 {
+        t.Errorf("config = %+v", cfg)
+    }
+    if cfg.MedianSamples != 9 || cfg.MedianWindow != 3*time.Hour {
+        t.Errorf("median sampling = %d within %v", cfg.MedianSamples, cfg.MedianWindow)
+    }
+    if cfg.SaleInterval != 2*time.Minute {
+        t.Errorf("sale interval = %v, want 2m", cfg.SaleInterval)
+    }
+    if cfg.HostRateBurst != 10 {
+        t.Errorf("host rate burst = %d, want 10", cfg.HostRateBurst)
+    }
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+    for name, value := range map[string]string{
+        "TRACK_INTERVAL":  "90",
+        "NUM_WORKERS":     "0",
+        "FETCH_TIMEOUT":   "soon",
+        "GRAPHQL_ENABLED": "maybe",
+        "MEDIAN_SAMPLES":  "0",
+        "MEDIAN_WINDOW":   "-1h",
+        "SALE_INTERVAL":   "0s",
+        "HOST_RATE_BURST": "0",
+    } {
+        t.Run(name, func(t *testing.T) {
+            t.Setenv(name, value)
+            // an invalid value is an error, not the default
+            if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), name) {
+                t.Errorf("%s=%q: err = %v, want an error naming it", name, value, err)
+            }
+        })
+    }
+
+    t.Setenv("TRACK_INTERVAL", "-1m")
+    if _, err := LoadConfig(); err == nil {
+        t.Error("a negative interval was accepted")
+    }
+}
+
+func TestLoadConfigDuplicateEpsilon(t *testing.T) {
+    for value, want := range map[string]float64{"0": 0, "0.05": 0.05, "2": 2} {
+        t.Setenv("DUPLICATE_EPSILON", value)
+        if cfg, err := LoadConfig(); err != nil || cfg.DuplicateEpsilon != want {
+            t.Errorf("DUPLICATE_EPSILON=%q: %v, %v; want %v", value, cfg.DuplicateEpsilon, err, want)
+        }
+    }
+
+    for _, value := range []string{"-0.01", "NaN", "Inf", "+Inf", "cent"} {
+        t.Setenv("DUPLICATE_EPSILON", value)
+        if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "DUPLICATE_EPSILON") {
+            t.Errorf("DUPLICATE_EPSILON=%q: err = %v, want an error naming it", value, err)
+        }
+    }
+}
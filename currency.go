@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+)
+
+// currencySymbols are the prefixes used when formatting common currencies;
+// others are shown with their code after the amount
+var currencySymbols = map[string]string{
+    "USD": "$",
+    "EUR": "€",
+    "GBP": "£",
+    "JPY": "¥",
+}
+
+// isCurrencyCode reports whether code looks like an ISO 4217 currency code
+func isCurrencyCode(code string) bool {
+    if len(code) != 3 {
+        return false
+    }
+    for i := 0; i < len(code); i++ {
+        if code[i] < 'A' || code[i] > 'Z' {
+            return false
+        }
+    }
+    return true
+}
+
+// formatPrice formats a price for display, e.g. "$12.50" or "12.50 CHF"
+func formatPrice(price float64, currency string) string {
+    if currency == "" {
+        currency = DefaultCurrency
+    }
+    if symbol, ok := currencySymbols[currency]; ok {
+        return fmt.Sprintf("%s%.2f", symbol, price)
+    }
+    return fmt.Sprintf("%.2f %s", price, currency)
+}
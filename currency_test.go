@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCurrencyRoundTrip(t *testing.T) {
+    tracker := newTestTracker(t, cyclePrices(89.9))
+    tracker.SetRetryPolicy(1, 0)
+    server := NewAPIServer(tracker)
+
+    for _, body := range []string{
+        `{"id": "currency-eu", "name": "Kettle", "url": "https://shop.test/eu/kettle", "currency": " eur "}`,
+        `{"id": "currency-us", "name": "Kettle", "url": "https://shop.test/us/kettle"}`,
+    } {
+        if rec := serve(t, server, "POST", "/api/v1/products", body, nil); rec.Code != http.StatusCreated {
+            t.Fatalf("create = %d: %s", rec.Code, rec.Body)
+        }
+    }
+    tracker.trackProducts(context.Background(), tracker.snapshotProducts(), nil)
+
+    for id, want := range map[string]string{"currency-eu": "EUR", "currency-us": DefaultCurrency} {
+        var history struct {
+            History []PriceEntry `json:"history"`
+        }
+        serve(t, server, "GET", "/api/v1/products/"+id+"/history", "", &history)
+        if len(history.History) != 1 || history.History[0].Currency != want {
+            t.Errorf("%s history = %+v, want one %s price", id, history.History, want)
+        }
+
+        var stats PriceStats
+        serve(t, server, "GET", "/api/v1/products/"+id+"/stats", "", &stats)
+        if stats.Currency != want || stats.LatestPrice != 89.9 {
+            t.Errorf("%s stats = %+v, want %s", id, stats, want)
+        }
+    }
+
+    var page ProductPage
+    serve(t, server, "GET", "/api/v1/products", "", &page)
+    if len(page.Items) != 2 {
+        t.Fatalf("listing = %+v, want both products", page.Items)
+    }
+    for _, item := range page.Items {
+        want := map[string]string{"currency-eu": "EUR", "currency-us": DefaultCurrency}[item.ID]
+        if item.Currency != want || item.LatestPrice == nil || *item.LatestPrice != 89.9 {
+            t.Errorf("listing %s = %s %v, want %s 89.9", item.ID, item.Currency, item.LatestPrice, want)
+        }
+    }
+}
+
+func TestCurrencyStoredWithEntry(t *testing.T) {
+    tracker := newTestTracker(t, nil)
+    addTestProduct(t, tracker, "currency-gb")
+
+    // an entry keeps its own currency
+    entry := PriceEntry{ProductID: "currency-gb", Price: 45, Currency: "GBP", Timestamp: time.Now().UTC()}
+    if _, err := tracker.db.InsertPriceEntry(entry); err != nil {
+        t.Fatal(err)
+    }
+    history, err := tracker.db.GetPriceHistory("currency-gb", 10, true)
+    if err != nil || len(history) != 1 || history[0].Currency != "GBP" {
+        t.Errorf("history = %+v, %v; want a GBP price", history, err)
+    }
+
+    server := NewAPIServer(tracker)
+    if rec := serve(t, server, "POST", "/api/v1/products", `{"id": "currency-bad", "name": "Kettle", "url": "https://shop.test/kettle", "currency": "euro"}`, nil); rec.Code != http.StatusBadRequest {
+        t.Errorf("invalid currency = %d, want 400", rec.Code)
+    }
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestDashboardPage(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+
+    rec := serve(t, server, "GET", "/", "", nil)
+    if rec.Code != http.StatusOK {
+        t.Fatalf("root page = %d: %s", rec.Code, rec.Body)
+    }
+    if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/html") {
+        t.Errorf("content type = %q, want HTML", got)
+    }
+
+    page := rec.Body.String()
+    for _, want := range []string{
+        `id="dashboard"`,
+        `id="filter"`,
+        `id="sort"`,
+        `fetch("/api/v1/products?limit=200&offset="`,
+        `"/history?limit=30"`,
+    } {
+        if !strings.Contains(page, want) {
+            t.Errorf("root page is missing %q", want)
+        }
+    }
+
+    // every API route is listed in the endpoint reference
+    routes := 0
+    err := server.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+        path, err := route.GetPathTemplate()
+        if err != nil || !strings.HasPrefix(path, "/api/v1/") {
+            return nil
+        }
+        routes++
+        if !strings.Contains(page, path) {
+            t.Errorf("root page doesn't list %s", path)
+        }
+        return nil
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if routes == 0 {
+        t.Error("found no API routes")
+    }
+}
+
+// the dashboard script reads these fields, so they must stay in the API
+func TestDashboardDataFields(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    product := addTestProduct(t, server.tracker, "dashboard-mug", 12, 10)
+    product.Tags = []string{"kitchen"}
+    if _, err := server.tracker.UpdateProduct(product); err != nil {
+        t.Fatal(err)
+    }
+
+    var page struct {
+        Items []map[string]interface{} `json:"items"`
+        Total int                      `json:"total"`
+    }
+    if rec := serve(t, server, "GET", "/api/v1/products?limit=200&offset=0", "", &page); rec.Code != http.StatusOK {
+        t.Fatalf("products = %d: %s", rec.Code, rec.Body)
+    }
+    if len(page.Items) != 1 || page.Total != 1 {
+        t.Fatalf("products = %+v, want one", page)
+    }
+    item := page.Items[0]
+    for _, field := range []string{"id", "name", "url", "currency", "tags", "latest_price", "previous_price", "change_percent", "last_updated"} {
+        if _, ok := item[field]; !ok {
+            t.Errorf("product is missing %q: %v", field, item)
+        }
+    }
+
+    var history struct {
+        History []PriceEntry `json:"history"`
+    }
+    if rec := serve(t, server, "GET", "/api/v1/products/dashboard-mug/history?limit=30", "", &history); rec.Code != http.StatusOK || len(history.History) != 2 {
+        t.Errorf("history = %d with %d entries, want 2", rec.Code, len(history.History))
+    }
+}
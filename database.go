@@ -1,64 +1,188 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 )
 
 type Database struct {
     db *sql.DB
+
+    // query timings, guarded by statsMu
+    statsMu            sync.Mutex
+    timings            map[string]*queryTiming
+    slowQueryThreshold time.Duration
+}
+
+type queryTiming struct {
+    count int64
+    slow  int64
+    total time.Duration
+    max   time.Duration
 }
 
+// busyTimeout is how long a query waits for another connection's lock
+// before failing with SQLITE_BUSY. Prices, notifications and API writes run
+// on separate goroutines, so queries do overlap.
+const busyTimeout = 5 * time.Second
+
 func NewDatabase(dbPath string) (*Database, error) {
-    db, err := sql.Open("sqlite", dbPath)
+    sep := "?"
+    if strings.Contains(dbPath, "?") {
+        sep = "&"
+    }
+    dsn := fmt.Sprintf("%s%s_pragma=busy_timeout(%d)", dbPath, sep, busyTimeout.Milliseconds())
+    db, err := sql.Open("sqlite", dsn)
     if err != nil {
         return nil, err
     }
 
-    database := &Database{db: db}
-    if err := database.createTables(); err != nil {
+    // sql.Open is lazy; ping so a bad driver or path fails here rather
+    // than on the first query
+    if err := db.Ping(); err != nil {
+        db.Close()
+        return nil, err
+    }
+
+    database := &Database{
+        db:      db,
+        timings: make(map[string]*queryTiming),
+    }
+    if err := database.migrate(); err != nil {
+        db.Close()
         return nil, err
     }
 
     return database, nil
 }
 
-func (d *Database) createTables() error {
-    queries := []string{
-        `CREATE TABLE IF NOT EXISTS products (
-            id TEXT PRIMARY KEY,
-            name TEXT NOT NULL,
-            url TEXT NOT NULL,
-            created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-        )`,
-        `CREATE TABLE IF NOT EXISTS price_entries (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            product_id TEXT NOT NULL,
-            price REAL NOT NULL,
-            timestamp DATETIME NOT NULL,
-            FOREIGN KEY (product_id) REFERENCES products (id)
-        )`,
-        `CREATE INDEX IF NOT EXISTS idx_price_entries_product_id ON price_entries (product_id)`,
-        `CREATE INDEX IF NOT EXISTS idx_price_entries_timestamp ON price_entries (timestamp)`,
-    }
+// productColumns lists the products table columns read into a Product, in the
+// order returned by productFields
+var productColumns = []string{
+    "id", "name", "url", "image_url", "price_selector", "min_change", "min_change_percent", "priority", "target_price", "currency", "interval_seconds", "price_xpath", "render_js", "price_regex", "fetch_profile", "ignore_robots", "list_price_selector", "price_locale", "fetcher", "price_script", "shipping_selector", "tags", "notes", "tenant", "fingerprint_selector",
+}
 
-    for _, query := range queries {
-        if _, err := d.db.Exec(query); err != nil {
-            return err
+// productColumnList returns productColumns as a select list, prefixed with
+// the table alias if one is given
+func productColumnList(alias string) string {
+    columns := make([]string, len(productColumns))
+    for i, column := range productColumns {
+        if alias != "" {
+            column = alias + "." + column
         }
+        columns[i] = column
+    }
+    return strings.Join(columns, ", ")
+}
+
+// productFields returns pointers to the Product fields matching productColumns
+func productFields(p *Product) []interface{} {
+    return []interface{}{
+        &p.ID, &p.Name, &p.URL, &p.ImageURL, &p.PriceSelector, &p.MinChange, &p.MinChangePercent, &p.Priority, &p.TargetPrice, &p.Currency, &p.IntervalSeconds, &p.PriceXPath, &p.RenderJS, &p.PriceRegex, profileColumn{&p.FetchProfile}, &p.IgnoreRobots, &p.ListPriceSelector, &p.PriceLocale, &p.Fetcher, &p.PriceScript, &p.ShippingSelector, tagsColumn{&p.Tags}, &p.Notes, &p.Tenant, &p.FingerprintSelector,
+    }
+}
+
+// productValues returns the Product field values matching productColumns
+func productValues(p Product) []interface{} {
+    return []interface{}{
+        p.ID, p.Name, p.URL, p.ImageURL, p.PriceSelector, p.MinChange, p.MinChangePercent, p.Priority, p.TargetPrice, p.Currency, p.IntervalSeconds, p.PriceXPath, p.RenderJS, p.PriceRegex, profileColumn{&p.FetchProfile}, p.IgnoreRobots, p.ListPriceSelector, p.PriceLocale, p.Fetcher, p.PriceScript, p.ShippingSelector, tagsColumn{&p.Tags}, p.Notes, p.Tenant, p.FingerprintSelector,
+    }
+}
+
+// profileColumn stores a product's fetch profile as JSON text, with an
+// empty string for no profile
+type profileColumn struct {
+    profile **FetchProfile
+}
+
+func (c profileColumn) Scan(src interface{}) error {
+    *c.profile = nil
+    var data []byte
+    switch v := src.(type) {
+    case nil:
+        return nil
+    case string:
+        data = []byte(v)
+    case []byte:
+        data = v
+    default:
+        return fmt.Errorf("fetch_profile: unexpected type %T", src)
+    }
+    if len(data) == 0 {
+        return nil
+    }
+    return json.Unmarshal(data, c.profile)
+}
+
+func (c profileColumn) Value() (driver.Value, error) {
+    if *c.profile == nil {
+        return "", nil
+    }
+    data, err := json.Marshal(*c.profile)
+    if err != nil {
+        return nil, err
+    }
+    return string(data), nil
+}
+
+// tagsColumn stores a product's tags as a JSON array, with an empty string
+// for no tags
+type tagsColumn struct {
+    tags *[]string
+}
+
+func (c tagsColumn) Scan(src interface{}) error {
+    *c.tags = nil
+    var data []byte
+    switch v := src.(type) {
+    case nil:
+        return nil
+    case string:
+        data = []byte(v)
+    case []byte:
+        data = v
+    default:
+        return fmt.Errorf("tags: unexpected type %T", src)
     }
+    if len(data) == 0 {
+        return nil
+    }
+    return json.Unmarshal(data, c.tags)
+}
 
-    return nil
+func (c tagsColumn) Value() (driver.Value, error) {
+    if len(*c.tags) == 0 {
+        return "", nil
+    }
+    data, err := json.Marshal(*c.tags)
+    if err != nil {
+        return nil, err
+    }
+    return string(data), nil
 }
 
 func (d *Database) InsertProduct(product Product) error {
-    query := `INSERT OR REPLACE INTO products (id, name, url) VALUES (?, ?, ?)`
-    _, err := d.db.Exec(query, product.ID, product.Name, product.URL)
+    defer d.observe("insert_product", time.Now())
+
+    query := `INSERT OR REPLACE INTO products (` + productColumnList("") + `)
+        VALUES (?` + strings.Repeat(", ?", len(productColumns)-1) + `)`
+    _, err := d.db.Exec(query, productValues(product)...)
     return err
 }
 
 func (d *Database) GetAllProducts() ([]Product, error) {
-    query := `SELECT id, name, url FROM products ORDER BY name`
+    defer d.observe("all_products", time.Now())
+
+    query := `SELECT ` + productColumnList("") + ` FROM products ORDER BY name`
     rows, err := d.db.Query(query)
     if err != nil {
         return nil, err
@@ -68,7 +192,7 @@ func (d *Database) GetAllProducts() ([]Product, error) {
     var products []Product
     for rows.Next() {
         var product Product
-        if err := rows.Scan(&product.ID, &product.Name, &product.URL); err != nil {
+        if err := rows.Scan(productFields(&product)...); err != nil {
             return nil, err
         }
         products = append(products, product)
@@ -77,43 +201,154 @@ func (d *Database) GetAllProducts() ([]Product, error) {
     return products, nil
 }
 
-func (d *Database) GetProductsWithLatestPrices() ([]ProductWithLatestPrice, error) {
+// latestPriceJoin joins each product p to its latest non-outlier entry as
+// pe. It joins on the entry id rather than using window functions so the
+// timestamp column keeps its DATETIME type when scanned.
+const latestPriceJoin = `
+        LEFT JOIN price_entries pe ON pe.id = (
+            SELECT id FROM price_entries WHERE product_id = p.id AND is_outlier = 0
+            ORDER BY timestamp DESC LIMIT 1
+        )`
+
+// changeRatio is the latest price's change from the previous one, or NULL
+const changeRatio = `CASE WHEN prev.price != 0 THEN (pe.price - prev.price) / prev.price END`
+
+// likeEscaper escapes the LIKE wildcards in a literal substring
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// productFilterClauses returns the joins and WHERE clause for a products
+// listing filter, over products p joined with latestPriceJoin, and their
+// arguments in order. A search joins the matching products as fts, with
+// their rank.
+func productFilterClauses(filter ProductFilter) (joins, where string, args []interface{}) {
+    if filter.Search != "" {
+        joins = `
+        JOIN (
+            SELECT product_id, rank FROM products_fts WHERE products_fts MATCH ?
+        ) fts ON fts.product_id = p.id`
+        args = append(args, searchMatchQuery(filter.Search))
+    }
+
+    conditions := []string{"p.tenant = ?"}
+    args = append(args, filter.Tenant)
+    if filter.Query != "" {
+        conditions = append(conditions, `p.name LIKE ? ESCAPE '\'`)
+        args = append(args, "%"+likeEscaper.Replace(filter.Query)+"%")
+    }
+    if filter.MinPrice != nil {
+        conditions = append(conditions, "pe.price >= ?")
+        args = append(args, *filter.MinPrice)
+    }
+    if filter.MaxPrice != nil {
+        conditions = append(conditions, "pe.price <= ?")
+        args = append(args, *filter.MaxPrice)
+    }
+    if filter.UpdatedSince != nil {
+        conditions = append(conditions, "pe.timestamp >= ?")
+        args = append(args, filter.UpdatedSince.UTC())
+    }
+
+    where = "WHERE " + strings.Join(conditions, " AND ")
+    return joins, where, args
+}
+
+// productOrderBy returns the ORDER BY clause for a products listing filter.
+// Products without the sorted value come last either way, and ties are
+// broken by name and id.
+func productOrderBy(filter ProductFilter) (string, error) {
+    direction := "ASC"
+    if filter.Descending {
+        direction = "DESC"
+    }
+
+    sort := filter.Sort
+    if sort == "" && filter.Search != "" {
+        sort = SortByRelevance
+    }
+
+    var column string
+    switch sort {
+    case SortByRelevance:
+        if filter.Search == "" {
+            return "", fmt.Errorf("%w %q: only search results can be sorted by relevance", ErrInvalidSort, sort)
+        }
+        return fmt.Sprintf("ORDER BY fts.rank %s, p.name, p.id", direction), nil
+    case "", SortByName:
+        return fmt.Sprintf("ORDER BY p.name %s, p.id %s", direction, direction), nil
+    case SortByLatestPrice:
+        column = "pe.price"
+    case SortByLastUpdated:
+        column = "pe.timestamp"
+    case SortByChangePercent:
+        column = changeRatio
+    default:
+        return "", fmt.Errorf("%w %q: must be name, latest_price, last_updated or change_percent", ErrInvalidSort, filter.Sort)
+    }
+    return fmt.Sprintf("ORDER BY (%s) IS NULL, %s %s, p.name, p.id", column, column, direction), nil
+}
+
+// GetProductsWithLatestPrices returns one page of the products matching a
+// filter, in its order, with their latest and previous prices
+func (d *Database) GetProductsWithLatestPrices(filter ProductFilter, limit, offset int) ([]ProductWithLatestPrice, error) {
+    defer d.observe("latest_prices", time.Now())
+
+    orderBy, err := productOrderBy(filter)
+    if err != nil {
+        return nil, err
+    }
+    joins, where, args := productFilterClauses(filter)
+
     query := `
         SELECT
-            p.id, p.name, p.url,
-            pe.price, pe.timestamp
-        FROM products p
-        LEFT JOIN (
-            SELECT DISTINCT product_id,
-                   FIRST_VALUE(price) OVER (PARTITION BY product_id ORDER BY timestamp DESC) as price,
-                   FIRST_VALUE(timestamp) OVER (PARTITION BY product_id ORDER BY timestamp DESC) as timestamp,
-                   ROW_NUMBER() OVER (PARTITION BY product_id ORDER BY timestamp DESC) as rn
-            FROM price_entries
-        ) pe ON p.id = pe.product_id AND pe.rn = 1
-        ORDER BY p.name`
+            ` + productColumnList("p") + `,
+            pe.price, pe.timestamp, pe.in_stock, pe.list_price, COALESCE(pe.listing_type, ''), pe.shipping, prev.price,
+            COALESCE(prev.price IS NOT NULL AND pe.price <= (
+                SELECT MIN(price) FROM price_entries WHERE product_id = p.id AND is_outlier = 0
+            ), 0)
+        FROM products p` + latestPriceJoin + `
+        LEFT JOIN price_entries prev ON prev.id = (
+            SELECT id FROM price_entries WHERE product_id = p.id AND is_outlier = 0
+            ORDER BY timestamp DESC LIMIT 1 OFFSET 1
+        )` + joins + `
+        ` + where + `
+        ` + orderBy + `
+        LIMIT ? OFFSET ?`
 
-    rows, err := d.db.Query(query)
+    rows, err := d.db.Query(query, append(args, limit, offset)...)
     if err != nil {
         return nil, err
     }
     defer rows.Close()
 
-    var products []ProductWithLatestPrice
+    products := []ProductWithLatestPrice{}
     for rows.Next() {
         var product ProductWithLatestPrice
-        var price sql.NullFloat64
+        var price, previous sql.NullFloat64
         var timestamp sql.NullTime
 
-        if err := rows.Scan(&product.ID, &product.Name, &product.URL, &price, &timestamp); err != nil {
+        fields := append(productFields(&product.Product), &price, &timestamp, &product.InStock, &product.ListPrice, &product.ListingType, &product.Shipping, &previous, &product.AtAllTimeLow)
+        if err := rows.Scan(fields...); err != nil {
             return nil, err
         }
 
         if price.Valid {
             product.LatestPrice = &price.Float64
+            product.TotalPrice = totalPrice(price.Float64, product.Shipping)
         }
         if timestamp.Valid {
             product.LastUpdated = &timestamp.Time
         }
+        if product.ListPrice != nil && *product.ListPrice != 0 {
+            discount := (*product.ListPrice - price.Float64) / *product.ListPrice * 100
+            product.DiscountPercent = &discount
+        }
+        if price.Valid && previous.Valid {
+            product.PreviousPrice = &previous.Float64
+            if previous.Float64 != 0 {
+                change := (price.Float64 - previous.Float64) / previous.Float64 * 100
+                product.ChangePercent = &change
+            }
+        }
 
         products = append(products, product)
     }
@@ -121,21 +356,66 @@ func (d *Database) GetProductsWithLatestPrices() ([]ProductWithLatestPrice, erro
     return products, nil
 }
 
-func (d *Database) InsertPriceEntry(productID string, price float64, timestamp time.Time) error {
-    query := `INSERT INTO price_entries (product_id, price, timestamp) VALUES (?, ?, ?)`
-    _, err := d.db.Exec(query, productID, price, timestamp)
-    return err
+// GetLatestPrices returns the most recent non-outlier entry of every product
+// that has one, keyed by product ID. Only the price and availability are
+// filled in.
+func (d *Database) GetLatestPrices() (map[string]PriceEntry, error) {
+    defer d.observe("latest_price_map", time.Now())
+
+    query := `
+        SELECT p.id, pe.price, pe.in_stock, pe.shipping
+        FROM products p
+        JOIN price_entries pe ON pe.id = (
+            SELECT id FROM price_entries WHERE product_id = p.id AND is_outlier = 0
+            ORDER BY timestamp DESC LIMIT 1
+        )`
+
+    rows, err := d.db.Query(query)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    prices := make(map[string]PriceEntry)
+    for rows.Next() {
+        var entry PriceEntry
+        if err := rows.Scan(&entry.ProductID, &entry.Price, &entry.InStock, &entry.Shipping); err != nil {
+            return nil, err
+        }
+        prices[entry.ProductID] = entry
+    }
+
+    return prices, rows.Err()
+}
+
+// InsertPriceEntry stores a price and returns the new entry's ID. Its ID
+// and flags are ignored.
+func (d *Database) InsertPriceEntry(entry PriceEntry) (int, error) {
+    defer d.observe("insert_price", time.Now())
+
+    // store UTC so timestamps compare correctly in range queries
+    query := `INSERT INTO price_entries (product_id, price, currency, in_stock, list_price, listing_type, shipping, timestamp) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+    result, err := d.db.Exec(query, entry.ProductID, entry.Price, entry.Currency, entry.InStock, entry.ListPrice, entry.ListingType, entry.Shipping, entry.Timestamp.UTC())
+    if err != nil {
+        return 0, err
+    }
+    id, err := result.LastInsertId()
+    return int(id), err
 }
 
-func (d *Database) GetPriceHistory(productID string, limit int) ([]PriceEntry, error) {
+// GetPriceHistory returns the latest entries for a product, newest first.
+// Entries flagged as outliers are skipped unless includeOutliers is set.
+func (d *Database) GetPriceHistory(productID string, limit int, includeOutliers bool) ([]PriceEntry, error) {
+    defer d.observe("history", time.Now())
+
     query := `
-        SELECT id, product_id, price, timestamp
+        SELECT id, product_id, price, currency, in_stock, list_price, listing_type, shipping, timestamp, is_outlier
         FROM price_entries
-        WHERE product_id = ?
+        WHERE product_id = ? AND (? OR is_outlier = 0)
         ORDER BY timestamp DESC
         LIMIT ?`
 
-    rows, err := d.db.Query(query, productID, limit)
+    rows, err := d.db.Query(query, productID, includeOutliers, limit)
     if err != nil {
         return nil, err
     }
@@ -144,20 +424,1065 @@ func (d *Database) GetPriceHistory(productID string, limit int) ([]PriceEntry, e
     var entries []PriceEntry
     for rows.Next() {
         var entry PriceEntry
-        if err := rows.Scan(&entry.ID, &entry.ProductID, &entry.Price, &entry.Timestamp); err != nil {
+        if err := rows.Scan(&entry.ID, &entry.ProductID, &entry.Price, &entry.Currency, &entry.InStock, &entry.ListPrice, &entry.ListingType, &entry.Shipping, &entry.Timestamp, &entry.IsOutlier); err != nil {
             return nil, err
         }
+        entry.TotalPrice = totalPrice(entry.Price, entry.Shipping)
         entries = append(entries, entry)
     }
 
     return entries, nil
 }
 
-func (d *Database) ProductExists(productID string) (bool, error) {
-    query := `SELECT COUNT(*) FROM products WHERE id = ?`
-    var count int
-    err := d.db.QueryRow(query, productID).Scan(&count)
-    return count > 0, err
+// GetPriceHistories returns the latest limit entries of each of several
+// products in one query, newest first, by product ID. Products without
+// entries are left out. Entries flagged as outliers are skipped unless
+// includeOutliers is set.
+func (d *Database) GetPriceHistories(productIDs []string, limit int, includeOutliers bool) (map[string][]PriceEntry, error) {
+    defer d.observe("histories", time.Now())
+
+    histories := make(map[string][]PriceEntry)
+    if len(productIDs) == 0 {
+        return histories, nil
+    }
+
+    placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(productIDs)), ", ")
+    query := `
+        SELECT id, product_id, price, currency, in_stock, list_price, listing_type, shipping, timestamp, is_outlier
+        FROM (
+            SELECT *, ROW_NUMBER() OVER (PARTITION BY product_id ORDER BY timestamp DESC, id DESC) AS n
+            FROM price_entries
+            WHERE product_id IN (` + placeholders + `) AND (? OR is_outlier = 0)
+        )
+        WHERE n <= ?
+        ORDER BY product_id, n`
+
+    args := make([]interface{}, 0, len(productIDs)+2)
+    for _, id := range productIDs {
+        args = append(args, id)
+    }
+    args = append(args, includeOutliers, limit)
+
+    rows, err := d.db.Query(query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var entry PriceEntry
+        if err := rows.Scan(&entry.ID, &entry.ProductID, &entry.Price, &entry.Currency, &entry.InStock, &entry.ListPrice, &entry.ListingType, &entry.Shipping, &entry.Timestamp, &entry.IsOutlier); err != nil {
+            return nil, err
+        }
+        entry.TotalPrice = totalPrice(entry.Price, entry.Shipping)
+        histories[entry.ProductID] = append(histories[entry.ProductID], entry)
+    }
+
+    return histories, rows.Err()
+}
+
+// GetPriceHistoryRange returns entries between from and to (inclusive), newest
+// first. A limit of zero or less returns every entry in the range. Entries
+// flagged as outliers are skipped unless includeOutliers is set.
+func (d *Database) GetPriceHistoryRange(productID string, from, to time.Time, limit int, includeOutliers bool) ([]PriceEntry, error) {
+    defer d.observe("history_range", time.Now())
+
+    if limit <= 0 {
+        limit = -1
+    }
+
+    query := `
+        SELECT id, product_id, price, currency, in_stock, list_price, listing_type, shipping, timestamp, is_outlier
+        FROM price_entries
+        WHERE product_id = ? AND timestamp BETWEEN ? AND ? AND (? OR is_outlier = 0)
+        ORDER BY timestamp DESC
+        LIMIT ?`
+
+    rows, err := d.db.Query(query, productID, from.UTC(), to.UTC(), includeOutliers, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var entries []PriceEntry
+    for rows.Next() {
+        var entry PriceEntry
+        if err := rows.Scan(&entry.ID, &entry.ProductID, &entry.Price, &entry.Currency, &entry.InStock, &entry.ListPrice, &entry.ListingType, &entry.Shipping, &entry.Timestamp, &entry.IsOutlier); err != nil {
+            return nil, err
+        }
+        entry.TotalPrice = totalPrice(entry.Price, entry.Shipping)
+        entries = append(entries, entry)
+    }
+
+    return entries, nil
+}
+
+// historyBucketStarts maps each history interval to the SQL for the start of
+// the bucket an entry falls in. Timestamps are stored in UTC as Go writes
+// them, so their first 19 characters are a time SQLite can read.
+var historyBucketStarts = map[string]string{
+    IntervalHour: `strftime('%Y-%m-%dT%H:00:00Z', substr(timestamp, 1, 19))`,
+    IntervalDay:  `strftime('%Y-%m-%dT00:00:00Z', substr(timestamp, 1, 19))`,
+    IntervalWeek: `strftime('%Y-%m-%dT00:00:00Z', substr(timestamp, 1, 19), 'weekday 0', '-6 days')`,
+}
+
+// GetPriceHistoryBuckets groups a product's entries between from and to
+// (inclusive) by interval, oldest bucket first. A limit above zero keeps
+// only the newest buckets. Entries in different currencies get separate
+// buckets.
+func (d *Database) GetPriceHistoryBuckets(productID, interval string, from, to time.Time, limit int, includeOutliers bool) ([]HistoryBucket, error) {
+    defer d.observe("history_buckets", time.Now())
+
+    start, ok := historyBucketStarts[interval]
+    if !ok {
+        return nil, fmt.Errorf("unknown history interval %q", interval)
+    }
+    if limit <= 0 {
+        limit = -1
+    }
+
+    // open and close are the same for every entry of a bucket, so taking
+    // their MAX just picks that value
+    query := `
+        SELECT bucket, currency, MAX(open), MAX(close), MIN(price), MAX(price), AVG(price), COUNT(*)
+        FROM (
+            SELECT price, currency, ` + start + ` AS bucket,
+                FIRST_VALUE(price) OVER (PARTITION BY ` + start + `, currency ORDER BY timestamp, id) AS open,
+                FIRST_VALUE(price) OVER (PARTITION BY ` + start + `, currency ORDER BY timestamp DESC, id DESC) AS close
+            FROM price_entries
+            WHERE product_id = ? AND timestamp BETWEEN ? AND ? AND (? OR is_outlier = 0)
+        )
+        GROUP BY bucket, currency
+        ORDER BY bucket DESC, currency
+        LIMIT ?`
+
+    rows, err := d.db.Query(query, productID, from.UTC(), to.UTC(), includeOutliers, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    buckets := []HistoryBucket{}
+    for rows.Next() {
+        var bucket HistoryBucket
+        var startText string
+        if err := rows.Scan(&startText, &bucket.Currency, &bucket.Open, &bucket.Close, &bucket.Min, &bucket.Max, &bucket.Avg, &bucket.Count); err != nil {
+            return nil, err
+        }
+        if bucket.Start, err = time.Parse(time.RFC3339, startText); err != nil {
+            return nil, fmt.Errorf("reading bucket start %q: %w", startText, err)
+        }
+        buckets = append(buckets, bucket)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    slices.Reverse(buckets)
+    return buckets, nil
+}
+
+// GetPriceStats aggregates a product's non-outlier prices since the given
+// time. The latest price is the most recent one overall, whether or not it
+// falls in the window; it is zero when the product has no prices. Its
+// change is computed against the price as of each of changeDays days
+// before now.
+func (d *Database) GetPriceStats(productID string, since, now time.Time, changeDays []int) (PriceStats, error) {
+    defer d.observe("price_stats", time.Now())
+
+    // the median is the middle price, or the mean of the middle two
+    query := `
+        WITH recent AS (
+            SELECT price FROM price_entries
+            WHERE product_id = ? AND timestamp >= ? AND is_outlier = 0
+        ),
+        latest AS (
+            SELECT price FROM price_entries
+            WHERE product_id = ? AND is_outlier = 0
+            ORDER BY timestamp DESC LIMIT 1
+        ),
+        summary AS (
+            SELECT COUNT(*) AS n, MIN(price) AS low, MAX(price) AS high, AVG(price) AS mean FROM recent
+        )
+        SELECT
+            summary.n,
+            COALESCE(summary.low, 0),
+            COALESCE(summary.high, 0),
+            COALESCE(summary.mean, 0),
+            COALESCE((
+                SELECT AVG(price) FROM (
+                    SELECT price FROM recent ORDER BY price
+                    LIMIT 2 - (SELECT n FROM summary) % 2 OFFSET ((SELECT n FROM summary) - 1) / 2
+                )
+            ), 0),
+            COALESCE((SELECT AVG((price - summary.mean) * (price - summary.mean)) FROM recent), 0),
+            COALESCE((SELECT price FROM latest), 0),
+            (SELECT (latest.price - summary.low) / summary.low * 100 FROM latest WHERE summary.low > 0)`
+    args := []interface{}{productID, since.UTC(), productID}
+
+    for _, days := range changeDays {
+        query += `,
+            (
+                SELECT (latest.price - old.price) / old.price * 100 FROM latest, (
+                    SELECT price FROM price_entries
+                    WHERE product_id = ? AND timestamp <= ? AND is_outlier = 0
+                    ORDER BY timestamp DESC LIMIT 1
+                ) old
+                WHERE old.price != 0
+            )`
+        args = append(args, productID, now.AddDate(0, 0, -days).UTC())
+    }
+    query += `
+        FROM summary`
+
+    stats := PriceStats{ProductID: productID, Since: since.UTC(), ChangePercent: map[string]float64{}}
+    var variance float64
+    changes := make([]sql.NullFloat64, len(changeDays))
+    fields := []interface{}{
+        &stats.Count, &stats.MinPrice, &stats.MaxPrice, &stats.AvgPrice, &stats.MedianPrice, &variance, &stats.LatestPrice, &stats.PercentFromLow,
+    }
+    for i := range changes {
+        fields = append(fields, &changes[i])
+    }
+    if err := d.db.QueryRow(query, args...).Scan(fields...); err != nil {
+        return PriceStats{}, err
+    }
+
+    // rounding can leave a flat history's variance a hair below zero
+    stats.StdDev = math.Sqrt(math.Max(variance, 0))
+    for i, change := range changes {
+        if change.Valid {
+            stats.ChangePercent[fmt.Sprintf("%dd", changeDays[i])] = change.Float64
+        }
+    }
+    return stats, nil
+}
+
+// GetPriceEntriesAfter returns up to limit entries between from and to
+// (inclusive) with IDs greater than afterID, in ID order, for one product or
+// every product when productID is empty. Paging by ID keeps each query
+// short, so a slow reader doesn't hold the database open.
+func (d *Database) GetPriceEntriesAfter(productID string, from, to time.Time, afterID, limit int, includeOutliers bool) ([]PriceEntry, error) {
+    defer d.observe("history_page", time.Now())
+
+    where := `id > ? AND timestamp BETWEEN ? AND ? AND (? OR is_outlier = 0)`
+    args := []interface{}{afterID, from.UTC(), to.UTC(), includeOutliers, limit}
+    if productID != "" {
+        where = `product_id = ? AND ` + where
+        args = append([]interface{}{productID}, args...)
+    }
+    query := `
+        SELECT id, product_id, price, currency, in_stock, list_price, listing_type, shipping, timestamp, is_outlier
+        FROM price_entries
+        WHERE ` + where + `
+        ORDER BY id ASC
+        LIMIT ?`
+
+    rows, err := d.db.Query(query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var entries []PriceEntry
+    for rows.Next() {
+        var entry PriceEntry
+        if err := rows.Scan(&entry.ID, &entry.ProductID, &entry.Price, &entry.Currency, &entry.InStock, &entry.ListPrice, &entry.ListingType, &entry.Shipping, &entry.Timestamp, &entry.IsOutlier); err != nil {
+            return nil, err
+        }
+        entry.TotalPrice = totalPrice(entry.Price, entry.Shipping)
+        entries = append(entries, entry)
+    }
+
+    return entries, rows.Err()
+}
+
+// DeletePriceEntriesOlderThan removes entries recorded before cutoff and
+// returns how many were deleted
+func (d *Database) DeletePriceEntriesOlderThan(cutoff time.Time) (int64, error) {
+    defer d.observe("prune_prices", time.Now())
+
+    result, err := d.db.Exec(`DELETE FROM price_entries WHERE timestamp < ?`, cutoff.UTC())
+    if err != nil {
+        return 0, err
+    }
+    return result.RowsAffected()
+}
+
+func (d *Database) InsertScrapeError(scrapeErr ScrapeError) error {
+    defer d.observe("insert_scrape_error", time.Now())
+
+    query := `INSERT INTO scrape_errors (product_id, error, attempts, occurred_at) VALUES (?, ?, ?, ?)`
+    _, err := d.db.Exec(query, scrapeErr.ProductID, scrapeErr.Error, scrapeErr.Attempts, scrapeErr.OccurredAt.UTC())
+    return err
+}
+
+// GetScrapeErrors returns a product's most recent scrape errors, newest first
+func (d *Database) GetScrapeErrors(productID string, limit int) ([]ScrapeError, error) {
+    defer d.observe("scrape_errors", time.Now())
+
+    query := `
+        SELECT id, product_id, error, attempts, occurred_at
+        FROM scrape_errors
+        WHERE product_id = ?
+        ORDER BY occurred_at DESC, id DESC
+        LIMIT ?`
+
+    rows, err := d.db.Query(query, productID, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    scrapeErrors := []ScrapeError{}
+    for rows.Next() {
+        var scrapeErr ScrapeError
+        if err := rows.Scan(&scrapeErr.ID, &scrapeErr.ProductID, &scrapeErr.Error, &scrapeErr.Attempts, &scrapeErr.OccurredAt); err != nil {
+            return nil, err
+        }
+        scrapeErrors = append(scrapeErrors, scrapeErr)
+    }
+
+    return scrapeErrors, rows.Err()
+}
+
+// DeleteScrapeErrorsOlderThan removes scrape errors recorded before cutoff
+// and returns how many were deleted
+func (d *Database) DeleteScrapeErrorsOlderThan(cutoff time.Time) (int64, error) {
+    defer d.observe("prune_scrape_errors", time.Now())
+
+    result, err := d.db.Exec(`DELETE FROM scrape_errors WHERE occurred_at < ?`, cutoff.UTC())
+    if err != nil {
+        return 0, err
+    }
+    return result.RowsAffected()
+}
+
+// InsertSnapshot stores a gzipped page snapshot and deletes the product's
+// oldest snapshots beyond keep
+func (d *Database) InsertSnapshot(snapshot PageSnapshot, body []byte, keep int) error {
+    defer d.observe("insert_snapshot", time.Now())
+
+    tx, err := d.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    _, err = tx.Exec(`INSERT INTO page_snapshots (product_id, url, error, size, truncated, taken_at, body)
+        VALUES (?, ?, ?, ?, ?, ?, ?)`,
+        snapshot.ProductID, snapshot.URL, snapshot.Error, snapshot.Size, snapshot.Truncated, snapshot.TakenAt.UTC(), body)
+    if err != nil {
+        return err
+    }
+
+    _, err = tx.Exec(`DELETE FROM page_snapshots WHERE product_id = ? AND id NOT IN (
+        SELECT id FROM page_snapshots WHERE product_id = ? ORDER BY taken_at DESC, id DESC LIMIT ?
+    )`, snapshot.ProductID, snapshot.ProductID, keep)
+    if err != nil {
+        return err
+    }
+
+    return tx.Commit()
+}
+
+// GetSnapshots returns a product's snapshots without their pages, newest
+// first
+func (d *Database) GetSnapshots(productID string) ([]PageSnapshot, error) {
+    defer d.observe("snapshots", time.Now())
+
+    query := `
+        SELECT id, product_id, url, error, size, truncated, taken_at
+        FROM page_snapshots
+        WHERE product_id = ?
+        ORDER BY taken_at DESC, id DESC`
+
+    rows, err := d.db.Query(query, productID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    snapshots := []PageSnapshot{}
+    for rows.Next() {
+        var s PageSnapshot
+        if err := rows.Scan(&s.ID, &s.ProductID, &s.URL, &s.Error, &s.Size, &s.Truncated, &s.TakenAt); err != nil {
+            return nil, err
+        }
+        snapshots = append(snapshots, s)
+    }
+
+    return snapshots, rows.Err()
+}
+
+// GetSnapshot returns a snapshot with its gzipped page, reporting whether it
+// exists
+func (d *Database) GetSnapshot(id int64) (PageSnapshot, []byte, bool, error) {
+    defer d.observe("snapshot", time.Now())
+
+    var s PageSnapshot
+    var body []byte
+    err := d.db.QueryRow(`
+        SELECT id, product_id, url, error, size, truncated, taken_at, body
+        FROM page_snapshots WHERE id = ?`, id).
+        Scan(&s.ID, &s.ProductID, &s.URL, &s.Error, &s.Size, &s.Truncated, &s.TakenAt, &body)
+    if err == sql.ErrNoRows {
+        return PageSnapshot{}, nil, false, nil
+    }
+    if err != nil {
+        return PageSnapshot{}, nil, false, err
+    }
+    return s, body, true, nil
+}
+
+// GetPageFingerprint returns a product's latest page fingerprint, reporting
+// whether it has one
+func (d *Database) GetPageFingerprint(productID string) (PageFingerprint, bool, error) {
+    defer d.observe("page_fingerprint", time.Now())
+
+    var fp PageFingerprint
+    var changedAt sql.NullTime
+    err := d.db.QueryRow(`
+        SELECT product_id, url, selector, fingerprint, previous, distance, checked_at, changed_at
+        FROM page_fingerprints WHERE product_id = ?`, productID).
+        Scan(&fp.ProductID, &fp.URL, &fp.Selector, &fp.Fingerprint, &fp.Previous, &fp.Distance, &fp.CheckedAt, &changedAt)
+    if err == sql.ErrNoRows {
+        return PageFingerprint{}, false, nil
+    }
+    if err != nil {
+        return PageFingerprint{}, false, err
+    }
+    if changedAt.Valid {
+        fp.ChangedAt = &changedAt.Time
+    }
+    return fp, true, nil
+}
+
+// SavePageFingerprint replaces a product's page fingerprint
+func (d *Database) SavePageFingerprint(fp PageFingerprint) error {
+    defer d.observe("save_page_fingerprint", time.Now())
+
+    var changedAt interface{}
+    if fp.ChangedAt != nil {
+        changedAt = fp.ChangedAt.UTC()
+    }
+    _, err := d.db.Exec(`INSERT OR REPLACE INTO page_fingerprints
+        (product_id, url, selector, fingerprint, previous, distance, checked_at, changed_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+        fp.ProductID, fp.URL, fp.Selector, fp.Fingerprint, fp.Previous, fp.Distance, fp.CheckedAt.UTC(), changedAt)
+    return err
+}
+
+// GetPageChanges returns the fingerprints of the product pages whose markup
+// changed, most recent change first
+func (d *Database) GetPageChanges() ([]PageFingerprint, error) {
+    defer d.observe("page_changes", time.Now())
+
+    query := `
+        SELECT f.product_id, p.name, f.url, f.selector, f.fingerprint, f.previous, f.distance, f.checked_at, f.changed_at
+        FROM page_fingerprints f
+        JOIN products p ON p.id = f.product_id
+        WHERE f.changed_at IS NOT NULL
+        ORDER BY f.changed_at DESC, f.product_id`
+
+    rows, err := d.db.Query(query)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    changes := []PageFingerprint{}
+    for rows.Next() {
+        var fp PageFingerprint
+        var changedAt sql.NullTime
+        if err := rows.Scan(&fp.ProductID, &fp.Name, &fp.URL, &fp.Selector, &fp.Fingerprint, &fp.Previous, &fp.Distance, &fp.CheckedAt, &changedAt); err != nil {
+            return nil, err
+        }
+        if changedAt.Valid {
+            fp.ChangedAt = &changedAt.Time
+        }
+        changes = append(changes, fp)
+    }
+
+    return changes, rows.Err()
+}
+
+// EnqueueNotification queues a notification, due straight away, unless one
+// with the same dedupe key is still waiting to be sent. It returns the new
+// notification's ID and whether it was queued.
+func (d *Database) EnqueueNotification(n Notification) (int64, bool, error) {
+    defer d.observe("enqueue_notification", time.Now())
+
+    tx, err := d.db.Begin()
+    if err != nil {
+        return 0, false, err
+    }
+    defer tx.Rollback()
+
+    var exists int
+    err = tx.QueryRow(`SELECT COUNT(*) FROM notifications
+        WHERE dedupe_key = ? AND status IN ('pending', 'sending')`, n.DedupeKey).Scan(&exists)
+    if err != nil {
+        return 0, false, err
+    }
+    if exists > 0 {
+        return 0, false, nil
+    }
+
+    result, err := tx.Exec(`INSERT INTO notifications
+        (kind, product_id, dedupe_key, url, payload, status, created_at, next_attempt_at)
+        VALUES (?, ?, ?, ?, ?, 'pending', ?, ?)`,
+        n.Kind, n.ProductID, n.DedupeKey, n.URL, string(n.Payload), n.CreatedAt.UTC(), n.CreatedAt.UTC())
+    if err != nil {
+        return 0, false, err
+    }
+    id, err := result.LastInsertId()
+    if err != nil {
+        return 0, false, err
+    }
+    return id, true, tx.Commit()
+}
+
+// ClaimNotification marks a pending notification as being sent and counts
+// the attempt. It reports false when the notification isn't pending, e.g.
+// because another attempt already claimed it.
+func (d *Database) ClaimNotification(id int64) (Notification, bool, error) {
+    defer d.observe("claim_notification", time.Now())
+
+    result, err := d.db.Exec(`UPDATE notifications SET status = 'sending', attempts = attempts + 1
+        WHERE id = ? AND status = 'pending'`, id)
+    if err != nil {
+        return Notification{}, false, err
+    }
+    claimed, err := result.RowsAffected()
+    if err != nil || claimed == 0 {
+        return Notification{}, false, err
+    }
+
+    n, err := scanNotification(d.db.QueryRow(`SELECT `+notificationColumns+` FROM notifications WHERE id = ?`, id))
+    if err != nil {
+        return Notification{}, false, err
+    }
+    return n, true, nil
+}
+
+// MarkNotificationDelivered records that a notification was sent
+func (d *Database) MarkNotificationDelivered(id int64, at time.Time) error {
+    defer d.observe("deliver_notification", time.Now())
+
+    _, err := d.db.Exec(`UPDATE notifications SET status = 'delivered', delivered_at = ?, last_error = ''
+        WHERE id = ?`, at.UTC(), id)
+    return err
+}
+
+// RetryNotification puts a notification whose delivery failed back in the
+// queue, due at next. A zero next gives up on it instead.
+func (d *Database) RetryNotification(id int64, next time.Time, lastError string) error {
+    defer d.observe("retry_notification", time.Now())
+
+    if next.IsZero() {
+        _, err := d.db.Exec(`UPDATE notifications SET status = 'failed', last_error = ? WHERE id = ?`, lastError, id)
+        return err
+    }
+    _, err := d.db.Exec(`UPDATE notifications SET status = 'pending', next_attempt_at = ?, last_error = ?
+        WHERE id = ?`, next.UTC(), lastError, id)
+    return err
+}
+
+// RequeueNotifications puts notifications left being sent, by a process that
+// stopped before it heard back, back in the queue and returns how many there
+// were. It must only run before any deliveries start.
+func (d *Database) RequeueNotifications() (int64, error) {
+    defer d.observe("requeue_notifications", time.Now())
+
+    result, err := d.db.Exec(`UPDATE notifications SET status = 'pending' WHERE status = 'sending'`)
+    if err != nil {
+        return 0, err
+    }
+    return result.RowsAffected()
+}
+
+// DueNotifications returns the IDs of up to limit pending notifications due
+// by now, oldest first
+func (d *Database) DueNotifications(now time.Time, limit int) ([]int64, error) {
+    defer d.observe("due_notifications", time.Now())
+
+    rows, err := d.db.Query(`SELECT id FROM notifications
+        WHERE status = 'pending' AND next_attempt_at <= ?
+        ORDER BY next_attempt_at, id LIMIT ?`, now.UTC(), limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var ids []int64
+    for rows.Next() {
+        var id int64
+        if err := rows.Scan(&id); err != nil {
+            return nil, err
+        }
+        ids = append(ids, id)
+    }
+    return ids, rows.Err()
+}
+
+// GetNotifications returns up to limit notifications, newest first, only
+// those with the given status unless it is empty
+func (d *Database) GetNotifications(status string, limit int) ([]Notification, error) {
+    defer d.observe("notifications", time.Now())
+
+    query := `SELECT ` + notificationColumns + ` FROM notifications`
+    var args []interface{}
+    if status != "" {
+        query += ` WHERE status = ?`
+        args = append(args, status)
+    }
+    query += ` ORDER BY id DESC LIMIT ?`
+    args = append(args, limit)
+
+    rows, err := d.db.Query(query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    notifications := []Notification{}
+    for rows.Next() {
+        n, err := scanNotification(rows)
+        if err != nil {
+            return nil, err
+        }
+        notifications = append(notifications, n)
+    }
+    return notifications, rows.Err()
+}
+
+const notificationColumns = `id, kind, product_id, dedupe_key, url, payload, status, attempts,
+    last_error, created_at, next_attempt_at, delivered_at`
+
+func scanNotification(row interface{ Scan(...interface{}) error }) (Notification, error) {
+    var n Notification
+    var payload string
+    var deliveredAt sql.NullTime
+    err := row.Scan(&n.ID, &n.Kind, &n.ProductID, &n.DedupeKey, &n.URL, &payload, &n.Status, &n.Attempts,
+        &n.LastError, &n.CreatedAt, &n.NextAttemptAt, &deliveredAt)
+    if err != nil {
+        return Notification{}, err
+    }
+    n.Payload = json.RawMessage(payload)
+    if deliveredAt.Valid {
+        n.DeliveredAt = &deliveredAt.Time
+    }
+    return n, nil
+}
+
+// SetPriceEntryOutlier flags or unflags an entry of one of a tenant's
+// products as an outlier, reporting whether the tenant has the entry
+func (d *Database) SetPriceEntryOutlier(entryID int, tenant string, outlier bool) (bool, error) {
+    defer d.observe("set_outlier", time.Now())
+
+    query := `
+        UPDATE price_entries SET is_outlier = ?
+        WHERE id = ? AND product_id IN (SELECT id FROM products WHERE tenant = ?)`
+    result, err := d.db.Exec(query, outlier, entryID, tenant)
+    if err != nil {
+        return false, err
+    }
+    affected, err := result.RowsAffected()
+    return affected > 0, err
+}
+
+func (d *Database) GetBestDeals(productID string) ([]BestDeal, error) {
+    defer d.observe("best_deals", time.Now())
+
+    // join on entry ids rather than window functions so the timestamp
+    // columns keep their DATETIME type when scanned
+    query := `
+        SELECT
+            p.id, p.name,
+            low.price, low.timestamp,
+            cur.price, cur.timestamp,
+            (SELECT COUNT(*) FROM price_entries WHERE product_id = p.id AND is_outlier = 0)
+        FROM products p
+        JOIN price_entries low ON low.id = (
+            SELECT id FROM price_entries WHERE product_id = p.id AND is_outlier = 0
+            ORDER BY price ASC, timestamp ASC LIMIT 1
+        )
+        JOIN price_entries cur ON cur.id = (
+            SELECT id FROM price_entries WHERE product_id = p.id AND is_outlier = 0
+            ORDER BY timestamp DESC LIMIT 1
+        )
+        WHERE ? = '' OR p.id = ?`
+
+    rows, err := d.db.Query(query, productID, productID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var deals []BestDeal
+    for rows.Next() {
+        var deal BestDeal
+        if err := rows.Scan(&deal.ProductID, &deal.Name, &deal.LowestPrice, &deal.LowestAt,
+            &deal.CurrentPrice, &deal.CurrentAt, &deal.EntryCount); err != nil {
+            return nil, err
+        }
+        if deal.LowestPrice > 0 {
+            deal.AboveLowPercent = (deal.CurrentPrice - deal.LowestPrice) / deal.LowestPrice * 100
+        }
+        deals = append(deals, deal)
+    }
+
+    return deals, nil
+}
+
+// GetPriceExtremes returns a product's all-time lowest and highest
+// non-outlier prices, each the first time it was recorded, and its current
+// price
+func (d *Database) GetPriceExtremes(productID string) (PriceExtremes, error) {
+    defer d.observe("price_extremes", time.Now())
+
+    // join on entry ids rather than window functions so the timestamp
+    // columns keep their DATETIME type when scanned
+    query := `
+        SELECT
+            low.price, low.timestamp,
+            high.price, high.timestamp,
+            cur.price, cur.timestamp,
+            (SELECT COUNT(*) FROM price_entries WHERE product_id = p.id AND is_outlier = 0)
+        FROM products p
+        LEFT JOIN price_entries low ON low.id = (
+            SELECT id FROM price_entries WHERE product_id = p.id AND is_outlier = 0
+            ORDER BY price ASC, timestamp ASC LIMIT 1
+        )
+        LEFT JOIN price_entries high ON high.id = (
+            SELECT id FROM price_entries WHERE product_id = p.id AND is_outlier = 0
+            ORDER BY price DESC, timestamp ASC LIMIT 1
+        )
+        LEFT JOIN price_entries cur ON cur.id = (
+            SELECT id FROM price_entries WHERE product_id = p.id AND is_outlier = 0
+            ORDER BY timestamp DESC LIMIT 1
+        )
+        WHERE p.id = ?`
+
+    extremes := PriceExtremes{ProductID: productID}
+    var prices [3]sql.NullFloat64
+    var times [3]sql.NullTime
+    err := d.db.QueryRow(query, productID).Scan(
+        &prices[0], &times[0], &prices[1], &times[1], &prices[2], &times[2], &extremes.EntryCount,
+    )
+    if err != nil {
+        return extremes, err
+    }
+
+    points := []**PricePoint{&extremes.Low, &extremes.High, &extremes.Current}
+    for i, point := range points {
+        if prices[i].Valid && times[i].Valid {
+            *point = &PricePoint{Price: prices[i].Float64, Timestamp: times[i].Time}
+        }
+    }
+    extremes.AtAllTimeLow = extremes.EntryCount > 1 && extremes.Current.Price <= extremes.Low.Price
+    return extremes, nil
+}
+
+// GetPriceDrops returns a tenant's products whose latest non-outlier price is
+// below their price at since, largest percentage drop first. A product first
+// priced after since is measured from its first price in the window.
+func (d *Database) GetPriceDrops(tenant string, since time.Time, limit int) ([]PriceDrop, error) {
+    defer d.observe("price_drops", time.Now())
+
+    query := `
+        SELECT p.id, p.name, p.currency, base.price, base.timestamp, cur.price, cur.timestamp
+        FROM products p
+        JOIN price_entries cur ON cur.id = (
+            SELECT id FROM price_entries WHERE product_id = p.id AND is_outlier = 0
+            ORDER BY timestamp DESC LIMIT 1
+        )
+        JOIN price_entries base ON base.id = COALESCE(
+            (SELECT id FROM price_entries WHERE product_id = p.id AND is_outlier = 0 AND timestamp <= ?
+             ORDER BY timestamp DESC LIMIT 1),
+            (SELECT id FROM price_entries WHERE product_id = p.id AND is_outlier = 0 AND timestamp > ?
+             ORDER BY timestamp ASC LIMIT 1)
+        )
+        WHERE p.tenant = ? AND base.price > 0 AND cur.price < base.price
+        ORDER BY (base.price - cur.price) / base.price DESC, p.name
+        LIMIT ?`
+
+    rows, err := d.db.Query(query, since, since, tenant, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    drops := []PriceDrop{}
+    for rows.Next() {
+        var drop PriceDrop
+        err := rows.Scan(&drop.ProductID, &drop.Name, &drop.Currency,
+            &drop.PreviousPrice, &drop.PreviousAt, &drop.CurrentPrice, &drop.CurrentAt)
+        if err != nil {
+            return nil, err
+        }
+        drop.DropAmount = drop.PreviousPrice - drop.CurrentPrice
+        drop.DropPercent = drop.DropAmount / drop.PreviousPrice * 100
+        drops = append(drops, drop)
+    }
+    return drops, rows.Err()
+}
+
+func (d *Database) InsertSaleWindow(window SaleWindow) (int64, error) {
+    defer d.observe("insert_sale_window", time.Now())
+
+    query := `INSERT INTO sale_windows (product_id, name, starts_at, ends_at) VALUES (?, ?, ?, ?)`
+    result, err := d.db.Exec(query, window.ProductID, window.Name, window.StartsAt, window.EndsAt)
+    if err != nil {
+        return 0, err
+    }
+    return result.LastInsertId()
+}
+
+// GetSaleWindows returns the sale windows for a product, or for every product
+// when productID is empty
+func (d *Database) GetSaleWindows(productID string) ([]SaleWindow, error) {
+    defer d.observe("sale_windows", time.Now())
+
+    query := `
+        SELECT id, product_id, name, starts_at, ends_at
+        FROM sale_windows
+        WHERE ? = '' OR product_id = ?
+        ORDER BY starts_at`
+
+    rows, err := d.db.Query(query, productID, productID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var windows []SaleWindow
+    for rows.Next() {
+        var window SaleWindow
+        if err := rows.Scan(&window.ID, &window.ProductID, &window.Name, &window.StartsAt, &window.EndsAt); err != nil {
+            return nil, err
+        }
+        windows = append(windows, window)
+    }
+
+    return windows, nil
+}
+
+// DeleteProduct removes a product together with its price history, sale
+// windows, scrape errors and snapshots in one transaction. Unless purge is
+// set the price history is first copied to archived_price_entries. It
+// reports whether the product existed.
+func (d *Database) DeleteProduct(productID string, purge bool) (bool, error) {
+    defer d.observe("delete_product", time.Now())
+
+    tx, err := d.db.Begin()
+    if err != nil {
+        return false, err
+    }
+    defer tx.Rollback()
+
+    // keep the price history, under the entries' own IDs, unless purging
+    if !purge {
+        _, err := tx.Exec(`
+            INSERT OR REPLACE INTO archived_price_entries
+                (id, product_id, product_name, tenant, price, currency, in_stock, list_price, listing_type, shipping, timestamp, is_outlier, archived_at)
+            SELECT pe.id, pe.product_id, COALESCE(p.name, ''), COALESCE(p.tenant, ''), pe.price, pe.currency, pe.in_stock, pe.list_price, pe.listing_type, pe.shipping, pe.timestamp, pe.is_outlier, ?
+            FROM price_entries pe
+            LEFT JOIN products p ON p.id = pe.product_id
+            WHERE pe.product_id = ?`, time.Now().UTC(), productID)
+        if err != nil {
+            return false, err
+        }
+    }
+
+    for _, query := range []string{
+        `DELETE FROM price_entries WHERE product_id = ?`,
+        `DELETE FROM sale_windows WHERE product_id = ?`,
+        `DELETE FROM scrape_errors WHERE product_id = ?`,
+        `DELETE FROM page_snapshots WHERE product_id = ?`,
+        `DELETE FROM page_fingerprints WHERE product_id = ?`,
+    } {
+        if _, err := tx.Exec(query, productID); err != nil {
+            return false, err
+        }
+    }
+
+    result, err := tx.Exec(`DELETE FROM products WHERE id = ?`, productID)
+    if err != nil {
+        return false, err
+    }
+    affected, err := result.RowsAffected()
+    if err != nil {
+        return false, err
+    }
+
+    return affected > 0, tx.Commit()
+}
+
+// GetArchivedPriceEntries returns the price history archived when one of a
+// tenant's products was deleted, newest first
+func (d *Database) GetArchivedPriceEntries(productID, tenant string, limit int) ([]ArchivedPriceEntry, error) {
+    defer d.observe("archived_prices", time.Now())
+
+    query := `
+        SELECT id, product_id, product_name, price, currency, in_stock, list_price, listing_type, shipping, timestamp, is_outlier, archived_at
+        FROM archived_price_entries
+        WHERE product_id = ? AND tenant = ?
+        ORDER BY timestamp DESC, id DESC
+        LIMIT ?`
+
+    rows, err := d.db.Query(query, productID, tenant, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    entries := []ArchivedPriceEntry{}
+    for rows.Next() {
+        var entry ArchivedPriceEntry
+        if err := rows.Scan(&entry.ID, &entry.ProductID, &entry.ProductName, &entry.Price, &entry.Currency, &entry.InStock, &entry.ListPrice, &entry.ListingType, &entry.Shipping, &entry.Timestamp, &entry.IsOutlier, &entry.ArchivedAt); err != nil {
+            return nil, err
+        }
+        entry.TotalPrice = totalPrice(entry.Price, entry.Shipping)
+        entries = append(entries, entry)
+    }
+
+    return entries, rows.Err()
+}
+
+// CountProducts counts the products matching a filter
+func (d *Database) CountProducts(filter ProductFilter) (int, error) {
+    defer d.observe("count_products", time.Now())
+
+    query := `SELECT COUNT(*) FROM products p`
+    joins, where, args := productFilterClauses(filter)
+    // the price conditions need the latest price
+    if filter.MinPrice != nil || filter.MaxPrice != nil || filter.UpdatedSince != nil {
+        query += latestPriceJoin
+    }
+    query += joins + " " + where
+
+    var count int
+    err := d.db.QueryRow(query, args...).Scan(&count)
+    return count, err
+}
+
+func (d *Database) ProductExists(productID string) (bool, error) {
+    defer d.observe("product_exists", time.Now())
+
+    query := `SELECT COUNT(*) FROM products WHERE id = ?`
+    var count int
+    err := d.db.QueryRow(query, productID).Scan(&count)
+    return count > 0, err
+}
+
+// ProductOwner returns the tenant a product belongs to, from its row or,
+// once it has been deleted, its archived price history. ok is false when
+// neither records one.
+func (d *Database) ProductOwner(productID string) (tenant string, ok bool, err error) {
+    defer d.observe("product_owner", time.Now())
+
+    query := `
+        SELECT tenant FROM products WHERE id = ?
+        UNION ALL
+        SELECT * FROM (
+            SELECT tenant FROM archived_price_entries WHERE product_id = ?
+            ORDER BY archived_at DESC LIMIT 1
+        )
+        LIMIT 1`
+    err = d.db.QueryRow(query, productID, productID).Scan(&tenant)
+    if err == sql.ErrNoRows {
+        return "", false, nil
+    }
+    return tenant, err == nil, err
+}
+
+// LatestEntryTime returns when the most recent price entry was recorded, or
+// nil if there are none
+func (d *Database) LatestEntryTime() (*time.Time, error) {
+    defer d.observe("latest_entry_time", time.Now())
+
+    query := `SELECT timestamp FROM price_entries ORDER BY timestamp DESC LIMIT 1`
+    var timestamp time.Time
+    err := d.db.QueryRow(query).Scan(&timestamp)
+    if err == sql.ErrNoRows {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    return &timestamp, nil
+}
+
+// Ping checks that the database is reachable and its schema readable, so a
+// closed, locked or corrupt file is reported rather than just a live pool
+func (d *Database) Ping(ctx context.Context) error {
+    if err := d.db.PingContext(ctx); err != nil {
+        return err
+    }
+    var count int
+    return d.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master`).Scan(&count)
+}
+
+// defaultSlowQueryThreshold is how slow a query must be to be logged when no
+// threshold is configured
+const defaultSlowQueryThreshold = 250 * time.Millisecond
+
+// SetSlowQueryThreshold logs any query slower than threshold. Zero disables
+// slow query logging.
+func (d *Database) SetSlowQueryThreshold(threshold time.Duration) {
+    d.statsMu.Lock()
+    defer d.statsMu.Unlock()
+
+    d.slowQueryThreshold = threshold
+}
+
+// QueryTimings returns latency statistics per query type
+func (d *Database) QueryTimings() map[string]QueryStats {
+    d.statsMu.Lock()
+    defer d.statsMu.Unlock()
+
+    stats := make(map[string]QueryStats, len(d.timings))
+    for name, timing := range d.timings {
+        stats[name] = QueryStats{
+            Count:     timing.count,
+            SlowCount: timing.slow,
+            TotalMs:   durationMs(timing.total),
+            AvgMs:     durationMs(timing.total) / float64(timing.count),
+            MaxMs:     durationMs(timing.max),
+        }
+    }
+    return stats
+}
+
+// observe records how long a query took; call it as
+// defer d.observe("name", time.Now())
+func (d *Database) observe(name string, start time.Time) {
+    elapsed := time.Since(start)
+
+    d.statsMu.Lock()
+    defer d.statsMu.Unlock()
+
+    timing, ok := d.timings[name]
+    if !ok {
+        timing = &queryTiming{}
+        d.timings[name] = timing
+    }
+    timing.count++
+    timing.total += elapsed
+    if elapsed > timing.max {
+        timing.max = elapsed
+    }
+
+    if d.slowQueryThreshold > 0 && elapsed > d.slowQueryThreshold {
+        timing.slow++
+        log.Printf("Slow query %s took %v", name, elapsed)
+    }
+}
+
+func durationMs(d time.Duration) float64 {
+    return float64(d) / float64(time.Millisecond)
 }
 
 func (d *Database) Close() error {
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestNewDatabaseCreatesTables(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "prices.db")
+    db, err := NewDatabase(path)
+    if err != nil {
+        t.Fatalf("NewDatabase: %v", err)
+    }
+
+    tables := map[string]bool{}
+    rows, err := db.db.Query(`SELECT name FROM sqlite_master WHERE type = 'table'`)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for rows.Next() {
+        var name string
+        if err := rows.Scan(&name); err != nil {
+            t.Fatal(err)
+        }
+        tables[name] = true
+    }
+    rows.Close()
+    for _, want := range []string{"schema_migrations", "products", "price_entries", "scrape_errors", "page_snapshots", "archived_price_entries", "page_fingerprints", "notifications"} {
+        if !tables[want] {
+            t.Errorf("table %s wasn't created; have %v", want, tables)
+        }
+    }
+
+    // reopening the file applies nothing twice
+    db.Close()
+    db, err = NewDatabase(path)
+    if err != nil {
+        t.Fatalf("reopening: %v", err)
+    }
+    defer db.Close()
+    var applied int
+    if err := db.db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&applied); err != nil || applied != len(migrations) {
+        t.Errorf("%d migrations recorded (%v), want %d", applied, err, len(migrations))
+    }
+
+    // a file that can't be opened fails straight away
+    if _, err := NewDatabase(filepath.Join(t.TempDir(), "missing", "prices.db")); err == nil {
+        t.Error("NewDatabase in a missing directory succeeded")
+    }
+}
+
+func TestQueryTimingsRecorded(t *testing.T) {
+    tracker := newTestTracker(t, nil)
+    server := NewAPIServer(tracker)
+    addTestProduct(t, tracker, "timed", 10, 11)
+
+    if _, err := tracker.GetPriceHistory("timed", 10, false); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := tracker.GetProducts(ProductFilter{}, 10, 0); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := tracker.GetPriceStats("timed", 7); err != nil {
+        t.Fatal(err)
+    }
+
+    var metrics struct {
+        DBQueries map[string]QueryStats `json:"db_queries"`
+    }
+    serve(t, server, "GET", "/api/v1/metrics", "", &metrics)
+    for name, count := range map[string]int64{"insert_product": 1, "insert_price": 2, "history": 1, "latest_prices": 1, "price_stats": 1} {
+        stats, ok := metrics.DBQueries[name]
+        if !ok || stats.Count != count {
+            t.Errorf("%s timings = %+v, want %d queries", name, stats, count)
+            continue
+        }
+        if stats.TotalMs <= 0 || stats.MaxMs <= 0 || stats.AvgMs <= 0 {
+            t.Errorf("%s timings = %+v, want durations recorded", name, stats)
+        }
+        if stats.SlowCount != 0 {
+            t.Errorf("%s counted %d slow queries with slow query logging off", name, stats.SlowCount)
+        }
+    }
+}
+
+func TestSlowQueriesCounted(t *testing.T) {
+    tracker := newTestTracker(t, nil)
+    tracker.db.SetSlowQueryThreshold(time.Nanosecond)
+    addTestProduct(t, tracker, "slow", 10)
+
+    if stats := tracker.QueryTimings()["insert_price"]; stats.SlowCount != 1 {
+        t.Errorf("insert_price = %+v, want its query counted as slow", stats)
+    }
+}
+
+func TestProductsListingChangePercent(t *testing.T) {
+    tracker := newTestTracker(t, nil)
+    addTestProduct(t, tracker, "change-three", 100, 80, 90)
+    addTestProduct(t, tracker, "change-drop", 50, 40)
+    addTestProduct(t, tracker, "change-one", 25)
+    addTestProduct(t, tracker, "change-none")
+
+    products, err := tracker.db.GetProductsWithLatestPrices(ProductFilter{}, 10, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    listed := map[string]ProductWithLatestPrice{}
+    for _, product := range products {
+        listed[product.ID] = product
+    }
+    if len(listed) != 4 {
+        t.Fatalf("listed %d products, want 4", len(listed))
+    }
+
+    // compared with the entry before the latest, not the oldest
+    tests := []struct {
+        id       string
+        previous float64
+        change   float64
+    }{
+        {"change-three", 80, 12.5},
+        {"change-drop", 50, -20},
+    }
+    for _, tt := range tests {
+        product := listed[tt.id]
+        if product.PreviousPrice == nil || *product.PreviousPrice != tt.previous {
+            t.Errorf("%s previous price = %v, want %v", tt.id, product.PreviousPrice, tt.previous)
+        }
+        if product.ChangePercent == nil || *product.ChangePercent != tt.change {
+            t.Errorf("%s change = %v, want %v%%", tt.id, product.ChangePercent, tt.change)
+        }
+    }
+
+    for _, id := range []string{"change-one", "change-none"} {
+        if product := listed[id]; product.PreviousPrice != nil || product.ChangePercent != nil {
+            t.Errorf("%s = previous %v, change %v; want both nil", id, product.PreviousPrice, product.ChangePercent)
+        }
+    }
+}
+
+func TestProductsListingPages(t *testing.T) {
+    tracker := newTestTracker(t, nil)
+    for _, id := range []string{"page-c", "page-a", "page-e", "page-b", "page-d"} {
+        addTestProduct(t, tracker, id, 10)
+    }
+
+    if total, err := tracker.db.CountProducts(ProductFilter{}); err != nil || total != 5 {
+        t.Fatalf("count = %d, %v; want 5", total, err)
+    }
+    tests := []struct {
+        limit, offset int
+        want          []string
+    }{
+        {2, 0, []string{"page-a", "page-b"}},
+        {2, 2, []string{"page-c", "page-d"}},
+        {2, 4, []string{"page-e"}},
+        {2, 5, nil},
+        {10, 0, []string{"page-a", "page-b", "page-c", "page-d", "page-e"}},
+    }
+    for _, tt := range tests {
+        products, err := tracker.db.GetProductsWithLatestPrices(ProductFilter{}, tt.limit, tt.offset)
+        if err != nil {
+            t.Fatal(err)
+        }
+        var ids []string
+        for _, product := range products {
+            ids = append(ids, product.ID)
+        }
+        if !slices.Equal(ids, tt.want) {
+            t.Errorf("limit %d offset %d = %v, want %v", tt.limit, tt.offset, ids, tt.want)
+        }
+    }
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPriceDropsWindow(t *testing.T) {
+    tracker := newTestTracker(t, nil)
+    server := NewAPIServer(tracker)
+    now := time.Now().UTC()
+
+    // prices by how many hours ago they were recorded
+    for id, prices := range map[string]map[int]float64{
+        "drop-big":   {30: 200, 1: 150},
+        "drop-small": {30: 100, 1: 90},
+        // first priced inside the window: measured from its first price
+        "drop-recent": {10: 50, 5: 45, 1: 40},
+        "drop-rise":   {30: 100, 1: 120},
+        // fell before the last day, flat since
+        "drop-old": {72: 100, 48: 60, 1: 60},
+    } {
+        addTestProduct(t, tracker, id)
+        for hoursAgo, price := range prices {
+            entry := PriceEntry{ProductID: id, Price: price, Currency: DefaultCurrency, Timestamp: now.Add(-time.Duration(hoursAgo) * time.Hour)}
+            if _, err := tracker.db.InsertPriceEntry(entry); err != nil {
+                t.Fatal(err)
+            }
+        }
+    }
+
+    type drop struct {
+        id      string
+        percent float64
+    }
+    tests := []struct {
+        query string
+        want  []drop
+    }{
+        {"", []drop{{"drop-big", 25}, {"drop-recent", 20}, {"drop-small", 10}}},
+        {"?window=4d", []drop{{"drop-old", 40}, {"drop-big", 25}, {"drop-recent", 20}, {"drop-small", 10}}},
+        // the base is the price in effect when the window opened
+        {"?window=3h&limit=5", []drop{{"drop-big", 25}, {"drop-recent", 100 * 5.0 / 45}, {"drop-small", 10}}},
+        {"?window=4d&limit=2", []drop{{"drop-old", 40}, {"drop-big", 25}}},
+    }
+    for _, tt := range tests {
+        var result struct {
+            Window   string      `json:"window"`
+            Products []PriceDrop `json:"products"`
+            Count    int         `json:"count"`
+        }
+        if rec := serve(t, server, "GET", "/api/v1/deals"+tt.query, "", &result); rec.Code != http.StatusOK {
+            t.Fatalf("deals%s = %d: %s", tt.query, rec.Code, rec.Body)
+        }
+        if result.Count != len(tt.want) || len(result.Products) != len(tt.want) {
+            t.Errorf("deals%s = %+v, want %v", tt.query, result.Products, tt.want)
+            continue
+        }
+        for i, want := range tt.want {
+            got := result.Products[i]
+            if got.ProductID != want.id || math.Abs(got.DropPercent-want.percent) > 1e-9 || math.Abs(got.DropAmount-(got.PreviousPrice-got.CurrentPrice)) > 1e-9 {
+                t.Errorf("deals%s #%d = %s down %.2f%%, want %s down %.2f%%", tt.query, i+1, got.ProductID, got.DropPercent, want.id, want.percent)
+            }
+        }
+    }
+
+    for _, query := range []string{"?window=0h", "?window=soon", "?window=-2d", "?limit=0"} {
+        if rec := serve(t, server, "GET", "/api/v1/deals"+query, "", nil); rec.Code != http.StatusBadRequest {
+            t.Errorf("deals%s = %d, want 400", query, rec.Code)
+        }
+    }
+}
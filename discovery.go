@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ErrDiscoveryFailed is returned when a product added without a name can't
+// be looked up from its page
+var ErrDiscoveryFailed = errors.New("could not discover product details")
+
+// maxProductIDLength caps IDs derived from a discovered name
+const maxProductIDLength = 48
+
+var nonSlugChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// pageMetadata is what a product page says about itself
+type pageMetadata struct {
+    name     string
+    imageURL string
+    currency string
+}
+
+// DiscoverProduct fills in a product added with little more than a URL. It
+// fetches the page once and takes the name, image and currency from its
+// JSON-LD Product, Open Graph tags or title, keeping any the caller set. A
+// missing ID is derived from the name. Products that already have a name
+// are returned unchanged apart from the ID.
+func (pt *PriceTracker) DiscoverProduct(ctx context.Context, product Product) (Product, error) {
+    product.URL = strings.TrimSpace(product.URL)
+    product.Name = strings.TrimSpace(product.Name)
+
+    if product.Name == "" && product.URL != "" {
+        if err := validateProductURL(product.URL); err != nil {
+            return Product{}, err
+        }
+
+        ctx, cancel := context.WithTimeout(ctx, pt.fetchTimeoutOrDefault())
+        defer cancel()
+        if err := pt.hostLimiter.wait(ctx, product.URL); err != nil {
+            return Product{}, fmt.Errorf("%w: %v", ErrDiscoveryFailed, err)
+        }
+        page, _, err := loadPage(ctx, product, pageValidators{})
+        if err != nil {
+            return Product{}, fmt.Errorf("%w: %v", ErrDiscoveryFailed, err)
+        }
+
+        meta := discoverMetadata(parseHTML(page), product.URL)
+        if meta.name == "" {
+            return Product{}, fmt.Errorf("%w: the page has no product name or title", ErrDiscoveryFailed)
+        }
+        product.Name = meta.name
+        if product.ImageURL == "" {
+            product.ImageURL = meta.imageURL
+        }
+        if strings.TrimSpace(product.Currency) == "" && isCurrencyCode(meta.currency) {
+            product.Currency = meta.currency
+        }
+    }
+
+    if strings.TrimSpace(product.ID) == "" && product.Name != "" {
+        id, err := pt.uniqueProductID(product.Name)
+        if err != nil {
+            return Product{}, err
+        }
+        product.ID = id
+    }
+    return product, nil
+}
+
+// uniqueProductID turns a name into a URL-safe ID, numbering it if the
+// plain one is taken
+func (pt *PriceTracker) uniqueProductID(name string) (string, error) {
+    base := strings.Trim(nonSlugChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+    if len(base) > maxProductIDLength {
+        base = strings.TrimRight(base[:maxProductIDLength], "-")
+    }
+    if base == "" {
+        base = "product"
+    }
+
+    id := base
+    for n := 2; ; n++ {
+        exists, err := pt.db.ProductExists(id)
+        if err != nil {
+            return "", err
+        }
+        if !exists {
+            return id, nil
+        }
+        id = fmt.Sprintf("%s-%d", base, n)
+    }
+}
+
+// discoverMetadata reads a page's product details, preferring its JSON-LD
+// Product, then Open Graph and product meta tags, then the <title>. Relative
+// image URLs are resolved against the page URL.
+func discoverMetadata(root *goquery.Document, pageURL string) pageMetadata {
+    var meta pageMetadata
+    for _, data := range jsonLDBlocks(root) {
+        if product := findJSONLDProduct(data); product != nil {
+            meta.name, _ = product["name"].(string)
+            meta.imageURL = jsonLDImage(product["image"])
+            if prices := findOffers(product); len(prices) > 0 {
+                meta.currency = prices[0].currency
+            }
+            break
+        }
+    }
+
+    if meta.name == "" {
+        meta.name = metaContent(root, "og:title")
+    }
+    if meta.name == "" {
+        meta.name = root.Find("title").First().Text()
+    }
+    if meta.imageURL == "" {
+        meta.imageURL = metaContent(root, "og:image")
+    }
+    if meta.currency == "" {
+        for _, property := range []string{"og:price:currency", "product:price:currency"} {
+            if meta.currency = metaContent(root, property); meta.currency != "" {
+                break
+            }
+        }
+    }
+
+    meta.name = strings.Join(strings.Fields(meta.name), " ")
+    meta.currency = strings.ToUpper(strings.TrimSpace(meta.currency))
+    if meta.imageURL != "" {
+        meta.imageURL = resolveURL(pageURL, strings.TrimSpace(meta.imageURL))
+    }
+    return meta
+}
+
+// findJSONLDProduct returns the first object typed Product in a JSON-LD
+// document, looking inside @graph and other properties
+func findJSONLDProduct(value interface{}) map[string]interface{} {
+    switch v := value.(type) {
+    case []interface{}:
+        for _, item := range v {
+            if product := findJSONLDProduct(item); product != nil {
+                return product
+            }
+        }
+    case map[string]interface{}:
+        if jsonLDHasType(v["@type"], "Product") {
+            return v
+        }
+        for _, key := range sortedKeys(v) {
+            if product := findJSONLDProduct(v[key]); product != nil {
+                return product
+            }
+        }
+    }
+    return nil
+}
+
+// jsonLDHasType reports whether an @type value, a string or a list of them,
+// includes want
+func jsonLDHasType(value interface{}, want string) bool {
+    switch v := value.(type) {
+    case string:
+        return v == want || strings.HasSuffix(v, "/"+want)
+    case []interface{}:
+        for _, item := range v {
+            if jsonLDHasType(item, want) {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// jsonLDImage reads an image given as a URL, an ImageObject or a list of
+// either, returning the first
+func jsonLDImage(value interface{}) string {
+    switch v := value.(type) {
+    case string:
+        return v
+    case []interface{}:
+        if len(v) > 0 {
+            return jsonLDImage(v[0])
+        }
+    case map[string]interface{}:
+        if imageURL, ok := v["url"].(string); ok {
+            return imageURL
+        }
+        if imageURL, ok := v["contentUrl"].(string); ok {
+            return imageURL
+        }
+    }
+    return ""
+}
+
+// resolveURL resolves ref against base, returning ref unchanged if either
+// doesn't parse
+func resolveURL(base, ref string) string {
+    baseURL, err := url.Parse(base)
+    if err != nil {
+        return ref
+    }
+    refURL, err := url.Parse(ref)
+    if err != nil {
+        return ref
+    }
+    return baseURL.ResolveReference(refURL).String()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDuplicatePricesSkipped(t *testing.T) {
+    tracker := newTestTracker(t, cyclePrices(10, 10, 10.005, 10.5, 10))
+    tracker.SetRetryPolicy(1, 0)
+    tracker.SetDuplicateEpsilon(0.01)
+    addTestProduct(t, tracker, "duplicate-kettle")
+
+    for i, want := range []int{
+        1, // the first price is always stored
+        1, // unchanged
+        1, // moved by less than the epsilon
+        2, // changed
+        3, // changed back
+    } {
+        tracker.trackProducts(context.Background(), tracker.snapshotProducts(), nil)
+        history, err := tracker.GetPriceHistory("duplicate-kettle", 10, true)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(history) != want {
+            t.Fatalf("after cycle %d: %d entries stored, want %d", i+1, len(history), want)
+        }
+    }
+
+    // history is newest first
+    history, _ := tracker.GetPriceHistory("duplicate-kettle", 10, true)
+    for i, want := range []float64{10, 10.5, 10} {
+        if got := history[len(history)-1-i].Price; got != want {
+            t.Errorf("entry %d = %v, want %v", i, got, want)
+        }
+    }
+}
+
+func TestDuplicateCheckPerProduct(t *testing.T) {
+    // one product's stored price doesn't count as another's
+    tracker := newTestTracker(t, cyclePrices(25))
+    tracker.SetRetryPolicy(1, 0)
+    tracker.SetDuplicateEpsilon(0.01)
+    addTestProduct(t, tracker, "duplicate-a", 25)
+    addTestProduct(t, tracker, "duplicate-b")
+
+    tracker.trackProducts(context.Background(), tracker.snapshotProducts(), nil)
+    for id, want := range map[string]int{"duplicate-a": 1, "duplicate-b": 1} {
+        if history, _ := tracker.GetPriceHistory(id, 10, true); len(history) != want {
+            t.Errorf("%s has %d entries, want %d", id, len(history), want)
+        }
+    }
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const ebayAPIBase = "https://api.ebay.com"
+
+// Listing types recorded for eBay items
+const (
+    ListingAuction  = "auction"
+    ListingBuyItNow = "buy_it_now"
+)
+
+// ebayMarketplaces maps eBay site domains, without "www.", to Browse API
+// marketplace IDs
+var ebayMarketplaces = map[string]string{
+    "ebay.com":    "EBAY_US",
+    "ebay.ca":     "EBAY_CA",
+    "ebay.co.uk":  "EBAY_GB",
+    "ebay.ie":     "EBAY_IE",
+    "ebay.de":     "EBAY_DE",
+    "ebay.at":     "EBAY_AT",
+    "ebay.ch":     "EBAY_CH",
+    "ebay.fr":     "EBAY_FR",
+    "ebay.it":     "EBAY_IT",
+    "ebay.es":     "EBAY_ES",
+    "ebay.nl":     "EBAY_NL",
+    "ebay.pl":     "EBAY_PL",
+    "ebay.com.au": "EBAY_AU",
+}
+
+// ebayItemPattern finds the item number in /itm/<id> and
+// /itm/<title>/<id> URLs
+var ebayItemPattern = regexp.MustCompile(`/itm/(?:[^/]+/)?(\d{9,15})(?:[/?#]|$)`)
+
+// errNotEBayItem is returned for products whose URL isn't an eBay item
+var errNotEBayItem = errors.New("not an eBay item URL")
+
+// EBayFetcher gets prices from the eBay Browse API for products whose URL
+// is an eBay item. Auctions are priced at their current bid.
+type EBayFetcher struct {
+    // Token is an OAuth application access token for the Browse API
+    Token string
+
+    // baseURL replaces the API host, for pointing the fetcher at a stub
+    baseURL string
+}
+
+// ebayItem returns the legacy item number and marketplace ID of an eBay
+// item URL
+func ebayItem(rawURL string) (string, string, bool) {
+    parsed, err := url.Parse(rawURL)
+    if err != nil {
+        return "", "", false
+    }
+    domain := strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+    marketplace, ok := ebayMarketplaces[domain]
+    if !ok {
+        return "", "", false
+    }
+    match := ebayItemPattern.FindStringSubmatch(parsed.EscapedPath())
+    if match == nil {
+        return "", "", false
+    }
+    return match[1], marketplace, true
+}
+
+func (f *EBayFetcher) handles(product Product) bool {
+    if f == nil {
+        return false
+    }
+    _, _, ok := ebayItem(product.URL)
+    return ok
+}
+
+func (f *EBayFetcher) FetchPrice(ctx context.Context, product Product) (float64, error) {
+    reading, err := f.FetchReading(ctx, product)
+    return reading.Price, err
+}
+
+func (f *EBayFetcher) FetchReading(ctx context.Context, product Product) (PriceReading, error) {
+    itemID, marketplace, ok := ebayItem(product.URL)
+    if !ok {
+        return PriceReading{}, fmt.Errorf("%w: %s", errNotEBayItem, product.URL)
+    }
+
+    base := ebayAPIBase
+    if f.baseURL != "" {
+        base = f.baseURL
+    }
+    endpoint := base + "/buy/browse/v1/item/get_item_by_legacy_id?legacy_item_id=" + url.QueryEscape(itemID)
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+    if err != nil {
+        return PriceReading{}, err
+    }
+    req.Header.Set("Authorization", "Bearer "+f.Token)
+    req.Header.Set("X-EBAY-C-MARKETPLACE-ID", marketplace)
+    req.Header.Set("Accept", "application/json")
+
+    resp, err := apiClient.Do(req)
+    if err != nil {
+        return PriceReading{}, retryableError{err}
+    }
+    defer resp.Body.Close()
+
+    body, err := io.ReadAll(io.LimitReader(resp.Body, maxAPIResponseBytes))
+    if err != nil {
+        return PriceReading{}, retryableError{err}
+    }
+
+    var item ebayItemResponse
+    if err := json.Unmarshal(body, &item); err != nil && resp.StatusCode == http.StatusOK {
+        return PriceReading{}, fmt.Errorf("decoding eBay API response for item %s: %w", itemID, err)
+    }
+    if resp.StatusCode != http.StatusOK {
+        msg := resp.Status
+        if len(item.Errors) > 0 {
+            msg = fmt.Sprintf("%d: %s", item.Errors[0].ErrorID, item.Errors[0].Message)
+        }
+        err := statusError{code: resp.StatusCode, err: fmt.Errorf("eBay API request for item %s failed: %s", itemID, msg)}
+        if isRetryableStatus(resp.StatusCode) {
+            return PriceReading{}, retryableError{err}
+        }
+        return PriceReading{}, err
+    }
+
+    reading := PriceReading{ListingType: ListingBuyItNow}
+    price := item.Price
+    for _, option := range item.BuyingOptions {
+        if option == "AUCTION" {
+            reading.ListingType = ListingAuction
+            // an auction that also offers Buy It Now is priced at its bid
+            if item.CurrentBidPrice != nil {
+                price = item.CurrentBidPrice
+            }
+        }
+    }
+    if price == nil {
+        return PriceReading{}, fmt.Errorf("eBay item %s has no price", itemID)
+    }
+
+    currency := product.Currency
+    if currency == "" {
+        currency = DefaultCurrency
+    }
+    if !strings.EqualFold(price.Currency, currency) {
+        return PriceReading{}, fmt.Errorf("eBay item %s is priced in %s, but the product is tracked in %s", itemID, price.Currency, currency)
+    }
+    if reading.Price, err = parsePrice(price.Value); err != nil {
+        return PriceReading{}, fmt.Errorf("eBay item %s: %w", itemID, err)
+    }
+
+    if original := item.MarketingPrice.OriginalPrice; original != nil && strings.EqualFold(original.Currency, currency) {
+        if listPrice, err := parsePrice(original.Value); err == nil && listPrice > reading.Price {
+            reading.ListPrice = &listPrice
+        }
+    }
+    for _, availability := range item.EstimatedAvailabilities {
+        switch availability.Status {
+        case "IN_STOCK", "LIMITED_STOCK":
+            inStock := true
+            reading.InStock = &inStock
+        case "OUT_OF_STOCK":
+            inStock := false
+            reading.InStock = &inStock
+        }
+        if reading.InStock != nil {
+            break
+        }
+    }
+    // the cheapest shipping option in the product's currency
+    for _, option := range item.ShippingOptions {
+        if option.ShippingCost == nil || !strings.EqualFold(option.ShippingCost.Currency, currency) {
+            continue
+        }
+        if shipping, err := strconv.ParseFloat(option.ShippingCost.Value, 64); err == nil && shipping >= 0 && (reading.Shipping == nil || shipping < *reading.Shipping) {
+            reading.Shipping = &shipping
+        }
+    }
+    return reading, nil
+}
+
+// ebayItemResponse is the part of a Browse API item the fetcher reads
+type ebayItemResponse struct {
+    Price           *ebayAmount `json:"price"`
+    CurrentBidPrice *ebayAmount `json:"currentBidPrice"`
+    BuyingOptions   []string    `json:"buyingOptions"`
+    MarketingPrice  struct {
+        OriginalPrice *ebayAmount `json:"originalPrice"`
+    } `json:"marketingPrice"`
+    EstimatedAvailabilities []struct {
+        Status string `json:"estimatedAvailabilityStatus"`
+    } `json:"estimatedAvailabilities"`
+    ShippingOptions []struct {
+        ShippingCost *ebayAmount `json:"shippingCost"`
+    } `json:"shippingOptions"`
+    Errors []struct {
+        ErrorID int    `json:"errorId"`
+        Message string `json:"message"`
+    } `json:"errors"`
+}
+
+// ebayAmount is a Browse API amount; values are decimal strings
+type ebayAmount struct {
+    Value    string `json:"value"`
+    Currency string `json:"currency"`
+}
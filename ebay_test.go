@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newEBayAPI serves canned Browse API items by legacy item ID; unknown IDs
+// get eBay's 404
+func newEBayAPI(t *testing.T, items map[string]string) *httptest.Server {
+    t.Helper()
+    api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path != "/buy/browse/v1/item/get_item_by_legacy_id" || r.Header.Get("Authorization") != "Bearer test-token" {
+            w.WriteHeader(http.StatusUnauthorized)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        item, ok := items[r.URL.Query().Get("legacy_item_id")]
+        if !ok {
+            w.WriteHeader(http.StatusNotFound)
+            w.Write([]byte(`{"errors": [{"errorId": 11001, "message": "The specified item Id was not found."}]}`))
+            return
+        }
+        // the marketplace header is echoed so items can check it
+        item = strings.ReplaceAll(item, "$MARKETPLACE", r.Header.Get("X-EBAY-C-MARKETPLACE-ID"))
+        w.Write([]byte(item))
+    }))
+    t.Cleanup(api.Close)
+    return api
+}
+
+func TestEBayFetchReading(t *testing.T) {
+    api := newEBayAPI(t, map[string]string{
+        "123456789012": `{
+            "title": "$MARKETPLACE",
+            "price": {"value": "249.99", "currency": "GBP"},
+            "buyingOptions": ["FIXED_PRICE"],
+            "marketingPrice": {"originalPrice": {"value": "299.99", "currency": "GBP"}},
+            "estimatedAvailabilities": [{"estimatedAvailabilityStatus": "LIMITED_STOCK"}],
+            "shippingOptions": [{"shippingCost": {"value": "4.99", "currency": "GBP"}}, {"shippingCost": {"value": "0.00", "currency": "GBP"}}]
+        }`,
+        "223456789012": `{
+            "price": {"value": "180.00", "currency": "USD"},
+            "currentBidPrice": {"value": "97.50", "currency": "USD"},
+            "buyingOptions": ["AUCTION", "FIXED_PRICE"],
+            "estimatedAvailabilities": [{"estimatedAvailabilityStatus": "OUT_OF_STOCK"}]
+        }`,
+    })
+    f := &EBayFetcher{Token: "test-token", baseURL: api.URL}
+
+    reading, err := f.FetchReading(context.Background(), Product{URL: "https://www.ebay.co.uk/itm/Espresso-Machine/123456789012?hash=item1", Currency: "GBP"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if reading.Price != 249.99 || reading.ListingType != ListingBuyItNow {
+        t.Errorf("reading = %+v, want 249.99 buy it now", reading)
+    }
+    if reading.ListPrice == nil || *reading.ListPrice != 299.99 {
+        t.Errorf("list price = %v, want 299.99", reading.ListPrice)
+    }
+    if reading.InStock == nil || !*reading.InStock {
+        t.Errorf("in stock = %v, want limited stock to count", reading.InStock)
+    }
+    if reading.Shipping == nil || *reading.Shipping != 0 {
+        t.Errorf("shipping = %v, want the free option", reading.Shipping)
+    }
+
+    // an auction is priced at its current bid
+    reading, err = f.FetchReading(context.Background(), Product{URL: "https://www.ebay.com/itm/223456789012"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if reading.Price != 97.50 || reading.ListingType != ListingAuction || reading.InStock == nil || *reading.InStock || reading.Shipping != nil {
+        t.Errorf("auction reading = %+v", reading)
+    }
+
+    // the item's currency must be the product's
+    if _, err := f.FetchReading(context.Background(), Product{URL: "https://www.ebay.co.uk/itm/123456789012"}); err == nil || !strings.Contains(err.Error(), "priced in GBP") {
+        t.Errorf("currency mismatch = %v", err)
+    }
+}
+
+func TestEBayItem(t *testing.T) {
+    for rawURL, want := range map[string]string{
+        "https://www.ebay.com/itm/123456789012":               "123456789012 EBAY_US",
+        "https://ebay.de/itm/Kaffeemaschine/123456789012?x=1": "123456789012 EBAY_DE",
+        "https://www.ebay.com/sch/i.html?_nkw=kettle":         "",
+        "https://www.ebay.xyz/itm/123456789012":               "",
+    } {
+        id, marketplace, ok := ebayItem(rawURL)
+        got := ""
+        if ok {
+            got = id + " " + marketplace
+        }
+        if got != want {
+            t.Errorf("ebayItem(%q) = %q, want %q", rawURL, got, want)
+        }
+    }
+}
+
+func TestEBayFetchErrors(t *testing.T) {
+    api := newEBayAPI(t, map[string]string{"323456789012": `{"buyingOptions": ["FIXED_PRICE"]}`})
+    f := &EBayFetcher{Token: "test-token", baseURL: api.URL}
+
+    // a removed listing is a permanent failure with eBay's reason
+    _, err := f.FetchReading(context.Background(), Product{URL: "https://www.ebay.com/itm/999999999999"})
+    var status statusError
+    if !errors.As(err, &status) || status.code != http.StatusNotFound || isRetryable(err) || !strings.Contains(err.Error(), "11001") {
+        t.Errorf("missing item = %v, want a permanent 404 with the error ID", err)
+    }
+
+    if _, err := f.FetchReading(context.Background(), Product{URL: "https://www.ebay.com/itm/323456789012"}); err == nil || !strings.Contains(err.Error(), "has no price") {
+        t.Errorf("item without a price = %v", err)
+    }
+
+    // a bad token is permanent too
+    f.Token = "expired"
+    if _, err := f.FetchReading(context.Background(), Product{URL: "https://www.ebay.com/itm/323456789012"}); err == nil || isRetryable(err) {
+        t.Errorf("unauthorized = %v, want a permanent error", err)
+    }
+
+    if _, err := f.FetchReading(context.Background(), Product{URL: "https://shop.test/itm/123456789012"}); !errors.Is(err, errNotEBayItem) {
+        t.Errorf("non-eBay URL = %v", err)
+    }
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+    // elasticityLookback is how much history the elasticity hint considers
+    elasticityLookback = 180 * 24 * time.Hour
+    // elasticityWindow is how soon after a price change a sell-out is
+    // taken to be a response to it
+    elasticityWindow = 72 * time.Hour
+    // elasticityMinChanges and elasticityMinDrops gate the hint on having
+    // enough price changes, with stock known, to say anything
+    elasticityMinChanges = 4
+    elasticityMinDrops   = 2
+    // elasticityMinChange is the smallest price move, in percent, that
+    // counts as a change
+    elasticityMinChange = 0.5
+)
+
+// GetElasticity gives a crude hint of how sensitive demand for a product is
+// to its price: how often it sold out soon after a price drop, compared with
+// after a rise. It is speculative, since a sell-out can have many causes,
+// and needs the pages to report availability; without any stock data the
+// label is "unavailable".
+func (pt *PriceTracker) GetElasticity(productID string) (*ElasticityHint, error) {
+    exists, err := pt.db.ProductExists(productID)
+    if err != nil {
+        return nil, err
+    }
+    if !exists {
+        return nil, fmt.Errorf("%w: %s", ErrProductNotFound, productID)
+    }
+
+    to := time.Now()
+    history, err := pt.db.GetPriceHistoryRange(productID, to.Add(-elasticityLookback), to, 0, false)
+    if err != nil {
+        return nil, err
+    }
+    return elasticityHint(productID, history), nil
+}
+
+// elasticityHint works out the hint from a product's history, newest first
+func elasticityHint(productID string, history []PriceEntry) *ElasticityHint {
+    hint := &ElasticityHint{ProductID: productID, Label: "unavailable", Points: []ElasticityPoint{}}
+
+    // oldest first, keeping only the entries that say whether it was in stock
+    var entries []PriceEntry
+    for i := len(history) - 1; i >= 0; i-- {
+        if history[i].InStock != nil {
+            entries = append(entries, history[i])
+        }
+    }
+    if len(entries) == 0 {
+        return hint
+    }
+
+    // each price change made while the product was in stock, and the
+    // first sell-out after it, if one came before the next change and
+    // within the window
+    var dropSellOuts, riseSellOuts int
+    var hoursToSellOut float64
+    for i := 1; i < len(entries); i++ {
+        previous, entry := entries[i-1], entries[i]
+        if previous.Price <= 0 {
+            continue
+        }
+        change := (entry.Price - previous.Price) / previous.Price * 100
+        if math.Abs(change) < elasticityMinChange || !*entry.InStock {
+            continue
+        }
+
+        point := ElasticityPoint{
+            At:            entry.Timestamp,
+            PreviousPrice: previous.Price,
+            Price:         entry.Price,
+            ChangePercent: change,
+        }
+        for _, later := range entries[i+1:] {
+            if later.Timestamp.Sub(entry.Timestamp) > elasticityWindow {
+                break
+            }
+            if math.Abs(later.Price-entry.Price)/entry.Price*100 >= elasticityMinChange {
+                break
+            }
+            if !*later.InStock {
+                at := later.Timestamp
+                hours := at.Sub(entry.Timestamp).Hours()
+                point.SoldOutAt = &at
+                point.HoursToSellOut = &hours
+                break
+            }
+        }
+
+        if change < 0 {
+            hint.Drops++
+            if point.SoldOutAt != nil {
+                dropSellOuts++
+                hoursToSellOut += *point.HoursToSellOut
+            }
+        } else {
+            hint.Rises++
+            if point.SoldOutAt != nil {
+                riseSellOuts++
+            }
+        }
+        hint.Points = append(hint.Points, point)
+    }
+
+    if hint.Drops+hint.Rises < elasticityMinChanges || hint.Drops < elasticityMinDrops {
+        hint.Label = "insufficient_data"
+        return hint
+    }
+
+    hint.DropSellOutRate = float64(dropSellOuts) / float64(hint.Drops)
+    if hint.Rises > 0 {
+        hint.RiseSellOutRate = float64(riseSellOuts) / float64(hint.Rises)
+    }
+    if dropSellOuts > 0 {
+        average := hoursToSellOut / float64(dropSellOuts)
+        hint.AvgHoursToSellOut = &average
+    }
+
+    switch gap := hint.DropSellOutRate - hint.RiseSellOutRate; {
+    case gap >= 0.5:
+        hint.Label = "high"
+    case gap >= 0.2:
+        hint.Label = "moderate"
+    default:
+        hint.Label = "low"
+    }
+    return hint
+}
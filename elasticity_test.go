@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// stockReading is a synthetic fetch: hours after the start, the price and
+// whether the product was in stock
+type stockReading struct {
+    hour    int
+    price   float64
+    inStock bool
+}
+
+func addStockHistory(t *testing.T, tracker *PriceTracker, id string, readings []stockReading) {
+    t.Helper()
+    addTestProduct(t, tracker, id)
+    start := time.Now().UTC().Add(-60 * 24 * time.Hour)
+    for _, reading := range readings {
+        inStock := reading.inStock
+        entry := PriceEntry{ProductID: id, Price: reading.price, Currency: DefaultCurrency, InStock: &inStock, Timestamp: start.Add(time.Duration(reading.hour) * time.Hour)}
+        if _, err := tracker.db.InsertPriceEntry(entry); err != nil {
+            t.Fatal(err)
+        }
+    }
+}
+
+// priceCycles repeats a rise to 100 and a drop to 80, selling out 10 hours
+// after the drop, or after the rise when sellOutAfterRise is set
+func priceCycles(cycles int, sellOutAfterRise bool) []stockReading {
+    var readings []stockReading
+    for k := 0; k < cycles; k++ {
+        base := k * 200
+        if sellOutAfterRise {
+            readings = append(readings,
+                stockReading{base, 100, true},
+                stockReading{base + 10, 100, false},
+                stockReading{base + 50, 100, true},
+                stockReading{base + 100, 80, true},
+            )
+        } else {
+            readings = append(readings,
+                stockReading{base, 100, true},
+                stockReading{base + 100, 80, true},
+                stockReading{base + 110, 80, false},
+                stockReading{base + 150, 80, true},
+            )
+        }
+    }
+    return readings
+}
+
+func TestElasticityCorrelated(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    addStockHistory(t, server.tracker, "elastic-tv", priceCycles(3, false))
+
+    var hint ElasticityHint
+    if rec := serve(t, server, "GET", "/api/v1/products/elastic-tv/elasticity", "", &hint); rec.Code != http.StatusOK {
+        t.Fatalf("elasticity = %d: %s", rec.Code, rec.Body)
+    }
+    if hint.Label != "high" || hint.Drops != 3 || hint.Rises != 2 {
+        t.Errorf("hint = %+v, want high with 3 drops and 2 rises", hint)
+    }
+    if hint.DropSellOutRate != 1 || hint.RiseSellOutRate != 0 {
+        t.Errorf("sell-out rates %v after drops and %v after rises, want 1 and 0", hint.DropSellOutRate, hint.RiseSellOutRate)
+    }
+    if hint.AvgHoursToSellOut == nil || *hint.AvgHoursToSellOut != 10 {
+        t.Errorf("hours to sell out = %v, want 10", hint.AvgHoursToSellOut)
+    }
+    if len(hint.Points) != 5 || hint.Points[0].ChangePercent != -20 || hint.Points[0].SoldOutAt == nil {
+        t.Errorf("points = %+v, want 5 starting with a 20%% drop that sold out", hint.Points)
+    }
+}
+
+func TestElasticityUncorrelated(t *testing.T) {
+    tracker := newTestTracker(t, nil)
+    addStockHistory(t, tracker, "elastic-rise", priceCycles(3, true))
+
+    hint, err := tracker.GetElasticity("elastic-rise")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if hint.Label != "low" || hint.DropSellOutRate != 0 {
+        t.Errorf("hint = %+v, want low when sell-outs don't follow drops", hint)
+    }
+}
+
+func TestElasticityGated(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+
+    // no stock data at all
+    addTestProduct(t, server.tracker, "elastic-nostock", 100, 80, 100, 80, 100)
+    var hint ElasticityHint
+    serve(t, server, "GET", "/api/v1/products/elastic-nostock/elasticity", "", &hint)
+    if hint.Label != "unavailable" || hint.Points == nil {
+        t.Errorf("hint = %+v, want unavailable with no points", hint)
+    }
+
+    // one drop isn't enough to go on
+    addStockHistory(t, server.tracker, "elastic-short", priceCycles(1, false))
+    serve(t, server, "GET", "/api/v1/products/elastic-short/elasticity", "", &hint)
+    if hint.Label != "insufficient_data" || hint.Drops != 1 {
+        t.Errorf("hint = %+v, want insufficient_data", hint)
+    }
+
+    if rec := serve(t, server, "GET", "/api/v1/products/no-such-product/elasticity", "", nil); rec.Code != http.StatusNotFound {
+        t.Errorf("unknown product = %d, want 404", rec.Code)
+    }
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+    ecbRatesURL = "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-daily.xml"
+    oxrRatesURL = "https://openexchangerates.org/api/latest.json"
+
+    // defaultExchangeRatesTTL is how long exchange rates are cached when
+    // EXCHANGE_RATES_TTL is unset; the ECB publishes once a day
+    defaultExchangeRatesTTL = 6 * time.Hour
+
+    // ratesRetryDelay is how long stale rates are used after a failed
+    // refresh before trying again
+    ratesRetryDelay = time.Minute
+)
+
+var (
+    // ErrUnknownCurrency is returned when no rate is known for a currency
+    ErrUnknownCurrency = errors.New("no exchange rate for currency")
+    // ErrRatesUnavailable is returned when exchange rates couldn't be
+    // loaded and none are cached
+    ErrRatesUnavailable = errors.New("exchange rates unavailable")
+)
+
+// ExchangeRates converts prices between currencies using rates from the
+// European Central Bank or, given an app ID, Open Exchange Rates. Rates are
+// cached for ttl; when a refresh fails the cached rates keep being used.
+type ExchangeRates struct {
+    appID string
+    ttl   time.Duration
+
+    // endpoint replaces the rates URL, for pointing the service at a stub
+    endpoint string
+
+    mu        sync.Mutex
+    base      string
+    rates     map[string]float64
+    fetchedAt time.Time
+    retryAt   time.Time
+}
+
+// NewExchangeRates returns a rate service; an empty appID uses the ECB's
+// daily reference rates
+func NewExchangeRates(appID string, ttl time.Duration) *ExchangeRates {
+    return &ExchangeRates{appID: appID, ttl: ttl}
+}
+
+// Rate returns how many units of to one unit of from is worth
+func (e *ExchangeRates) Rate(ctx context.Context, from, to string) (float64, error) {
+    if from == "" {
+        from = DefaultCurrency
+    }
+    if from == to {
+        return 1, nil
+    }
+    base, rates, err := e.current(ctx)
+    if err != nil {
+        return 0, err
+    }
+    fromRate, err := baseRate(base, rates, from)
+    if err != nil {
+        return 0, err
+    }
+    toRate, err := baseRate(base, rates, to)
+    if err != nil {
+        return 0, err
+    }
+    return toRate / fromRate, nil
+}
+
+// baseRate returns how many units of currency one unit of base is worth
+func baseRate(base string, rates map[string]float64, currency string) (float64, error) {
+    if currency == base {
+        return 1, nil
+    }
+    rate, ok := rates[currency]
+    if !ok || rate <= 0 {
+        return 0, fmt.Errorf("%w %s", ErrUnknownCurrency, currency)
+    }
+    return rate, nil
+}
+
+// current returns the cached rates, refreshing them once they are older
+// than the ttl
+func (e *ExchangeRates) current(ctx context.Context) (string, map[string]float64, error) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+
+    if e.rates != nil && (time.Since(e.fetchedAt) < e.ttl || time.Now().Before(e.retryAt)) {
+        return e.base, e.rates, nil
+    }
+    base, rates, err := e.load(ctx)
+    if err != nil {
+        if e.rates == nil {
+            return "", nil, fmt.Errorf("%w: %v", ErrRatesUnavailable, err)
+        }
+        // keep serving stale rates, and don't retry on every request
+        log.Printf("Refreshing exchange rates failed, using rates from %s: %v", e.fetchedAt.Format(time.RFC3339), err)
+        e.retryAt = time.Now().Add(ratesRetryDelay)
+        return e.base, e.rates, nil
+    }
+    e.base, e.rates, e.fetchedAt = base, rates, time.Now()
+    return base, rates, nil
+}
+
+func (e *ExchangeRates) load(ctx context.Context) (string, map[string]float64, error) {
+    if e.appID != "" {
+        return e.loadOXR(ctx)
+    }
+    return e.loadECB(ctx)
+}
+
+// loadOXR reads the latest rates from Open Exchange Rates, which are
+// quoted against USD on the free plan
+func (e *ExchangeRates) loadOXR(ctx context.Context) (string, map[string]float64, error) {
+    endpoint := oxrRatesURL
+    if e.endpoint != "" {
+        endpoint = e.endpoint
+    }
+    var latest struct {
+        Base  string             `json:"base"`
+        Rates map[string]float64 `json:"rates"`
+    }
+    if err := getAPIJSON(ctx, endpoint+"?"+url.Values{"app_id": {e.appID}}.Encode(), nil, &latest); err != nil {
+        return "", nil, err
+    }
+    if latest.Base == "" || len(latest.Rates) == 0 {
+        return "", nil, errors.New("rates response has no rates")
+    }
+    return latest.Base, latest.Rates, nil
+}
+
+// loadECB reads the ECB's daily euro reference rates
+func (e *ExchangeRates) loadECB(ctx context.Context) (string, map[string]float64, error) {
+    endpoint := ecbRatesURL
+    if e.endpoint != "" {
+        endpoint = e.endpoint
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+    if err != nil {
+        return "", nil, err
+    }
+    resp, err := apiClient.Do(req)
+    if err != nil {
+        return "", nil, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return "", nil, fmt.Errorf("rates request failed: %s", resp.Status)
+    }
+
+    // the rates are Cube elements with currency and rate attributes
+    var envelope struct {
+        Cubes []struct {
+            Currency string `xml:"currency,attr"`
+            Rate     string `xml:"rate,attr"`
+        } `xml:"Cube>Cube>Cube"`
+    }
+    if err := xml.NewDecoder(io.LimitReader(resp.Body, maxAPIResponseBytes)).Decode(&envelope); err != nil {
+        return "", nil, fmt.Errorf("decoding rates: %w", err)
+    }
+    rates := make(map[string]float64, len(envelope.Cubes))
+    for _, cube := range envelope.Cubes {
+        rate, err := strconv.ParseFloat(strings.TrimSpace(cube.Rate), 64)
+        if err != nil || cube.Currency == "" {
+            continue
+        }
+        rates[cube.Currency] = rate
+    }
+    if len(rates) == 0 {
+        return "", nil, errors.New("rates response has no rates")
+    }
+    return "EUR", rates, nil
+}
+
+// ConvertProducts converts a products page's prices to currency in place
+func (e *ExchangeRates) ConvertProducts(ctx context.Context, items []ProductWithLatestPrice, currency string) error {
+    for i := range items {
+        rate, err := e.Rate(ctx, items[i].Currency, currency)
+        if err != nil {
+            return err
+        }
+        item := &items[i]
+        item.LatestPrice = scaled(item.LatestPrice, rate)
+        item.MedianPrice = scaled(item.MedianPrice, rate)
+        item.ListPrice = scaled(item.ListPrice, rate)
+        item.Shipping = scaled(item.Shipping, rate)
+        item.TotalPrice = scaled(item.TotalPrice, rate)
+        item.PreviousPrice = scaled(item.PreviousPrice, rate)
+        item.TargetPrice = scaled(item.TargetPrice, rate)
+        item.Currency = currency
+    }
+    return nil
+}
+
+// ConvertEntries converts price entries, each from its own currency, to
+// currency in place
+func (e *ExchangeRates) ConvertEntries(ctx context.Context, entries []PriceEntry, currency string) error {
+    for i := range entries {
+        rate, err := e.Rate(ctx, entries[i].Currency, currency)
+        if err != nil {
+            return err
+        }
+        entries[i].Price = convertPrice(entries[i].Price, rate)
+        entries[i].ListPrice = scaled(entries[i].ListPrice, rate)
+        entries[i].Shipping = scaled(entries[i].Shipping, rate)
+        entries[i].TotalPrice = scaled(entries[i].TotalPrice, rate)
+        entries[i].Currency = currency
+    }
+    return nil
+}
+
+// ConvertBuckets converts history buckets, each from its own currency, to
+// currency in place
+func (e *ExchangeRates) ConvertBuckets(ctx context.Context, buckets []HistoryBucket, currency string) error {
+    for i := range buckets {
+        rate, err := e.Rate(ctx, buckets[i].Currency, currency)
+        if err != nil {
+            return err
+        }
+        b := &buckets[i]
+        b.Open = convertPrice(b.Open, rate)
+        b.Close = convertPrice(b.Close, rate)
+        b.Min = convertPrice(b.Min, rate)
+        b.Max = convertPrice(b.Max, rate)
+        b.Avg = convertPrice(b.Avg, rate)
+        b.Currency = currency
+    }
+    return nil
+}
+
+// ConvertExtremes converts a product's price extremes to currency in place
+func (e *ExchangeRates) ConvertExtremes(ctx context.Context, extremes *PriceExtremes, currency string) error {
+    rate, err := e.Rate(ctx, extremes.Currency, currency)
+    if err != nil {
+        return err
+    }
+    for _, point := range []*PricePoint{extremes.Low, extremes.High, extremes.Current} {
+        if point != nil {
+            point.Price = convertPrice(point.Price, rate)
+        }
+    }
+    extremes.Currency = currency
+    return nil
+}
+
+// ConvertStats converts price stats to currency in place
+func (e *ExchangeRates) ConvertStats(ctx context.Context, stats *PriceStats, currency string) error {
+    rate, err := e.Rate(ctx, stats.Currency, currency)
+    if err != nil {
+        return err
+    }
+    stats.MinPrice = convertPrice(stats.MinPrice, rate)
+    stats.MaxPrice = convertPrice(stats.MaxPrice, rate)
+    stats.AvgPrice = convertPrice(stats.AvgPrice, rate)
+    stats.MedianPrice = convertPrice(stats.MedianPrice, rate)
+    stats.StdDev = convertPrice(stats.StdDev, rate)
+    stats.LatestPrice = convertPrice(stats.LatestPrice, rate)
+    stats.Currency = currency
+    return nil
+}
+
+// convertPrice applies an exchange rate, rounding to the cent
+func convertPrice(price, rate float64) float64 {
+    return math.Round(price*rate*100) / 100
+}
+
+// scaled converts an optional price, returning a copy
+func scaled(price *float64, rate float64) *float64 {
+    if price == nil {
+        return nil
+    }
+    converted := convertPrice(*price, rate)
+    return &converted
+}
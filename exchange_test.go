@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const ecbRatesXML = `<?xml version="1.0" encoding="UTF-8"?>
+<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+  <gesmes:subject>Reference rates</gesmes:subject>
+  <Cube>
+    <Cube time="2024-01-02">
+      <Cube currency="USD" rate="1.0956"/>
+      <Cube currency="JPY" rate="155.65"/>
+      <Cube currency="GBP" rate="0.86675"/>
+      <Cube currency="XXX" rate="n/a"/>
+    </Cube>
+  </Cube>
+</gesmes:Envelope>`
+
+// newRatesServer serves body, or a 503 while down is set, counting requests
+func newRatesServer(t *testing.T, body string, down *atomic.Bool) (*httptest.Server, *atomic.Int32) {
+    t.Helper()
+    var hits atomic.Int32
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        hits.Add(1)
+        if down != nil && down.Load() {
+            w.WriteHeader(http.StatusServiceUnavailable)
+            return
+        }
+        if r.URL.Query().Get("app_id") != "" && r.URL.Query().Get("app_id") != "test-app" {
+            w.WriteHeader(http.StatusUnauthorized)
+            return
+        }
+        w.Write([]byte(body))
+    }))
+    t.Cleanup(server.Close)
+    return server, &hits
+}
+
+func TestExchangeRatesECB(t *testing.T) {
+    server, hits := newRatesServer(t, ecbRatesXML, nil)
+    rates := NewExchangeRates("", time.Hour)
+    rates.endpoint = server.URL
+    ctx := context.Background()
+
+    tests := []struct {
+        from, to string
+        want     float64
+    }{
+        {"EUR", "USD", 1.0956},
+        {"USD", "EUR", 1 / 1.0956},
+        // crosses go through the euro
+        {"GBP", "JPY", 155.65 / 0.86675},
+        // an unset currency is the default
+        {"", "GBP", 0.86675 / 1.0956},
+    }
+    for _, tt := range tests {
+        rate, err := rates.Rate(ctx, tt.from, tt.to)
+        if err != nil {
+            t.Fatalf("%s to %s: %v", tt.from, tt.to, err)
+        }
+        if diff := rate - tt.want; diff > 1e-9 || diff < -1e-9 {
+            t.Errorf("%s to %s = %v, want %v", tt.from, tt.to, rate, tt.want)
+        }
+    }
+    if _, err := rates.Rate(ctx, "EUR", "XXX"); !errors.Is(err, ErrUnknownCurrency) {
+        t.Errorf("unparseable rate = %v, want ErrUnknownCurrency", err)
+    }
+    if _, err := rates.Rate(ctx, "CHF", "EUR"); !errors.Is(err, ErrUnknownCurrency) {
+        t.Errorf("unknown currency = %v, want ErrUnknownCurrency", err)
+    }
+    // the rates were fetched once and cached
+    if n := hits.Load(); n != 1 {
+        t.Errorf("rates fetched %d times, want once", n)
+    }
+    if _, err := rates.Rate(ctx, "JPY", "JPY"); err != nil {
+        t.Error(err)
+    }
+
+    // once the ttl is up they are fetched again
+    rates.mu.Lock()
+    rates.fetchedAt = time.Now().Add(-2 * time.Hour)
+    rates.mu.Unlock()
+    if _, err := rates.Rate(ctx, "EUR", "USD"); err != nil {
+        t.Fatal(err)
+    }
+    if n := hits.Load(); n != 2 {
+        t.Errorf("rates fetched %d times after the ttl, want twice", n)
+    }
+}
+
+func TestExchangeRatesStale(t *testing.T) {
+    var down atomic.Bool
+    server, hits := newRatesServer(t, ecbRatesXML, &down)
+    rates := NewExchangeRates("", time.Hour)
+    rates.endpoint = server.URL
+    ctx := context.Background()
+
+    // without any rates a failed load is an error
+    down.Store(true)
+    if _, err := rates.Rate(ctx, "EUR", "USD"); !errors.Is(err, ErrRatesUnavailable) {
+        t.Fatalf("no rates = %v, want ErrRatesUnavailable", err)
+    }
+
+    down.Store(false)
+    if _, err := rates.Rate(ctx, "EUR", "USD"); err != nil {
+        t.Fatal(err)
+    }
+
+    // a failed refresh keeps the old rates, and waits before trying again
+    down.Store(true)
+    rates.mu.Lock()
+    rates.fetchedAt = time.Now().Add(-2 * time.Hour)
+    rates.mu.Unlock()
+    before := hits.Load()
+    for i := 0; i < 3; i++ {
+        if rate, err := rates.Rate(ctx, "EUR", "USD"); err != nil || rate != 1.0956 {
+            t.Fatalf("stale rate = %v, %v", rate, err)
+        }
+    }
+    if n := hits.Load() - before; n != 1 {
+        t.Errorf("%d refreshes while the source was down, want 1", n)
+    }
+}
+
+func TestExchangeRatesOpenExchangeRates(t *testing.T) {
+    server, _ := newRatesServer(t, `{"base": "USD", "rates": {"EUR": 0.9127, "GBP": 0.7911}}`, nil)
+    rates := NewExchangeRates("test-app", time.Hour)
+    rates.endpoint = server.URL
+
+    rate, err := rates.Rate(context.Background(), "EUR", "GBP")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if diff := rate - 0.7911/0.9127; diff > 1e-9 || diff < -1e-9 {
+        t.Errorf("EUR to GBP = %v", rate)
+    }
+
+    rates = NewExchangeRates("wrong-app", time.Hour)
+    rates.endpoint = server.URL
+    if _, err := rates.Rate(context.Background(), "EUR", "GBP"); !errors.Is(err, ErrRatesUnavailable) {
+        t.Errorf("rejected app ID = %v, want ErrRatesUnavailable", err)
+    }
+}
+
+func TestHistoryInCurrency(t *testing.T) {
+    var down atomic.Bool
+    ratesServer, _ := newRatesServer(t, ecbRatesXML, &down)
+    tracker := newTestTracker(t, nil)
+    addTestProduct(t, tracker, "converted", 100, 50)
+    server := NewAPIServer(tracker)
+    rates := NewExchangeRates("", time.Hour)
+    rates.endpoint = ratesServer.URL
+    server.SetExchangeRates(rates)
+
+    // down before the first load: no rates to convert with
+    down.Store(true)
+    if rec := serve(t, server, "GET", "/api/v1/products/converted/history?currency=EUR", "", nil); rec.Code != http.StatusBadGateway {
+        t.Errorf("rates unavailable = %d, want 502", rec.Code)
+    }
+    down.Store(false)
+
+    var history struct {
+        History []PriceEntry `json:"history"`
+    }
+    serve(t, server, "GET", "/api/v1/products/converted/history?currency=gbp", "", &history)
+    if len(history.History) != 2 {
+        t.Fatalf("history = %+v", history.History)
+    }
+    want := map[float64]bool{convertPrice(100, 0.86675/1.0956): true, convertPrice(50, 0.86675/1.0956): true}
+    for _, entry := range history.History {
+        if entry.Currency != "GBP" || !want[entry.Price] {
+            t.Errorf("entry = %v %s, want 79.11 or 39.56 GBP", entry.Price, entry.Currency)
+        }
+    }
+
+    for query, want := range map[string]int{"currency=CHF": http.StatusBadRequest, "currency=euro": http.StatusBadRequest} {
+        if rec := serve(t, server, "GET", "/api/v1/products/converted/history?"+query, "", nil); rec.Code != want {
+            t.Errorf("%s = %d, want %d", query, rec.Code, want)
+        }
+    }
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestExportHistoryCSV(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    addTestProduct(t, server.tracker, "export-laptop", 1299.99, 1249.5, 1200)
+    addTestProduct(t, server.tracker, "export-other", 10)
+
+    rec := serve(t, server, "GET", "/api/v1/products/export-laptop/history.csv", "", nil)
+    if rec.Code != http.StatusOK {
+        t.Fatalf("export = %d: %s", rec.Code, rec.Body)
+    }
+    if got := rec.Header().Get("Content-Type"); got != "text/csv; charset=utf-8" {
+        t.Errorf("content type = %q", got)
+    }
+    if got := rec.Header().Get("Content-Disposition"); got != `attachment; filename="export-laptop-history.csv"` {
+        t.Errorf("content disposition = %q", got)
+    }
+
+    rows, err := csv.NewReader(rec.Body).ReadAll()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(rows) != 4 {
+        t.Fatalf("rows = %v, want a header and 3 entries", rows)
+    }
+    if header := rows[0]; len(header) != 5 || header[0] != "id" || header[1] != "product_id" || header[2] != "price" || header[3] != "currency" || header[4] != "timestamp" {
+        t.Errorf("header = %v", header)
+    }
+    var previous time.Time
+    for i, want := range []string{"1299.99", "1249.5", "1200"} {
+        row := rows[i+1]
+        if _, err := strconv.Atoi(row[0]); err != nil || row[1] != "export-laptop" || row[2] != want || row[3] != DefaultCurrency {
+            t.Errorf("row %d = %v, want price %s", i, row, want)
+        }
+        // oldest first, in RFC 3339 UTC
+        stamp, err := time.Parse(time.RFC3339, row[4])
+        if err != nil || stamp.Location() != time.UTC || !stamp.After(previous) {
+            t.Errorf("row %d timestamp = %q", i, row[4])
+        }
+        previous = stamp
+    }
+
+    if rec := serve(t, server, "GET", "/api/v1/products/no-such-product/history.csv", "", nil); rec.Code != http.StatusNotFound {
+        t.Errorf("unknown product = %d, want 404", rec.Code)
+    }
+}
+
+func TestExportHistoryCSVPages(t *testing.T) {
+    // more entries than one page, so the export reads several
+    prices := make([]float64, 2*exportPageSize+1)
+    for i := range prices {
+        prices[i] = float64(i + 1)
+    }
+    server := NewAPIServer(newTestTracker(t, nil))
+    addTestProduct(t, server.tracker, "export-long", prices...)
+
+    rows, _ := exportCSV(t, server, "/api/v1/products/export-long/history.csv")
+    if len(rows) != len(prices) {
+        t.Fatalf("exported %d rows, want %d", len(rows), len(prices))
+    }
+    for i, row := range rows {
+        if row[2] != strconv.Itoa(i+1) {
+            t.Fatalf("row %d = %v, want price %d", i, row, i+1)
+        }
+    }
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxAPIResponseBytes caps how much of a retailer API response is read
+const maxAPIResponseBytes = 1 << 20
+
+// apiClient is shared by the retailer API fetchers. Unlike page fetches
+// their requests don't go through the proxy pool.
+var apiClient = &http.Client{Timeout: 15 * time.Second}
+
+// getAPIJSON GETs a retailer API endpoint and decodes its JSON answer into
+// v. Throttling and server errors are retryable.
+func getAPIJSON(ctx context.Context, endpoint string, header http.Header, v interface{}) error {
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+    if err != nil {
+        return err
+    }
+    for name, values := range header {
+        req.Header[name] = values
+    }
+    req.Header.Set("Accept", "application/json")
+
+    resp, err := apiClient.Do(req)
+    if err != nil {
+        return retryableError{err}
+    }
+    defer resp.Body.Close()
+
+    body, err := io.ReadAll(io.LimitReader(resp.Body, maxAPIResponseBytes))
+    if err != nil {
+        return retryableError{err}
+    }
+    if resp.StatusCode != http.StatusOK {
+        err := statusError{code: resp.StatusCode, err: fmt.Errorf("API request failed: %s", resp.Status)}
+        if isRetryableStatus(resp.StatusCode) {
+            return retryableError{err}
+        }
+        return err
+    }
+    if err := json.Unmarshal(body, v); err != nil {
+        return fmt.Errorf("decoding API response: %w", err)
+    }
+    return nil
+}
+
+// PriceFetcher gets a product's current price. Implementations must give up
+// when ctx is done and may wrap transient failures in retryableError so they
+// are retried.
+type PriceFetcher interface {
+    FetchPrice(ctx context.Context, product Product) (float64, error)
+}
+
+// PriceFetcherFunc adapts an ordinary function to PriceFetcher
+type PriceFetcherFunc func(ctx context.Context, product Product) (float64, error)
+
+func (f PriceFetcherFunc) FetchPrice(ctx context.Context, product Product) (float64, error) {
+    return f(ctx, product)
+}
+
+// PriceReading is what a fetch read from a product page: the price and,
+// when the page says, whether the product is in stock, the list price it
+// was discounted from and, for marketplaces, the kind of listing
+type PriceReading struct {
+    Price       float64
+    InStock     *bool
+    ListPrice   *float64
+    ListingType string
+    Shipping    *float64
+    // Title is the product name the page showed, empty when the fetcher
+    // doesn't read pages or the page had none
+    Title string
+    // Fingerprint is the page region's fingerprint when the product sets a
+    // fingerprint selector; see pageFingerprint
+    Fingerprint string
+    // NotModified is set when the page answered 304 Not Modified and the
+    // reading is the one last extracted from it
+    NotModified bool
+}
+
+// ReadingFetcher is a PriceFetcher that can also report stock availability.
+// Fetchers that only implement PriceFetcher leave availability unknown.
+type ReadingFetcher interface {
+    PriceFetcher
+    FetchReading(ctx context.Context, product Product) (PriceReading, error)
+}
+
+// DefaultFetcher scrapes product pages, except that products on the
+// reserved example domains (example.com and friends, which never host real
+// shops) with no price rule or render_js get simulated prices, so the sample
+// products still produce data. Products a configured retailer API covers
+// are fetched from the API instead.
+type DefaultFetcher struct {
+    // Amazon, when set, prices Amazon product URLs
+    Amazon *AmazonFetcher
+    // EBay, when set, prices eBay item URLs
+    EBay *EBayFetcher
+    // BestBuy and Walmart, when set, price those retailers' product URLs
+    BestBuy *BestBuyFetcher
+    Walmart *WalmartFetcher
+    // GoogleShopping, when set, prices Google Shopping search URLs at the
+    // lowest offer
+    GoogleShopping *GoogleShoppingFetcher
+    // Shopify, when set, reads Shopify-style product URLs without a price
+    // rule from the store's product JSON
+    Shopify *ShopifyFetcher
+}
+
+func (f DefaultFetcher) FetchPrice(ctx context.Context, product Product) (float64, error) {
+    reading, err := f.FetchReading(ctx, product)
+    return reading.Price, err
+}
+
+func (f DefaultFetcher) FetchReading(ctx context.Context, product Product) (PriceReading, error) {
+    for _, api := range []retailerAPI{f.Amazon, f.EBay, f.BestBuy, f.Walmart, f.GoogleShopping} {
+        if api.handles(product) {
+            return api.FetchReading(ctx, product)
+        }
+    }
+    if !product.hasPriceRule() && !product.RenderJS && isExampleURL(product.URL) {
+        price, err := SimulatedFetcher{}.FetchPrice(ctx, product)
+        return PriceReading{Price: price}, err
+    }
+    if f.Shopify != nil && f.Shopify.handles(product) {
+        return f.Shopify.FetchReading(ctx, product)
+    }
+    return ScrapingFetcher{}.FetchReading(ctx, product)
+}
+
+// retailerAPI is a fetcher for one retailer's product URLs. handles must be
+// safe to call on a nil fetcher, which handles nothing.
+type retailerAPI interface {
+    ReadingFetcher
+    handles(product Product) bool
+}
+
+// isExampleURL reports whether a URL is on an RFC 2606 example domain
+func isExampleURL(rawURL string) bool {
+    parsed, err := url.Parse(rawURL)
+    if err != nil {
+        return false
+    }
+    host := strings.ToLower(parsed.Hostname())
+    for _, domain := range []string{"example.com", "example.net", "example.org"} {
+        if host == domain || strings.HasSuffix(host, "."+domain) {
+            return true
+        }
+    }
+    return false
+}
+
+// ScrapingFetcher reads the price from the product page, using the
+// product's price selector, XPath or regex or else the page's structured
+// data, and the stock availability and list price from the structured data
+// unless the product has a list price selector
+type ScrapingFetcher struct{}
+
+func (f ScrapingFetcher) FetchPrice(ctx context.Context, product Product) (float64, error) {
+    reading, err := f.FetchReading(ctx, product)
+    return reading.Price, err
+}
+
+func (ScrapingFetcher) FetchReading(ctx context.Context, product Product) (PriceReading, error) {
+    return scrapeReading(ctx, product)
+}
+
+// SimulatedFetcher makes up prices, for demos and local development
+type SimulatedFetcher struct{}
+
+// FetchPrice returns a random price around a base price for the product
+// after a short random delay
+func (SimulatedFetcher) FetchPrice(ctx context.Context, product Product) (float64, error) {
+    // simulate network delay
+    delay := time.NewTimer(time.Duration(rand.Intn(1000)) * time.Millisecond)
+    defer delay.Stop()
+    select {
+    case <-delay.C:
+    case <-ctx.Done():
+        return 0, ctx.Err()
+    }
+
+    basePrice := 100.0
+    switch product.ID {
+    case "laptop-1":
+        basePrice = 1200.0
+    case "phone-1":
+        basePrice = 800.0
+    case "tablet-1":
+        basePrice = 500.0
+    }
+
+    // add some random variation (±10%)
+    variation := (rand.Float64() - 0.5) * 0.2
+    price := basePrice * (1 + variation)
+
+    return price, nil
+}
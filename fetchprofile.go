@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// defaultAccept is sent unless a fetch profile overrides it
+const defaultAccept = "text/html,application/xhtml+xml,application/json;q=0.9,*/*;q=0.8"
+
+// validate checks a fetch profile before it is stored
+func (f *FetchProfile) validate() error {
+    switch strings.ToUpper(f.Method) {
+    case "", http.MethodGet:
+        if f.Body != "" {
+            return errors.New("body needs method POST")
+        }
+    case http.MethodPost:
+    default:
+        return fmt.Errorf("unsupported method %q: must be GET or POST", f.Method)
+    }
+    for name, value := range f.Headers {
+        if !validHeaderField(name) || strings.ContainsAny(value, "\r\n") {
+            return fmt.Errorf("invalid header %q", name)
+        }
+    }
+    for name, value := range f.Cookies {
+        if !validHeaderField(name) || strings.ContainsAny(name, "=;,") || strings.ContainsAny(value, "\r\n;") {
+            return fmt.Errorf("invalid cookie %q", name)
+        }
+    }
+    if strings.ContainsAny(f.UserAgent+f.AcceptLanguage, "\r\n") {
+        return errors.New("user_agent and accept_language must be a single line")
+    }
+    return nil
+}
+
+// validHeaderField reports whether name can be used as a header or cookie
+// name: non-empty, with no spaces, colons or control characters
+func validHeaderField(name string) bool {
+    if name == "" {
+        return false
+    }
+    for _, c := range name {
+        if c <= ' ' || c == ':' || c >= 0x7f {
+            return false
+        }
+    }
+    return true
+}
+
+// newRequest builds the request for a product page. A nil profile gives a
+// plain GET with the default headers.
+func (f *FetchProfile) newRequest(ctx context.Context, pageURL string) (*http.Request, error) {
+    if f == nil {
+        f = &FetchProfile{}
+    }
+
+    method := strings.ToUpper(f.Method)
+    if method == "" {
+        method = http.MethodGet
+    }
+    var body io.Reader
+    if f.Body != "" {
+        body = strings.NewReader(f.Body)
+    }
+    req, err := http.NewRequestWithContext(ctx, method, pageURL, body)
+    if err != nil {
+        return nil, err
+    }
+
+    req.Header.Set("User-Agent", userAgents.pick(req.URL.Hostname()))
+    req.Header.Set("Accept", defaultAccept)
+    if body != nil {
+        req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    }
+    // explicit headers override the defaults, and the dedicated fields
+    // override the headers
+    for name, value := range f.Headers {
+        req.Header.Set(name, value)
+    }
+    if f.UserAgent != "" {
+        req.Header.Set("User-Agent", f.UserAgent)
+    }
+    if f.AcceptLanguage != "" {
+        req.Header.Set("Accept-Language", f.AcceptLanguage)
+    }
+    for name, value := range f.Cookies {
+        req.AddCookie(&http.Cookie{Name: name, Value: value})
+    }
+    return req, nil
+}
+
+// userAgent returns the User-Agent a profile asks for, or else the one from
+// the pool for host
+func (f *FetchProfile) userAgent(host string) string {
+    if f == nil || f.UserAgent == "" {
+        return userAgents.pick(host)
+    }
+    return f.UserAgent
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPostFetchProfile(t *testing.T) {
+    type sent struct {
+        method, body, contentType, requestedWith, userAgent, language, session, currency string
+    }
+    requests := make(chan sent, 1)
+    api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/robots.txt" {
+            http.NotFound(w, r)
+            return
+        }
+        body, _ := io.ReadAll(r.Body)
+        var session, currency string
+        if cookie, err := r.Cookie("session"); err == nil {
+            session = cookie.Value
+        }
+        if cookie, err := r.Cookie("currency"); err == nil {
+            currency = cookie.Value
+        }
+        requests <- sent{r.Method, string(body), r.Header.Get("Content-Type"), r.Header.Get("X-Requested-With"),
+            r.Header.Get("User-Agent"), r.Header.Get("Accept-Language"), session, currency}
+        if r.Method != http.MethodPost {
+            w.WriteHeader(http.StatusMethodNotAllowed)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        w.Write([]byte(`{"data": {"product": {"sku": "kettle-1", "price": 54.95}}}`))
+    }))
+    t.Cleanup(api.Close)
+
+    tracker := newTestTracker(t, nil)
+    tracker.SetRetryPolicy(1, 0)
+    server := NewAPIServer(tracker)
+    body := `{"id": "profile-post", "name": "Kettle", "url": "` + api.URL + `/graphql",
+        "price_regex": "\"price\":\\s*([0-9.]+)",
+        "fetch_profile": {
+            "method": "post",
+            "body": "{\"query\": \"{ product(sku: \\\"kettle-1\\\") { price } }\"}",
+            "user_agent": "PriceBot/1.0",
+            "accept_language": "en-GB",
+            "headers": {"Content-Type": "application/json", "X-Requested-With": "XMLHttpRequest"},
+            "cookies": {"session": "abc123", "currency": "USD"}
+        }}`
+    if rec := serve(t, server, "POST", "/api/v1/products", body, nil); rec.Code != http.StatusCreated {
+        t.Fatalf("create = %d: %s", rec.Code, rec.Body)
+    }
+
+    tracker.trackProducts(context.Background(), tracker.snapshotProducts(), nil)
+    got := <-requests
+    want := sent{http.MethodPost, `{"query": "{ product(sku: \"kettle-1\") { price } }"}`, "application/json", "XMLHttpRequest", "PriceBot/1.0", "en-GB", "abc123", "USD"}
+    if got != want {
+        t.Errorf("request = %+v\nwant %+v", got, want)
+    }
+    if history, err := tracker.GetPriceHistory("profile-post", 10, true); err != nil || len(history) != 1 || history[0].Price != 54.95 {
+        t.Errorf("history = %v, %v; want the price from the POST response", history, err)
+    }
+}
+
+func TestFetchProfileValidation(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+
+    for name, profile := range map[string]string{
+        "unsupported method": `{"method": "PUT"}`,
+        "body on GET":        `{"method": "GET", "body": "q=kettle"}`,
+        "body, no method":    `{"body": "q=kettle"}`,
+        "bad header":         `{"headers": {"X Bad": "1"}}`,
+        "header injection":   `{"headers": {"X-Ok": "1\r\nX-Evil: 1"}}`,
+        "bad cookie":         `{"cookies": {"a=b": "1"}}`,
+    } {
+        body := `{"id": "profile-invalid", "name": "Kettle", "url": "https://shop.test/kettle", "fetch_profile": ` + profile + `}`
+        if rec := serve(t, server, "POST", "/api/v1/products", body, nil); rec.Code != http.StatusBadRequest {
+            t.Errorf("%s = %d, want 400: %s", name, rec.Code, rec.Body)
+        }
+    }
+    if _, err := server.tracker.GetProduct("profile-invalid"); err == nil {
+        t.Error("an invalid profile was stored")
+    }
+
+    // GET is the default, and a POST may have a body
+    for _, profile := range []*FetchProfile{{}, {Method: "get"}, {Method: "POST", Body: "q=kettle"}, {Method: "post"}} {
+        if err := profile.validate(); err != nil {
+            t.Errorf("%+v: %v", *profile, err)
+        }
+    }
+}
+
+func TestFetchProfileRequest(t *testing.T) {
+    ctx := context.Background()
+
+    // without a profile: a plain GET with the default headers
+    var none *FetchProfile
+    req, err := none.newRequest(ctx, "https://shop.test/kettle")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if req.Method != http.MethodGet || req.Header.Get("Accept") != defaultAccept || req.Header.Get("User-Agent") == "" || req.Header.Get("Accept-Language") != "" || len(req.Cookies()) != 0 {
+        t.Errorf("default request = %s %v", req.Method, req.Header)
+    }
+
+    // headers override the defaults, and the dedicated fields the headers
+    profile := &FetchProfile{
+        UserAgent:      "PriceBot/1.0",
+        AcceptLanguage: "de-DE",
+        Headers:        map[string]string{"Accept": "application/json", "User-Agent": "Ignored/1.0", "X-Store": "berlin"},
+        Cookies:        map[string]string{"region": "de", "consent": "yes"},
+    }
+    req, err = profile.newRequest(ctx, "https://shop.test/kettle")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if req.Method != http.MethodGet || req.Body != nil {
+        t.Errorf("request = %s with a body, want a GET", req.Method)
+    }
+    for name, want := range map[string]string{"User-Agent": "PriceBot/1.0", "Accept-Language": "de-DE", "Accept": "application/json", "X-Store": "berlin"} {
+        if got := req.Header.Get(name); got != want {
+            t.Errorf("%s = %q, want %q", name, got, want)
+        }
+    }
+    cookies := map[string]string{}
+    for _, cookie := range req.Cookies() {
+        cookies[cookie.Name] = cookie.Value
+    }
+    if len(cookies) != 2 || cookies["region"] != "de" || cookies["consent"] != "yes" {
+        t.Errorf("cookies = %v", cookies)
+    }
+
+    // a POST body is form encoded unless a header says otherwise
+    req, _ = (&FetchProfile{Method: "post", Body: "sku=kettle-1"}).newRequest(ctx, "https://shop.test/price")
+    if req.Method != http.MethodPost || req.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+        t.Errorf("POST request = %s %v", req.Method, req.Header)
+    }
+}
+
+func TestFetchProfileStored(t *testing.T) {
+    cookies := make(chan string, 2)
+    page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/robots.txt" {
+            http.NotFound(w, r)
+            return
+        }
+        session, _ := r.Cookie("session")
+        if session == nil {
+            cookies <- ""
+            w.WriteHeader(http.StatusForbidden)
+            return
+        }
+        cookies <- session.Value
+        w.Header().Set("Content-Type", "text/html; charset=utf-8")
+        w.Write([]byte(productPage))
+    }))
+    t.Cleanup(page.Close)
+
+    tracker := newTestTracker(t, nil)
+    server := NewAPIServer(tracker)
+    body := `{"id": "profile-stored", "name": "Laptop", "url": "` + page.URL + `/laptop", "price_selector": ".price",
+        "fetch_profile": {"user_agent": "PriceBot/1.0", "headers": {"X-Store": "berlin"}, "cookies": {"session": "abc123"}}}`
+    if rec := serve(t, server, "POST", "/api/v1/products", body, nil); rec.Code != http.StatusCreated {
+        t.Fatalf("create = %d: %s", rec.Code, rec.Body)
+    }
+
+    // a tracker started on the same database loads the profile and uses it
+    restarted := NewPriceTracker(tracker.db, nil)
+    restarted.SetRetryPolicy(1, 0)
+    product, err := restarted.GetProduct("profile-stored")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if p := product.FetchProfile; p == nil || p.UserAgent != "PriceBot/1.0" || p.Headers["X-Store"] != "berlin" || p.Cookies["session"] != "abc123" {
+        t.Fatalf("stored profile = %+v", p)
+    }
+    restarted.trackProducts(context.Background(), restarted.snapshotProducts(), nil)
+    if got := <-cookies; got != "abc123" {
+        t.Errorf("session cookie = %q, want the stored one", got)
+    }
+    if history, _ := restarted.GetPriceHistory("profile-stored", 10, true); len(history) != 1 || history[0].Price != 1299.99 {
+        t.Errorf("history = %v", history)
+    }
+}
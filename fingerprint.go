@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// pageChangeBits is how many of a fingerprint's 64 bits must differ from the
+// last fetch's before the page counts as changed. Small edits to a large
+// region, such as one more list item, stay under it.
+const pageChangeBits = 8
+
+// pageFingerprint fingerprints the markup of the first element matching
+// selector: the tags, IDs and classes of it and everything inside it, but
+// not their text, so a new price or stock message leaves it alone while a
+// redesign changes it. It is a simhash, so regions that differ a little get
+// fingerprints that differ in a few bits. It is empty when nothing matches.
+func pageFingerprint(root *goquery.Document, selector cssSelector) string {
+    region := root.FindMatcher(selector).First()
+    if region.Length() == 0 {
+        return ""
+    }
+
+    var votes [64]int
+    var walk func(node *html.Node, path string)
+    walk = func(node *html.Node, path string) {
+        if node.Type != html.ElementNode {
+            return
+        }
+        path += "/" + elementToken(node)
+        hash := fnv.New64a()
+        hash.Write([]byte(path))
+        sum := hash.Sum64()
+        for bit := range votes {
+            if sum&(1<<bit) != 0 {
+                votes[bit]++
+            } else {
+                votes[bit]--
+            }
+        }
+        for child := node.FirstChild; child != nil; child = child.NextSibling {
+            walk(child, path)
+        }
+    }
+    walk(region.Get(0), "")
+
+    var fingerprint uint64
+    for bit, vote := range votes {
+        if vote > 0 {
+            fingerprint |= 1 << bit
+        }
+    }
+    return fmt.Sprintf("%016x", fingerprint)
+}
+
+// elementToken describes an element by its tag, ID and classes, e.g.
+// span#price.amount.sale
+func elementToken(node *html.Node) string {
+    token := node.Data
+    var classes []string
+    for _, attr := range node.Attr {
+        switch attr.Key {
+        case "id":
+            token += "#" + attr.Val
+        case "class":
+            classes = strings.Fields(attr.Val)
+        }
+    }
+    sort.Strings(classes)
+    for _, class := range classes {
+        token += "." + class
+    }
+    return token
+}
+
+// fingerprintDistance is how many bits two fingerprints differ in
+func fingerprintDistance(a, b string) int {
+    x, errA := strconv.ParseUint(a, 16, 64)
+    y, errB := strconv.ParseUint(b, 16, 64)
+    if errA != nil || errB != nil {
+        return 64
+    }
+    return bits.OnesCount64(x ^ y)
+}
+
+// checkFingerprint stores the fingerprint a fetch took of a product page's
+// region and compares it with the previous fetch's. When they differ by more
+// than pageChangeBits while the price still read fine, it flags the product
+// and sends a page change alert. A new URL or fingerprint selector starts
+// over with no previous fingerprint.
+func (pt *PriceTracker) checkFingerprint(product Product, fingerprint string) {
+    if fingerprint == "" || product.FingerprintSelector == "" {
+        return
+    }
+
+    previous, found, err := pt.db.GetPageFingerprint(product.ID)
+    if err != nil {
+        log.Printf("Failed to load page fingerprint for %s: %v", product.ID, err)
+        return
+    }
+    current := PageFingerprint{
+        ProductID:   product.ID,
+        URL:         product.URL,
+        Selector:    product.FingerprintSelector,
+        Fingerprint: fingerprint,
+        CheckedAt:   time.Now().UTC(),
+    }
+    comparable := found && previous.URL == product.URL && previous.Selector == product.FingerprintSelector
+    if comparable {
+        current.Previous = previous.Previous
+        current.ChangedAt = previous.ChangedAt
+        current.Distance = fingerprintDistance(previous.Fingerprint, fingerprint)
+    }
+    changed := comparable && current.Distance > pageChangeBits
+    if changed {
+        current.Previous = previous.Fingerprint
+        current.ChangedAt = &current.CheckedAt
+    }
+    if err := pt.db.SavePageFingerprint(current); err != nil {
+        log.Printf("Failed to save page fingerprint for %s: %v", product.ID, err)
+        return
+    }
+    if !changed {
+        return
+    }
+
+    log.Printf("%s's page changed: %q markup differs in %d of 64 fingerprint bits; its price rule may soon break", product.ID, product.FingerprintSelector, current.Distance)
+    pt.statusMu.Lock()
+    webhookURL := pt.webhookURL
+    pt.statusMu.Unlock()
+    if webhookURL == "" {
+        return
+    }
+    alert := PageChangeAlert{
+        Type:                "page_changed",
+        ProductID:           product.ID,
+        Name:                product.Name,
+        URL:                 product.URL,
+        Selector:            product.FingerprintSelector,
+        PreviousFingerprint: previous.Fingerprint,
+        Fingerprint:         fingerprint,
+        Distance:            current.Distance,
+    }
+    pt.notify(webhookURL, alert.Type, product.ID, "page_changed:"+product.ID+":"+fingerprint, alert)
+}
+
+// PageChanges lists the products whose fingerprinted page region changed
+// markup, most recent change first
+func (pt *PriceTracker) PageChanges() ([]PageFingerprint, error) {
+    return pt.db.GetPageChanges()
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// buyBox is a product page region with the price and n offers
+func buyBox(price string, offers int) string {
+    var page strings.Builder
+    page.WriteString(`<html><body><div id="buy-box"><span class="price">` + price + `</span><ul class="offers">`)
+    for i := 0; i < offers; i++ {
+        fmt.Fprintf(&page, `<li class="offer item"><b>Offer %d</b></li>`, i)
+    }
+    page.WriteString(`</ul></div></body></html>`)
+    return page.String()
+}
+
+func fingerprintOf(t *testing.T, page string) string {
+    t.Helper()
+    selector, err := parseSelector("#buy-box")
+    if err != nil {
+        t.Fatal(err)
+    }
+    return pageFingerprint(parseHTML(page), selector)
+}
+
+func TestPageFingerprint(t *testing.T) {
+    base := fingerprintOf(t, buyBox("$19.99", 20))
+    if len(base) != 16 {
+        t.Fatalf("fingerprint = %q, want 16 hex digits", base)
+    }
+
+    // text doesn't count, so a new price leaves the fingerprint alone
+    if got := fingerprintOf(t, buyBox("$24.99", 20)); got != base {
+        t.Errorf("price change: fingerprint %s, want %s", got, base)
+    }
+    // classes in another order are the same markup
+    reordered := strings.ReplaceAll(buyBox("$19.99", 20), `class="offer item"`, `class="item  offer"`)
+    if got := fingerprintOf(t, reordered); got != base {
+        t.Errorf("same markup: fingerprint %s, want %s", got, base)
+    }
+
+    // one more offer is a small change
+    if distance := fingerprintDistance(base, fingerprintOf(t, buyBox("$19.99", 21))); distance > pageChangeBits {
+        t.Errorf("one more offer changed %d bits, want at most %d", distance, pageChangeBits)
+    }
+
+    // a redesign is a large one
+    redesigned := `<html><body><section id="buy-box" class="pdp"><div class="pdp-price"><em>$19.99</em></div><button class="add">Add</button></section></body></html>`
+    if distance := fingerprintDistance(base, fingerprintOf(t, redesigned)); distance <= pageChangeBits {
+        t.Errorf("redesign changed %d bits, want more than %d", distance, pageChangeBits)
+    }
+
+    if got := fingerprintOf(t, `<html><body><div id="other"></div></body></html>`); got != "" {
+        t.Errorf("fingerprint without a match = %q, want none", got)
+    }
+}
+
+func TestFingerprintDistance(t *testing.T) {
+    tests := []struct {
+        a, b string
+        want int
+    }{
+        {"00000000000000ff", "00000000000000ff", 0},
+        {"0000000000000000", "000000000000000f", 4},
+        {"ffffffffffffffff", "0000000000000000", 64},
+        {"", "0000000000000000", 64},
+    }
+    for _, tt := range tests {
+        if got := fingerprintDistance(tt.a, tt.b); got != tt.want {
+            t.Errorf("fingerprintDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+        }
+    }
+}
+
+func TestPageChangeDetected(t *testing.T) {
+    var mu sync.Mutex
+    page := buyBox("$19.99", 3)
+    pages := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/robots.txt" {
+            http.NotFound(w, r)
+            return
+        }
+        mu.Lock()
+        defer mu.Unlock()
+        w.Write([]byte(page))
+    }))
+    t.Cleanup(pages.Close)
+    setPage := func(p string) {
+        mu.Lock()
+        page = p
+        mu.Unlock()
+    }
+
+    server := NewAPIServer(newTestTracker(t, nil))
+    tracker := server.tracker
+    webhookURL, received := newWebhookRecorder(t)
+    tracker.SetWebhookURL(webhookURL)
+    product, err := tracker.CreateProduct(Product{ID: "fp-kettle", Name: "Kettle", URL: pages.URL + "/kettle", PriceSelector: ".price", FingerprintSelector: "#buy-box"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    check := func() {
+        t.Helper()
+        if _, err := tracker.CheckProduct(context.Background(), product.ID); err != nil {
+            t.Fatalf("check: %v", err)
+        }
+    }
+
+    // the first fetch is the baseline and a new price isn't a change
+    check()
+    setPage(buyBox("$17.49", 3))
+    check()
+    first, found, err := tracker.db.GetPageFingerprint(product.ID)
+    if err != nil || !found {
+        t.Fatalf("fingerprint = %+v, %v, %v; want one stored", first, found, err)
+    }
+    if first.Distance != 0 || first.ChangedAt != nil {
+        t.Errorf("fingerprint = %+v, want it unchanged", first)
+    }
+
+    // the price still reads, but the region around it was redesigned
+    setPage(`<html><body><section id="buy-box" class="pdp"><div class="pdp-price"><span class="price">$17.49</span></div><button class="add">Add</button></section></body></html>`)
+    check()
+    payloads := waitForPayloads(t, received, 1)
+    if len(payloads) != 1 {
+        t.Fatalf("got %d alerts, want 1", len(payloads))
+    }
+    alert := payloads[0]
+    if alert["type"] != "page_changed" || alert["product_id"] != "fp-kettle" || alert["previous_fingerprint"] != first.Fingerprint || alert["selector"] != "#buy-box" {
+        t.Errorf("alert = %v", alert)
+    }
+
+    var changes struct {
+        Products []PageFingerprint `json:"products"`
+        Count    int               `json:"count"`
+    }
+    serve(t, server, "GET", "/api/v1/page-changes", "", &changes)
+    if changes.Count != 1 || changes.Products[0].ProductID != "fp-kettle" || changes.Products[0].Name != "Kettle" || changes.Products[0].Previous != first.Fingerprint || changes.Products[0].ChangedAt == nil {
+        t.Errorf("page changes = %+v", changes)
+    }
+
+    // the new markup is the baseline now
+    check()
+    time.Sleep(50 * time.Millisecond)
+    if payloads := received(); len(payloads) != 1 {
+        t.Errorf("got %d alerts, want no second one for unchanged markup", len(payloads))
+    }
+
+    // a new selector starts over without an alert
+    product.FingerprintSelector = ".pdp-price"
+    if _, err := tracker.UpdateProduct(product); err != nil {
+        t.Fatal(err)
+    }
+    check()
+    time.Sleep(50 * time.Millisecond)
+    if payloads := received(); len(payloads) != 1 {
+        t.Errorf("got %d alerts, want none for a new selector", len(payloads))
+    }
+}
+
+func TestFingerprintSelectorValidated(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    rec := serve(t, server, "POST", "/api/v1/products", `{"id": "fp-bad", "name": "Bad", "url": "https://shop.test/bad", "fingerprint_selector": "div["}`, nil)
+    if rec.Code != http.StatusBadRequest {
+        t.Errorf("invalid fingerprint selector = %d, want 400", rec.Code)
+    }
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const googleShoppingAPIBase = "https://serpapi.com/search.json"
+
+// errNotGoogleShopping is returned for products whose URL isn't a Google
+// Shopping search
+var errNotGoogleShopping = errors.New("not a Google Shopping search URL")
+
+// GoogleShoppingFetcher prices products whose URL is a Google Shopping
+// search at the lowest offer a SerpAPI-compatible results API returns for
+// it, for products sold by many merchants with no one page to track
+type GoogleShoppingFetcher struct {
+    APIKey string
+
+    // Endpoint is the search API URL; empty uses SerpAPI
+    Endpoint string
+}
+
+// googleShoppingSearch returns the query parameters of a Google Shopping
+// search URL: google.<tld>/search with tbm=shop or udm=28, or
+// shopping.google.com
+func googleShoppingSearch(rawURL string) (url.Values, bool) {
+    parsed, err := url.Parse(rawURL)
+    if err != nil {
+        return nil, false
+    }
+    query := parsed.Query()
+    host := strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+    if host == "shopping.google.com" {
+        return query, true
+    }
+    if !strings.HasPrefix(host, "google.") || parsed.Path != "/search" {
+        return nil, false
+    }
+    if query.Get("tbm") != "shop" && query.Get("udm") != "28" {
+        return nil, false
+    }
+    return query, true
+}
+
+func (f *GoogleShoppingFetcher) handles(product Product) bool {
+    if f == nil {
+        return false
+    }
+    _, ok := googleShoppingSearch(product.URL)
+    return ok
+}
+
+func (f *GoogleShoppingFetcher) FetchPrice(ctx context.Context, product Product) (float64, error) {
+    reading, err := f.FetchReading(ctx, product)
+    return reading.Price, err
+}
+
+// FetchReading searches for the URL's q parameter, a name or a GTIN, or
+// the product's name when there is none
+func (f *GoogleShoppingFetcher) FetchReading(ctx context.Context, product Product) (PriceReading, error) {
+    search, ok := googleShoppingSearch(product.URL)
+    if !ok {
+        return PriceReading{}, fmt.Errorf("%w: %s", errNotGoogleShopping, product.URL)
+    }
+    q := strings.TrimSpace(search.Get("q"))
+    if q == "" {
+        q = product.Name
+    }
+    if q == "" {
+        return PriceReading{}, fmt.Errorf("Google Shopping search has no query and the product has no name")
+    }
+
+    query := url.Values{
+        "engine":  {"google_shopping"},
+        "q":       {q},
+        "api_key": {f.APIKey},
+    }
+    // the country and language decide which merchants and currency the
+    // results are in
+    for _, key := range []string{"gl", "hl", "location"} {
+        if v := search.Get(key); v != "" {
+            query.Set(key, v)
+        }
+    }
+
+    endpoint := googleShoppingAPIBase
+    if f.Endpoint != "" {
+        endpoint = f.Endpoint
+    }
+    var results struct {
+        Error           string `json:"error"`
+        ShoppingResults []struct {
+            ExtractedPrice    float64 `json:"extracted_price"`
+            ExtractedOldPrice float64 `json:"extracted_old_price"`
+        } `json:"shopping_results"`
+    }
+    if err := getAPIJSON(ctx, endpoint+"?"+query.Encode(), nil, &results); err != nil {
+        return PriceReading{}, fmt.Errorf("Google Shopping search %q: %w", q, err)
+    }
+
+    var reading PriceReading
+    for _, offer := range results.ShoppingResults {
+        if offer.ExtractedPrice <= 0 || (reading.Price > 0 && offer.ExtractedPrice >= reading.Price) {
+            continue
+        }
+        reading = PriceReading{Price: offer.ExtractedPrice}
+        if offer.ExtractedOldPrice > offer.ExtractedPrice {
+            listPrice := offer.ExtractedOldPrice
+            reading.ListPrice = &listPrice
+        }
+    }
+    if reading.Price == 0 {
+        if results.Error != "" {
+            return PriceReading{}, fmt.Errorf("Google Shopping search %q: %s", q, results.Error)
+        }
+        return PriceReading{}, fmt.Errorf("Google Shopping search %q returned no offers", q)
+    }
+    return reading, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestGoogleShoppingFetchReading(t *testing.T) {
+    var searched url.Values
+    api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        searched = r.URL.Query()
+        w.Header().Set("Content-Type", "application/json")
+        switch searched.Get("q") {
+        case "sony wh-1000xm5":
+            // results are in the currency of the gl country
+            w.Write([]byte(`{"shopping_results": [
+                {"title": "Sony WH-1000XM5", "price": "£299.00", "extracted_price": 299.00},
+                {"title": "Sony WH-1000XM5 (refurbished)", "price": "£0.00", "extracted_price": 0},
+                {"title": "Sony WH-1000XM5 Black", "price": "£249.00", "extracted_price": 249.00, "old_price": "£379.00", "extracted_old_price": 379.00},
+                {"title": "Sony WH-1000XM5 Silver", "price": "£259.00", "extracted_price": 259.00}
+            ]}`))
+        case "Kettle":
+            w.Write([]byte(`{"shopping_results": []}`))
+        case "quota":
+            w.Write([]byte(`{"error": "Your account has run out of searches."}`))
+        default:
+            w.WriteHeader(http.StatusTooManyRequests)
+        }
+    }))
+    t.Cleanup(api.Close)
+    f := &GoogleShoppingFetcher{APIKey: "test-key", Endpoint: api.URL}
+    ctx := context.Background()
+
+    reading, err := f.FetchReading(ctx, Product{URL: "https://www.google.co.uk/search?q=sony+wh-1000xm5&tbm=shop&gl=uk&hl=en", Currency: "GBP"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if reading.Price != 249 || reading.ListPrice == nil || *reading.ListPrice != 379 {
+        t.Errorf("reading = %+v, want the lowest offer, 249 down from 379", reading)
+    }
+    // offers carry no stock status
+    if reading.InStock != nil {
+        t.Errorf("in stock = %v, want unknown", *reading.InStock)
+    }
+    if searched.Get("engine") != "google_shopping" || searched.Get("api_key") != "test-key" || searched.Get("gl") != "uk" || searched.Get("hl") != "en" {
+        t.Errorf("search = %v, want the key and the URL's country and language", searched)
+    }
+
+    // without a q parameter the product's name is searched
+    if _, err := f.FetchReading(ctx, Product{Name: "Kettle", URL: "https://shopping.google.com/?gl=us"}); err == nil || !strings.Contains(err.Error(), "no offers") {
+        t.Errorf("no offers = %v", err)
+    }
+    if searched.Get("q") != "Kettle" {
+        t.Errorf("searched for %q, want the product's name", searched.Get("q"))
+    }
+
+    if _, err := f.FetchReading(ctx, Product{URL: "https://www.google.com/search?q=quota&udm=28"}); err == nil || !strings.Contains(err.Error(), "run out of searches") {
+        t.Errorf("API error = %v, want the API's message", err)
+    }
+    if _, err := f.FetchReading(ctx, Product{URL: "https://www.google.com/search?q=busy&tbm=shop"}); !isRetryable(err) {
+        t.Errorf("throttled search = %v, want a retryable error", err)
+    }
+    if _, err := f.FetchReading(ctx, Product{URL: "https://www.google.com/search?q=kettle"}); !errors.Is(err, errNotGoogleShopping) {
+        t.Errorf("web search = %v", err)
+    }
+}
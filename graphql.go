@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+// graphQLSchema is the schema served at /graphql. It mirrors the REST
+// types, read-only, so clients can pick fields and nest history and stats
+// under products in one request.
+const graphQLSchema = `
+schema {
+    query: Query
+}
+
+scalar Time
+
+type Query {
+    # one page of products, filtered like GET /api/v1/products
+    products(query: String, search: String, minPrice: Float, maxPrice: Float, limit: Int = 50, offset: Int = 0): ProductPage!
+    product(id: ID!): Product
+    # products whose latest price is at or below their target price
+    alerts: [Alert!]!
+}
+
+type ProductPage {
+    items: [Product!]!
+    total: Int!
+}
+
+type Product {
+    id: ID!
+    name: String!
+    url: String!
+    imageUrl: String
+    tags: [String!]!
+    notes: String!
+    priority: String!
+    currency: String!
+    targetPrice: Float
+    latestPrice: Float
+    lastUpdated: Time
+    inStock: Boolean
+    # the latest entries, newest first
+    history(limit: Int = 100, includeOutliers: Boolean = false): [PriceEntry!]!
+    stats(days: Int = 30): PriceStats!
+}
+
+type PriceEntry {
+    id: ID!
+    price: Float!
+    currency: String!
+    inStock: Boolean
+    listPrice: Float
+    shipping: Float
+    totalPrice: Float
+    timestamp: Time!
+    isOutlier: Boolean!
+}
+
+type PriceStats {
+    since: Time!
+    count: Int!
+    minPrice: Float!
+    maxPrice: Float!
+    avgPrice: Float!
+    medianPrice: Float!
+    stdDev: Float!
+    latestPrice: Float!
+    percentFromLow: Float
+}
+
+type Alert {
+    product: Product!
+    price: Float!
+    targetPrice: Float!
+}
+`
+
+const (
+    // maxGraphQLDepth caps how deeply a query may nest
+    maxGraphQLDepth = 8
+    // maxGraphQLHistory caps the entries one product's history field returns
+    maxGraphQLHistory = 1000
+)
+
+// EnableGraphQL serves the GraphQL schema at /graphql. Queries only read,
+// so like the REST reads they don't need the API key, and a tenant's key
+// limits them to the tenant's products.
+func (s *APIServer) EnableGraphQL() {
+    schema := graphql.MustParseSchema(graphQLSchema, &graphQLResolver{tracker: s.tracker}, graphql.MaxDepth(maxGraphQLDepth))
+    s.graphQL = schema
+    s.router.HandleFunc("/graphql", s.handleGraphQL).Methods("GET", "POST")
+}
+
+// handleGraphQL runs a query given as a JSON body {query, operationName,
+// variables}, or for GET in the query and operationName parameters
+func (s *APIServer) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+    var params struct {
+        Query         string                 `json:"query"`
+        OperationName string                 `json:"operationName"`
+        Variables     map[string]interface{} `json:"variables"`
+    }
+    if r.Method == http.MethodGet {
+        params.Query = r.URL.Query().Get("query")
+        params.OperationName = r.URL.Query().Get("operationName")
+    } else if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+        s.writeError(w, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+        return
+    }
+    if params.Query == "" {
+        s.writeError(w, http.StatusBadRequest, "query is required")
+        return
+    }
+
+    response := s.graphQL.Exec(r.Context(), params.Query, params.OperationName, params.Variables)
+    s.writeJSON(w, http.StatusOK, response)
+}
+
+// graphQLResolver resolves the Query type
+type graphQLResolver struct {
+    tracker *PriceTracker
+}
+
+func (q *graphQLResolver) Products(ctx context.Context, args struct {
+    Query    *string
+    Search   *string
+    MinPrice *float64
+    MaxPrice *float64
+    Limit    int32
+    Offset   int32
+}) (*productPageResolver, error) {
+    filter := ProductFilter{MinPrice: args.MinPrice, MaxPrice: args.MaxPrice, Tenant: contextTenant(ctx)}
+    if args.Query != nil {
+        filter.Query = *args.Query
+    }
+    if args.Search != nil {
+        filter.Search = *args.Search
+    }
+    if args.Limit < 1 || args.Offset < 0 {
+        return nil, errors.New("limit must be positive and offset non-negative")
+    }
+    limit := min(int(args.Limit), maxProductsLimit)
+
+    page, err := q.tracker.GetProducts(filter, limit, int(args.Offset))
+    if err != nil {
+        return nil, err
+    }
+    return &productPageResolver{page: page, products: q.productResolvers(page.Items)}, nil
+}
+
+func (q *graphQLResolver) Product(ctx context.Context, args struct{ ID graphql.ID }) (*productResolver, error) {
+    id := string(args.ID)
+    if !q.tracker.ownsProduct(contextTenant(ctx), id) {
+        return nil, nil
+    }
+    product, err := q.tracker.GetProduct(id)
+    if errors.Is(err, ErrProductNotFound) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    item := ProductWithLatestPrice{Product: product}
+    latest, err := q.tracker.db.GetPriceHistory(id, 1, false)
+    if err != nil {
+        return nil, err
+    }
+    if len(latest) > 0 {
+        item.LatestPrice = &latest[0].Price
+        item.LastUpdated = &latest[0].Timestamp
+        item.InStock = latest[0].InStock
+    }
+    return q.productResolvers([]ProductWithLatestPrice{item})[0], nil
+}
+
+func (q *graphQLResolver) Alerts(ctx context.Context) ([]*alertResolver, error) {
+    var targeted []ProductWithLatestPrice
+    for _, product := range q.tracker.tenantProducts(contextTenant(ctx)) {
+        if product.TargetPrice != nil {
+            targeted = append(targeted, ProductWithLatestPrice{Product: product})
+        }
+    }
+    products := q.productResolvers(targeted)
+    if len(products) == 0 {
+        return []*alertResolver{}, nil
+    }
+
+    // one lookup of every targeted product's latest price
+    latest, err := products[0].batch.load(1, false)
+    if err != nil {
+        return nil, err
+    }
+    alerts := []*alertResolver{}
+    for _, product := range products {
+        entries := latest[product.item.ID]
+        if len(entries) > 0 && entries[0].Price <= *product.item.TargetPrice {
+            product.item.LatestPrice = &entries[0].Price
+            product.item.LastUpdated = &entries[0].Timestamp
+            product.item.InStock = entries[0].InStock
+            alerts = append(alerts, &alertResolver{product: product, price: entries[0].Price})
+        }
+    }
+    return alerts, nil
+}
+
+// productResolvers wraps products sharing one historyBatch, so their
+// history fields are loaded together
+func (q *graphQLResolver) productResolvers(items []ProductWithLatestPrice) []*productResolver {
+    batch := &historyBatch{tracker: q.tracker, loaded: make(map[historyArgs]map[string][]PriceEntry)}
+    resolvers := make([]*productResolver, len(items))
+    for i := range items {
+        batch.ids = append(batch.ids, items[i].ID)
+        resolvers[i] = &productResolver{tracker: q.tracker, item: items[i], batch: batch}
+    }
+    return resolvers
+}
+
+// historyBatch loads the history of every product in a result with one
+// query per set of history arguments, the first time any of them is asked
+// for, instead of one query per product
+type historyBatch struct {
+    tracker *PriceTracker
+    ids     []string
+
+    mu     sync.Mutex
+    loaded map[historyArgs]map[string][]PriceEntry
+}
+
+type historyArgs struct {
+    limit           int
+    includeOutliers bool
+}
+
+func (b *historyBatch) load(limit int, includeOutliers bool) (map[string][]PriceEntry, error) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    args := historyArgs{limit: limit, includeOutliers: includeOutliers}
+    if histories, ok := b.loaded[args]; ok {
+        return histories, nil
+    }
+    histories, err := b.tracker.db.GetPriceHistories(b.ids, limit, includeOutliers)
+    if err != nil {
+        return nil, err
+    }
+    b.loaded[args] = histories
+    return histories, nil
+}
+
+type productPageResolver struct {
+    page     ProductPage
+    products []*productResolver
+}
+
+func (p *productPageResolver) Items() []*productResolver { return p.products }
+func (p *productPageResolver) Total() int32              { return int32(p.page.Total) }
+
+type productResolver struct {
+    tracker *PriceTracker
+    item    ProductWithLatestPrice
+    batch   *historyBatch
+}
+
+func (p *productResolver) ID() graphql.ID        { return graphql.ID(p.item.ID) }
+func (p *productResolver) Name() string          { return p.item.Name }
+func (p *productResolver) URL() string           { return p.item.URL }
+func (p *productResolver) Notes() string         { return p.item.Notes }
+func (p *productResolver) Priority() string      { return p.item.Priority }
+func (p *productResolver) Currency() string      { return p.item.Currency }
+func (p *productResolver) TargetPrice() *float64 { return p.item.TargetPrice }
+func (p *productResolver) LatestPrice() *float64 { return p.item.LatestPrice }
+func (p *productResolver) InStock() *bool        { return p.item.InStock }
+
+func (p *productResolver) ImageURL() *string {
+    if p.item.ImageURL == "" {
+        return nil
+    }
+    return &p.item.ImageURL
+}
+
+func (p *productResolver) Tags() []string {
+    if p.item.Tags == nil {
+        return []string{}
+    }
+    return p.item.Tags
+}
+
+func (p *productResolver) LastUpdated() *graphql.Time {
+    if p.item.LastUpdated == nil {
+        return nil
+    }
+    return &graphql.Time{Time: *p.item.LastUpdated}
+}
+
+func (p *productResolver) History(args struct {
+    Limit           int32
+    IncludeOutliers bool
+}) ([]*priceEntryResolver, error) {
+    if args.Limit < 1 || args.Limit > maxGraphQLHistory {
+        return nil, fmt.Errorf("history limit must be between 1 and %d", maxGraphQLHistory)
+    }
+    histories, err := p.batch.load(int(args.Limit), args.IncludeOutliers)
+    if err != nil {
+        return nil, err
+    }
+    entries := histories[p.item.ID]
+    resolvers := make([]*priceEntryResolver, len(entries))
+    for i := range entries {
+        resolvers[i] = &priceEntryResolver{entry: entries[i]}
+    }
+    return resolvers, nil
+}
+
+func (p *productResolver) Stats(args struct{ Days int32 }) (*priceStatsResolver, error) {
+    if args.Days < 1 {
+        return nil, errors.New("days must be positive")
+    }
+    stats, err := p.tracker.GetPriceStats(p.item.ID, int(args.Days))
+    if err != nil {
+        return nil, err
+    }
+    return &priceStatsResolver{stats: stats}, nil
+}
+
+type priceEntryResolver struct {
+    entry PriceEntry
+}
+
+func (e *priceEntryResolver) ID() graphql.ID          { return graphql.ID(fmt.Sprint(e.entry.ID)) }
+func (e *priceEntryResolver) Price() float64          { return e.entry.Price }
+func (e *priceEntryResolver) Currency() string        { return e.entry.Currency }
+func (e *priceEntryResolver) InStock() *bool          { return e.entry.InStock }
+func (e *priceEntryResolver) ListPrice() *float64     { return e.entry.ListPrice }
+func (e *priceEntryResolver) Shipping() *float64      { return e.entry.Shipping }
+func (e *priceEntryResolver) TotalPrice() *float64    { return e.entry.TotalPrice }
+func (e *priceEntryResolver) Timestamp() graphql.Time { return graphql.Time{Time: e.entry.Timestamp} }
+func (e *priceEntryResolver) IsOutlier() bool         { return e.entry.IsOutlier }
+
+type priceStatsResolver struct {
+    stats PriceStats
+}
+
+func (s *priceStatsResolver) Since() graphql.Time      { return graphql.Time{Time: s.stats.Since} }
+func (s *priceStatsResolver) Count() int32             { return int32(s.stats.Count) }
+func (s *priceStatsResolver) MinPrice() float64        { return s.stats.MinPrice }
+func (s *priceStatsResolver) MaxPrice() float64        { return s.stats.MaxPrice }
+func (s *priceStatsResolver) AvgPrice() float64        { return s.stats.AvgPrice }
+func (s *priceStatsResolver) MedianPrice() float64     { return s.stats.MedianPrice }
+func (s *priceStatsResolver) StdDev() float64          { return s.stats.StdDev }
+func (s *priceStatsResolver) LatestPrice() float64     { return s.stats.LatestPrice }
+func (s *priceStatsResolver) PercentFromLow() *float64 { return s.stats.PercentFromLow }
+
+type alertResolver struct {
+    product *productResolver
+    price   float64
+}
+
+func (a *alertResolver) Product() *productResolver { return a.product }
+func (a *alertResolver) Price() float64            { return a.price }
+func (a *alertResolver) TargetPrice() float64      { return *a.product.item.TargetPrice }
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// graphQLQuery runs a query against /graphql and decodes its data into out,
+// failing the test on errors
+func graphQLQuery(t *testing.T, server *APIServer, key, query string, out interface{}) {
+    t.Helper()
+    body, err := json.Marshal(map[string]string{"query": query})
+    if err != nil {
+        t.Fatal(err)
+    }
+    var response struct {
+        Data   json.RawMessage `json:"data"`
+        Errors []struct {
+            Message string `json:"message"`
+        } `json:"errors"`
+    }
+    if rec := serveWithKey(t, server, key, "POST", "/graphql", string(body), &response); rec.Code != http.StatusOK {
+        t.Fatalf("POST /graphql = %d: %s", rec.Code, rec.Body)
+    }
+    if len(response.Errors) > 0 {
+        t.Fatalf("query errors: %+v", response.Errors)
+    }
+    if err := json.Unmarshal(response.Data, out); err != nil {
+        t.Fatalf("decoding %s: %v", response.Data, err)
+    }
+}
+
+func newGraphQLServer(t *testing.T) *APIServer {
+    t.Helper()
+    server := NewAPIServer(newTestTracker(t, nil))
+    server.EnableGraphQL()
+    return server
+}
+
+func TestGraphQLProductsNestHistoryInOneQuery(t *testing.T) {
+    server := newGraphQLServer(t)
+    addTestProduct(t, server.tracker, "gql-a", 10, 9, 8)
+    addTestProduct(t, server.tracker, "gql-b", 20, 21)
+    addTestProduct(t, server.tracker, "gql-c")
+
+    var data struct {
+        Products struct {
+            Total int
+            Items []struct {
+                ID          string
+                LatestPrice *float64
+                History     []struct{ Price float64 }
+            }
+        }
+    }
+    graphQLQuery(t, server, "", `{ products { total items { id latestPrice history(limit: 2) { price } } } }`, &data)
+
+    if data.Products.Total != 3 || len(data.Products.Items) != 3 {
+        t.Fatalf("products = %+v", data.Products)
+    }
+    want := map[string][]float64{"gql-a": {8, 9}, "gql-b": {21, 20}, "gql-c": {}}
+    for _, item := range data.Products.Items {
+        var prices []float64
+        for _, entry := range item.History {
+            prices = append(prices, entry.Price)
+        }
+        if len(prices) != len(want[item.ID]) || len(prices) > 0 && (prices[0] != want[item.ID][0] || prices[1] != want[item.ID][1]) {
+            t.Errorf("%s history = %v, want %v", item.ID, prices, want[item.ID])
+        }
+        if item.ID == "gql-a" && (item.LatestPrice == nil || *item.LatestPrice != 8) {
+            t.Errorf("gql-a latest price = %v, want 8", item.LatestPrice)
+        }
+    }
+
+    timings := server.tracker.QueryTimings()
+    if timings["histories"].Count != 1 || timings["history"].Count != 0 {
+        t.Errorf("history queries = %d batched, %d single; want one batched", timings["histories"].Count, timings["history"].Count)
+    }
+}
+
+func TestGraphQLProductStats(t *testing.T) {
+    server := newGraphQLServer(t)
+    addTestProduct(t, server.tracker, "gql-stats", 30, 10, 20)
+
+    var data struct {
+        Product *struct {
+            Name  string
+            Stats struct {
+                Count    int
+                MinPrice float64
+                MaxPrice float64
+                AvgPrice float64
+            }
+        }
+        Missing *struct{ Name string }
+    }
+    graphQLQuery(t, server, "", `{
+        product(id: "gql-stats") { name stats(days: 7) { count minPrice maxPrice avgPrice } }
+        missing: product(id: "no-such-product") { name }
+    }`, &data)
+
+    if data.Product == nil || data.Product.Name != "Product gql-stats" {
+        t.Fatalf("product = %+v", data.Product)
+    }
+    if stats := data.Product.Stats; stats.Count != 3 || stats.MinPrice != 10 || stats.MaxPrice != 30 || stats.AvgPrice != 20 {
+        t.Errorf("stats = %+v", stats)
+    }
+    if data.Missing != nil {
+        t.Errorf("unknown product = %+v, want null", data.Missing)
+    }
+}
+
+func TestGraphQLAlerts(t *testing.T) {
+    server := newGraphQLServer(t)
+    for id, target := range map[string]float64{"gql-reached": 15, "gql-above": 5} {
+        product := addTestProduct(t, server.tracker, id, 20, 10)
+        product.TargetPrice = &target
+        if _, err := server.tracker.UpdateProduct(product); err != nil {
+            t.Fatal(err)
+        }
+    }
+    addTestProduct(t, server.tracker, "gql-untargeted", 1)
+
+    var data struct {
+        Alerts []struct {
+            Product     struct{ ID string }
+            Price       float64
+            TargetPrice float64
+        }
+    }
+    graphQLQuery(t, server, "", `{ alerts { product { id } price targetPrice } }`, &data)
+
+    if len(data.Alerts) != 1 {
+        t.Fatalf("alerts = %+v, want only gql-reached", data.Alerts)
+    }
+    if alert := data.Alerts[0]; alert.Product.ID != "gql-reached" || alert.Price != 10 || alert.TargetPrice != 15 {
+        t.Errorf("alert = %+v", alert)
+    }
+}
+
+func TestGraphQLTenantScoping(t *testing.T) {
+    server := newTenantServer(t)
+    server.EnableGraphQL()
+
+    var data struct {
+        Products struct{ Total int }
+        Product  *struct{ ID string }
+    }
+    query := `{ products { total } product(id: "alice-kettle") { id } }`
+    graphQLQuery(t, server, "bob-key", query, &data)
+    if data.Products.Total != 0 || data.Product != nil {
+        t.Errorf("bob sees %+v", data)
+    }
+    graphQLQuery(t, server, "alice-key", query, &data)
+    if data.Products.Total != 1 || data.Product == nil {
+        t.Errorf("alice sees %+v", data)
+    }
+}
+
+func TestGraphQLRejectsBadQueries(t *testing.T) {
+    server := newGraphQLServer(t)
+
+    var response struct {
+        Errors []struct{ Message string }
+    }
+    serve(t, server, "POST", "/graphql", `{"query": "{ products { items { nope } } }"}`, &response)
+    if len(response.Errors) == 0 || !strings.Contains(response.Errors[0].Message, "nope") {
+        t.Errorf("errors = %+v, want an unknown field", response.Errors)
+    }
+    if rec := serve(t, server, "POST", "/graphql", `{}`, nil); rec.Code != http.StatusBadRequest {
+        t.Errorf("empty query = %d, want 400", rec.Code)
+    }
+
+    disabled := NewAPIServer(server.tracker)
+    if rec := serve(t, disabled, "POST", "/graphql", `{"query": "{ alerts { price } }"}`, nil); rec.Code != http.StatusNotFound {
+        t.Errorf("disabled GraphQL = %d, want 404", rec.Code)
+    }
+}
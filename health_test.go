@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// healthResponse is the part of the health check the tests look at
+type healthResponse struct {
+    Status      string     `json:"status"`
+    Error       string     `json:"error"`
+    Products    int        `json:"products"`
+    LastPriceAt *time.Time `json:"last_price_at"`
+}
+
+func getHealth(t *testing.T, server *APIServer) (int, healthResponse) {
+    t.Helper()
+    rec := serve(t, server, "GET", "/api/v1/health", "", nil)
+    var health healthResponse
+    if err := json.Unmarshal(rec.Body.Bytes(), &health); err != nil {
+        t.Fatalf("decoding %q: %v", rec.Body, err)
+    }
+    return rec.Code, health
+}
+
+func TestHealthy(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+
+    code, health := getHealth(t, server)
+    if code != http.StatusOK || health.Status != "ok" || health.Products != 0 || health.LastPriceAt != nil {
+        t.Errorf("empty tracker = %d %+v", code, health)
+    }
+
+    addTestProduct(t, server.tracker, "health-kettle", 10, 11)
+    addTestProduct(t, server.tracker, "health-mug")
+    history, _ := server.tracker.GetPriceHistory("health-kettle", 1, true)
+
+    code, health = getHealth(t, server)
+    if code != http.StatusOK || health.Status != "ok" || health.Products != 2 {
+        t.Errorf("health = %d %+v", code, health)
+    }
+    if health.LastPriceAt == nil || !health.LastPriceAt.Equal(history[0].Timestamp) {
+        t.Errorf("last price at %v, want %v", health.LastPriceAt, history[0].Timestamp)
+    }
+}
+
+func TestUnhealthyDatabase(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    addTestProduct(t, server.tracker, "health-closed", 10)
+    server.tracker.db.Close()
+
+    code, health := getHealth(t, server)
+    if code != http.StatusServiceUnavailable || health.Status != "unhealthy" || health.Error == "" {
+        t.Errorf("closed database = %d %+v, want 503 unhealthy with the error", code, health)
+    }
+    if health.Products != 1 {
+        t.Errorf("products = %d, want the tracked count still reported", health.Products)
+    }
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestTracker opens a tracker on a fresh database in a temporary
+// directory. A nil fetcher uses DefaultFetcher.
+func newTestTracker(t *testing.T, fetcher PriceFetcher) *PriceTracker {
+    t.Helper()
+    db, err := NewDatabase(filepath.Join(t.TempDir(), "prices.db"))
+    if err != nil {
+        t.Fatalf("NewDatabase: %v", err)
+    }
+    t.Cleanup(func() { db.Close() })
+    return NewPriceTracker(db, fetcher)
+}
+
+// addTestProduct adds a product with the given ID and prices, oldest first,
+// one hour apart and ending an hour ago
+func addTestProduct(t *testing.T, tracker *PriceTracker, id string, prices ...float64) Product {
+    t.Helper()
+    product, err := tracker.CreateProduct(Product{ID: id, Name: "Product " + id, URL: "https://shop.test/" + id})
+    if err != nil {
+        t.Fatalf("CreateProduct: %v", err)
+    }
+    start := time.Now().UTC().Add(-time.Duration(len(prices)) * time.Hour)
+    for i, price := range prices {
+        entry := PriceEntry{ProductID: id, Price: price, Currency: DefaultCurrency, Timestamp: start.Add(time.Duration(i) * time.Hour)}
+        if _, err := tracker.db.InsertPriceEntry(entry); err != nil {
+            t.Fatalf("InsertPriceEntry: %v", err)
+        }
+    }
+    return product
+}
+
+// serve sends a request through the API's router and decodes a JSON
+// response into out, if given
+func serve(t *testing.T, server *APIServer, method, path, body string, out interface{}) *httptest.ResponseRecorder {
+    t.Helper()
+    return serveWithKey(t, server, "", method, path, body, out)
+}
+
+// serveWithKey is serve for a request sent with an API key
+func serveWithKey(t *testing.T, server *APIServer, key, method, path, body string, out interface{}) *httptest.ResponseRecorder {
+    t.Helper()
+    req := httptest.NewRequest(method, path, strings.NewReader(body))
+    if body != "" {
+        req.Header.Set("Content-Type", "application/json")
+    }
+    if key != "" {
+        req.Header.Set("X-API-Key", key)
+    }
+    rec := httptest.NewRecorder()
+    server.router.ServeHTTP(rec, req)
+    if out != nil && rec.Code < 300 {
+        if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+            t.Fatalf("%s %s: decoding %q: %v", method, path, rec.Body.String(), err)
+        }
+    }
+    return rec
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPriceHistoryRange(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    // one price a day at noon for the last six days
+    addDailyPrices(t, server.tracker, "range-kettle", 6, func(time.Time) float64 { return 50 })
+    today := time.Now().UTC().Truncate(24 * time.Hour)
+    daysAgo := func(n int) time.Time { return today.AddDate(0, 0, -n) }
+
+    tests := []struct {
+        name  string
+        query url.Values
+        from  time.Time
+        to    time.Time
+        count int
+    }{
+        {"neither", url.Values{}, daysAgo(6), today, 6},
+        {"both", url.Values{"from": {daysAgo(4).Format(time.RFC3339)}, "to": {daysAgo(2).Add(-time.Second).Format(time.RFC3339)}}, daysAgo(4), daysAgo(2), 2},
+        {"from only", url.Values{"from": {daysAgo(3).Format(time.RFC3339)}}, daysAgo(3), today, 3},
+        {"to only", url.Values{"to": {daysAgo(5).Format(time.RFC3339)}}, daysAgo(6), daysAgo(5), 1},
+        {"empty range", url.Values{"from": {daysAgo(3).Add(13 * time.Hour).Format(time.RFC3339)}, "to": {daysAgo(2).Add(11 * time.Hour).Format(time.RFC3339)}}, time.Time{}, time.Time{}, 0},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            var result struct {
+                History []PriceEntry `json:"history"`
+                Count   int          `json:"count"`
+            }
+            path := "/api/v1/products/range-kettle/history?" + tt.query.Encode()
+            if rec := serve(t, server, "GET", path, "", &result); rec.Code != http.StatusOK {
+                t.Fatalf("history = %d: %s", rec.Code, rec.Body)
+            }
+            if result.Count != tt.count || len(result.History) != tt.count {
+                t.Fatalf("got %d entries, want %d: %+v", len(result.History), tt.count, result.History)
+            }
+            for _, entry := range result.History {
+                if entry.Timestamp.Before(tt.from) || !entry.Timestamp.Before(tt.to) {
+                    t.Errorf("entry at %s is outside %s to %s", entry.Timestamp, tt.from, tt.to)
+                }
+            }
+        })
+    }
+}
+
+func TestPriceHistoryRangeInvalid(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    addTestProduct(t, server.tracker, "range-invalid", 10)
+
+    for name, query := range map[string]url.Values{
+        "bad from":      {"from": {"yesterday"}},
+        "bad to":        {"to": {"2024-13-01T00:00:00Z"}},
+        "from after to": {"from": {"2024-03-02T00:00:00Z"}, "to": {"2024-03-01T00:00:00Z"}},
+    } {
+        if rec := serve(t, server, "GET", "/api/v1/products/range-invalid/history?"+query.Encode(), "", nil); rec.Code != http.StatusBadRequest {
+            t.Errorf("%s = %d, want 400: %s", name, rec.Code, rec.Body)
+        }
+    }
+}
+
+func TestBatchHistory(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    addTestProduct(t, server.tracker, "batch-kettle", 30, 28, 25)
+    addTestProduct(t, server.tracker, "batch-mug", 8)
+    addTestProduct(t, server.tracker, "batch-empty")
+
+    var batch struct {
+        Products []struct {
+            ProductID string       `json:"product_id"`
+            History   []PriceEntry `json:"history"`
+            Count     int          `json:"count"`
+            Error     string       `json:"error"`
+        } `json:"products"`
+        Count int `json:"count"`
+    }
+    // repeats are dropped, the order is kept and the limit is per product
+    path := "/api/v1/history/batch?ids=batch-mug,+batch-kettle,batch-missing,,batch-mug,batch-empty&limit=2"
+    if rec := serve(t, server, "GET", path, "", &batch); rec.Code != http.StatusOK {
+        t.Fatalf("batch = %d: %s", rec.Code, rec.Body)
+    }
+    if batch.Count != 4 || len(batch.Products) != 4 {
+        t.Fatalf("batch = %+v, want four products", batch)
+    }
+
+    wants := []struct {
+        id     string
+        prices []float64
+        err    bool
+    }{
+        {"batch-mug", []float64{8}, false},
+        {"batch-kettle", []float64{25, 28}, false},
+        {"batch-missing", nil, true},
+        {"batch-empty", nil, false},
+    }
+    for i, want := range wants {
+        got := batch.Products[i]
+        if got.ProductID != want.id || (got.Error != "") != want.err || got.Count != len(want.prices) || len(got.History) != len(want.prices) {
+            t.Errorf("product %d = %+v, want %s with %v", i, got, want.id, want.prices)
+            continue
+        }
+        for j, entry := range got.History {
+            if entry.ProductID != want.id || entry.Price != want.prices[j] {
+                t.Errorf("%s entry %d = %s %v, want %v", want.id, j, entry.ProductID, entry.Price, want.prices[j])
+            }
+        }
+    }
+
+    tooMany := make([]string, maxBatchHistoryProducts+1)
+    for i := range tooMany {
+        tooMany[i] = fmt.Sprintf("batch-%d", i)
+    }
+    for _, query := range []string{"", "ids=,+,", "ids=" + strings.Join(tooMany, ","), "ids=batch-mug&interval=month"} {
+        if rec := serve(t, server, "GET", "/api/v1/history/batch?"+query, "", nil); rec.Code != http.StatusBadRequest {
+            t.Errorf("batch %.40q = %d, want 400", query, rec.Code)
+        }
+    }
+}
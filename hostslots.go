@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// defaultHostConcurrency is how many fetches may be in flight to one host
+// at once when no cap is configured
+const defaultHostConcurrency = 2
+
+// hostSlots caps how many fetches are in flight to each host. Dispatchers
+// claim a slot before handing a product to a worker, which releases it once
+// the fetch and its retries are done. A zero cap disables it.
+type hostSlots struct {
+    mu    sync.Mutex
+    limit int
+    inUse map[string]int
+    // freed is closed, and replaced, whenever a slot is released
+    freed chan struct{}
+}
+
+func newHostSlots() *hostSlots {
+    return &hostSlots{
+        limit: defaultHostConcurrency,
+        inUse: make(map[string]int),
+        freed: make(chan struct{}),
+    }
+}
+
+// SetHostConcurrency caps how many of a cycle's fetches may be in flight to
+// any one host at once; the remaining workers move on to other hosts. Zero
+// removes the cap.
+func (pt *PriceTracker) SetHostConcurrency(n int) {
+    s := pt.hostSlots
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.limit = n
+}
+
+// claimFirst takes a slot for the first product whose host has one free and
+// returns its index, or -1 if every host is busy. It also returns a channel
+// that is closed the next time a slot is released.
+func (s *hostSlots) claimFirst(products []Product) (int, <-chan struct{}) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for i, product := range products {
+        host := urlHost(product.URL)
+        if s.limit <= 0 || s.inUse[host] < s.limit {
+            s.inUse[host]++
+            return i, s.freed
+        }
+    }
+    return -1, s.freed
+}
+
+// release gives back a slot claimed for a product
+func (s *hostSlots) release(product Product) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    host := urlHost(product.URL)
+    if s.inUse[host] <= 1 {
+        delete(s.inUse, host)
+    } else {
+        s.inUse[host]--
+    }
+    close(s.freed)
+    s.freed = make(chan struct{})
+}
+
+// dispatch sends products to workers in order, except that a product whose
+// host is at its cap waits while later products for other hosts go ahead.
+// It stops when ctx is done and returns the products it didn't send.
+func (s *hostSlots) dispatch(ctx context.Context, products []Product, out chan<- Product) []Product {
+    pending := append([]Product(nil), products...)
+    for len(pending) > 0 {
+        // a select with a free worker and a cancelled ctx picks either, so
+        // check first that the cycle is still running
+        if ctx.Err() != nil {
+            return pending
+        }
+        i, freed := s.claimFirst(pending)
+        if i < 0 {
+            select {
+            case <-freed:
+                continue
+            case <-ctx.Done():
+                return pending
+            }
+        }
+
+        select {
+        case out <- pending[i]:
+            pending = append(pending[:i], pending[i+1:]...)
+        case <-ctx.Done():
+            s.release(pending[i])
+            return pending
+        }
+    }
+    return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHostConcurrencyCap(t *testing.T) {
+    var inFlight, maxInFlight, othersDone int32
+    othersFinished := make(chan struct{})
+    var once sync.Once
+    heldUp := false
+
+    // fetches from busy.test hold their slot until both products on
+    // other.test are done, which they can only be if they aren't queued
+    // behind busy.test
+    fetcher := PriceFetcherFunc(func(ctx context.Context, product Product) (float64, error) {
+        if urlHost(product.URL) != "busy.test" {
+            if atomic.AddInt32(&othersDone, 1) == 2 {
+                once.Do(func() { close(othersFinished) })
+            }
+            return 5, nil
+        }
+
+        n := atomic.AddInt32(&inFlight, 1)
+        defer atomic.AddInt32(&inFlight, -1)
+        for {
+            seen := atomic.LoadInt32(&maxInFlight)
+            if n <= seen || atomic.CompareAndSwapInt32(&maxInFlight, seen, n) {
+                break
+            }
+        }
+        select {
+        case <-othersFinished:
+        case <-time.After(2 * time.Second):
+            heldUp = true
+        }
+        time.Sleep(10 * time.Millisecond)
+        return 10, nil
+    })
+
+    tracker := newTestTracker(t, fetcher)
+    tracker.SetRetryPolicy(1, 0)
+    tracker.SetNumWorkers(4)
+    tracker.SetHostConcurrency(1)
+    // the busy host's products come first in the cycle
+    for i := 1; i <= 3; i++ {
+        id := fmt.Sprintf("a-busy-%d", i)
+        if _, err := tracker.CreateProduct(Product{ID: id, Name: id, URL: "https://busy.test/" + id}); err != nil {
+            t.Fatal(err)
+        }
+    }
+    for i := 1; i <= 2; i++ {
+        id := fmt.Sprintf("b-other-%d", i)
+        if _, err := tracker.CreateProduct(Product{ID: id, Name: id, URL: fmt.Sprintf("https://other-%d.test/%s", i, id)}); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    var counts cycleReport
+    tracker.trackProducts(context.Background(), tracker.snapshotProducts(), counts.report)
+
+    if maxInFlight != 1 {
+        t.Errorf("%d fetches to busy.test in flight at once, want at most 1", maxInFlight)
+    }
+    if heldUp {
+        t.Error("products on other hosts waited behind busy.test")
+    }
+    if counts.ok != 5 || counts.failed != 0 {
+        t.Errorf("reported %d ok and %d failed, want all 5 ok", counts.ok, counts.failed)
+    }
+    if n := len(tracker.hostSlots.inUse); n != 0 {
+        t.Errorf("%d hosts still hold slots after the cycle", n)
+    }
+}
+
+func TestHostSlotsClaimFirst(t *testing.T) {
+    s := newHostSlots()
+    s.limit = 1
+    products := []Product{
+        {ID: "one", URL: "https://busy.test/one"},
+        {ID: "two", URL: "https://busy.test/two"},
+        {ID: "three", URL: "https://other.test/three"},
+    }
+
+    if i, _ := s.claimFirst(products); i != 0 {
+        t.Fatalf("first claim = %d, want 0", i)
+    }
+    // busy.test is at its cap, so the other host's product goes ahead
+    i, freed := s.claimFirst(products[1:])
+    if i != 1 {
+        t.Fatalf("second claim = %d, want the product on other.test", i)
+    }
+    if i, _ := s.claimFirst(products[1:2]); i != -1 {
+        t.Fatalf("claim on a full host = %d, want -1", i)
+    }
+
+    s.release(products[0])
+    select {
+    case <-freed:
+    default:
+        t.Fatal("releasing a slot didn't signal")
+    }
+    if i, _ := s.claimFirst(products[1:2]); i != 0 {
+        t.Errorf("claim after a release = %d, want 0", i)
+    }
+
+    // no cap
+    s.limit = 0
+    for n := 0; n < 5; n++ {
+        if i, _ := s.claimFirst(products[:1]); i != 0 {
+            t.Fatalf("uncapped claim %d = %d", n, i)
+        }
+    }
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+)
+
+// cssSelector is a compiled CSS selector such as "div.price > span, #price"
+type cssSelector = goquery.Matcher
+
+// parseHTML parses a fetched page with the HTML5 parser, which accepts any
+// input the way a browser would
+func parseHTML(page string) *goquery.Document {
+    root, err := html.Parse(strings.NewReader(page))
+    if err != nil {
+        // html.Parse only fails when its reader does, which a string can't
+        root = &html.Node{Type: html.DocumentNode}
+    }
+    return goquery.NewDocumentFromNode(root)
+}
+
+// parseSelector compiles a CSS selector. Any selector cascadia supports is
+// accepted, including pseudo-classes such as :nth-child and :contains.
+func parseSelector(input string) (cssSelector, error) {
+    if strings.TrimSpace(input) == "" {
+        return nil, errors.New("empty selector")
+    }
+    sel, err := cascadia.Compile(input)
+    if err != nil {
+        return nil, err
+    }
+    return sel, nil
+}
+
+// firstText returns the text of the first element in document order that
+// matches selector
+func firstText(root *goquery.Document, selector cssSelector) (string, bool) {
+    match := root.FindMatcher(selector).First()
+    return match.Text(), match.Length() > 0
+}
+
+// jsonLDBlocks returns a page's schema.org JSON-LD blocks, decoded, in
+// document order. Blocks that aren't valid JSON are skipped.
+func jsonLDBlocks(root *goquery.Document) []interface{} {
+    var blocks []interface{}
+    root.Find("script").Each(func(_ int, script *goquery.Selection) {
+        if !strings.EqualFold(strings.TrimSpace(script.AttrOr("type", "")), "application/ld+json") {
+            return
+        }
+        var data interface{}
+        if err := json.Unmarshal([]byte(script.Text()), &data); err == nil {
+            blocks = append(blocks, data)
+        }
+    })
+    return blocks
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+    // maxImageBytes caps the size of a proxied product image
+    maxImageBytes = 5 << 20
+    // imageCacheTTL is how long a proxied image is served from memory
+    imageCacheTTL = time.Hour
+)
+
+// ErrNoImage is returned when a product has no image to serve
+var ErrNoImage = errors.New("product has no image")
+
+var imageClient = &http.Client{Timeout: 10 * time.Second}
+
+type cachedImage struct {
+    contentType string
+    data        []byte
+    fetchedAt   time.Time
+}
+
+// ProductImage returns the product's image, fetching it from the image URL
+// and caching it in memory so pages can show it without hotlinking the
+// retailer or mixing content
+func (pt *PriceTracker) ProductImage(ctx context.Context, productID string) (string, []byte, error) {
+    product, err := pt.GetProduct(productID)
+    if err != nil {
+        return "", nil, err
+    }
+    if product.ImageURL == "" {
+        return "", nil, fmt.Errorf("%w: %s", ErrNoImage, productID)
+    }
+
+    pt.imagesMu.Lock()
+    cached, ok := pt.images[product.ImageURL]
+    pt.imagesMu.Unlock()
+    if ok && time.Since(cached.fetchedAt) < imageCacheTTL {
+        return cached.contentType, cached.data, nil
+    }
+
+    contentType, data, err := fetchImage(ctx, product.ImageURL)
+    if err != nil {
+        return "", nil, err
+    }
+
+    pt.imagesMu.Lock()
+    pt.images[product.ImageURL] = cachedImage{contentType: contentType, data: data, fetchedAt: time.Now()}
+    pt.imagesMu.Unlock()
+
+    return contentType, data, nil
+}
+
+func fetchImage(ctx context.Context, imageURL string) (string, []byte, error) {
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, imageURL, nil)
+    if err != nil {
+        return "", nil, err
+    }
+
+    resp, err := imageClient.Do(req)
+    if err != nil {
+        return "", nil, err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        return "", nil, fmt.Errorf("fetching image: unexpected status %s", resp.Status)
+    }
+
+    contentType := resp.Header.Get("Content-Type")
+    if !strings.HasPrefix(contentType, "image/") {
+        return "", nil, fmt.Errorf("fetching image: unexpected content type %q", contentType)
+    }
+
+    data, err := io.ReadAll(io.LimitReader(resp.Body, maxImageBytes+1))
+    if err != nil {
+        return "", nil, err
+    }
+    if len(data) > maxImageBytes {
+        return "", nil, fmt.Errorf("fetching image: larger than %d bytes", maxImageBytes)
+    }
+
+    return contentType, data, nil
+}
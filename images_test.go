@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDiscoverImageURL(t *testing.T) {
+    tests := []struct {
+        name string
+        head string
+        want string
+    }{
+        {"json-ld url", `<script type="application/ld+json">{"@type": "Product", "name": "Mug", "image": "https://cdn.test/mug.jpg"}</script>`, "https://cdn.test/mug.jpg"},
+        {"json-ld image object", `<script type="application/ld+json">{"@type": "Product", "name": "Mug", "image": {"@type": "ImageObject", "url": "https://cdn.test/object.jpg"}}</script>`, "https://cdn.test/object.jpg"},
+        {"json-ld list", `<script type="application/ld+json">{"@type": "Product", "name": "Mug", "image": ["https://cdn.test/first.jpg", "https://cdn.test/second.jpg"]}</script>`, "https://cdn.test/first.jpg"},
+        {"open graph, relative", `<meta property="og:image" content=" /images/mug.png ">`, "https://shop.test/images/mug.png"},
+        {"none", `<title>Mug</title>`, ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            page := "<html><head>" + tt.head + "</head><body></body></html>"
+            if got := discoverMetadata(parseHTML(page), "https://shop.test/kitchen/mug").imageURL; got != tt.want {
+                t.Errorf("image URL = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestDiscoveredProductImage(t *testing.T) {
+    pages := newPageServer(t, `<html><head>
+        <meta property="og:title" content="Travel Mug">
+        <meta property="og:image" content="/img/travel-mug.png">
+    </head><body></body></html>`)
+    server := NewAPIServer(newTestTracker(t, nil))
+
+    var product Product
+    if rec := serve(t, server, "POST", "/api/v1/products", `{"url": "`+pages.URL+`/mug"}`, &product); rec.Code != http.StatusCreated {
+        t.Fatalf("create = %d: %s", rec.Code, rec.Body)
+    }
+    if product.ImageURL != pages.URL+"/img/travel-mug.png" {
+        t.Errorf("image_url = %q, want the resolved og:image", product.ImageURL)
+    }
+
+    // an image given by the caller is kept
+    body := `{"url": "` + pages.URL + `/other", "id": "mug-2", "image_url": "https://cdn.test/mine.png"}`
+    if rec := serve(t, server, "POST", "/api/v1/products", body, &product); rec.Code != http.StatusCreated || product.ImageURL != "https://cdn.test/mine.png" {
+        t.Errorf("create with an image = %d, image_url %q", rec.Code, product.ImageURL)
+    }
+}
+
+func TestProductImageProxy(t *testing.T) {
+    png := []byte("\x89PNG\r\n\x1a\nfake image")
+    var hits int32
+    images := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        atomic.AddInt32(&hits, 1)
+        if r.URL.Path == "/page.html" {
+            w.Header().Set("Content-Type", "text/html")
+            w.Write([]byte("<html></html>"))
+            return
+        }
+        w.Header().Set("Content-Type", "image/png")
+        w.Write(png)
+    }))
+    t.Cleanup(images.Close)
+
+    server := NewAPIServer(newTestTracker(t, nil))
+    for id, imageURL := range map[string]string{
+        "image-mug":    images.URL + "/mug.png",
+        "image-none":   "",
+        "image-notpng": images.URL + "/page.html",
+    } {
+        if _, err := server.tracker.CreateProduct(Product{ID: id, Name: id, URL: "https://shop.test/" + id, ImageURL: imageURL}); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    for i := 0; i < 2; i++ {
+        rec := serve(t, server, "GET", "/api/v1/products/image-mug/image", "", nil)
+        if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), png) {
+            t.Fatalf("image = %d %q", rec.Code, rec.Body)
+        }
+        if got := rec.Header().Get("Content-Type"); got != "image/png" {
+            t.Errorf("content type = %q, want image/png", got)
+        }
+        if got := rec.Header().Get("Cache-Control"); got == "" {
+            t.Error("the image isn't cacheable")
+        }
+    }
+    if n := atomic.LoadInt32(&hits); n != 1 {
+        t.Errorf("the image was fetched %d times, want once and then served from the cache", n)
+    }
+
+    for path, want := range map[string]int{
+        "/api/v1/products/image-none/image":      http.StatusNotFound,
+        "/api/v1/products/no-such-product/image": http.StatusNotFound,
+        "/api/v1/products/image-notpng/image":    http.StatusBadGateway,
+    } {
+        if rec := serve(t, server, "GET", path, "", nil); rec.Code != want {
+            t.Errorf("%s = %d, want %d", path, rec.Code, want)
+        }
+    }
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Import row statuses
+const (
+    ImportInserted = "inserted"
+    ImportSkipped  = "skipped"
+    ImportFailed   = "failed"
+)
+
+// ImportProducts adds the products listed in a CSV file. The first row is a
+// header naming the columns: id, name and url are required, selector,
+// xpath, regex and target_price are optional, and columns may come in any order. Rows for
+// products that already exist are skipped with a warning; invalid rows are
+// counted as failed without stopping the import. Only an unreadable header
+// or a failed read is returned as an error. The products belong to tenant.
+func (pt *PriceTracker) ImportProducts(r io.Reader, tenant string) (ImportSummary, error) {
+    summary := ImportSummary{Rows: []ImportRow{}}
+
+    reader := csv.NewReader(r)
+    reader.FieldsPerRecord = -1
+    reader.TrimLeadingSpace = true
+
+    header, err := reader.Read()
+    if err != nil {
+        return summary, fmt.Errorf("reading CSV header: %w", err)
+    }
+    columns := make(map[string]int)
+    for i, name := range header {
+        columns[strings.ToLower(strings.TrimSpace(name))] = i
+    }
+    for _, required := range []string{"id", "name", "url"} {
+        if _, ok := columns[required]; !ok {
+            return summary, fmt.Errorf("CSV header is missing the %q column", required)
+        }
+    }
+
+    for {
+        record, err := reader.Read()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            // a malformed row fails on its own, but the reader failing ends
+            // the import
+            var parseErr *csv.ParseError
+            if !errors.As(err, &parseErr) {
+                return summary, fmt.Errorf("reading CSV: %w", err)
+            }
+            // csv errors already name the line
+            summary.fail(parseErr.Line, "", err.Error(), err.Error())
+            continue
+        }
+        line, _ := reader.FieldPos(0)
+
+        field := func(name string) string {
+            i, ok := columns[name]
+            if !ok || i >= len(record) {
+                return ""
+            }
+            return strings.TrimSpace(record[i])
+        }
+
+        product := Product{
+            ID:            field("id"),
+            Name:          field("name"),
+            URL:           field("url"),
+            PriceSelector: field("selector"),
+            PriceXPath:    field("xpath"),
+            PriceRegex:    field("regex"),
+        }
+        if raw := field("target_price"); raw != "" {
+            target, err := strconv.ParseFloat(raw, 64)
+            if err != nil {
+                message := fmt.Sprintf("invalid target_price %q", raw)
+                summary.fail(line, product.ID, message, fmt.Sprintf("line %d: %s", line, message))
+                continue
+            }
+            product.TargetPrice = &target
+        }
+
+        product.Tenant = tenant
+        pt.importProduct(&summary, line, fmt.Sprintf("line %d", line), product)
+    }
+
+    return summary, nil
+}
+
+// ImportProductList adds a list of products the way ImportProducts adds the
+// rows of a CSV file, with rows numbered by their position from 1
+func (pt *PriceTracker) ImportProductList(products []Product, tenant string) ImportSummary {
+    summary := ImportSummary{Rows: []ImportRow{}}
+    for i, product := range products {
+        product.Tenant = tenant
+        pt.importProduct(&summary, i+1, fmt.Sprintf("item %d", i+1), product)
+    }
+    return summary
+}
+
+// importProduct adds one imported product and records the outcome; where
+// names the row in log and error messages
+func (pt *PriceTracker) importProduct(summary *ImportSummary, row int, where string, product Product) {
+    _, err := pt.CreateProduct(product)
+    switch {
+    case errors.Is(err, ErrProductExists):
+        log.Printf("Import: skipping %s, product %s already exists", where, product.ID)
+        summary.Skipped++
+        summary.Rows = append(summary.Rows, ImportRow{Row: row, ProductID: product.ID, Status: ImportSkipped, Error: err.Error()})
+    case err != nil:
+        summary.fail(row, product.ID, err.Error(), fmt.Sprintf("%s: %v", where, err))
+    default:
+        summary.Inserted++
+        summary.Rows = append(summary.Rows, ImportRow{Row: row, ProductID: product.ID, Status: ImportInserted})
+    }
+}
+
+// fail records a failed row; message is the row's error and logged the
+// same error naming the row
+func (s *ImportSummary) fail(row int, productID, message, logged string) {
+    log.Printf("Import: %s", logged)
+    s.Failed++
+    s.Errors = append(s.Errors, logged)
+    s.Rows = append(s.Rows, ImportRow{Row: row, ProductID: productID, Status: ImportFailed, Error: message})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const importCSV = `id,name,url,selector,target_price
+import-kettle,Kettle,https://shop.test/kettle,.price,
+import-mug,Mug,https://shop.test/mug,.sale-price,12.5
+import-kettle,Kettle again,https://shop.test/kettle-2,,
+import-existing,Existing,https://shop.test/existing,,
+import-nourl,No URL,,,
+import-badtarget,Bad target,https://shop.test/bad,,cheap
+`
+
+func TestImportProducts(t *testing.T) {
+    tracker := newTestTracker(t, nil)
+    addTestProduct(t, tracker, "import-existing")
+
+    summary, err := tracker.ImportProducts(strings.NewReader(importCSV), "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if summary.Inserted != 2 || summary.Skipped != 2 || summary.Failed != 2 || len(summary.Rows) != 6 {
+        t.Fatalf("summary = %+v, want 2 inserted, 2 skipped and 2 failed", summary)
+    }
+    for i, want := range []string{ImportInserted, ImportInserted, ImportSkipped, ImportSkipped, ImportFailed, ImportFailed} {
+        if row := summary.Rows[i]; row.Status != want || row.Row != i+2 {
+            t.Errorf("row %d = %+v, want %s on line %d", i, row, want, i+2)
+        }
+    }
+
+    // the valid rows were stored
+    products, err := tracker.db.GetAllProducts()
+    if err != nil {
+        t.Fatal(err)
+    }
+    stored := map[string]Product{}
+    for _, product := range products {
+        stored[product.ID] = product
+    }
+    if len(stored) != 3 {
+        t.Errorf("stored %d products, want the existing one and 2 imported", len(stored))
+    }
+    if kettle := stored["import-kettle"]; kettle.Name != "Kettle" || kettle.PriceSelector != ".price" || kettle.TargetPrice != nil {
+        t.Errorf("kettle = %+v, want the first row kept", kettle)
+    }
+    if mug := stored["import-mug"]; mug.PriceSelector != ".sale-price" || mug.TargetPrice == nil || *mug.TargetPrice != 12.5 {
+        t.Errorf("mug = %+v", mug)
+    }
+}
+
+func TestImportProductsFile(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "products.csv")
+    if err := os.WriteFile(path, []byte(importCSV), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    tracker := newTestTracker(t, nil)
+
+    // bad rows don't stop the startup import
+    if err := importProducts(tracker, path); err != nil {
+        t.Fatal(err)
+    }
+    for _, id := range []string{"import-kettle", "import-mug", "import-existing"} {
+        if _, err := tracker.GetProduct(id); err != nil {
+            t.Errorf("%s wasn't imported: %v", id, err)
+        }
+    }
+
+    if err := importProducts(tracker, filepath.Join(t.TempDir(), "missing.csv")); err == nil {
+        t.Error("importing a missing file succeeded")
+    }
+    headerless := filepath.Join(t.TempDir(), "headerless.csv")
+    os.WriteFile(headerless, []byte("id,name\nimport-x,X\n"), 0o644)
+    if err := importProducts(tracker, headerless); err == nil || !strings.Contains(err.Error(), `"url"`) {
+        t.Errorf("err = %v, want the missing url column named", err)
+    }
+}
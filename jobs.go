@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+    // jobTTL is how long a finished job stays queryable
+    jobTTL = 15 * time.Minute
+
+    // validateWorkers caps concurrent fetches during a validation run
+    validateWorkers = 3
+    // validateTimeout caps the total runtime of a validation run
+    validateTimeout = 2 * time.Minute
+)
+
+// CheckAll starts an immediate tracking cycle for every one of a tenant's
+// products in the background and returns the job tracking its progress
+func (pt *PriceTracker) CheckAll(tenant string) Job {
+    products := pt.tenantProducts(tenant)
+    job := pt.newJob("check-all", tenant, len(products))
+
+    go func() {
+        pt.trackProducts(context.Background(), products, func(ok bool) {
+            pt.updateJob(job.ID, func(j *Job) {
+                j.Checked++
+                if ok {
+                    j.Succeeded++
+                } else {
+                    j.Failed++
+                }
+            })
+        })
+        pt.finishJob(job.ID)
+    }()
+
+    return job
+}
+
+// CheckProduct fetches a product's price now, outside the tracking
+// schedule, and stores it the way a tracking cycle would. A failed fetch is
+// recorded as a scrape error and returned wrapped in ErrCheckFailed.
+func (pt *PriceTracker) CheckProduct(ctx context.Context, productID string) (PriceCheck, error) {
+    product, err := pt.GetProduct(productID)
+    if err != nil {
+        return PriceCheck{}, err
+    }
+
+    reading, attempts, err := pt.fetchReadingWithRetry(ctx, product, pt.fetchTimeoutOrDefault())
+    if err != nil {
+        log.Printf("On-demand check of %s failed after %d attempts: %v", productID, attempts, err)
+        pt.recordScrapeError(ctx, product, attempts, err)
+        return PriceCheck{}, fmt.Errorf("%w: %v", ErrCheckFailed, err)
+    }
+    pt.checkListing(product, reading.Title)
+    pt.checkFingerprint(product, reading.Fingerprint)
+
+    history, err := pt.db.GetPriceHistory(productID, 1, false)
+    if err != nil {
+        return PriceCheck{}, err
+    }
+    var last *PriceEntry
+    if len(history) > 0 {
+        last = &history[0]
+    }
+    pt.statusMu.Lock()
+    epsilon := pt.duplicateEpsilon
+    pt.statusMu.Unlock()
+
+    entry := newPriceEntry(product, reading)
+    stored, err := pt.storePrice(&entry, last, epsilon)
+    if err != nil {
+        return PriceCheck{}, err
+    }
+
+    return PriceCheck{
+        ProductID: productID,
+        Price:     entry.Price,
+        Currency:  entry.Currency,
+        InStock:   entry.InStock,
+        Shipping:  entry.Shipping,
+        Stored:    stored,
+        EntryID:   entry.ID,
+        Attempts:  attempts,
+        CheckedAt: entry.Timestamp,
+    }, nil
+}
+
+// StartProductCheck runs CheckProduct in the background and returns the job
+// tracking it, whose single result holds the price or the error
+func (pt *PriceTracker) StartProductCheck(productID string) (Job, error) {
+    product, err := pt.GetProduct(productID)
+    if err != nil {
+        return Job{}, err
+    }
+    job := pt.newJob("check", product.Tenant, 1)
+
+    go func() {
+        check, err := pt.CheckProduct(context.Background(), productID)
+        result := ValidationResult{ProductID: productID, URL: product.URL, OK: err == nil, Price: check.Price}
+        if err != nil {
+            result.Error = err.Error()
+        }
+        pt.recordValidation(job.ID, result)
+        pt.finishJob(job.ID)
+    }()
+
+    return job, nil
+}
+
+// ValidateAll starts a dry-run fetch of every one of a tenant's products in
+// the background and returns the job collecting the report. Nothing is
+// stored; products not reached before the deadline are reported as failed.
+func (pt *PriceTracker) ValidateAll(tenant string) Job {
+    products := pt.tenantProducts(tenant)
+    job := pt.newJob("validate-all", tenant, len(products))
+
+    go func() {
+        ctx, cancel := context.WithTimeout(context.Background(), validateTimeout)
+        defer cancel()
+
+        productChan := make(chan Product)
+        var wg sync.WaitGroup
+        for i := 0; i < validateWorkers; i++ {
+            wg.Add(1)
+            go func() {
+                defer wg.Done()
+                for product := range productChan {
+                    result := pt.validateProduct(ctx, product)
+                    pt.hostSlots.release(product)
+                    pt.recordValidation(job.ID, result)
+                }
+            }()
+        }
+
+        for _, skipped := range pt.hostSlots.dispatch(ctx, products, productChan) {
+            pt.recordValidation(job.ID, ValidationResult{
+                ProductID: skipped.ID,
+                URL:       skipped.URL,
+                Error:     "validation run timed out before this product was checked",
+            })
+        }
+        close(productChan)
+        wg.Wait()
+
+        pt.finishJob(job.ID)
+    }()
+
+    return job
+}
+
+func (pt *PriceTracker) validateProduct(ctx context.Context, product Product) ValidationResult {
+    result := ValidationResult{ProductID: product.ID, URL: product.URL}
+
+    reading, _, err := pt.fetchReadingWithRetry(ctx, product, pt.fetchTimeoutOrDefault())
+    if err != nil {
+        result.Error = err.Error()
+        return result
+    }
+
+    result.OK = true
+    result.Price = reading.Price
+    return result
+}
+
+func (pt *PriceTracker) recordValidation(jobID string, result ValidationResult) {
+    pt.updateJob(jobID, func(j *Job) {
+        j.Checked++
+        if result.OK {
+            j.Succeeded++
+        } else {
+            j.Failed++
+        }
+        j.Results = append(j.Results, result)
+    })
+}
+
+// GetJob returns a snapshot of a job by ID
+func (pt *PriceTracker) GetJob(id string) (Job, bool) {
+    pt.jobsMu.Lock()
+    defer pt.jobsMu.Unlock()
+
+    pt.pruneJobs()
+    job, ok := pt.jobs[id]
+    if !ok {
+        return Job{}, false
+    }
+
+    snapshot := *job
+    snapshot.Results = append([]ValidationResult(nil), job.Results...)
+    return snapshot, true
+}
+
+func (pt *PriceTracker) newJob(jobType, tenant string, total int) Job {
+    pt.jobsMu.Lock()
+    defer pt.jobsMu.Unlock()
+
+    pt.pruneJobs()
+    job := &Job{
+        ID:        newJobID(),
+        Type:      jobType,
+        Total:     total,
+        StartedAt: time.Now(),
+        Tenant:    tenant,
+    }
+    pt.jobs[job.ID] = job
+
+    log.Printf("Started %s job %s for %d products", jobType, job.ID, total)
+    return *job
+}
+
+func (pt *PriceTracker) updateJob(id string, update func(j *Job)) {
+    pt.jobsMu.Lock()
+    defer pt.jobsMu.Unlock()
+
+    if job, ok := pt.jobs[id]; ok {
+        update(job)
+    }
+}
+
+func (pt *PriceTracker) finishJob(id string) {
+    pt.updateJob(id, func(j *Job) {
+        now := time.Now()
+        j.Done = true
+        j.FinishedAt = &now
+        log.Printf("Finished %s job %s: %d succeeded, %d failed", j.Type, j.ID, j.Succeeded, j.Failed)
+    })
+}
+
+// pruneJobs drops finished jobs older than jobTTL; callers must hold jobsMu
+func (pt *PriceTracker) pruneJobs() {
+    for id, job := range pt.jobs {
+        if job.FinishedAt != nil && time.Since(*job.FinishedAt) > jobTTL {
+            delete(pt.jobs, id)
+        }
+    }
+}
+
+func newJobID() string {
+    b := make([]byte, 8)
+    if _, err := rand.Read(b); err != nil {
+        return time.Now().Format("20060102150405.000000000")
+    }
+    return hex.EncodeToString(b)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCheckAllJobProgress(t *testing.T) {
+    // fetches wait for the gate, so the job can be seen in progress
+    gate := make(chan struct{})
+    fetcher := PriceFetcherFunc(func(ctx context.Context, product Product) (float64, error) {
+        <-gate
+        if product.ID == "job-broken" {
+            return 0, errors.New("no price on the page")
+        }
+        return 12.5, nil
+    })
+    tracker := newTestTracker(t, fetcher)
+    tracker.SetRetryPolicy(1, 0)
+    server := NewAPIServer(tracker)
+    for _, id := range []string{"job-a", "job-b", "job-broken"} {
+        addTestProduct(t, tracker, id)
+    }
+
+    var job Job
+    if rec := serve(t, server, "POST", "/api/v1/check-all", "", &job); rec.Code != http.StatusAccepted {
+        t.Fatalf("check-all = %d: %s", rec.Code, rec.Body)
+    }
+    if job.ID == "" || job.Type != "check-all" || job.Total != 3 || job.Done {
+        t.Fatalf("started job = %+v", job)
+    }
+
+    var progress Job
+    serve(t, server, "GET", "/api/v1/jobs/"+job.ID, "", &progress)
+    if progress.Checked != 0 || progress.Done || progress.FinishedAt != nil {
+        t.Errorf("job before any fetch finished = %+v", progress)
+    }
+
+    close(gate)
+    deadline := time.Now().Add(5 * time.Second)
+    for !progress.Done && time.Now().Before(deadline) {
+        time.Sleep(10 * time.Millisecond)
+        serve(t, server, "GET", "/api/v1/jobs/"+job.ID, "", &progress)
+    }
+    if !progress.Done || progress.FinishedAt == nil {
+        t.Fatalf("job never finished: %+v", progress)
+    }
+    if progress.Checked != 3 || progress.Succeeded != 2 || progress.Failed != 1 {
+        t.Errorf("finished job = %+v, want 3 checked, 2 succeeded, 1 failed", progress)
+    }
+
+    history, err := tracker.GetPriceHistory("job-a", 1, false)
+    if err != nil || len(history) != 1 || history[0].Price != 12.5 {
+        t.Errorf("job-a history = %+v, %v; want the checked price stored", history, err)
+    }
+}
+
+func TestJobsExpire(t *testing.T) {
+    tracker := newTestTracker(t, nil)
+    server := NewAPIServer(tracker)
+
+    job := tracker.newJob("check-all", "", 0)
+    tracker.finishJob(job.ID)
+    if _, ok := tracker.GetJob(job.ID); !ok {
+        t.Fatal("a just-finished job is gone")
+    }
+
+    tracker.updateJob(job.ID, func(j *Job) {
+        finished := time.Now().Add(-jobTTL - time.Minute)
+        j.FinishedAt = &finished
+    })
+    if _, ok := tracker.GetJob(job.ID); ok {
+        t.Error("a job finished longer than jobTTL ago is still kept")
+    }
+    if rec := serve(t, server, "GET", "/api/v1/jobs/"+job.ID, "", nil); rec.Code != http.StatusNotFound {
+        t.Errorf("expired job = %d, want 404", rec.Code)
+    }
+}
+
+func TestCheckProduct(t *testing.T) {
+    var down atomic.Bool
+    var fetches atomic.Int32
+    fetcher := PriceFetcherFunc(func(ctx context.Context, product Product) (float64, error) {
+        fetches.Add(1)
+        if down.Load() {
+            return 0, retryableError{errors.New("503 Service Unavailable")}
+        }
+        return 42, nil
+    })
+    tracker := newTestTracker(t, fetcher)
+    tracker.SetRetryPolicy(2, time.Millisecond)
+    server := NewAPIServer(tracker)
+    addTestProduct(t, tracker, "check-kettle", 40)
+
+    var check PriceCheck
+    if rec := serve(t, server, "POST", "/api/v1/products/check-kettle/check", "", &check); rec.Code != http.StatusOK {
+        t.Fatalf("check = %d: %s", rec.Code, rec.Body)
+    }
+    if check.ProductID != "check-kettle" || check.Price != 42 || check.Currency != DefaultCurrency || !check.Stored || check.EntryID == 0 || check.Attempts != 1 {
+        t.Errorf("check = %+v, want 42 stored after one attempt", check)
+    }
+    if history, _ := tracker.GetPriceHistory("check-kettle", 10, false); len(history) != 2 || history[0].Price != 42 || history[0].ID != check.EntryID {
+        t.Errorf("history = %+v, want the checked price first", history)
+    }
+
+    // an unchanged price is reported but not stored again
+    first := check.EntryID
+    serve(t, server, "POST", "/api/v1/products/check-kettle/check", "", &check)
+    if check.Price != 42 || check.Stored || check.EntryID != first {
+        t.Errorf("repeat check = %+v, want 42 not stored, pointing at entry %d", check, first)
+    }
+
+    // a failed fetch is retried, recorded and reported as a bad gateway
+    down.Store(true)
+    fetches.Store(0)
+    rec := serve(t, server, "POST", "/api/v1/products/check-kettle/check", "", nil)
+    if rec.Code != http.StatusBadGateway {
+        t.Errorf("failed check = %d: %s, want 502", rec.Code, rec.Body)
+    }
+    if n := fetches.Load(); n != 2 {
+        t.Errorf("failed check fetched %d times, want 2", n)
+    }
+    if errs, _ := tracker.GetScrapeErrors("check-kettle", 10); len(errs) != 1 || errs[0].Attempts != 2 {
+        t.Errorf("scrape errors = %+v, want the failed check recorded", errs)
+    }
+
+    if rec := serve(t, server, "POST", "/api/v1/products/check-missing/check", "", nil); rec.Code != http.StatusNotFound {
+        t.Errorf("unknown product = %d, want 404", rec.Code)
+    }
+
+    // async checks hand back a job holding the result
+    down.Store(false)
+    var job Job
+    if rec := serve(t, server, "POST", "/api/v1/products/check-kettle/check?async=true", "", &job); rec.Code != http.StatusAccepted || job.Type != "check" || job.Total != 1 {
+        t.Fatalf("async check = %d: %+v", rec.Code, job)
+    }
+    job = waitForJob(t, server, job.ID)
+    if len(job.Results) != 1 || !job.Results[0].OK || job.Results[0].Price != 42 {
+        t.Errorf("async check job = %+v", job)
+    }
+    if rec := serve(t, server, "POST", "/api/v1/products/check-missing/check?async=true", "", nil); rec.Code != http.StatusNotFound {
+        t.Errorf("async check of an unknown product = %d, want 404", rec.Code)
+    }
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"unicode"
+)
+
+// defaultListingSimilarity is the lowest similarity between a page's product
+// name and the one it first showed before the listing counts as changed
+const defaultListingSimilarity = 0.5
+
+// listingState is the product name a page first showed, which later names
+// are compared with, and whether the page has since diverged from it
+type listingState struct {
+    title     string
+    divergent bool
+}
+
+// SetListingSimilarity sets how similar, from 0 to 1, the product name a
+// page shows must stay to the name it first showed. Below it the listing is
+// taken to point at a different item and a listing alert is sent. Zero
+// disables the check.
+func (pt *PriceTracker) SetListingSimilarity(similarity float64) {
+    pt.statusMu.Lock()
+    defer pt.statusMu.Unlock()
+
+    pt.listingSimilarity = similarity
+}
+
+// checkListing compares the product name a fetched page showed with the
+// name it showed the first time, and alerts when they stop looking like the
+// same item. It only alerts on the transition, so a listing that stays
+// changed doesn't alert every cycle; one that changes back is quiet again.
+func (pt *PriceTracker) checkListing(product Product, title string) {
+    pt.statusMu.Lock()
+    threshold := pt.listingSimilarity
+    webhookURL := pt.webhookURL
+    pt.statusMu.Unlock()
+    if threshold <= 0 || title == "" {
+        return
+    }
+
+    pt.alertsMu.Lock()
+    state, seen := pt.listings[product.ID]
+    if !seen {
+        pt.listings[product.ID] = listingState{title: title}
+        pt.alertsMu.Unlock()
+        return
+    }
+    similarity := titleSimilarity(state.title, title)
+    divergent := similarity < threshold
+    wasDivergent := state.divergent
+    state.divergent = divergent
+    pt.listings[product.ID] = state
+    pt.alertsMu.Unlock()
+
+    if !divergent || wasDivergent {
+        return
+    }
+
+    log.Printf("%s's page now shows %q instead of %q; the listing may be for a different item", product.ID, title, state.title)
+    if webhookURL == "" {
+        return
+    }
+    alert := ListingAlert{
+        Type:          "listing_changed",
+        ProductID:     product.ID,
+        Name:          product.Name,
+        URL:           product.URL,
+        PreviousTitle: state.title,
+        Title:         title,
+        Similarity:    similarity,
+    }
+    pt.notify(webhookURL, alert.Type, product.ID, "listing_changed:"+product.ID+":"+title, alert)
+}
+
+// forgetListing forgets the name a product's page showed, e.g. after its URL
+// changes
+func (pt *PriceTracker) forgetListing(productID string) {
+    pt.alertsMu.Lock()
+    defer pt.alertsMu.Unlock()
+
+    delete(pt.listings, productID)
+}
+
+// titleSimilarity is the Jaccard similarity of two product names' words,
+// ignoring case and punctuation: 1 for the same words, 0 for none in common
+func titleSimilarity(a, b string) float64 {
+    words := func(s string) map[string]bool {
+        set := make(map[string]bool)
+        for _, word := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+            return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+        }) {
+            set[word] = true
+        }
+        return set
+    }
+    wordsA, wordsB := words(a), words(b)
+    if len(wordsA) == 0 && len(wordsB) == 0 {
+        return 1
+    }
+    shared := 0
+    for word := range wordsA {
+        if wordsB[word] {
+            shared++
+        }
+    }
+    return float64(shared) / float64(len(wordsA)+len(wordsB)-shared)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTitleSimilarity(t *testing.T) {
+    tests := []struct {
+        a, b string
+        want float64
+    }{
+        {"Acme Kettle 1.7L", "acme kettle 1.7l", 1},
+        {"Acme Kettle, Steel", "Acme Kettle - Steel | Shop", 0.75},
+        {"Acme Kettle", "Office Chair", 0},
+        {"", "", 1},
+    }
+    for _, tt := range tests {
+        if got := titleSimilarity(tt.a, tt.b); got != tt.want {
+            t.Errorf("titleSimilarity(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+        }
+    }
+}
+
+// newWebhookRecorder returns a webhook URL and a function returning the
+// payloads POSTed to it so far
+func newWebhookRecorder(t *testing.T) (string, func() []map[string]interface{}) {
+    t.Helper()
+    var mu sync.Mutex
+    var payloads []map[string]interface{}
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        var payload map[string]interface{}
+        json.NewDecoder(r.Body).Decode(&payload)
+        mu.Lock()
+        payloads = append(payloads, payload)
+        mu.Unlock()
+    }))
+    t.Cleanup(server.Close)
+    return server.URL, func() []map[string]interface{} {
+        mu.Lock()
+        defer mu.Unlock()
+        return append([]map[string]interface{}(nil), payloads...)
+    }
+}
+
+// waitForPayloads waits for webhooks delivered in the background
+func waitForPayloads(t *testing.T, received func() []map[string]interface{}, n int) []map[string]interface{} {
+    t.Helper()
+    deadline := time.Now().Add(2 * time.Second)
+    for len(received()) < n && time.Now().Before(deadline) {
+        time.Sleep(10 * time.Millisecond)
+    }
+    return received()
+}
+
+func TestListingDivergenceAlertsOnce(t *testing.T) {
+    tracker := newTestTracker(t, nil)
+    webhookURL, received := newWebhookRecorder(t)
+    tracker.SetWebhookURL(webhookURL)
+    tracker.SetListingSimilarity(0.5)
+    product := Product{ID: "listing-kettle", Name: "Kettle", URL: "https://shop.test/kettle"}
+
+    for _, title := range []string{
+        "Acme Kettle 1.7L Steel",
+        "Acme Kettle 1.7L Steel | Shop", // similar enough
+        "Office Chair",                  // diverges: alert
+        "Office Chair",                  // still diverged: no new alert
+        "Acme Kettle 1.7L Steel",        // back to normal
+        "",                              // no title read
+    } {
+        tracker.checkListing(product, title)
+    }
+
+    // give a second, unwanted alert time to arrive
+    waitForPayloads(t, received, 1)
+    time.Sleep(50 * time.Millisecond)
+    payloads := received()
+    if len(payloads) != 1 {
+        t.Fatalf("got %d alerts, want 1: %v", len(payloads), payloads)
+    }
+    alert := payloads[0]
+    if alert["type"] != "listing_changed" || alert["product_id"] != "listing-kettle" || alert["previous_title"] != "Acme Kettle 1.7L Steel" || alert["title"] != "Office Chair" {
+        t.Errorf("alert = %v", alert)
+    }
+
+    // a diverging listing alerts again after it came back
+    tracker.checkListing(product, "Office Chair")
+    if payloads := waitForPayloads(t, received, 2); len(payloads) != 2 {
+        t.Errorf("got %d alerts, want a second after the listing changed again", len(payloads))
+    }
+}
+
+func TestListingDivergenceDisabled(t *testing.T) {
+    tracker := newTestTracker(t, nil)
+    webhookURL, received := newWebhookRecorder(t)
+    tracker.SetWebhookURL(webhookURL)
+    product := Product{ID: "listing-disabled", Name: "Kettle", URL: "https://shop.test/kettle"}
+
+    tracker.checkListing(product, "Acme Kettle")
+    tracker.checkListing(product, "Office Chair")
+    time.Sleep(50 * time.Millisecond)
+    if payloads := received(); len(payloads) != 0 {
+        t.Errorf("alerts sent with the check disabled: %v", payloads)
+    }
+}
+
+func TestListingDivergenceFromScrapedPages(t *testing.T) {
+    var mu sync.Mutex
+    title := "Acme Kettle 1.7L"
+    pages := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/robots.txt" {
+            http.NotFound(w, r)
+            return
+        }
+        mu.Lock()
+        defer mu.Unlock()
+        w.Write([]byte(`<html><head><title>` + title + `</title></head><body><span class="price">$39.99</span></body></html>`))
+    }))
+    t.Cleanup(pages.Close)
+
+    tracker := newTestTracker(t, nil)
+    webhookURL, received := newWebhookRecorder(t)
+    tracker.SetWebhookURL(webhookURL)
+    tracker.SetListingSimilarity(0.5)
+    product, err := tracker.CreateProduct(Product{ID: "listing-scraped", Name: "Kettle", URL: pages.URL + "/kettle", PriceSelector: ".price"})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if _, err := tracker.CheckProduct(context.Background(), product.ID); err != nil {
+        t.Fatalf("first check: %v", err)
+    }
+    mu.Lock()
+    title = "Office Chair"
+    mu.Unlock()
+    if _, err := tracker.CheckProduct(context.Background(), product.ID); err != nil {
+        t.Fatalf("second check: %v", err)
+    }
+
+    payloads := waitForPayloads(t, received, 1)
+    if len(payloads) != 1 || payloads[0]["previous_title"] != "Acme Kettle 1.7L" || payloads[0]["title"] != "Office Chair" {
+        t.Errorf("alerts = %v", payloads)
+    }
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// listPriceTypes are the schema.org priceType values that mark a
+// priceSpecification as the undiscounted price
+var listPriceTypes = []string{"listprice", "strikethroughprice", "msrp", "suggestedretailprice"}
+
+// listPriceMeta are the prefixes of the amount and currency meta tags
+// stores use for the price before a discount
+var listPriceMeta = []string{"product:original_price:", "og:original_price:"}
+
+// pageListPrice reads the product's list price, the price before any
+// discount, from the page's structured data: a JSON-LD priceSpecification
+// typed as a list or strikethrough price, then the original price meta
+// tags. It returns 0 when the page doesn't show one in the product's
+// currency.
+func pageListPrice(root *goquery.Document, product Product) float64 {
+    want := product.Currency
+    if want == "" {
+        want = DefaultCurrency
+    }
+    matches := func(p structuredPrice) bool {
+        return p.currency == "" || strings.EqualFold(p.currency, want)
+    }
+
+    for _, data := range jsonLDBlocks(root) {
+        for _, p := range findListPrices(data, "", false) {
+            if matches(p) && p.price > 0 {
+                return p.price
+            }
+        }
+    }
+
+    for _, prefix := range listPriceMeta {
+        amount := metaContent(root, prefix+"amount")
+        if amount == "" {
+            continue
+        }
+        price, err := parsePrice(amount)
+        if err == nil && matches(structuredPrice{price: price, currency: metaContent(root, prefix+"currency")}) {
+            return price
+        }
+    }
+    return 0
+}
+
+// findListPrices collects the list prices of every offer in a JSON-LD
+// document. currency is the enclosing offer's priceCurrency, which a
+// priceSpecification without its own inherits.
+func findListPrices(value interface{}, currency string, inOffer bool) []structuredPrice {
+    var prices []structuredPrice
+    switch v := value.(type) {
+    case []interface{}:
+        for _, item := range v {
+            prices = append(prices, findListPrices(item, currency, inOffer)...)
+        }
+    case map[string]interface{}:
+        if c, ok := v["priceCurrency"].(string); ok {
+            currency = c
+        }
+        if inOffer && isListPriceType(v["priceType"]) {
+            if price, ok := jsonLDNumber(v["price"]); ok {
+                return []structuredPrice{{price: price, currency: currency}}
+            }
+        }
+        for _, key := range sortedKeys(v) {
+            prices = append(prices, findListPrices(v[key], currency, inOffer || key == "offers")...)
+        }
+    }
+    return prices
+}
+
+func isListPriceType(value interface{}) bool {
+    priceType, ok := value.(string)
+    if !ok {
+        return false
+    }
+    priceType = strings.ToLower(priceType)
+    if i := strings.LastIndex(priceType, "/"); i >= 0 {
+        priceType = priceType[i+1:]
+    }
+    for _, listType := range listPriceTypes {
+        if priceType == listType {
+            return true
+        }
+    }
+    return false
+}
@@ -2,10 +2,12 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
@@ -13,19 +15,58 @@ import (
 )
 
 func main() {
+    importPath := flag.String("import", "", "CSV file of products (id,name,url[,selector,xpath,regex,target_price]) to add at startup")
+    flag.Parse()
+
+    cfg, err := LoadConfig()
+    if err != nil {
+        log.Fatal("Invalid configuration: ", err)
+    }
+
     // Initialize database
-    db, err := NewDatabase("prices.db")
+    db, err := NewDatabase(cfg.DBPath)
     if err != nil {
         log.Fatal("Failed to initialize database:", err)
     }
     defer db.Close()
-
-    // Create tracker
-    tracker := NewPriceTracker(db)
+    db.SetSlowQueryThreshold(cfg.SlowQueryThreshold)
+
+    // Create tracker; the default fetcher scrapes products with a price
+    // selector and simulates the rest, using retailer APIs where configured
+    fetcher := DefaultFetcher{Shopify: &ShopifyFetcher{}}
+    if cfg.AmazonAccessKey != "" {
+        fetcher.Amazon = &AmazonFetcher{
+            AccessKey:   cfg.AmazonAccessKey,
+            SecretKey:   cfg.AmazonSecretKey,
+            PartnerTag:  cfg.AmazonPartnerTag,
+            PreferPrime: cfg.AmazonPreferPrime,
+        }
+        log.Printf("Pricing Amazon products through the Product Advertising API")
+    }
+    if cfg.EBayToken != "" {
+        fetcher.EBay = &EBayFetcher{Token: cfg.EBayToken}
+        log.Printf("Pricing eBay items through the Browse API")
+    }
+    if cfg.BestBuyAPIKey != "" {
+        fetcher.BestBuy = &BestBuyFetcher{APIKey: cfg.BestBuyAPIKey}
+        log.Printf("Pricing Best Buy products through the Products API")
+    }
+    if cfg.WalmartConsumerID != "" {
+        fetcher.Walmart, err = NewWalmartFetcher(cfg.WalmartConsumerID, cfg.WalmartKeyVersion, cfg.WalmartPrivateKeyFile)
+        if err != nil {
+            log.Fatal("Invalid Walmart API configuration: ", err)
+        }
+        log.Printf("Pricing Walmart products through the Affiliate API")
+    }
+    if cfg.GoogleShoppingAPIKey != "" {
+        fetcher.GoogleShopping = &GoogleShoppingFetcher{APIKey: cfg.GoogleShoppingAPIKey, Endpoint: cfg.GoogleShoppingEndpoint}
+        log.Printf("Pricing Google Shopping searches through the results API")
+    }
+    tracker := NewPriceTracker(db, fetcher)
 
     // Add some sample products to track
     sampleProducts := []Product{
-        {ID: "laptop-1", Name: "Gaming Laptop", URL: "https://example.com/laptop-1"},
+        {ID: "laptop-1", Name: "Gaming Laptop", URL: "https://example.com/laptop-1", Priority: PriorityHigh},
         {ID: "phone-1", Name: "Smartphone X", URL: "https://example.com/phone-1"},
         {ID: "tablet-1", Name: "Tablet Pro", URL: "https://example.com/tablet-1"},
     }
@@ -36,22 +77,73 @@ func main() {
         }
     }
 
+    if *importPath != "" {
+        if err := importProducts(tracker, *importPath); err != nil {
+            log.Fatal("Failed to import products: ", err)
+        }
+    }
+
+    // POST target price alerts here; empty disables them
+    tracker.SetWebhookURL(cfg.WebhookURL)
+    tracker.SetNotificationPolicy(cfg.NotifyAttempts, cfg.NotifyRetryDelay)
+    tracker.SetListingSimilarity(cfg.ListingSimilarity)
+    tracker.SetMedianAlerts(cfg.MedianAlerts)
+
     // start price tracking in background
     ctx, cancel := context.WithCancel(context.Background())
     defer cancel()
 
-    go tracker.StartTracking(ctx, 30*time.Second) // check prices every 30 seconds
+    tracker.SetWatchdogThreshold(cfg.WatchdogThreshold)
+    tracker.SetSaleInterval(cfg.SaleInterval)
+    tracker.SetMedianSampling(cfg.MedianSamples, cfg.MedianWindow)
+    tracker.SetMinSignificantChange(0.01, 0) // ignore sub-cent drift when detecting price changes
+    tracker.SetDuplicateEpsilon(cfg.DuplicateEpsilon)
+    tracker.SetNumWorkers(cfg.NumWorkers)
+    tracker.SetFetchTimeout(cfg.FetchTimeout)
+    tracker.SetRetryPolicy(cfg.FetchAttempts, cfg.RetryDelay)
+    tracker.SetHostRateLimit(cfg.HostRateLimit, cfg.HostRateBurst)
+    tracker.SetHostConcurrency(cfg.HostConcurrency)
+    tracker.SetCircuitBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown)
+    SetUserAgents(cfg.UserAgents, cfg.UserAgentStickiness)
+    SetProxies(cfg.Proxies, cfg.ProxyStrategy)
+    if len(cfg.Proxies) > 0 {
+        log.Printf("Fetching pages through %d proxies (%s)", len(cfg.Proxies), cfg.ProxyStrategy)
+    }
+
+    // background writers to the database; shutdown waits for them before
+    // closing it
+    var background sync.WaitGroup
+    background.Add(3)
+    go func() {
+        defer background.Done()
+        tracker.StartTracking(ctx, cfg.TrackInterval)
+    }()
+    go func() {
+        defer background.Done()
+        tracker.StartRetention(ctx, cfg.Retention)
+    }()
+    go func() {
+        defer background.Done()
+        tracker.StartNotifications(ctx)
+    }()
 
     // create and start HTTP server
     server := NewAPIServer(tracker)
+    server.SetAPIKey(cfg.APIKey)
+    server.SetTenantKeys(cfg.TenantKeys)
+    server.SetExportAnonymization(cfg.AnonymizationKey, cfg.AnonymizeExports)
+    if cfg.GraphQL {
+        server.EnableGraphQL()
+    }
+    server.SetExchangeRates(NewExchangeRates(cfg.OpenExchangeRatesAppID, cfg.ExchangeRatesTTL))
     httpServer := &http.Server{
-        Addr:    ":8080",
+        Addr:    cfg.ListenAddr,
         Handler: server.router,
     }
 
     // start server in goroutine
     go func() {
-        log.Println("Starting HTTP server on :8080")
+        log.Printf("Starting HTTP server on %s", cfg.ListenAddr)
         if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
             log.Fatal("HTTP server failed:", err)
         }
@@ -72,6 +164,36 @@ func main() {
         log.Printf("Server shutdown error: %v", err)
     }
 
+    // stop tracking and let an in-flight cycle store what it has fetched
+    // before the deferred db.Close runs
+    cancel()
+    stopped := make(chan struct{})
+    go func() {
+        background.Wait()
+        close(stopped)
+    }()
+    select {
+    case <-stopped:
+    case <-shutdownCtx.Done():
+        log.Println("Timed out waiting for price tracking to stop")
+    }
+
     log.Println("Server stopped")
 }
 
+// importProducts adds the products listed in a CSV file and logs a summary
+func importProducts(tracker *PriceTracker, path string) error {
+    file, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    summary, err := tracker.ImportProducts(file, "")
+    if err != nil {
+        return err
+    }
+    log.Printf("Imported products from %s: %d inserted, %d skipped, %d failed",
+        path, summary.Inserted, summary.Skipped, summary.Failed)
+    return nil
+}
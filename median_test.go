@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMedianPriceSmoothsNoise(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    // a steady price with a one-off blip in the latest fetch
+    addTestProduct(t, server.tracker, "median-noisy", 100, 101, 99, 100, 60)
+
+    latestAndMedian := func() (float64, float64) {
+        t.Helper()
+        var page ProductPage
+        serve(t, server, "GET", "/api/v1/products", "", &page)
+        if len(page.Items) != 1 || page.Items[0].LatestPrice == nil || page.Items[0].MedianPrice == nil {
+            t.Fatalf("products = %+v, want the latest and median prices", page.Items)
+        }
+        return *page.Items[0].LatestPrice, *page.Items[0].MedianPrice
+    }
+
+    server.tracker.SetMedianSampling(5, 6*time.Hour)
+    if latest, median := latestAndMedian(); latest != 60 || median != 100 {
+        t.Errorf("latest %v, median %v; want the raw blip 60 and the median 100", latest, median)
+    }
+
+    // fewer samples follow the blip more closely
+    server.tracker.SetMedianSampling(3, 6*time.Hour)
+    if _, median := latestAndMedian(); median != 99 {
+        t.Errorf("median of 3 = %v, want 99", median)
+    }
+
+    // a short window only holds the blip
+    server.tracker.SetMedianSampling(5, 90*time.Minute)
+    if _, median := latestAndMedian(); median != 60 {
+        t.Errorf("median within 90m = %v, want 60", median)
+    }
+}
+
+func TestMedianOf(t *testing.T) {
+    if got := medianOf([]float64{3, 1, 2}); got != 2 {
+        t.Errorf("odd median = %v, want 2", got)
+    }
+    if got := medianOf([]float64{4, 1, 3, 2}); got != 2.5 {
+        t.Errorf("even median = %v, want 2.5", got)
+    }
+}
+
+// newNoisyTargetProduct adds a product with a steady price of about 100 and
+// a target price of 80
+func newNoisyTargetProduct(t *testing.T, tracker *PriceTracker, id string) Product {
+    t.Helper()
+    product := addTestProduct(t, tracker, id, 100, 101, 99, 100)
+    target := 80.0
+    product.TargetPrice = &target
+    product, err := tracker.UpdateProduct(product)
+    if err != nil {
+        t.Fatal(err)
+    }
+    tracker.SetMedianSampling(5, 6*time.Hour)
+    return product
+}
+
+func storeTestPrice(t *testing.T, tracker *PriceTracker, product Product, price float64) {
+    t.Helper()
+    entry := newPriceEntry(product, PriceReading{Price: price})
+    if _, err := tracker.storePrice(&entry, nil, 0); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestRawAlertsFollowBlips(t *testing.T) {
+    tracker := newTestTracker(t, nil)
+    webhookURL, received := newWebhookRecorder(t)
+    tracker.SetWebhookURL(webhookURL)
+    product := newNoisyTargetProduct(t, tracker, "median-raw")
+
+    storeTestPrice(t, tracker, product, 60)
+    payloads := waitForPayloads(t, received, 1)
+    if len(payloads) != 1 || payloads[0]["price"] != 60.0 {
+        t.Errorf("alerts = %v, want one for the raw blip", payloads)
+    }
+}
+
+func TestMedianAlertsIgnoreBlips(t *testing.T) {
+    tracker := newTestTracker(t, nil)
+    webhookURL, received := newWebhookRecorder(t)
+    tracker.SetWebhookURL(webhookURL)
+    tracker.SetMedianAlerts(true)
+    product := newNoisyTargetProduct(t, tracker, "median-alerts")
+
+    // one or two low fetches leave the median near 100
+    storeTestPrice(t, tracker, product, 60)
+    storeTestPrice(t, tracker, product, 61)
+    time.Sleep(50 * time.Millisecond)
+    if payloads := received(); len(payloads) != 0 {
+        t.Fatalf("alerts = %v, want none while most fetches are near 100", payloads)
+    }
+
+    // once most recent fetches are low, the median is too
+    storeTestPrice(t, tracker, product, 59)
+    payloads := waitForPayloads(t, received, 1)
+    if len(payloads) != 1 || payloads[0]["price"] != 61.0 {
+        t.Errorf("alerts = %v, want one at the median 61, not the raw 59", payloads)
+    }
+}
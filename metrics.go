@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// trackerMetrics are the Prometheus collectors served at /metrics. Each
+// tracker has its own registry so creating several trackers never registers
+// a collector twice.
+type trackerMetrics struct {
+    registry      *prometheus.Registry
+    fetches       prometheus.Counter
+    fetchFailures *prometheus.CounterVec
+    fetchBlocks   *prometheus.CounterVec
+    fetchDuration prometheus.Histogram
+}
+
+func newTrackerMetrics(pt *PriceTracker) *trackerMetrics {
+    m := &trackerMetrics{
+        registry: prometheus.NewRegistry(),
+        fetches: prometheus.NewCounter(prometheus.CounterOpts{
+            Name: "price_tracker_fetches_total",
+            Help: "Price fetch attempts, including retries and validation runs.",
+        }),
+        fetchFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+            Name: "price_tracker_fetch_failures_total",
+            Help: "Price fetch attempts that failed, by product.",
+        }, []string{"product"}),
+        fetchBlocks: prometheus.NewCounterVec(prometheus.CounterOpts{
+            Name: "price_tracker_fetch_blocks_total",
+            Help: "Price fetch attempts refused by anti-bot protection or rate limiting, by product.",
+        }, []string{"product"}),
+        fetchDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+            Name:    "price_tracker_fetch_duration_seconds",
+            Help:    "How long each price fetch attempt took.",
+            Buckets: prometheus.DefBuckets,
+        }),
+    }
+
+    products := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+        Name: "price_tracker_products",
+        Help: "Number of tracked products.",
+    }, func() float64 {
+        pt.mu.RLock()
+        defer pt.mu.RUnlock()
+        return float64(len(pt.products))
+    })
+
+    m.registry.MustRegister(m.fetches, m.fetchFailures, m.fetchBlocks, m.fetchDuration, products, proxyCollector{})
+    return m
+}
+
+// MetricsHandler serves the tracker's metrics in the Prometheus exposition
+// format
+func (pt *PriceTracker) MetricsHandler() http.Handler {
+    return promhttp.HandlerFor(pt.metrics.registry, promhttp.HandlerOpts{})
+}
+
+// observeFetch records one fetch attempt that started at start
+func (m *trackerMetrics) observeFetch(productID string, start time.Time, err error) {
+    m.fetches.Inc()
+    m.fetchDuration.Observe(time.Since(start).Seconds())
+    if err != nil {
+        m.fetchFailures.WithLabelValues(productID).Inc()
+    }
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+    fetcher := PriceFetcherFunc(func(ctx context.Context, product Product) (float64, error) {
+        if product.ID == "metrics-broken" {
+            return 0, errors.New("no price on the page")
+        }
+        return 10, nil
+    })
+    tracker := newTestTracker(t, fetcher)
+    tracker.SetRetryPolicy(1, 0)
+    server := NewAPIServer(tracker)
+    addTestProduct(t, tracker, "metrics-ok")
+    addTestProduct(t, tracker, "metrics-broken")
+
+    tracker.trackProducts(context.Background(), tracker.snapshotProducts(), nil)
+
+    rec := serve(t, server, "GET", "/metrics", "", nil)
+    if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+        t.Fatalf("metrics = %d %q", rec.Code, rec.Header().Get("Content-Type"))
+    }
+    samples := map[string]bool{}
+    for _, line := range strings.Split(rec.Body.String(), "\n") {
+        if line != "" && !strings.HasPrefix(line, "#") {
+            samples[line] = true
+        }
+    }
+    for _, want := range []string{
+        "price_tracker_fetches_total 2",
+        `price_tracker_fetch_failures_total{product="metrics-broken"} 1`,
+        "price_tracker_fetch_duration_seconds_count 2",
+        "price_tracker_products 2",
+    } {
+        if !samples[want] {
+            t.Errorf("no %q in the metrics:\n%s", want, rec.Body)
+        }
+    }
+    for sample := range samples {
+        if strings.Contains(sample, `product="metrics-ok"`) {
+            t.Errorf("unexpected sample %q: the product didn't fail", sample)
+        }
+    }
+}
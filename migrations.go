@@ -0,0 +1,294 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// migration is one step of the schema. Migrations run in order on startup,
+// each in its own transaction, and a database's schema version is the number
+// of migrations applied to it.
+type migration struct {
+    name string
+    up   func(tx *sql.Tx) error
+}
+
+// migrations must only ever be appended to. Databases created before
+// versioning existed are at version 0 with any subset of the baseline
+// columns, so the baseline is written to be safe to re-run.
+var migrations = []migration{
+    {"baseline schema", migrateBaseline},
+    {"add products.price_xpath", addColumn("products", "price_xpath", "TEXT NOT NULL DEFAULT ''")},
+    {"add products.render_js", addColumn("products", "render_js", "INTEGER NOT NULL DEFAULT 0")},
+    {"add products.price_regex", addColumn("products", "price_regex", "TEXT NOT NULL DEFAULT ''")},
+    {"add products.fetch_profile", addColumn("products", "fetch_profile", "TEXT NOT NULL DEFAULT ''")},
+    {"add products.ignore_robots", addColumn("products", "ignore_robots", "INTEGER NOT NULL DEFAULT 0")},
+    {"create scrape_errors", execAll(
+        `CREATE TABLE scrape_errors (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            product_id TEXT NOT NULL,
+            error TEXT NOT NULL,
+            attempts INTEGER NOT NULL,
+            occurred_at DATETIME NOT NULL,
+            FOREIGN KEY (product_id) REFERENCES products (id)
+        )`,
+        `CREATE INDEX idx_scrape_errors_product_id ON scrape_errors (product_id, occurred_at)`,
+    )},
+    {"create page_snapshots", execAll(
+        `CREATE TABLE page_snapshots (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            product_id TEXT NOT NULL,
+            url TEXT NOT NULL,
+            error TEXT NOT NULL,
+            size INTEGER NOT NULL,
+            truncated INTEGER NOT NULL DEFAULT 0,
+            taken_at DATETIME NOT NULL,
+            body BLOB NOT NULL,
+            FOREIGN KEY (product_id) REFERENCES products (id)
+        )`,
+        `CREATE INDEX idx_page_snapshots_product_id ON page_snapshots (product_id, taken_at)`,
+    )},
+    {"add price_entries.in_stock", addColumn("price_entries", "in_stock", "INTEGER")},
+    {"add products.list_price_selector", addColumn("products", "list_price_selector", "TEXT NOT NULL DEFAULT ''")},
+    {"add price_entries.list_price", addColumn("price_entries", "list_price", "REAL")},
+    {"add price_entries.listing_type", addColumn("price_entries", "listing_type", "TEXT NOT NULL DEFAULT ''")},
+    {"add products.price_locale", addColumn("products", "price_locale", "TEXT NOT NULL DEFAULT ''")},
+    {"add products.fetcher", addColumn("products", "fetcher", "TEXT NOT NULL DEFAULT ''")},
+    {"add products.price_script", addColumn("products", "price_script", "TEXT NOT NULL DEFAULT ''")},
+    {"add products.shipping_selector", addColumn("products", "shipping_selector", "TEXT NOT NULL DEFAULT ''")},
+    {"add price_entries.shipping", addColumn("price_entries", "shipping", "REAL")},
+    {"create archived_price_entries", execAll(
+        `CREATE TABLE archived_price_entries (
+            id INTEGER PRIMARY KEY,
+            product_id TEXT NOT NULL,
+            product_name TEXT NOT NULL DEFAULT '',
+            price REAL NOT NULL,
+            currency TEXT NOT NULL DEFAULT 'USD',
+            in_stock INTEGER,
+            list_price REAL,
+            listing_type TEXT NOT NULL DEFAULT '',
+            shipping REAL,
+            timestamp DATETIME NOT NULL,
+            is_outlier INTEGER NOT NULL DEFAULT 0,
+            archived_at DATETIME NOT NULL
+        )`,
+        `CREATE INDEX idx_archived_price_entries_product_id ON archived_price_entries (product_id, timestamp)`,
+    )},
+    {"add products.tags", addColumn("products", "tags", "TEXT NOT NULL DEFAULT ''")},
+    {"add products.notes", addColumn("products", "notes", "TEXT NOT NULL DEFAULT ''")},
+    // the index keeps its own copy of the text, keyed by product id, and
+    // triggers keep it in step. Products are written with INSERT OR REPLACE,
+    // which doesn't fire delete triggers, so inserts clear any old row first.
+    {"create products_fts", execAll(
+        `CREATE VIRTUAL TABLE products_fts USING fts5(product_id UNINDEXED, name, url, tags, notes)`,
+        `INSERT INTO products_fts (product_id, name, url, tags, notes) SELECT id, name, url, tags, notes FROM products`,
+        `CREATE TRIGGER products_fts_insert AFTER INSERT ON products BEGIN
+            DELETE FROM products_fts WHERE product_id = new.id;
+            INSERT INTO products_fts (product_id, name, url, tags, notes) VALUES (new.id, new.name, new.url, new.tags, new.notes);
+        END`,
+        `CREATE TRIGGER products_fts_update AFTER UPDATE ON products BEGIN
+            DELETE FROM products_fts WHERE product_id = old.id;
+            INSERT INTO products_fts (product_id, name, url, tags, notes) VALUES (new.id, new.name, new.url, new.tags, new.notes);
+        END`,
+        `CREATE TRIGGER products_fts_delete AFTER DELETE ON products BEGIN
+            DELETE FROM products_fts WHERE product_id = old.id;
+        END`,
+    )},
+    {"add products.tenant", execAll(
+        `ALTER TABLE products ADD COLUMN tenant TEXT NOT NULL DEFAULT ''`,
+        `CREATE INDEX idx_products_tenant ON products (tenant, name)`,
+    )},
+    {"add archived_price_entries.tenant", addColumn("archived_price_entries", "tenant", "TEXT NOT NULL DEFAULT ''")},
+    {"add products.fingerprint_selector", addColumn("products", "fingerprint_selector", "TEXT NOT NULL DEFAULT ''")},
+    {"create page_fingerprints", execAll(
+        `CREATE TABLE page_fingerprints (
+            product_id TEXT PRIMARY KEY,
+            url TEXT NOT NULL,
+            selector TEXT NOT NULL,
+            fingerprint TEXT NOT NULL,
+            previous TEXT NOT NULL DEFAULT '',
+            distance INTEGER NOT NULL DEFAULT 0,
+            checked_at DATETIME NOT NULL,
+            changed_at DATETIME,
+            FOREIGN KEY (product_id) REFERENCES products (id)
+        )`,
+    )},
+    {"create notifications", execAll(
+        `CREATE TABLE notifications (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            kind TEXT NOT NULL,
+            product_id TEXT NOT NULL,
+            dedupe_key TEXT NOT NULL,
+            url TEXT NOT NULL,
+            payload TEXT NOT NULL,
+            status TEXT NOT NULL DEFAULT 'pending',
+            attempts INTEGER NOT NULL DEFAULT 0,
+            last_error TEXT NOT NULL DEFAULT '',
+            created_at DATETIME NOT NULL,
+            next_attempt_at DATETIME NOT NULL,
+            delivered_at DATETIME
+        )`,
+        `CREATE INDEX idx_notifications_due ON notifications (status, next_attempt_at)`,
+        `CREATE INDEX idx_notifications_dedupe ON notifications (dedupe_key, status)`,
+    )},
+}
+
+// migrate brings the schema up to the latest version
+func (d *Database) migrate() error {
+    _, err := d.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+        version INTEGER PRIMARY KEY,
+        name TEXT NOT NULL,
+        applied_at DATETIME NOT NULL
+    )`)
+    if err != nil {
+        return err
+    }
+
+    version, err := d.SchemaVersion()
+    if err != nil {
+        return err
+    }
+    if version > len(migrations) {
+        return fmt.Errorf("database schema version %d is newer than this build supports (%d)", version, len(migrations))
+    }
+
+    for i := version; i < len(migrations); i++ {
+        m := migrations[i]
+        log.Printf("Applying schema migration %d: %s", i+1, m.name)
+        if err := d.applyMigration(i+1, m); err != nil {
+            return fmt.Errorf("migration %d (%s): %w", i+1, m.name, err)
+        }
+    }
+    return nil
+}
+
+func (d *Database) applyMigration(version int, m migration) error {
+    tx, err := d.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    if err := m.up(tx); err != nil {
+        return err
+    }
+    _, err = tx.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+        version, m.name, time.Now().UTC())
+    if err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+// SchemaVersion returns how many migrations have been applied
+func (d *Database) SchemaVersion() (int, error) {
+    var version int
+    err := d.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+    return version, err
+}
+
+// migrateBaseline creates the schema as it stood when versioning was
+// introduced, adding any columns an older database is missing
+func migrateBaseline(tx *sql.Tx) error {
+    queries := []string{
+        `CREATE TABLE IF NOT EXISTS products (
+            id TEXT PRIMARY KEY,
+            name TEXT NOT NULL,
+            url TEXT NOT NULL,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        )`,
+        `CREATE TABLE IF NOT EXISTS price_entries (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            product_id TEXT NOT NULL,
+            price REAL NOT NULL,
+            timestamp DATETIME NOT NULL,
+            FOREIGN KEY (product_id) REFERENCES products (id)
+        )`,
+        `CREATE INDEX IF NOT EXISTS idx_price_entries_product_id ON price_entries (product_id)`,
+        `CREATE INDEX IF NOT EXISTS idx_price_entries_timestamp ON price_entries (timestamp)`,
+        `CREATE TABLE IF NOT EXISTS sale_windows (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            product_id TEXT NOT NULL,
+            name TEXT NOT NULL,
+            starts_at DATETIME NOT NULL,
+            ends_at DATETIME NOT NULL,
+            FOREIGN KEY (product_id) REFERENCES products (id)
+        )`,
+        `CREATE INDEX IF NOT EXISTS idx_sale_windows_product_id ON sale_windows (product_id)`,
+    }
+
+    for _, query := range queries {
+        if _, err := tx.Exec(query); err != nil {
+            return err
+        }
+    }
+
+    // columns that were added to existing tables before versioning
+    columns := []struct{ table, column, definition string }{
+        {"price_entries", "is_outlier", "INTEGER NOT NULL DEFAULT 0"},
+        {"products", "min_change", "REAL NOT NULL DEFAULT 0"},
+        {"products", "min_change_percent", "REAL NOT NULL DEFAULT 0"},
+        {"products", "priority", "TEXT NOT NULL DEFAULT 'normal'"},
+        {"products", "image_url", "TEXT NOT NULL DEFAULT ''"},
+        {"products", "price_selector", "TEXT NOT NULL DEFAULT ''"},
+        {"products", "target_price", "REAL"},
+        {"products", "currency", "TEXT NOT NULL DEFAULT 'USD'"},
+        {"price_entries", "currency", "TEXT NOT NULL DEFAULT 'USD'"},
+        {"products", "interval_seconds", "INTEGER NOT NULL DEFAULT 0"},
+    }
+    for _, c := range columns {
+        if err := addColumnIfMissing(tx, c.table, c.column, c.definition); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// addColumn returns a migration adding one column to a table
+func addColumn(table, column, definition string) func(tx *sql.Tx) error {
+    return func(tx *sql.Tx) error {
+        _, err := tx.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + definition)
+        return err
+    }
+}
+
+// execAll returns a migration running each statement in turn
+func execAll(statements ...string) func(tx *sql.Tx) error {
+    return func(tx *sql.Tx) error {
+        for _, statement := range statements {
+            if _, err := tx.Exec(statement); err != nil {
+                return err
+            }
+        }
+        return nil
+    }
+}
+
+// addColumnIfMissing adds a column to an existing table unless it is already there
+func addColumnIfMissing(tx *sql.Tx, table, column, definition string) error {
+    rows, err := tx.Query(`SELECT name FROM pragma_table_info(?)`, table)
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var name string
+        if err := rows.Scan(&name); err != nil {
+            return err
+        }
+        if name == column {
+            return nil
+        }
+    }
+    if err := rows.Err(); err != nil {
+        return err
+    }
+    rows.Close()
+
+    _, err = tx.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + definition)
+    return err
+}
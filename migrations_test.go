@@ -0,0 +1,130 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// tableColumns returns a table's column names in order
+func tableColumns(t *testing.T, db *sql.DB, table string) []string {
+    t.Helper()
+    rows, err := db.Query(`SELECT name FROM pragma_table_info(?) ORDER BY cid`, table)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer rows.Close()
+    var columns []string
+    for rows.Next() {
+        var name string
+        if err := rows.Scan(&name); err != nil {
+            t.Fatal(err)
+        }
+        columns = append(columns, name)
+    }
+    return columns
+}
+
+func TestMigrateEmptyDatabase(t *testing.T) {
+    db, err := NewDatabase(filepath.Join(t.TempDir(), "prices.db"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer db.Close()
+
+    if version, err := db.SchemaVersion(); err != nil || version != len(migrations) {
+        t.Fatalf("version = %d, %v; want %d", version, err, len(migrations))
+    }
+    rows, err := db.db.Query(`SELECT version, name FROM schema_migrations ORDER BY version`)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer rows.Close()
+    i := 0
+    for ; rows.Next(); i++ {
+        var version int
+        var name string
+        rows.Scan(&version, &name)
+        if version != i+1 || name != migrations[i].name {
+            t.Errorf("migration row %d = %d %q, want %d %q", i, version, name, i+1, migrations[i].name)
+        }
+    }
+    if i != len(migrations) {
+        t.Errorf("recorded %d migrations, want %d", i, len(migrations))
+    }
+}
+
+func TestMigrateUnversionedDatabase(t *testing.T) {
+    // a database from before versioning: the original tables, one column
+    // added since, and some data
+    path := filepath.Join(t.TempDir(), "prices.db")
+    old, err := sql.Open("sqlite", path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    stamp := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+    for _, query := range []string{
+        `CREATE TABLE products (
+            id TEXT PRIMARY KEY,
+            name TEXT NOT NULL,
+            url TEXT NOT NULL,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            target_price REAL
+        )`,
+        `CREATE TABLE price_entries (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            product_id TEXT NOT NULL,
+            price REAL NOT NULL,
+            timestamp DATETIME NOT NULL,
+            FOREIGN KEY (product_id) REFERENCES products (id)
+        )`,
+        `INSERT INTO products (id, name, url, target_price) VALUES ('legacy-kettle', 'Kettle', 'https://shop.test/kettle', 40)`,
+    } {
+        if _, err := old.Exec(query); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if _, err := old.Exec(`INSERT INTO price_entries (product_id, price, timestamp) VALUES (?, ?, ?)`, "legacy-kettle", 49.99, stamp); err != nil {
+        t.Fatal(err)
+    }
+    old.Close()
+
+    db, err := NewDatabase(path)
+    if err != nil {
+        t.Fatalf("upgrading: %v", err)
+    }
+    defer db.Close()
+    if version, err := db.SchemaVersion(); err != nil || version != len(migrations) {
+        t.Fatalf("version = %d, %v; want %d", version, err, len(migrations))
+    }
+
+    // the same schema as a new database
+    fresh, err := NewDatabase(filepath.Join(t.TempDir(), "fresh.db"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer fresh.Close()
+    for _, table := range []string{"products", "price_entries"} {
+        upgraded, want := tableColumns(t, db.db, table), tableColumns(t, fresh.db, table)
+        slices.Sort(upgraded)
+        slices.Sort(want)
+        if !slices.Equal(upgraded, want) {
+            t.Errorf("%s columns = %v, want %v", table, upgraded, want)
+        }
+    }
+
+    // and the data survived
+    products, err := db.GetAllProducts()
+    if err != nil || len(products) != 1 {
+        t.Fatalf("products = %v, %v", products, err)
+    }
+    if p := products[0]; p.ID != "legacy-kettle" || p.Name != "Kettle" || p.TargetPrice == nil || *p.TargetPrice != 40 || p.Currency != DefaultCurrency || p.Priority != PriorityNormal {
+        t.Errorf("product = %+v", p)
+    }
+    history, err := db.GetPriceHistory("legacy-kettle", 10, true)
+    if err != nil || len(history) != 1 || history[0].Price != 49.99 || history[0].Currency != DefaultCurrency || !history[0].Timestamp.Equal(stamp) {
+        t.Errorf("history = %+v, %v", history, err)
+    }
+}
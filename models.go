@@ -1,27 +1,617 @@
 package main
 
 import (
+	"encoding/json"
 	"time"
 )
 
 // Product represents a product to track
 type Product struct {
-    ID   string `json:"id" db:"id"`
-    Name string `json:"name" db:"name"`
-    URL  string `json:"url" db:"url"`
+    ID       string `json:"id" db:"id"`
+    Name     string `json:"name" db:"name"`
+    URL      string `json:"url" db:"url"`
+    ImageURL string `json:"image_url,omitempty" db:"image_url"`
+
+    // Tenant is the namespace of the API key that added the product. Each
+    // tenant only sees its own products; the default namespace is empty.
+    // It is set by the server, never from a request body.
+    Tenant string `json:"tenant,omitempty" db:"tenant"`
+
+    // Tags and Notes are free-form labels and text for finding and
+    // organizing products; they are searched but don't affect tracking
+    Tags  []string `json:"tags,omitempty" db:"tags"`
+    Notes string   `json:"notes,omitempty" db:"notes"`
+
+    // PriceSelector is the CSS selector of the element holding the price on
+    // the product page; products without one use simulated prices
+    PriceSelector string `json:"price_selector,omitempty" db:"price_selector"`
+
+    // PriceXPath is an alternative to PriceSelector for pages that are
+    // easier to target with XPath; a product sets at most one of the
+    // selector, the XPath and the regex
+    PriceXPath string `json:"price_xpath,omitempty" db:"price_xpath"`
+
+    // PriceRegex extracts the price from the raw response body, HTML or
+    // JSON, using its first capture group or else the whole match
+    PriceRegex string `json:"price_regex,omitempty" db:"price_regex"`
+
+    // PriceScript is a price script, for pages the other price rules
+    // can't read; see priceScript
+    PriceScript string `json:"price_script,omitempty" db:"price_script"`
+
+    // ListPriceSelector is the CSS selector of the element holding the
+    // price before any discount, for pages that show both; without one the
+    // list price comes from the page's structured data
+    ListPriceSelector string `json:"list_price_selector,omitempty" db:"list_price_selector"`
+
+    // ShippingSelector is the CSS selector of the element holding the
+    // shipping cost; without one it comes from the page's structured data
+    ShippingSelector string `json:"shipping_selector,omitempty" db:"shipping_selector"`
+
+    // FingerprintSelector is the CSS selector of the page region whose
+    // markup is fingerprinted on every fetch, so a redesign that may soon
+    // break the price rule is noticed; empty turns fingerprinting off
+    FingerprintSelector string `json:"fingerprint_selector,omitempty" db:"fingerprint_selector"`
+
+    // PriceLocale, e.g. de-DE, says how the text a price rule extracts
+    // writes decimals; without one the decimal separator is guessed
+    PriceLocale string `json:"price_locale,omitempty" db:"price_locale"`
+
+    // Fetcher names a registered fetcher to get the price with instead of
+    // picking one from the URL; see RegisterFetcher
+    Fetcher string `json:"fetcher,omitempty" db:"fetcher"`
+
+    // FetchProfile customizes the HTTP request for sites that need extra
+    // headers, cookies or a POST to return the page
+    FetchProfile *FetchProfile `json:"fetch_profile,omitempty" db:"fetch_profile"`
+
+    // IgnoreRobots fetches the page even if the site's robots.txt
+    // disallows it
+    IgnoreRobots bool `json:"ignore_robots,omitempty" db:"ignore_robots"`
+
+    // RenderJS loads the page in a headless browser before extracting the
+    // price, for storefronts that render prices client-side
+    RenderJS bool `json:"render_js,omitempty" db:"render_js"`
+
+    // smallest price movement that counts as a change for this product;
+    // zero falls back to the tracker default
+    MinChange        float64 `json:"min_change,omitempty" db:"min_change"`
+    MinChangePercent float64 `json:"min_change_percent,omitempty" db:"min_change_percent"`
+
+    // Priority is the fetch tier: high, normal or low
+    Priority string `json:"priority" db:"priority"`
+
+    // TargetPrice triggers an alert when the price drops to or below it
+    TargetPrice *float64 `json:"target_price,omitempty" db:"target_price"`
+
+    // Currency is the ISO 4217 code prices are quoted in, e.g. USD or EUR
+    Currency string `json:"currency" db:"currency"`
+
+    // IntervalSeconds is how often this product is checked; zero uses the
+    // global tracking interval
+    IntervalSeconds int `json:"interval_seconds,omitempty" db:"interval_seconds"`
+}
+
+// FetchProfile is how a product page is requested. Everything is optional;
+// an empty profile is a plain GET with the tracker's default headers.
+type FetchProfile struct {
+    Method         string            `json:"method,omitempty"`
+    Body           string            `json:"body,omitempty"`
+    UserAgent      string            `json:"user_agent,omitempty"`
+    AcceptLanguage string            `json:"accept_language,omitempty"`
+    Headers        map[string]string `json:"headers,omitempty"`
+    Cookies        map[string]string `json:"cookies,omitempty"`
+}
+
+// DefaultCurrency is used for products and prices without a currency
+const DefaultCurrency = "USD"
+
+// Priority tiers; higher tiers are fetched first each cycle
+const (
+    PriorityHigh   = "high"
+    PriorityNormal = "normal"
+    PriorityLow    = "low"
+)
+
+// priorityRank orders tiers for dispatch, lowest rank first
+var priorityRank = map[string]int{
+    PriorityHigh:   0,
+    PriorityNormal: 1,
+    PriorityLow:    2,
 }
 
 // PriceEntry represents a price data point
 type PriceEntry struct {
-    ID        int       `json:"id" db:"id"`
-    ProductID string    `json:"product_id" db:"product_id"`
-    Price     float64   `json:"price" db:"price"`
-    Timestamp time.Time `json:"timestamp" db:"timestamp"`
+    ID        int     `json:"id" db:"id"`
+    ProductID string  `json:"product_id" db:"product_id"`
+    Price     float64 `json:"price" db:"price"`
+    Currency  string  `json:"currency" db:"currency"`
+    // whether the page showed the product in stock; nil when it didn't say
+    InStock *bool `json:"in_stock,omitempty" db:"in_stock"`
+    // the undiscounted price the page showed next to a sale price
+    ListPrice *float64 `json:"list_price,omitempty" db:"list_price"`
+    // auction or buy_it_now for marketplace listings; empty otherwise
+    ListingType string `json:"listing_type,omitempty" db:"listing_type"`
+    // the shipping cost the page showed, zero for free shipping, and the
+    // price with it; nil when the page didn't say
+    Shipping   *float64  `json:"shipping,omitempty" db:"shipping"`
+    TotalPrice *float64  `json:"total_price,omitempty"`
+    Timestamp  time.Time `json:"timestamp" db:"timestamp"`
+    InSale     bool      `json:"in_sale,omitempty"`
+    IsOutlier  bool      `json:"is_outlier,omitempty" db:"is_outlier"`
+
+    // NotModified marks a fetched price whose page answered 304 Not
+    // Modified. It isn't stored; it makes storePrice record the last known
+    // price again rather than skip it as unchanged.
+    NotModified bool `json:"-"`
 }
 
 // ProductWithLatestPrice combines product info with its latest price
 type ProductWithLatestPrice struct {
     Product
     LatestPrice *float64   `json:"latest_price,omitempty"`
+    MedianPrice *float64   `json:"median_price,omitempty"`
     LastUpdated *time.Time `json:"last_updated,omitempty"`
+    // the latest price's availability, if its page reported one
+    InStock *bool `json:"in_stock,omitempty"`
+
+    // the latest price's list price and its discount from it, in percent;
+    // nil unless the product is on sale
+    ListPrice       *float64 `json:"list_price,omitempty"`
+    DiscountPercent *float64 `json:"discount_percent,omitempty"`
+
+    // the latest price's listing type, for marketplace listings
+    ListingType string `json:"listing_type,omitempty"`
+
+    // the latest price's shipping cost and the price with it; nil when its
+    // page didn't show one
+    Shipping   *float64 `json:"shipping,omitempty"`
+    TotalPrice *float64 `json:"total_price,omitempty"`
+
+    // the price before the latest one and the latest price's change from
+    // it; nil until a product has two prices
+    PreviousPrice *float64 `json:"previous_price,omitempty"`
+    ChangePercent *float64 `json:"change_percent,omitempty"`
+
+    // AtAllTimeLow is set when the latest price is the lowest the product
+    // has had, once it has more than one price
+    AtAllTimeLow bool `json:"at_all_time_low,omitempty"`
+}
+
+// ArchivedPriceEntry is a price entry kept after its product was deleted
+type ArchivedPriceEntry struct {
+    PriceEntry
+    ProductName string    `json:"product_name"`
+    ArchivedAt  time.Time `json:"archived_at"`
+}
+
+// Sort orders for the products listing
+const (
+    SortByName          = "name"
+    SortByLatestPrice   = "latest_price"
+    SortByLastUpdated   = "last_updated"
+    SortByChangePercent = "change_percent"
+)
+
+// ProductFilter narrows and orders the products listing. Zero fields don't
+// filter; prices are compared in each product's own currency.
+type ProductFilter struct {
+    // Query matches a substring of the name, ignoring ASCII case
+    Query        string
+    MinPrice     *float64
+    MaxPrice     *float64
+    UpdatedSince *time.Time
+
+    // Search is full-text search text, matched against the name, URL,
+    // tags and notes; see searchMatchQuery
+    Search string
+
+    // Sort is one of the SortBy orders, by relevance when searching and
+    // otherwise by name when empty
+    Sort       string
+    Descending bool
+
+    // Tenant limits the listing to one tenant's products
+    Tenant string
+}
+
+// ProductPage is one page of the products listing
+type ProductPage struct {
+    Items  []ProductWithLatestPrice `json:"items"`
+    Total  int                      `json:"total"`
+    Limit  int                      `json:"limit"`
+    Offset int                      `json:"offset"`
+}
+
+// PricePoint is a price and when it was recorded
+type PricePoint struct {
+    Price     float64   `json:"price"`
+    Timestamp time.Time `json:"timestamp"`
+}
+
+// PriceExtremes is a product's all-time lowest and highest prices, each
+// the first time it was recorded. Low, High and Current are nil until the
+// product has a price.
+type PriceExtremes struct {
+    ProductID  string      `json:"product_id"`
+    Currency   string      `json:"currency"`
+    Low        *PricePoint `json:"low"`
+    High       *PricePoint `json:"high"`
+    Current    *PricePoint `json:"current"`
+    EntryCount int         `json:"entry_count"`
+
+    // AtAllTimeLow is set when the current price equals the all-time low
+    // and the product has more than one price
+    AtAllTimeLow bool `json:"at_all_time_low"`
+}
+
+// BestDeal compares a product's current price with its all-time low
+type BestDeal struct {
+    ProductID       string    `json:"product_id"`
+    Name            string    `json:"name"`
+    LowestPrice     float64   `json:"lowest_price"`
+    LowestAt        time.Time `json:"lowest_at"`
+    CurrentPrice    float64   `json:"current_price"`
+    CurrentAt       time.Time `json:"current_at"`
+    AboveLowPercent float64   `json:"above_low_percent"`
+    EntryCount      int       `json:"entry_count"`
+}
+
+// PriceStats aggregates a product's prices since a point in time. Min, max and
+// average are zero when there are no entries in the window.
+type PriceStats struct {
+    ProductID   string    `json:"product_id"`
+    Currency    string    `json:"currency"`
+    Since       time.Time `json:"since"`
+    Count       int       `json:"count"`
+    MinPrice    float64   `json:"min_price"`
+    MaxPrice    float64   `json:"max_price"`
+    AvgPrice    float64   `json:"avg_price"`
+    MedianPrice float64   `json:"median_price"`
+    // StdDev is the population standard deviation of the window's prices
+    StdDev      float64 `json:"std_dev"`
+    LatestPrice float64 `json:"latest_price"`
+
+    // PercentFromLow is how far the latest price is above the window's
+    // lowest, in percent; nil when the window is empty
+    PercentFromLow *float64 `json:"percent_from_low,omitempty"`
+
+    // ChangePercent is the latest price's change from the price recorded
+    // a period ago, keyed by period such as "7d"; periods older than the
+    // product's history are left out
+    ChangePercent map[string]float64 `json:"change_percent"`
+}
+
+// History intervals a product's prices can be grouped by
+const (
+    IntervalHour = "hour"
+    IntervalDay  = "day"
+    IntervalWeek = "week"
+)
+
+// HistoryBucket summarizes a product's prices over one interval: the first
+// and last price recorded in it, the lowest, highest and average, and how
+// many there were. Weeks start on Monday, and all buckets are in UTC.
+type HistoryBucket struct {
+    Start    time.Time `json:"start"`
+    Currency string    `json:"currency"`
+    Open     float64   `json:"open"`
+    Close    float64   `json:"close"`
+    Min      float64   `json:"min"`
+    Max      float64   `json:"max"`
+    Avg      float64   `json:"avg"`
+    Count    int       `json:"count"`
+}
+
+// WatchdogEvent records something the tracking watchdog noticed or did
+type WatchdogEvent struct {
+    Time    time.Time `json:"time"`
+    Message string    `json:"message"`
+}
+
+// TrackingStatus summarizes the health of the background tracking loop
+type TrackingStatus struct {
+    LastCycle time.Time       `json:"last_cycle"`
+    Restarts  int             `json:"restarts"`
+    Events    []WatchdogEvent `json:"events"`
+}
+
+// Job tracks the progress of an on-demand run over the tracked products
+type Job struct {
+    ID         string     `json:"id"`
+    Type       string     `json:"type"`
+    Total      int        `json:"total"`
+    Checked    int        `json:"checked"`
+    Succeeded  int        `json:"succeeded"`
+    Failed     int        `json:"failed"`
+    Done       bool       `json:"done"`
+    StartedAt  time.Time  `json:"started_at"`
+    FinishedAt *time.Time `json:"finished_at,omitempty"`
+
+    // Tenant is the tenant that started the job; only it can read the job
+    Tenant string `json:"-"`
+
+    // Results holds per-product outcomes for validation, catalog import and
+    // single product check jobs
+    Results []ValidationResult `json:"results,omitempty"`
+}
+
+// PriceCheck is the outcome of an on-demand price check of one product
+type PriceCheck struct {
+    ProductID string   `json:"product_id"`
+    Price     float64  `json:"price"`
+    Currency  string   `json:"currency"`
+    InStock   *bool    `json:"in_stock,omitempty"`
+    Shipping  *float64 `json:"shipping,omitempty"`
+    // Stored is false when the price hadn't moved from the last stored one
+    Stored    bool      `json:"stored"`
+    EntryID   int       `json:"entry_id,omitempty"`
+    Attempts  int       `json:"attempts"`
+    CheckedAt time.Time `json:"checked_at"`
+}
+
+// ValidationResult is the outcome of a dry-run fetch for one product, or of
+// adding one from a catalog, where URL is the link it was added from
+type ValidationResult struct {
+    ProductID string  `json:"product_id"`
+    URL       string  `json:"url,omitempty"`
+    OK        bool    `json:"ok"`
+    Price     float64 `json:"price,omitempty"`
+    Error     string  `json:"error,omitempty"`
+}
+
+// QueryStats aggregates latency for one kind of database query
+type QueryStats struct {
+    Count     int64   `json:"count"`
+    SlowCount int64   `json:"slow_count"`
+    TotalMs   float64 `json:"total_ms"`
+    AvgMs     float64 `json:"avg_ms"`
+    MaxMs     float64 `json:"max_ms"`
+}
+
+// SaleWindow is a known sale period during which a product is polled more often
+type SaleWindow struct {
+    ID        int64     `json:"id" db:"id"`
+    ProductID string    `json:"product_id" db:"product_id"`
+    Name      string    `json:"name" db:"name"`
+    StartsAt  time.Time `json:"starts_at" db:"starts_at"`
+    EndsAt    time.Time `json:"ends_at" db:"ends_at"`
+}
+
+// Contains reports whether t falls inside the window
+func (w SaleWindow) Contains(t time.Time) bool {
+    return !t.Before(w.StartsAt) && t.Before(w.EndsAt)
+}
+
+// BasketItem is one product and quantity in a shopping basket
+type BasketItem struct {
+    ProductID string `json:"product_id"`
+    Quantity  int    `json:"quantity"`
+}
+
+// BasketAnalysis compares what a basket costs now with the cheapest it has
+// been over the analysis window
+type BasketAnalysis struct {
+    Days          int          `json:"days"`
+    Items         []BasketItem `json:"items"`
+    CurrentTotal  float64      `json:"current_total"`
+    CheapestTotal float64      `json:"cheapest_total"`
+    CheapestAt    *time.Time   `json:"cheapest_at,omitempty"`
+    Partial       bool         `json:"partial"`
+    Missing       []string     `json:"missing,omitempty"`
+}
+
+// PriceVelocity is how fast a product's price is moving over a window
+type PriceVelocity struct {
+    ProductID      string  `json:"product_id"`
+    Name           string  `json:"name"`
+    CurrentPrice   float64 `json:"current_price"`
+    DollarsPerHour float64 `json:"dollars_per_hour"`
+    PercentPerHour float64 `json:"percent_per_hour"`
+    EntryCount     int     `json:"entry_count"`
+}
+
+// PriceDrop is how far a product's price has fallen over a window
+type PriceDrop struct {
+    ProductID     string    `json:"product_id"`
+    Name          string    `json:"name"`
+    Currency      string    `json:"currency"`
+    PreviousPrice float64   `json:"previous_price"`
+    PreviousAt    time.Time `json:"previous_at"`
+    CurrentPrice  float64   `json:"current_price"`
+    CurrentAt     time.Time `json:"current_at"`
+    DropAmount    float64   `json:"drop_amount"`
+    DropPercent   float64   `json:"drop_percent"`
+}
+
+// PriceBucket is the average price over one group of a best-time analysis
+type PriceBucket struct {
+    Label            string  `json:"label"`
+    AveragePrice     float64 `json:"average_price"`
+    Count            int     `json:"count"`
+    VsAveragePercent float64 `json:"vs_average_percent"`
+}
+
+// BestTimeAnalysis describes when a product has historically been cheapest
+type BestTimeAnalysis struct {
+    ProductID      string        `json:"product_id"`
+    Sufficient     bool          `json:"sufficient"`
+    Confidence     string        `json:"confidence"`
+    Recommendation string        `json:"recommendation"`
+    EntryCount     int           `json:"entry_count"`
+    DaysCovered    int           `json:"days_covered"`
+    AveragePrice   float64       `json:"average_price,omitempty"`
+    ByWeekday      []PriceBucket `json:"by_weekday,omitempty"`
+    ByMonthPeriod  []PriceBucket `json:"by_month_period,omitempty"`
+}
+
+// ElasticityPoint is one price change and the first time the product sold
+// out after it, if it did before the next change
+type ElasticityPoint struct {
+    At             time.Time  `json:"at"`
+    PreviousPrice  float64    `json:"previous_price"`
+    Price          float64    `json:"price"`
+    ChangePercent  float64    `json:"change_percent"`
+    SoldOutAt      *time.Time `json:"sold_out_at,omitempty"`
+    HoursToSellOut *float64   `json:"hours_to_sell_out,omitempty"`
+}
+
+// ElasticityHint is a crude sign of how sensitive demand for a product is to
+// its price. Label is high, moderate or low, insufficient_data, or
+// unavailable when its pages don't report stock.
+type ElasticityHint struct {
+    ProductID         string            `json:"product_id"`
+    Label             string            `json:"label"`
+    Drops             int               `json:"drops"`
+    Rises             int               `json:"rises"`
+    DropSellOutRate   float64           `json:"drop_sell_out_rate"`
+    RiseSellOutRate   float64           `json:"rise_sell_out_rate"`
+    AvgHoursToSellOut *float64          `json:"avg_hours_to_sell_out,omitempty"`
+    Points            []ElasticityPoint `json:"points"`
+}
+
+// PriceAlert is the webhook payload sent when a product reaches its target price
+type PriceAlert struct {
+    Type        string  `json:"type"`
+    ProductID   string  `json:"product_id"`
+    Name        string  `json:"name"`
+    Price       float64 `json:"price"`
+    TargetPrice float64 `json:"target_price"`
+    Currency    string  `json:"currency"`
+    URL         string  `json:"url"`
+}
+
+// ListingAlert is the webhook payload sent when a product page starts
+// showing a product name unlike the one it showed before, suggesting the
+// listing now sells a different item
+type ListingAlert struct {
+    Type          string  `json:"type"`
+    ProductID     string  `json:"product_id"`
+    Name          string  `json:"name"`
+    URL           string  `json:"url"`
+    PreviousTitle string  `json:"previous_title"`
+    Title         string  `json:"title"`
+    Similarity    float64 `json:"similarity"`
+}
+
+// PageFingerprint is the latest fingerprint of the region of a product page
+// its fingerprint selector picks out. ChangedAt is when the region's markup
+// last changed significantly and Previous the fingerprint it changed from;
+// Distance is how many bits the latest fetch's fingerprint differs from the
+// one before it.
+type PageFingerprint struct {
+    ProductID   string     `json:"product_id"`
+    Name        string     `json:"name"`
+    URL         string     `json:"url"`
+    Selector    string     `json:"selector"`
+    Fingerprint string     `json:"fingerprint"`
+    Previous    string     `json:"previous_fingerprint,omitempty"`
+    Distance    int        `json:"distance"`
+    CheckedAt   time.Time  `json:"checked_at"`
+    ChangedAt   *time.Time `json:"changed_at,omitempty"`
+}
+
+// PageChangeAlert is the webhook payload sent when the fingerprinted region
+// of a product page changes markup while its price still reads, an early
+// sign that the price rule may soon break
+type PageChangeAlert struct {
+    Type                string `json:"type"`
+    ProductID           string `json:"product_id"`
+    Name                string `json:"name"`
+    URL                 string `json:"url"`
+    Selector            string `json:"selector"`
+    PreviousFingerprint string `json:"previous_fingerprint"`
+    Fingerprint         string `json:"fingerprint"`
+    Distance            int    `json:"distance"`
+}
+
+// Notification is a webhook alert in the delivery queue. Alerts are queued
+// before they are sent, so one that can't be delivered before a crash or
+// restart is sent afterwards. Status is pending, sending, delivered or failed,
+// once it has run out of attempts.
+type Notification struct {
+    ID            int64           `json:"id"`
+    Kind          string          `json:"kind"`
+    ProductID     string          `json:"product_id"`
+    DedupeKey     string          `json:"dedupe_key"`
+    URL           string          `json:"-"`
+    Payload       json.RawMessage `json:"payload"`
+    Status        string          `json:"status"`
+    Attempts      int             `json:"attempts"`
+    LastError     string          `json:"last_error,omitempty"`
+    CreatedAt     time.Time       `json:"created_at"`
+    NextAttemptAt time.Time       `json:"next_attempt_at"`
+    DeliveredAt   *time.Time      `json:"delivered_at,omitempty"`
+}
+
+// RobotsBlock records a product that isn't being fetched because its site's
+// robots.txt disallows the page
+type RobotsBlock struct {
+    ProductID string    `json:"product_id"`
+    Name      string    `json:"name"`
+    URL       string    `json:"url"`
+    Reason    string    `json:"reason"`
+    Since     time.Time `json:"since"`
+}
+
+// BreakerStatus is the state of one host's circuit breaker. OpenedAt and
+// RetryAt are set while it is open or half-open.
+type BreakerStatus struct {
+    Host      string     `json:"host"`
+    State     string     `json:"state"`
+    Failures  int        `json:"failures"`
+    LastError string     `json:"last_error,omitempty"`
+    OpenedAt  *time.Time `json:"opened_at,omitempty"`
+    RetryAt   *time.Time `json:"retry_at,omitempty"`
+}
+
+// ScrapeError records a fetch that failed for good, after any retries
+type ScrapeError struct {
+    ID         int64     `json:"id" db:"id"`
+    ProductID  string    `json:"product_id" db:"product_id"`
+    Error      string    `json:"error" db:"error"`
+    Attempts   int       `json:"attempts" db:"attempts"`
+    OccurredAt time.Time `json:"occurred_at" db:"occurred_at"`
+}
+
+// PageSnapshot describes a page saved because no price could be read from
+// it. Size is the page's full length; Truncated is set when only the first
+// part was kept.
+type PageSnapshot struct {
+    ID        int64     `json:"id" db:"id"`
+    ProductID string    `json:"product_id" db:"product_id"`
+    URL       string    `json:"url" db:"url"`
+    Error     string    `json:"error" db:"error"`
+    Size      int       `json:"size" db:"size"`
+    Truncated bool      `json:"truncated" db:"truncated"`
+    TakenAt   time.Time `json:"taken_at" db:"taken_at"`
+}
+
+// BlockStats counts how often a product's fetches were refused by
+// anti-bot protection or rate limiting since startup
+type BlockStats struct {
+    ProductID     string    `json:"product_id"`
+    Name          string    `json:"name"`
+    Blocked       int       `json:"blocked"`
+    LastBlockedAt time.Time `json:"last_blocked_at"`
+    LastReason    string    `json:"last_reason"`
+}
+
+// ImportSummary reports how a bulk product import went. Errors holds one
+// message per failed row, and Rows the outcome of every row.
+type ImportSummary struct {
+    Inserted int         `json:"inserted"`
+    Skipped  int         `json:"skipped"`
+    Failed   int         `json:"failed"`
+    Errors   []string    `json:"errors,omitempty"`
+    Rows     []ImportRow `json:"rows"`
+}
+
+// ImportRow is the outcome of one row of a bulk product import. Row is the
+// line number in a CSV file, or the position from 1 in a JSON list.
+type ImportRow struct {
+    Row       int    `json:"row"`
+    ProductID string `json:"product_id,omitempty"`
+    Status    string `json:"status"`
+    Error     string `json:"error,omitempty"`
 }
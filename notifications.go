@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+const (
+    // defaultNotifyAttempts and defaultNotifyRetryDelay are how many times a
+    // webhook alert is tried before it is given up on, and the backoff after
+    // the first failure, which doubles after each one up to
+    // notificationMaxDelay
+    defaultNotifyAttempts   = 8
+    defaultNotifyRetryDelay = 30 * time.Second
+    notificationMaxDelay    = time.Hour
+
+    // notificationPollInterval is how often the queue is checked for alerts
+    // due another attempt
+    notificationPollInterval = 5 * time.Second
+)
+
+// SetNotificationPolicy sets how many times a webhook alert is attempted in
+// total and the backoff after its first failed attempt
+func (pt *PriceTracker) SetNotificationPolicy(attempts int, retryDelay time.Duration) {
+    pt.statusMu.Lock()
+    defer pt.statusMu.Unlock()
+
+    pt.notifyAttempts = attempts
+    pt.notifyRetryDelay = retryDelay
+}
+
+func (pt *PriceTracker) notificationPolicy() (int, time.Duration) {
+    pt.statusMu.Lock()
+    defer pt.statusMu.Unlock()
+
+    attempts, delay := pt.notifyAttempts, pt.notifyRetryDelay
+    if attempts <= 0 {
+        attempts = defaultNotifyAttempts
+    }
+    if delay <= 0 {
+        delay = defaultNotifyRetryDelay
+    }
+    return attempts, delay
+}
+
+// notify queues an alert for webhookURL and sends it in the background.
+// Queueing first means an alert that isn't delivered before a crash is sent
+// after the restart. key names the event: an alert is dropped as a repeat
+// while another with the same key is still waiting to be sent.
+func (pt *PriceTracker) notify(webhookURL, kind, productID, key string, alert interface{}) {
+    payload, err := json.Marshal(alert)
+    if err != nil {
+        log.Printf("Failed to encode %s alert for %s: %v", kind, productID, err)
+        return
+    }
+
+    id, queued, err := pt.db.EnqueueNotification(Notification{
+        Kind:      kind,
+        ProductID: productID,
+        DedupeKey: key,
+        URL:       webhookURL,
+        Payload:   payload,
+        CreatedAt: time.Now(),
+    })
+    if err != nil {
+        log.Printf("Failed to queue %s alert for %s: %v", kind, productID, err)
+        return
+    }
+    if !queued {
+        log.Printf("Skipping repeated %s alert for %s", kind, productID)
+        return
+    }
+
+    // deliver in the background so a slow endpoint doesn't stall collection
+    go pt.deliverNotification(id)
+}
+
+// StartNotifications requeues the alerts a previous run was sending when it
+// stopped, then retries failed deliveries as they come due until ctx is
+// cancelled
+func (pt *PriceTracker) StartNotifications(ctx context.Context) {
+    requeued, err := pt.db.RequeueNotifications()
+    if err != nil {
+        log.Printf("Failed to requeue notifications: %v", err)
+    } else if requeued > 0 {
+        log.Printf("Requeued %d notifications left unsent at the last shutdown", requeued)
+    }
+
+    ticker := time.NewTicker(notificationPollInterval)
+    defer ticker.Stop()
+
+    for {
+        pt.deliverDueNotifications(ctx)
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+func (pt *PriceTracker) deliverDueNotifications(ctx context.Context) {
+    ids, err := pt.db.DueNotifications(time.Now(), 100)
+    if err != nil {
+        log.Printf("Failed to load queued notifications: %v", err)
+        return
+    }
+    for _, id := range ids {
+        if ctx.Err() != nil {
+            return
+        }
+        pt.deliverNotification(id)
+    }
+}
+
+// deliverNotification makes one attempt at sending a queued alert, unless
+// another attempt already has it, and schedules a retry if it fails
+func (pt *PriceTracker) deliverNotification(id int64) {
+    n, ok, err := pt.db.ClaimNotification(id)
+    if err != nil {
+        log.Printf("Failed to claim notification %d: %v", id, err)
+        return
+    }
+    if !ok {
+        return
+    }
+
+    sendErr := postWebhook(n.URL, n.ID, n.Payload)
+    if sendErr == nil {
+        if err := pt.db.MarkNotificationDelivered(id, time.Now()); err != nil {
+            log.Printf("Failed to mark notification %d delivered: %v", id, err)
+        }
+        return
+    }
+
+    attempts, delay := pt.notificationPolicy()
+    var next time.Time
+    if n.Attempts < attempts {
+        for i := 1; i < n.Attempts && delay < notificationMaxDelay; i++ {
+            delay *= 2
+        }
+        next = time.Now().Add(min(delay, notificationMaxDelay))
+        log.Printf("Failed to send %s alert for %s (attempt %d of %d), retrying at %s: %v",
+            n.Kind, n.ProductID, n.Attempts, attempts, next.UTC().Format(time.RFC3339), sendErr)
+    } else {
+        log.Printf("Giving up on %s alert for %s after %d attempts: %v", n.Kind, n.ProductID, n.Attempts, sendErr)
+    }
+    if err := pt.db.RetryNotification(id, next, sendErr.Error()); err != nil {
+        log.Printf("Failed to requeue notification %d: %v", id, err)
+    }
+}
+
+// GetNotifications lists up to limit queued webhook alerts, newest first,
+// optionally only those with one status
+func (pt *PriceTracker) GetNotifications(status string, limit int) ([]Notification, error) {
+    return pt.db.GetNotifications(status, limit)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newNotificationEndpoint returns a webhook URL that fails the first
+// failures requests with a 503, and a function returning the
+// X-Notification-ID of each request it accepted
+func newNotificationEndpoint(t *testing.T, failures int) (string, func() []string) {
+    t.Helper()
+    var mu sync.Mutex
+    var ids []string
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        mu.Lock()
+        defer mu.Unlock()
+        if failures > 0 {
+            failures--
+            w.WriteHeader(http.StatusServiceUnavailable)
+            return
+        }
+        ids = append(ids, r.Header.Get("X-Notification-ID"))
+    }))
+    t.Cleanup(server.Close)
+    return server.URL, func() []string {
+        mu.Lock()
+        defer mu.Unlock()
+        return append([]string(nil), ids...)
+    }
+}
+
+// waitForNotification waits for a queued notification to satisfy done, or
+// gives up after two seconds, and returns it
+func waitForNotification(t *testing.T, tracker *PriceTracker, id int64, done func(Notification) bool) Notification {
+    t.Helper()
+    deadline := time.Now().Add(2 * time.Second)
+    for {
+        notifications, err := tracker.GetNotifications("", 100)
+        if err != nil {
+            t.Fatal(err)
+        }
+        var n Notification
+        for _, queued := range notifications {
+            if queued.ID == id {
+                n = queued
+            }
+        }
+        if n.ID == 0 {
+            t.Fatalf("notification %d not found", id)
+        }
+        if done(n) || time.Now().After(deadline) {
+            return n
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+}
+
+// delivered and attempted are conditions to wait for
+func delivered(n Notification) bool { return n.Status == "delivered" }
+
+func attempted(attempts int) func(Notification) bool {
+    return func(n Notification) bool { return n.Status != "sending" && n.Attempts >= attempts }
+}
+
+func TestNotificationEnqueueAndDelivery(t *testing.T) {
+    tracker := newTestTracker(t, nil)
+    webhookURL, received := newWebhookRecorder(t)
+    tracker.SetWebhookURL(webhookURL)
+
+    target := 50.0
+    product := Product{ID: "notify-kettle", Name: "Kettle", URL: "https://shop.test/kettle", TargetPrice: &target}
+    tracker.checkTargetPrice(product, 45)
+
+    payloads := waitForPayloads(t, received, 1)
+    if len(payloads) != 1 || payloads[0]["type"] != "target_price" || payloads[0]["price"] != 45.0 {
+        t.Fatalf("payloads = %v", payloads)
+    }
+
+    notifications, err := tracker.GetNotifications("", 10)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(notifications) != 1 {
+        t.Fatalf("queued %d notifications, want 1", len(notifications))
+    }
+    n := waitForNotification(t, tracker, notifications[0].ID, delivered)
+    if n.Status != "delivered" || n.Attempts != 1 || n.DeliveredAt == nil || n.Kind != "target_price" || n.ProductID != "notify-kettle" {
+        t.Errorf("notification = %+v, want delivered on the first attempt", n)
+    }
+}
+
+func TestNotificationDedupe(t *testing.T) {
+    tracker := newTestTracker(t, nil)
+    alert := Notification{Kind: "target_price", ProductID: "notify-dedupe", DedupeKey: "target_price:notify-dedupe:50", URL: "http://127.0.0.1:0", Payload: []byte(`{}`), CreatedAt: time.Now()}
+
+    id, queued, err := tracker.db.EnqueueNotification(alert)
+    if err != nil || !queued {
+        t.Fatalf("first enqueue = %v, %v", queued, err)
+    }
+    // the same alert while the first is still waiting is a repeat
+    if _, queued, err := tracker.db.EnqueueNotification(alert); err != nil || queued {
+        t.Errorf("repeat enqueue = %v, %v; want it dropped", queued, err)
+    }
+
+    // once delivered, the same event may alert again
+    if err := tracker.db.MarkNotificationDelivered(id, time.Now()); err != nil {
+        t.Fatal(err)
+    }
+    if _, queued, err := tracker.db.EnqueueNotification(alert); err != nil || !queued {
+        t.Errorf("enqueue after delivery = %v, %v; want it queued", queued, err)
+    }
+
+    // a claimed notification can't be claimed again
+    if _, ok, err := tracker.db.ClaimNotification(id); err != nil || ok {
+        t.Errorf("claiming a delivered notification = %v, %v", ok, err)
+    }
+}
+
+func TestNotificationRetries(t *testing.T) {
+    tracker := newTestTracker(t, nil)
+    tracker.SetNotificationPolicy(3, time.Millisecond)
+    webhookURL, received := newNotificationEndpoint(t, 1)
+
+    tracker.notify(webhookURL, "listing_changed", "notify-retry", "listing_changed:notify-retry:Chair", ListingAlert{Type: "listing_changed"})
+    notifications, _ := tracker.GetNotifications("", 10)
+    if len(notifications) != 1 {
+        t.Fatalf("queued %d notifications, want 1", len(notifications))
+    }
+    id := notifications[0].ID
+
+    n := waitForNotification(t, tracker, id, attempted(1))
+    if n.Status != "pending" || n.LastError == "" || !n.NextAttemptAt.After(n.CreatedAt) {
+        t.Errorf("after a failure = %+v, want a retry scheduled with the error", n)
+    }
+
+    time.Sleep(5 * time.Millisecond)
+    tracker.deliverDueNotifications(context.Background())
+    n = waitForNotification(t, tracker, id, delivered)
+    if n.Status != "delivered" || n.Attempts != 2 {
+        t.Errorf("notification = %+v, want delivered on the second attempt", n)
+    }
+    if ids := received(); len(ids) != 1 || ids[0] != strconv.FormatInt(id, 10) {
+        t.Errorf("delivered IDs = %v, want [%d]", ids, id)
+    }
+
+    // an endpoint that keeps failing is given up on
+    webhookURL, _ = newNotificationEndpoint(t, 100)
+    tracker.notify(webhookURL, "page_changed", "notify-down", "page_changed:notify-down:ab", PageChangeAlert{Type: "page_changed"})
+    failing, _ := tracker.GetNotifications("", 1)
+    for attempts := 1; attempts < 3; attempts++ {
+        waitForNotification(t, tracker, failing[0].ID, attempted(attempts))
+        time.Sleep(5 * time.Millisecond)
+        tracker.deliverDueNotifications(context.Background())
+    }
+    if n := waitForNotification(t, tracker, failing[0].ID, attempted(3)); n.Status != "failed" || n.Attempts != 3 {
+        t.Errorf("notification = %+v, want failed after 3 attempts", n)
+    }
+}
+
+func TestNotificationCrashRecovery(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "prices.db")
+    webhookURL, received := newNotificationEndpoint(t, 0)
+
+    // the first run queues two alerts and stops before either is delivered:
+    // one was never tried and the other was being sent
+    db, err := NewDatabase(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var ids []int64
+    for _, key := range []string{"target_price:crash-a:10", "target_price:crash-b:10"} {
+        id, _, err := db.EnqueueNotification(Notification{Kind: "target_price", ProductID: key, DedupeKey: key, URL: webhookURL, Payload: []byte(`{"type":"target_price"}`), CreatedAt: time.Now()})
+        if err != nil {
+            t.Fatal(err)
+        }
+        ids = append(ids, id)
+    }
+    if _, ok, err := db.ClaimNotification(ids[1]); err != nil || !ok {
+        t.Fatalf("claim = %v, %v", ok, err)
+    }
+    db.Close()
+
+    // the restart delivers both
+    db, err = NewDatabase(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    tracker := NewPriceTracker(db, nil)
+    ctx, cancel := context.WithCancel(context.Background())
+    done := make(chan struct{})
+    go func() {
+        tracker.StartNotifications(ctx)
+        close(done)
+    }()
+    t.Cleanup(func() {
+        cancel()
+        <-done
+        db.Close()
+    })
+
+    for _, id := range ids {
+        if n := waitForNotification(t, tracker, id, delivered); n.Status != "delivered" {
+            t.Errorf("notification %d = %+v, want delivered after the restart", id, n)
+        }
+    }
+    got := received()
+    if len(got) != 2 || got[0] != strconv.FormatInt(ids[0], 10) || got[1] != strconv.FormatInt(ids[1], 10) {
+        t.Errorf("delivered IDs = %v, want %v", got, ids)
+    }
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// flagOutlier flags the entry of a product with the given price as an
+// outlier through the API and returns its ID
+func flagOutlier(t *testing.T, server *APIServer, productID string, price float64) int {
+    t.Helper()
+    entries, err := server.tracker.GetPriceHistory(productID, 100, true)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, entry := range entries {
+        if entry.Price == price {
+            path := fmt.Sprintf("/api/v1/entries/%d/outlier", entry.ID)
+            if rec := serve(t, server, "PUT", path, `{"is_outlier": true}`, nil); rec.Code != http.StatusOK {
+                t.Fatalf("flag %d = %d: %s", entry.ID, rec.Code, rec.Body)
+            }
+            return entry.ID
+        }
+    }
+    t.Fatalf("%s has no entry priced %v", productID, price)
+    return 0
+}
+
+func TestOutliersExcludedByDefault(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    addTestProduct(t, server.tracker, "outlier-tv", 500, 5, 510, 490)
+    flagOutlier(t, server, "outlier-tv", 5)
+
+    var history struct {
+        History []PriceEntry `json:"history"`
+        Count   int          `json:"count"`
+    }
+    serve(t, server, "GET", "/api/v1/products/outlier-tv/history", "", &history)
+    for _, entry := range history.History {
+        if entry.Price == 5 {
+            t.Errorf("history includes the outlier: %+v", history.History)
+        }
+    }
+    if history.Count != 3 {
+        t.Errorf("history has %d entries, want 3", history.Count)
+    }
+
+    serve(t, server, "GET", "/api/v1/products/outlier-tv/history?include_outliers=true", "", &history)
+    outliers := 0
+    for _, entry := range history.History {
+        if entry.IsOutlier {
+            outliers++
+            if entry.Price != 5 {
+                t.Errorf("entry %+v flagged, want only the 5", entry)
+            }
+        }
+    }
+    if history.Count != 4 || outliers != 1 {
+        t.Errorf("history with outliers = %+v, want 4 entries with the outlier flagged", history.History)
+    }
+
+    var stats PriceStats
+    serve(t, server, "GET", "/api/v1/products/outlier-tv/stats", "", &stats)
+    if stats.Count != 3 || stats.MinPrice != 490 || stats.MaxPrice != 510 {
+        t.Errorf("stats = %+v, want the outlier left out", stats)
+    }
+}
+
+func TestLatestOutlierSkipped(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    addTestProduct(t, server.tracker, "outlier-latest", 20, 21, 2)
+    id := flagOutlier(t, server, "outlier-latest", 2)
+
+    latest := func() float64 {
+        t.Helper()
+        var page ProductPage
+        serve(t, server, "GET", "/api/v1/products", "", &page)
+        if len(page.Items) != 1 || page.Items[0].LatestPrice == nil {
+            t.Fatalf("products = %+v", page.Items)
+        }
+        return *page.Items[0].LatestPrice
+    }
+    if got := latest(); got != 21 {
+        t.Errorf("latest price = %v, want 21 with the outlier skipped", got)
+    }
+
+    // unflagging brings it back
+    if rec := serve(t, server, "PUT", fmt.Sprintf("/api/v1/entries/%d/outlier", id), `{"is_outlier": false}`, nil); rec.Code != http.StatusOK {
+        t.Fatalf("unflag = %d: %s", rec.Code, rec.Body)
+    }
+    if got := latest(); got != 2 {
+        t.Errorf("latest price = %v, want 2 once unflagged", got)
+    }
+}
+
+func TestSetOutlierErrors(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    addTestProduct(t, server.tracker, "outlier-errors", 10)
+
+    for _, tt := range []struct {
+        path, body string
+        want       int
+    }{
+        {"/api/v1/entries/999999/outlier", `{"is_outlier": true}`, http.StatusNotFound},
+        {"/api/v1/entries/abc/outlier", `{"is_outlier": true}`, http.StatusBadRequest},
+        {"/api/v1/entries/1/outlier", `{}`, http.StatusBadRequest},
+    } {
+        if rec := serve(t, server, "PUT", tt.path, tt.body, nil); rec.Code != tt.want {
+            t.Errorf("PUT %s %s = %d, want %d", tt.path, tt.body, rec.Code, tt.want)
+        }
+    }
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// Built-in fetcher names; a product with either skips the retailer APIs
+const (
+    FetcherScrape    = "scrape"
+    FetcherSimulated = "simulated"
+)
+
+var fetcherNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// fetcherRegistry holds the fetchers products can pick by name. Third-party
+// fetchers register themselves from an init function in their own file,
+// usually behind a build tag, so they ship without changes to the tracker.
+var fetcherRegistry = struct {
+    sync.RWMutex
+    fetchers map[string]PriceFetcher
+}{fetchers: map[string]PriceFetcher{
+    FetcherScrape:    ScrapingFetcher{},
+    FetcherSimulated: SimulatedFetcher{},
+}}
+
+// RegisterFetcher makes a fetcher available to products whose fetcher field
+// is name. Names are lowercase letters, digits, - and _. It panics if the
+// name is invalid or already taken, since that is a build mistake.
+func RegisterFetcher(name string, fetcher PriceFetcher) {
+    if !fetcherNamePattern.MatchString(name) {
+        panic(fmt.Sprintf("RegisterFetcher: invalid fetcher name %q", name))
+    }
+    if fetcher == nil {
+        panic("RegisterFetcher: nil fetcher for " + name)
+    }
+
+    fetcherRegistry.Lock()
+    defer fetcherRegistry.Unlock()
+
+    if _, taken := fetcherRegistry.fetchers[name]; taken {
+        panic("RegisterFetcher: fetcher " + name + " registered twice")
+    }
+    fetcherRegistry.fetchers[name] = fetcher
+}
+
+// namedFetcher returns the registered fetcher called name
+func namedFetcher(name string) (PriceFetcher, error) {
+    fetcherRegistry.RLock()
+    defer fetcherRegistry.RUnlock()
+
+    fetcher, ok := fetcherRegistry.fetchers[name]
+    if !ok {
+        return nil, fmt.Errorf("unknown fetcher %q", name)
+    }
+    return fetcher, nil
+}
+
+// FetcherNames lists the registered fetchers in order
+func FetcherNames() []string {
+    fetcherRegistry.RLock()
+    defer fetcherRegistry.RUnlock()
+
+    names := make([]string, 0, len(fetcherRegistry.fetchers))
+    for name := range fetcherRegistry.fetchers {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// readWith gets a reading from any fetcher, leaving availability unknown
+// for those that only report a price
+func readWith(ctx context.Context, fetcher PriceFetcher, product Product) (PriceReading, error) {
+    if reader, ok := fetcher.(ReadingFetcher); ok {
+        return reader.FetchReading(ctx, product)
+    }
+    price, err := fetcher.FetchPrice(ctx, product)
+    return PriceReading{Price: price}, err
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// outOfStockFetcher is a plugin that reports availability as well as price
+type outOfStockFetcher struct{}
+
+func (outOfStockFetcher) FetchPrice(ctx context.Context, product Product) (float64, error) {
+    return 12.5, nil
+}
+
+func (outOfStockFetcher) FetchReading(ctx context.Context, product Product) (PriceReading, error) {
+    inStock := false
+    return PriceReading{Price: 12.5, InStock: &inStock}, nil
+}
+
+// registerTestFetcher registers a fetcher for the rest of the test
+func registerTestFetcher(t *testing.T, name string, fetcher PriceFetcher) {
+    t.Helper()
+    RegisterFetcher(name, fetcher)
+    t.Cleanup(func() {
+        fetcherRegistry.Lock()
+        delete(fetcherRegistry.fetchers, name)
+        fetcherRegistry.Unlock()
+    })
+}
+
+func TestNamedFetchers(t *testing.T) {
+    registerTestFetcher(t, "test-flat", PriceFetcherFunc(func(ctx context.Context, product Product) (float64, error) {
+        return 7, nil
+    }))
+    registerTestFetcher(t, "test_stock", outOfStockFetcher{})
+
+    // the tracker's own fetcher is only used for products without one
+    tracker := newTestTracker(t, cyclePrices(99))
+    tracker.SetRetryPolicy(1, 0)
+    server := NewAPIServer(tracker)
+    for _, body := range []string{
+        `{"id": "plugin-flat", "name": "Kettle", "url": "https://shop.test/kettle", "fetcher": "test-flat"}`,
+        `{"id": "plugin-stock", "name": "Toaster", "url": "https://shop.test/toaster", "fetcher": "test_stock"}`,
+        `{"id": "plugin-none", "name": "Mug", "url": "https://shop.test/mug"}`,
+    } {
+        if rec := serve(t, server, "POST", "/api/v1/products", body, nil); rec.Code != http.StatusCreated {
+            t.Fatalf("create = %d: %s", rec.Code, rec.Body)
+        }
+    }
+    tracker.trackProducts(context.Background(), tracker.snapshotProducts(), nil)
+
+    for id, want := range map[string]float64{"plugin-flat": 7, "plugin-stock": 12.5, "plugin-none": 99} {
+        if history, _ := tracker.GetPriceHistory(id, 10, true); len(history) != 1 || history[0].Price != want {
+            t.Errorf("%s history = %+v, want %v", id, history, want)
+        }
+    }
+    if history, _ := tracker.GetPriceHistory("plugin-stock", 10, true); len(history) == 1 && (history[0].InStock == nil || *history[0].InStock) {
+        t.Errorf("plugin-stock in stock = %v, want the plugin's availability", history[0].InStock)
+    }
+
+    var listed struct {
+        Fetchers []string `json:"fetchers"`
+        Count    int      `json:"count"`
+    }
+    serve(t, server, "GET", "/api/v1/fetchers", "", &listed)
+    if strings.Join(listed.Fetchers, " ") != "scrape simulated test-flat test_stock" || listed.Count != 4 {
+        t.Errorf("fetchers = %+v", listed)
+    }
+
+    // a product can't name a fetcher that isn't registered
+    rec := serve(t, server, "POST", "/api/v1/products", `{"id": "plugin-missing", "name": "Jug", "url": "https://shop.test/jug", "fetcher": "test-missing"}`, nil)
+    if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `unknown fetcher \"test-missing\"`) {
+        t.Errorf("unknown fetcher = %d: %s", rec.Code, rec.Body)
+    }
+}
+
+func TestRegisterFetcherPanics(t *testing.T) {
+    registerTestFetcher(t, "test-taken", outOfStockFetcher{})
+    for name, register := range map[string]func(){
+        "taken":     func() { RegisterFetcher("test-taken", outOfStockFetcher{}) },
+        "built in":  func() { RegisterFetcher(FetcherScrape, outOfStockFetcher{}) },
+        "uppercase": func() { RegisterFetcher("Test", outOfStockFetcher{}) },
+        "leading -": func() { RegisterFetcher("-test", outOfStockFetcher{}) },
+        "nil":       func() { RegisterFetcher("test-nil", nil) },
+    } {
+        func() {
+            defer func() {
+                if recover() == nil {
+                    t.Errorf("%s: RegisterFetcher didn't panic", name)
+                }
+            }()
+            register()
+        }()
+    }
+    if _, err := namedFetcher("test-nil"); err == nil {
+        t.Error("a nil fetcher was registered")
+    }
+}
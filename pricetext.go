@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// priceNumberPattern matches the first number in a price string with its
+// separators: dots, commas and apostrophes between digits, and spaces
+// before a group of three digits
+var priceNumberPattern = regexp.MustCompile(`\d+(?:[.,'’]\d+|[ \x{00a0}\x{202f}]\d{3}\b)*`)
+
+// localeDecimals are the decimal separators of price locales, by language
+// and by the language-region pairs that differ from their language
+var localeDecimals = map[string]byte{
+    "en": '.', "ja": '.', "zh": '.', "ko": '.', "hi": '.', "th": '.', "he": '.',
+    "ms": '.', "fil": '.', "ta": '.', "te": '.', "bn": '.', "ar": '.',
+    "de": ',', "fr": ',', "es": ',', "it": ',', "nl": ',', "pt": ',', "ru": ',',
+    "pl": ',', "sv": ',', "da": ',', "fi": ',', "nb": ',', "nn": ',', "no": ',',
+    "cs": ',', "sk": ',', "tr": ',', "el": ',', "hu": ',', "ro": ',', "id": ',',
+    "vi": ',', "uk": ',', "bg": ',', "hr": ',', "sl": ',', "sr": ',', "lt": ',',
+    "lv": ',', "et": ',', "ca": ',', "is": ',',
+    "de-ch": '.', "de-li": '.', "fr-ch": '.', "it-ch": '.', "es-mx": '.',
+    "es-us": '.', "en-za": ',',
+}
+
+// priceCurrencySymbols maps the symbols written next to prices to the
+// currencies using them. The first currency of a shared symbol such as $ is
+// assumed unless the product is tracked in one of the others.
+var priceCurrencySymbols = map[string][]string{
+    "$":   {"USD", "CAD", "AUD", "NZD", "MXN", "SGD", "HKD"},
+    "US$": {"USD"},
+    "C$":  {"CAD"},
+    "CA$": {"CAD"},
+    "A$":  {"AUD"},
+    "AU$": {"AUD"},
+    "NZ$": {"NZD"},
+    "MX$": {"MXN"},
+    "S$":  {"SGD"},
+    "HK$": {"HKD"},
+    "R$":  {"BRL"},
+    "€":   {"EUR"},
+    "£":   {"GBP"},
+    "¥":   {"JPY", "CNY"},
+    "円":   {"JPY"},
+    "CN¥": {"CNY"},
+    "元":   {"CNY"},
+    "₹":   {"INR"},
+    "Rs":  {"INR"},
+    "₩":   {"KRW"},
+    "₽":   {"RUB"},
+    "₺":   {"TRY"},
+    "₴":   {"UAH"},
+    "₪":   {"ILS"},
+    "₫":   {"VND"},
+    "฿":   {"THB"},
+    "₱":   {"PHP"},
+    "zł":  {"PLN"},
+    "Kč":  {"CZK"},
+    "Ft":  {"HUF"},
+    "kr":  {"SEK", "NOK", "DKK", "ISK"},
+    "Fr":  {"CHF"},
+}
+
+// priceCurrencyCodes are the ISO 4217 codes recognized when written next to
+// a price instead of a symbol
+var priceCurrencyCodes = map[string]bool{
+    "USD": true, "EUR": true, "GBP": true, "JPY": true, "CNY": true, "INR": true,
+    "CAD": true, "AUD": true, "NZD": true, "MXN": true, "SGD": true, "HKD": true,
+    "BRL": true, "CHF": true, "SEK": true, "NOK": true, "DKK": true, "ISK": true,
+    "PLN": true, "CZK": true, "HUF": true, "RON": true, "BGN": true, "TRY": true,
+    "RUB": true, "UAH": true, "ILS": true, "KRW": true, "THB": true, "VND": true,
+    "PHP": true, "IDR": true, "MYR": true, "ZAR": true, "AED": true, "SAR": true,
+}
+
+// parsePrice extracts a price from text such as "$1,299.99", dropping
+// currency symbols and guessing which separator is the decimal one
+func parsePrice(text string) (float64, error) {
+    price, _, err := parsePriceText(text, "", "")
+    return price, err
+}
+
+// parsePriceText reads the first number in text as a price, e.g.
+// "1.299,99 €", "₹1,29,999" or "$1,299.99", along with the currency of the
+// symbol or code written next to it. The locale, such as "de-DE", says
+// which separator is the decimal one; without one it is guessed. want
+// settles symbols shared by several currencies. The currency is empty when
+// the text doesn't show one.
+func parsePriceText(text, locale, want string) (float64, string, error) {
+    decimal, err := localeDecimal(locale)
+    if err != nil {
+        return 0, "", err
+    }
+    loc := priceNumberPattern.FindStringIndex(text)
+    if loc == nil {
+        return 0, "", fmt.Errorf("no price found in %q", strings.TrimSpace(text))
+    }
+
+    number := normalizeNumber(text[loc[0]:loc[1]], decimal)
+    price, err := strconv.ParseFloat(number, 64)
+    if err != nil {
+        return 0, "", fmt.Errorf("parsing price %q: %w", text[loc[0]:loc[1]], err)
+    }
+
+    // a symbol before the number wins over one after it
+    currency := tokenCurrency(lastToken(text[:loc[0]]), want)
+    if currency == "" {
+        currency = tokenCurrency(firstToken(text[loc[1]:]), want)
+    }
+    return price, currency, nil
+}
+
+// localeDecimal returns the decimal separator of a locale such as "de-DE"
+// or "en_IN", or 0 for no locale
+func localeDecimal(locale string) (byte, error) {
+    if locale == "" {
+        return 0, nil
+    }
+    tag := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+    if decimal, ok := localeDecimals[tag]; ok {
+        return decimal, nil
+    }
+    lang, _, _ := strings.Cut(tag, "-")
+    if decimal, ok := localeDecimals[lang]; ok {
+        return decimal, nil
+    }
+    return 0, fmt.Errorf("unknown price locale %q", locale)
+}
+
+// normalizeNumber rewrites a matched number for strconv: the last decimal
+// separator becomes a dot and every other separator is dropped. A zero
+// decimal is guessed from the number.
+func normalizeNumber(number string, decimal byte) string {
+    number = strings.Map(func(r rune) rune {
+        switch r {
+        case ' ', '\u00a0', '\u202f', '\'', '’':
+            return -1
+        }
+        return r
+    }, number)
+    if decimal == 0 {
+        decimal = guessDecimal(number)
+    }
+
+    point := -1
+    if decimal != 0 {
+        point = strings.LastIndexByte(number, decimal)
+    }
+    var b strings.Builder
+    for i := 0; i < len(number); i++ {
+        switch {
+        case i == point:
+            b.WriteByte('.')
+        case number[i] == '.' || number[i] == ',':
+        default:
+            b.WriteByte(number[i])
+        }
+    }
+    return b.String()
+}
+
+// guessDecimal picks the decimal separator of a number written without a
+// locale: the later of a dot and a comma when it has both, neither when one
+// repeats (1,29,999), a lone comma unless three digits follow it (1,299)
+// and otherwise a lone dot
+func guessDecimal(number string) byte {
+    dot, comma := strings.LastIndexByte(number, '.'), strings.LastIndexByte(number, ',')
+    switch {
+    case dot >= 0 && comma >= 0:
+        if dot > comma {
+            return '.'
+        }
+        return ','
+    case comma >= 0:
+        if strings.Count(number, ",") > 1 || len(number)-comma-1 == 3 {
+            return 0
+        }
+        return ','
+    case dot >= 0:
+        if strings.Count(number, ".") > 1 {
+            return 0
+        }
+        return '.'
+    }
+    return 0
+}
+
+// isCurrencyRune reports whether r can be part of a currency symbol or code
+func isCurrencyRune(r rune) bool {
+    return unicode.IsLetter(r) || unicode.IsSymbol(r) || r == '.'
+}
+
+// lastToken returns the currency-like characters at the end of s
+func lastToken(s string) string {
+    s = strings.TrimRightFunc(s, unicode.IsSpace)
+    i := strings.LastIndexFunc(s, func(r rune) bool { return !isCurrencyRune(r) })
+    if i < 0 {
+        return s
+    }
+    _, size := utf8.DecodeRuneInString(s[i:])
+    return s[i+size:]
+}
+
+// firstToken returns the currency-like characters at the start of s,
+// skipping leading spaces
+func firstToken(s string) string {
+    s = strings.TrimLeftFunc(s, unicode.IsSpace)
+    if end := strings.IndexFunc(s, func(r rune) bool { return !isCurrencyRune(r) }); end >= 0 {
+        return s[:end]
+    }
+    return s
+}
+
+// tokenCurrency returns the currency a symbol or code stands for, or ""
+func tokenCurrency(token, want string) string {
+    token = strings.Trim(token, ".")
+    if currencies, ok := priceCurrencySymbols[token]; ok {
+        for _, currency := range currencies {
+            if currency == want {
+                return currency
+            }
+        }
+        return currencies[0]
+    }
+    if code := strings.ToUpper(token); len(token) == 3 && priceCurrencyCodes[code] {
+        return code
+    }
+    return ""
+}
+
+// parsePagePrice reads a price from text a product's price rule extracted
+// from its page, in the product's locale, and fails if the text shows a
+// currency other than the product's
+func parsePagePrice(text string, product Product) (float64, error) {
+    want := product.Currency
+    if want == "" {
+        want = DefaultCurrency
+    }
+    price, currency, err := parsePriceText(text, product.PriceLocale, want)
+    if err != nil {
+        return 0, err
+    }
+    if currency != "" && currency != want {
+        return 0, fmt.Errorf("price %q is in %s, but the product is tracked in %s", strings.TrimSpace(text), currency, want)
+    }
+    return price, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePriceText(t *testing.T) {
+    tests := []struct {
+        text     string
+        locale   string
+        want     string
+        price    float64
+        currency string
+    }{
+        // separators guessed without a locale
+        {"1.299,99 €", "", "", 1299.99, "EUR"},
+        {"₹1,29,999", "", "", 129999, "INR"},
+        {"$1,299.99", "", "", 1299.99, "USD"},
+        {"12,50 €", "", "", 12.5, "EUR"},
+        {"1,299", "", "", 1299, ""},
+        {"¥12,800", "", "", 12800, "JPY"},
+        {"Now only 99.95 USD!", "", "", 99.95, "USD"},
+        {"EUR 45,00", "", "", 45, "EUR"},
+        {"19,99 zł", "", "", 19.99, "PLN"},
+        {"£1,049", "", "", 1049, "GBP"},
+
+        // the locale says which separator is the decimal one
+        {"1.299", "de-DE", "", 1299, ""},
+        {"1.299", "en-US", "", 1.299, ""},
+        {"1,299", "de_DE", "", 1.299, ""},
+        {"1 299,99 €", "fr-FR", "", 1299.99, "EUR"},
+        {"1 299,99 €", "fr", "", 1299.99, "EUR"},
+        {"CHF 1'299.50", "de-CH", "", 1299.5, "CHF"},
+        {"₹1,29,999.50", "en-IN", "", 129999.5, "INR"},
+
+        // shared symbols follow the product's currency
+        {"$25", "", "CAD", 25, "CAD"},
+        {"$25", "", "EUR", 25, "USD"},
+        {"kr 1.299,00", "", "NOK", 1299, "NOK"},
+        {"kr 1.299,00", "", "", 1299, "SEK"},
+        {"C$25.00", "", "USD", 25, "CAD"},
+    }
+    for _, tt := range tests {
+        price, currency, err := parsePriceText(tt.text, tt.locale, tt.want)
+        if err != nil {
+            t.Errorf("parsePriceText(%q, %q) failed: %v", tt.text, tt.locale, err)
+            continue
+        }
+        if price != tt.price || currency != tt.currency {
+            t.Errorf("parsePriceText(%q, %q, %q) = %v %q, want %v %q", tt.text, tt.locale, tt.want, price, currency, tt.price, tt.currency)
+        }
+    }
+}
+
+func TestParsePriceTextInvalid(t *testing.T) {
+    for _, tt := range []struct{ text, locale, err string }{
+        {"Sold out", "", "no price found"},
+        {"", "", "no price found"},
+        {"$12.99", "xx-YY", "unknown price locale"},
+    } {
+        if _, _, err := parsePriceText(tt.text, tt.locale, ""); err == nil || !strings.Contains(err.Error(), tt.err) {
+            t.Errorf("parsePriceText(%q, %q) = %v, want %q", tt.text, tt.locale, err, tt.err)
+        }
+    }
+}
+
+func TestParsePagePrice(t *testing.T) {
+    euro := Product{ID: "locale-kettle", Currency: "EUR", PriceLocale: "de-DE"}
+    if price, err := parsePagePrice(" 1.299,99 € ", euro); err != nil || price != 1299.99 {
+        t.Errorf("euro price = %v, %v", price, err)
+    }
+    // a price shown in another currency than the product's isn't taken
+    if _, err := parsePagePrice("£1.299,99", euro); err == nil || !strings.Contains(err.Error(), "GBP") {
+        t.Errorf("pound price on a euro product: err = %v", err)
+    }
+    // no currency shown: the product's is assumed
+    if price, err := parsePagePrice("1.299", euro); err != nil || price != 1299 {
+        t.Errorf("bare price = %v, %v", price, err)
+    }
+    // products without a currency are in dollars
+    if _, err := parsePagePrice("€10", Product{ID: "locale-plain"}); err == nil {
+        t.Error("a euro price was taken for a dollar product")
+    }
+}
+
+func TestScrapeLocalizedPrice(t *testing.T) {
+    pages := newPageServer(t, `<html><body><span class="preis">1.299,99&nbsp;€</span></body></html>`)
+    product := Product{ID: "locale-page", URL: pages.URL + "/kettle", PriceSelector: ".preis", Currency: "EUR", PriceLocale: "de-DE"}
+
+    reading, err := scrapeReading(t.Context(), product)
+    if err != nil || reading.Price != 1299.99 {
+        t.Errorf("reading = %+v, %v; want 1299.99", reading, err)
+    }
+}
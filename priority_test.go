@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// fetchRecorder is a fetcher that records which products it fetched, in
+// order, failing with a transient error for those in fail
+type fetchRecorder struct {
+    mu      sync.Mutex
+    fetched []string
+    fail    map[string]bool
+}
+
+func (f *fetchRecorder) FetchPrice(ctx context.Context, product Product) (float64, error) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.fetched = append(f.fetched, product.ID)
+    if f.fail[product.ID] {
+        return 0, retryableError{errors.New("503 Service Unavailable")}
+    }
+    return 10, nil
+}
+
+func (f *fetchRecorder) calls() []string {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    return append([]string(nil), f.fetched...)
+}
+
+func addPriorityProduct(t *testing.T, tracker *PriceTracker, id, priority string) {
+    t.Helper()
+    if _, err := tracker.CreateProduct(Product{ID: id, Name: id, URL: "https://shop.test/" + id, Priority: priority}); err != nil {
+        t.Fatalf("CreateProduct: %v", err)
+    }
+}
+
+func TestPriorityTierOrdering(t *testing.T) {
+    fetcher := &fetchRecorder{}
+    tracker := newTestTracker(t, fetcher)
+    // one worker fetches strictly in dispatch order
+    tracker.SetNumWorkers(1)
+    tracker.SetRetryPolicy(1, 0)
+
+    addPriorityProduct(t, tracker, "a-low", PriorityLow)
+    addPriorityProduct(t, tracker, "b-normal", "")
+    addPriorityProduct(t, tracker, "c-high", PriorityHigh)
+    addPriorityProduct(t, tracker, "d-low", PriorityLow)
+    addPriorityProduct(t, tracker, "e-high", PriorityHigh)
+    addPriorityProduct(t, tracker, "f-normal", PriorityNormal)
+
+    tracker.trackProducts(context.Background(), tracker.snapshotProducts(), nil)
+
+    got := fetcher.calls()
+    want := []string{"c-high", "e-high", "b-normal", "f-normal", "a-low", "d-low"}
+    if len(got) != len(want) {
+        t.Fatalf("fetched %v, want %v", got, want)
+    }
+    for i := range want {
+        if got[i] != want[i] {
+            t.Fatalf("fetched %v, want %v", got, want)
+        }
+    }
+
+    product, err := tracker.GetProduct("b-normal")
+    if err != nil || product.Priority != PriorityNormal {
+        t.Errorf("product without a priority = %q, %v; want normal", product.Priority, err)
+    }
+    if _, err := tracker.CreateProduct(Product{ID: "urgent", Name: "urgent", URL: "https://shop.test/urgent", Priority: "urgent"}); err == nil {
+        t.Error("an unknown priority was accepted")
+    }
+}
+
+func TestPriorityTierRetryBudget(t *testing.T) {
+    fetcher := &fetchRecorder{fail: map[string]bool{"tier-high": true, "tier-normal": true, "tier-low": true}}
+    tracker := newTestTracker(t, fetcher)
+    tracker.SetNumWorkers(1)
+    tracker.SetRetryPolicy(2, 1)
+
+    addPriorityProduct(t, tracker, "tier-high", PriorityHigh)
+    addPriorityProduct(t, tracker, "tier-normal", PriorityNormal)
+    addPriorityProduct(t, tracker, "tier-low", PriorityLow)
+
+    tracker.trackProducts(context.Background(), tracker.snapshotProducts(), nil)
+
+    attempts := map[string]int{}
+    for _, id := range fetcher.calls() {
+        attempts[id]++
+    }
+    for id, want := range map[string]int{"tier-high": 3, "tier-normal": 2, "tier-low": 1} {
+        if attempts[id] != want {
+            t.Errorf("%s was tried %d times, want %d", id, attempts[id], want)
+        }
+    }
+
+    if got := tierAttempts(PriorityLow, 1); got != 1 {
+        t.Errorf("low priority with one attempt = %d, want 1", got)
+    }
+}
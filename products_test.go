@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAddProduct(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+
+    var created Product
+    body := `{"id": "kettle", "name": "Kettle", "url": "https://shop.test/kettle", "price_selector": ".price"}`
+    if rec := serve(t, server, "POST", "/api/v1/products", body, &created); rec.Code != http.StatusCreated {
+        t.Fatalf("create = %d: %s", rec.Code, rec.Body)
+    }
+    if created.ID != "kettle" || created.Name != "Kettle" || created.PriceSelector != ".price" || created.Priority != PriorityNormal || created.Currency != DefaultCurrency {
+        t.Errorf("created = %+v", created)
+    }
+
+    var fetched Product
+    if rec := serve(t, server, "GET", "/api/v1/products/kettle", "", &fetched); rec.Code != http.StatusOK || fetched.URL != "https://shop.test/kettle" {
+        t.Errorf("get = %d %+v", rec.Code, fetched)
+    }
+    if _, err := server.tracker.GetProduct("kettle"); err != nil {
+        t.Errorf("the product isn't tracked: %v", err)
+    }
+
+    // the ID is taken: 409, and the first product is left alone
+    body = `{"id": "kettle", "name": "Other", "url": "https://shop.test/other"}`
+    if rec := serve(t, server, "POST", "/api/v1/products", body, nil); rec.Code != http.StatusConflict {
+        t.Errorf("duplicate = %d, want 409", rec.Code)
+    }
+    if product, _ := server.tracker.GetProduct("kettle"); product.Name != "Kettle" {
+        t.Errorf("the duplicate overwrote the product: %+v", product)
+    }
+}
+
+func TestAddProductInvalid(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+
+    for name, body := range map[string]string{
+        "malformed":     `{"id": "kettle",`,
+        "not an object": `["kettle"]`,
+        "no id or url":  `{"name": "Kettle"}`,
+        "relative url":  `{"id": "kettle", "name": "Kettle", "url": "/kettle"}`,
+        "bad scheme":    `{"id": "kettle", "name": "Kettle", "url": "ftp://shop.test/kettle"}`,
+        "bad priority":  `{"id": "kettle", "name": "Kettle", "url": "https://shop.test/kettle", "priority": "urgent"}`,
+    } {
+        if rec := serve(t, server, "POST", "/api/v1/products", body, nil); rec.Code != http.StatusBadRequest {
+            t.Errorf("%s = %d, want 400: %s", name, rec.Code, rec.Body)
+        }
+    }
+    if page, _ := server.tracker.GetProducts(ProductFilter{}, 10, 0); page.Total != 0 {
+        t.Errorf("invalid requests added %d products", page.Total)
+    }
+}
+
+func TestDeleteProduct(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    addTestProduct(t, server.tracker, "delete-purged", 10, 11)
+    addTestProduct(t, server.tracker, "delete-archived", 20, 21)
+    addTestProduct(t, server.tracker, "delete-kept", 30)
+
+    if rec := serve(t, server, "DELETE", "/api/v1/products/delete-purged?purge=true", "", nil); rec.Code != http.StatusNoContent {
+        t.Fatalf("delete = %d: %s", rec.Code, rec.Body)
+    }
+    if rec := serve(t, server, "GET", "/api/v1/products/delete-purged", "", nil); rec.Code != http.StatusNotFound {
+        t.Errorf("deleted product = %d, want 404", rec.Code)
+    }
+    if history, err := server.tracker.db.GetPriceHistory("delete-purged", 10, true); err != nil || len(history) != 0 {
+        t.Errorf("purged history = %v, %v; want none left", history, err)
+    }
+
+    // without purge the history moves to the archive
+    if rec := serve(t, server, "DELETE", "/api/v1/products/delete-archived", "", nil); rec.Code != http.StatusNoContent {
+        t.Fatalf("delete = %d: %s", rec.Code, rec.Body)
+    }
+    if history, _ := server.tracker.db.GetPriceHistory("delete-archived", 10, true); len(history) != 0 {
+        t.Errorf("archived product still has %d price entries", len(history))
+    }
+    if archived, err := server.tracker.GetArchivedHistory("delete-archived", "", 10); err != nil || len(archived) != 2 {
+        t.Errorf("archive = %v, %v; want both entries", archived, err)
+    }
+
+    // other products are untouched
+    if history, _ := server.tracker.GetPriceHistory("delete-kept", 10, false); len(history) != 1 {
+        t.Errorf("kept product has %d entries, want 1", len(history))
+    }
+
+    for path, want := range map[string]int{
+        "/api/v1/products/delete-purged":             http.StatusNotFound,
+        "/api/v1/products/no-such-product":           http.StatusNotFound,
+        "/api/v1/products/delete-kept?purge=perhaps": http.StatusBadRequest,
+    } {
+        if rec := serve(t, server, "DELETE", path, "", nil); rec.Code != want {
+            t.Errorf("DELETE %s = %d, want %d", path, rec.Code, want)
+        }
+    }
+}
+
+func TestDeleteProductMidCycle(t *testing.T) {
+    // the fetch waits until the product has been deleted
+    fetching := make(chan struct{})
+    deleted := make(chan struct{})
+    fetcher := PriceFetcherFunc(func(ctx context.Context, product Product) (float64, error) {
+        close(fetching)
+        <-deleted
+        return 42, nil
+    })
+    tracker := newTestTracker(t, fetcher)
+    tracker.SetRetryPolicy(1, 0)
+    addTestProduct(t, tracker, "delete-midcycle")
+
+    done := make(chan struct{})
+    go func() {
+        tracker.trackProducts(context.Background(), tracker.snapshotProducts(), nil)
+        close(done)
+    }()
+
+    <-fetching
+    if err := tracker.DeleteProduct("delete-midcycle", true); err != nil {
+        t.Fatal(err)
+    }
+    close(deleted)
+    <-done
+
+    if _, err := tracker.GetProduct("delete-midcycle"); err == nil {
+        t.Error("the cycle brought the deleted product back")
+    }
+    if history, _ := tracker.db.GetPriceHistory("delete-midcycle", 10, true); len(history) != 0 {
+        t.Errorf("the cycle stored %d prices for the deleted product", len(history))
+    }
+}
+
+func TestProductsPagination(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    for i := 0; i < 5; i++ {
+        addTestProduct(t, server.tracker, fmt.Sprintf("page-%d", i), 10)
+    }
+
+    var page ProductPage
+    rec := serve(t, server, "GET", "/api/v1/products?limit=2&offset=4", "", &page)
+    if rec.Code != http.StatusOK || page.Total != 5 || page.Limit != 2 || page.Offset != 4 || len(page.Items) != 1 || page.Items[0].ID != "page-4" {
+        t.Fatalf("last page = %d %+v", rec.Code, page)
+    }
+    if got := rec.Header().Get("X-Total-Count"); got != "5" {
+        t.Errorf("X-Total-Count = %q", got)
+    }
+    if link := rec.Header().Get("Link"); !strings.Contains(link, `offset=2>; rel="prev"`) || strings.Contains(link, `rel="next"`) {
+        t.Errorf("Link = %q", link)
+    }
+
+    // past the end: no items, still the total
+    page = ProductPage{}
+    serve(t, server, "GET", "/api/v1/products?limit=2&offset=6", "", &page)
+    if page.Total != 5 || len(page.Items) != 0 {
+        t.Errorf("past the end = %+v", page)
+    }
+
+    // no parameters: the first page at the default size; large limits are clamped
+    for path, want := range map[string]int{
+        "/api/v1/products":            defaultProductsLimit,
+        "/api/v1/products?limit=5000": maxProductsLimit,
+    } {
+        page = ProductPage{}
+        serve(t, server, "GET", path, "", &page)
+        if page.Limit != want || page.Offset != 0 || len(page.Items) != 5 || page.Total != 5 {
+            t.Errorf("%s = %+v, want limit %d", path, page, want)
+        }
+    }
+
+    for _, query := range []string{"offset=-1", "limit=0", "limit=-5", "limit=ten", "offset=x"} {
+        if rec := serve(t, server, "GET", "/api/v1/products?"+query, "", nil); rec.Code != http.StatusBadRequest {
+            t.Errorf("%s = %d, want 400", query, rec.Code)
+        }
+    }
+}
+
+func TestProductsPageLinks(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    for i := 0; i < 5; i++ {
+        addTestProduct(t, server.tracker, fmt.Sprintf("link-%d", i), 10)
+    }
+    addTestProduct(t, server.tracker, "other", 10)
+
+    tests := []struct {
+        path  string
+        total string
+        link  string
+    }{
+        // the page's own filters are kept in every link
+        {"/api/v1/products?q=link&sort=name&limit=2&offset=2", "5",
+            `</api/v1/products?limit=2&offset=0&q=link&sort=name>; rel="first", ` +
+                `</api/v1/products?limit=2&offset=0&q=link&sort=name>; rel="prev", ` +
+                `</api/v1/products?limit=2&offset=4&q=link&sort=name>; rel="next", ` +
+                `</api/v1/products?limit=2&offset=4&q=link&sort=name>; rel="last"`},
+        {"/api/v1/products?limit=3", "6",
+            `</api/v1/products?limit=3&offset=0>; rel="first", ` +
+                `</api/v1/products?limit=3&offset=3>; rel="next", ` +
+                `</api/v1/products?limit=3&offset=3>; rel="last"`},
+        // past the end, prev goes back to the last page
+        {"/api/v1/products?limit=2&offset=10", "6",
+            `</api/v1/products?limit=2&offset=0>; rel="first", ` +
+                `</api/v1/products?limit=2&offset=4>; rel="prev", ` +
+                `</api/v1/products?limit=2&offset=4>; rel="last"`},
+        {"/api/v1/products?q=nothing", "0",
+            `</api/v1/products?limit=50&offset=0&q=nothing>; rel="first", ` +
+                `</api/v1/products?limit=50&offset=0&q=nothing>; rel="last"`},
+        // search results page the same way
+        {"/api/v1/products/search?q=link&limit=4", "5",
+            `</api/v1/products/search?limit=4&offset=0&q=link>; rel="first", ` +
+                `</api/v1/products/search?limit=4&offset=4&q=link>; rel="next", ` +
+                `</api/v1/products/search?limit=4&offset=4&q=link>; rel="last"`},
+    }
+    for _, tt := range tests {
+        rec := serve(t, server, "GET", tt.path, "", nil)
+        if rec.Code != http.StatusOK {
+            t.Fatalf("%s = %d: %s", tt.path, rec.Code, rec.Body)
+        }
+        if got := rec.Header().Get("X-Total-Count"); got != tt.total {
+            t.Errorf("%s: X-Total-Count = %q, want %q", tt.path, got, tt.total)
+        }
+        if got := rec.Header().Get("Link"); got != tt.link {
+            t.Errorf("%s: Link =\n%s\nwant\n%s", tt.path, got, tt.link)
+        }
+    }
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+    // proxyMaxFailures is how many requests in a row may fail through a
+    // proxy before it is taken out of the pool
+    proxyMaxFailures = 3
+    // proxyDeadCooldown is how long a dead proxy is left out before it is
+    // tried again
+    proxyDeadCooldown = 5 * time.Minute
+)
+
+// Proxy assignment strategies
+const (
+    ProxyRoundRobin = "round-robin"
+    ProxyRandom     = "random"
+)
+
+// errNoLiveProxies is returned when every configured proxy is dead. Requests
+// fail rather than go out directly.
+var errNoLiveProxies = errors.New("no live proxies")
+
+// proxyPool spreads page fetches over a list of HTTP or SOCKS proxies. Each
+// domain is assigned a proxy, round-robin or at random, and keeps it while
+// it stays alive; a proxy that fails proxyMaxFailures requests in a row is
+// left out for proxyDeadCooldown and its domains move to other proxies.
+type proxyPool struct {
+    mu       sync.Mutex
+    proxies  []*proxyState
+    random   bool
+    next     int
+    assigned map[string]*proxyState
+}
+
+type proxyState struct {
+    url       *url.URL
+    failures  int
+    deadUntil time.Time
+    succeeded uint64
+    failed    uint64
+}
+
+// proxies is the pool page fetches go through; nil fetches directly
+var proxies *proxyPool
+
+// proxyKey carries the chosen proxy from proxyTransport to the transport's
+// Proxy func
+type proxyKey struct{}
+
+// proxyTransport sends each request through the proxy its domain is
+// assigned and reports the outcome back to the pool
+type proxyTransport struct {
+    pool *proxyPool
+    base http.RoundTripper
+}
+
+// SetProxies routes page fetches through list, assigning proxies to domains
+// with strategy. An empty list fetches directly.
+func SetProxies(list []*url.URL, strategy string) {
+    if len(list) == 0 {
+        proxies = nil
+        scrapeClient.Transport = nil
+        return
+    }
+
+    pool := &proxyPool{
+        random:   strategy == ProxyRandom,
+        assigned: make(map[string]*proxyState),
+    }
+    for _, proxyURL := range list {
+        pool.proxies = append(pool.proxies, &proxyState{url: proxyURL})
+    }
+
+    base := http.DefaultTransport.(*http.Transport).Clone()
+    base.Proxy = func(req *http.Request) (*url.URL, error) {
+        proxyURL, _ := req.Context().Value(proxyKey{}).(*url.URL)
+        return proxyURL, nil
+    }
+    proxies = pool
+    scrapeClient.Transport = &proxyTransport{pool: pool, base: base}
+}
+
+// ParseProxies parses a comma separated list of proxy URLs with an http,
+// https, socks5 or socks5h scheme
+func ParseProxies(list string) ([]*url.URL, error) {
+    var parsed []*url.URL
+    for _, raw := range strings.Split(list, ",") {
+        raw = strings.TrimSpace(raw)
+        if raw == "" {
+            continue
+        }
+        proxyURL, err := url.Parse(raw)
+        if err != nil {
+            return nil, fmt.Errorf("invalid proxy %q: %w", raw, err)
+        }
+        switch proxyURL.Scheme {
+        case "http", "https", "socks5", "socks5h":
+        default:
+            return nil, fmt.Errorf("invalid proxy %q: scheme must be http, https, socks5 or socks5h", raw)
+        }
+        if proxyURL.Host == "" {
+            return nil, fmt.Errorf("invalid proxy %q: missing host", raw)
+        }
+        parsed = append(parsed, proxyURL)
+    }
+    return parsed, nil
+}
+
+func (t *proxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    proxy, err := t.pool.pick(req.URL.Hostname())
+    if err != nil {
+        return nil, err
+    }
+
+    resp, err := t.base.RoundTrip(req.WithContext(context.WithValue(req.Context(), proxyKey{}, proxy.url)))
+    // a cancelled fetch says nothing about the proxy
+    if req.Context().Err() == nil {
+        t.pool.report(proxy, err == nil && resp.StatusCode != http.StatusProxyAuthRequired)
+    }
+    return resp, err
+}
+
+// pick returns the proxy for host, assigning a live one if it has none or
+// its proxy has died
+func (p *proxyPool) pick(host string) (*proxyState, error) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    now := time.Now()
+    domain := strings.TrimPrefix(strings.ToLower(host), "www.")
+    if proxy, ok := p.assigned[domain]; ok && proxy.alive(now) {
+        return proxy, nil
+    }
+
+    var live []*proxyState
+    for _, proxy := range p.proxies {
+        if proxy.alive(now) {
+            live = append(live, proxy)
+        }
+    }
+    if len(live) == 0 {
+        return nil, errNoLiveProxies
+    }
+
+    var proxy *proxyState
+    if p.random {
+        proxy = live[rand.Intn(len(live))]
+    } else {
+        proxy = live[p.next%len(live)]
+        p.next++
+    }
+    p.assigned[domain] = proxy
+    return proxy, nil
+}
+
+// report records a request's outcome, taking the proxy out of the pool after
+// too many failures in a row
+func (p *proxyPool) report(proxy *proxyState, ok bool) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    if ok {
+        proxy.succeeded++
+        proxy.failures = 0
+        return
+    }
+    proxy.failed++
+    proxy.failures++
+    if proxy.failures >= proxyMaxFailures {
+        proxy.deadUntil = time.Now().Add(proxyDeadCooldown)
+        proxy.failures = 0
+        log.Printf("Proxy %s failed %d requests in a row, removing it for %v", proxy.url.Redacted(), proxyMaxFailures, proxyDeadCooldown)
+    }
+}
+
+func (s *proxyState) alive(now time.Time) bool {
+    return !now.Before(s.deadUntil)
+}
+
+var (
+    proxyRequestsDesc = prometheus.NewDesc("price_tracker_proxy_requests_total",
+        "Requests sent through each proxy, by result.", []string{"proxy", "result"}, nil)
+    proxyUpDesc = prometheus.NewDesc("price_tracker_proxy_up",
+        "Whether each proxy is in the pool (1) or removed as dead (0).", []string{"proxy"}, nil)
+)
+
+// proxyCollector exports the pool's per-proxy counts at scrape time
+type proxyCollector struct{}
+
+func (proxyCollector) Describe(ch chan<- *prometheus.Desc) {
+    ch <- proxyRequestsDesc
+    ch <- proxyUpDesc
+}
+
+func (proxyCollector) Collect(ch chan<- prometheus.Metric) {
+    pool := proxies
+    if pool == nil {
+        return
+    }
+
+    pool.mu.Lock()
+    defer pool.mu.Unlock()
+
+    now := time.Now()
+    for _, proxy := range pool.proxies {
+        name := proxy.url.Redacted()
+        up := 0.0
+        if proxy.alive(now) {
+            up = 1
+        }
+        ch <- prometheus.MustNewConstMetric(proxyRequestsDesc, prometheus.CounterValue, float64(proxy.succeeded), name, "success")
+        ch <- prometheus.MustNewConstMetric(proxyRequestsDesc, prometheus.CounterValue, float64(proxy.failed), name, "failure")
+        ch <- prometheus.MustNewConstMetric(proxyUpDesc, prometheus.GaugeValue, up, name)
+    }
+}
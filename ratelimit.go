@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+    // defaultHostRateLimit is how many fetches per minute go to one host when
+    // no limit is configured
+    defaultHostRateLimit = 60
+    // defaultHostRateBurst is how many fetches to one host may go back to
+    // back before the rate limit spaces them out
+    defaultHostRateBurst = 5
+)
+
+// hostLimiter is a token bucket per host, so products that share a retailer
+// are fetched at a polite rate however many workers are free. A zero rate
+// disables it.
+type hostLimiter struct {
+    mu        sync.Mutex
+    perMinute int
+    burst     int
+    buckets   map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+    tokens float64
+    last   time.Time
+}
+
+func newHostLimiter() *hostLimiter {
+    return &hostLimiter{buckets: make(map[string]*tokenBucket)}
+}
+
+// SetHostRateLimit caps how many fetches per minute go to any one host,
+// allowing bursts of up to burst back to back. Zero removes the cap.
+func (pt *PriceTracker) SetHostRateLimit(perMinute, burst int) {
+    l := pt.hostLimiter
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    if burst < 1 {
+        burst = 1
+    }
+    l.perMinute = perMinute
+    l.burst = burst
+    l.buckets = make(map[string]*tokenBucket)
+}
+
+// wait blocks until a fetch of pageURL is allowed or ctx is done
+func (l *hostLimiter) wait(ctx context.Context, pageURL string) error {
+    host := urlHost(pageURL)
+    for {
+        delay := l.reserve(host, time.Now())
+        if delay <= 0 {
+            return nil
+        }
+
+        timer := time.NewTimer(delay)
+        select {
+        case <-timer.C:
+        case <-ctx.Done():
+            timer.Stop()
+            return ctx.Err()
+        }
+    }
+}
+
+// reserve takes a token for host if one is available and otherwise returns
+// how long until the next one is
+func (l *hostLimiter) reserve(host string, now time.Time) time.Duration {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    if l.perMinute <= 0 {
+        return 0
+    }
+
+    bucket, ok := l.buckets[host]
+    if !ok {
+        bucket = &tokenBucket{tokens: float64(l.burst), last: now}
+        l.buckets[host] = bucket
+    }
+
+    perSecond := float64(l.perMinute) / 60
+    bucket.tokens += now.Sub(bucket.last).Seconds() * perSecond
+    if bucket.tokens > float64(l.burst) {
+        bucket.tokens = float64(l.burst)
+    }
+    bucket.last = now
+
+    if bucket.tokens >= 1 {
+        bucket.tokens--
+        return 0
+    }
+    return time.Duration((1 - bucket.tokens) / perSecond * float64(time.Second))
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHostRateLimitWaitsPerHost(t *testing.T) {
+    tracker := newTestTracker(t, nil)
+    // one fetch per host back to back, then one every 200ms
+    tracker.SetHostRateLimit(300, 1)
+    ctx := context.Background()
+
+    timed := func(pageURL string) time.Duration {
+        t.Helper()
+        start := time.Now()
+        if err := tracker.hostLimiter.wait(ctx, pageURL); err != nil {
+            t.Fatal(err)
+        }
+        return time.Since(start)
+    }
+
+    if took := timed("https://shop.test/kettle"); took > 50*time.Millisecond {
+        t.Errorf("first request waited %v", took)
+    }
+    // another host has its own bucket
+    if took := timed("https://other.test/kettle"); took > 50*time.Millisecond {
+        t.Errorf("request to another host waited %v", took)
+    }
+    // the same host, even another page on it, waits for the next token
+    if took := timed("https://SHOP.test/toaster"); took < 150*time.Millisecond {
+        t.Errorf("second request to the host waited only %v, want about 200ms", took)
+    }
+
+    // a cancelled wait gives up
+    cancelled, cancel := context.WithCancel(ctx)
+    cancel()
+    if err := tracker.hostLimiter.wait(cancelled, "https://shop.test/kettle"); !errors.Is(err, context.Canceled) {
+        t.Errorf("cancelled wait = %v", err)
+    }
+}
+
+func TestHostRateLimitBurst(t *testing.T) {
+    tracker := newTestTracker(t, nil)
+    tracker.SetHostRateLimit(60, 3)
+    l := tracker.hostLimiter
+    now := time.Now()
+
+    // the burst goes straight through, then a token a second
+    for i := 0; i < 3; i++ {
+        if delay := l.reserve("shop.test", now); delay != 0 {
+            t.Fatalf("request %d of the burst delayed %v", i+1, delay)
+        }
+    }
+    if delay := l.reserve("shop.test", now); delay != time.Second {
+        t.Errorf("after the burst: delay %v, want 1s", delay)
+    }
+    if delay := l.reserve("shop.test", now.Add(time.Second)); delay != 0 {
+        t.Errorf("a second later: delay %v, want a token", delay)
+    }
+
+    // an idle host refills up to the burst, not beyond
+    later := now.Add(time.Hour)
+    for i := 0; i < 3; i++ {
+        l.reserve("shop.test", later)
+    }
+    if delay := l.reserve("shop.test", later); delay == 0 {
+        t.Error("an idle host saved up more than the burst")
+    }
+
+    // no limit
+    tracker.SetHostRateLimit(0, 3)
+    for i := 0; i < 10; i++ {
+        if delay := l.reserve("shop.test", now); delay != 0 {
+            t.Fatalf("unlimited: delay %v", delay)
+        }
+    }
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// pageRenderer loads a product page in a headless browser and returns the
+// DOM after its scripts have run. It is nil unless the binary is built with
+// -tags chromedp (see render_chromedp.go).
+var pageRenderer func(ctx context.Context, product Product) (string, error)
+
+// errNoRenderer is returned for render_js products when the binary has no
+// headless browser support
+var errNoRenderer = errors.New("render_js needs a build with headless browser support (go build -tags chromedp)")
+
+// loadPage returns a product page's HTML, rendered in a headless browser
+// when the product asks for it. Pages disallowed by robots.txt aren't
+// loaded unless the product ignores it. Plain fetches are conditional on
+// cached validators; rendered pages are always loaded in full.
+func loadPage(ctx context.Context, product Product, cached pageValidators) (string, pageValidators, error) {
+    if !product.IgnoreRobots {
+        if err := checkRobots(ctx, product); err != nil {
+            return "", pageValidators{}, err
+        }
+    }
+    if !product.RenderJS {
+        return fetchPage(ctx, product, cached)
+    }
+    if pageRenderer == nil {
+        return "", pageValidators{}, errNoRenderer
+    }
+    page, err := pageRenderer(ctx, product)
+    if err != nil {
+        return "", pageValidators{}, err
+    }
+    if reason, blocked := detectBlock(nil, page); blocked {
+        return "", pageValidators{}, blockedError{reason: reason}
+    }
+    return page, pageValidators{}, nil
+}
@@ -0,0 +1,48 @@
+//go:build chromedp
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/chromedp/chromedp"
+)
+
+func init() {
+    pageRenderer = renderWithChromedp
+}
+
+// renderWithChromedp loads the product page in a fresh headless Chrome and
+// returns the rendered document. It waits for the product's price selector
+// when it has one, otherwise just for the body. Only the User-Agent of the
+// fetch profile applies. Chrome must be installed.
+func renderWithChromedp(ctx context.Context, product Product) (string, error) {
+    pageURL, err := url.Parse(product.URL)
+    if err != nil {
+        return "", err
+    }
+    opts := append(chromedp.DefaultExecAllocatorOptions[:], chromedp.UserAgent(product.FetchProfile.userAgent(pageURL.Hostname())))
+    allocCtx, cancelAlloc := chromedp.NewExecAllocator(ctx, opts...)
+    defer cancelAlloc()
+    browserCtx, cancelBrowser := chromedp.NewContext(allocCtx)
+    defer cancelBrowser()
+
+    ready := "body"
+    if product.PriceSelector != "" {
+        ready = product.PriceSelector
+    }
+
+    var html string
+    err = chromedp.Run(browserCtx,
+        chromedp.Navigate(product.URL),
+        chromedp.WaitReady(ready, chromedp.ByQuery),
+        chromedp.OuterHTML("html", &html, chromedp.ByQuery),
+    )
+    if err != nil {
+        // browser and navigation failures are usually transient
+        return "", retryableError{fmt.Errorf("rendering %s: %w", product.URL, err)}
+    }
+    return html, nil
+}
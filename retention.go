@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// retentionCheckInterval is how often old price entries and scrape errors
+// are pruned
+const retentionCheckInterval = time.Hour
+
+// StartRetention deletes price entries and scrape errors older than
+// retention now and then every retentionCheckInterval until ctx is
+// cancelled. It returns straight away when retention is zero, which keeps
+// history forever.
+func (pt *PriceTracker) StartRetention(ctx context.Context, retention time.Duration) {
+    if retention <= 0 {
+        return
+    }
+
+    log.Printf("Pruning price entries older than %v", retention)
+    pt.pruneOldEntries(retention)
+
+    ticker := time.NewTicker(retentionCheckInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            pt.pruneOldEntries(retention)
+        }
+    }
+}
+
+func (pt *PriceTracker) pruneOldEntries(retention time.Duration) {
+    cutoff := time.Now().Add(-retention)
+
+    deleted, err := pt.db.DeletePriceEntriesOlderThan(cutoff)
+    if err != nil {
+        log.Printf("Failed to prune price entries: %v", err)
+        return
+    }
+    if deleted > 0 {
+        log.Printf("Pruned %d price entries older than %s", deleted, cutoff.UTC().Format(time.RFC3339))
+    }
+
+    deleted, err = pt.db.DeleteScrapeErrorsOlderThan(cutoff)
+    if err != nil {
+        log.Printf("Failed to prune scrape errors: %v", err)
+        return
+    }
+    if deleted > 0 {
+        log.Printf("Pruned %d scrape errors older than %s", deleted, cutoff.UTC().Format(time.RFC3339))
+    }
+}
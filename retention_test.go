@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDeletePriceEntriesOlderThan(t *testing.T) {
+    tracker := newTestTracker(t, nil)
+    day := 24 * time.Hour
+    addPricesAt(t, tracker, "retention-kettle", map[time.Duration]float64{
+        40 * day:  1,
+        31 * day:  2,
+        29 * day:  3,
+        time.Hour: 4,
+    })
+    addPricesAt(t, tracker, "retention-mug", map[time.Duration]float64{35 * day: 5})
+
+    deleted, err := tracker.db.DeletePriceEntriesOlderThan(time.Now().Add(-30 * day))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if deleted != 3 {
+        t.Errorf("deleted %d entries, want 3", deleted)
+    }
+    history, _ := tracker.GetPriceHistory("retention-kettle", 10, true)
+    if len(history) != 2 || history[0].Price != 4 || history[1].Price != 3 {
+        t.Errorf("kept %v, want the two recent entries", history)
+    }
+    if history, _ := tracker.GetPriceHistory("retention-mug", 10, true); len(history) != 0 {
+        t.Errorf("kept %v, want nothing", history)
+    }
+}
+
+func TestStartRetention(t *testing.T) {
+    tracker := newTestTracker(t, nil)
+    addPricesAt(t, tracker, "retention-loop", map[time.Duration]float64{
+        10 * 24 * time.Hour: 1,
+        time.Hour:           2,
+    })
+
+    // disabled: returns at once without pruning
+    tracker.StartRetention(context.Background(), 0)
+    if history, _ := tracker.GetPriceHistory("retention-loop", 10, true); len(history) != 2 {
+        t.Fatalf("disabled retention pruned the history to %v", history)
+    }
+
+    // enabled: prunes straight away and stops when cancelled
+    ctx, cancel := context.WithCancel(context.Background())
+    done := make(chan struct{})
+    go func() {
+        tracker.StartRetention(ctx, 7*24*time.Hour)
+        close(done)
+    }()
+    deadline := time.Now().Add(2 * time.Second)
+    for time.Now().Before(deadline) {
+        if history, _ := tracker.GetPriceHistory("retention-loop", 10, true); len(history) == 1 {
+            break
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+    cancel()
+    select {
+    case <-done:
+    case <-time.After(2 * time.Second):
+        t.Fatal("the retention loop didn't stop when cancelled")
+    }
+    if history, _ := tracker.GetPriceHistory("retention-loop", 10, true); len(history) != 1 || history[0].Price != 2 {
+        t.Errorf("history = %v, want only the recent entry", history)
+    }
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"time"
+)
+
+const (
+    // defaultFetchAttempts is how many times a fetch is tried when no retry
+    // policy is configured
+    defaultFetchAttempts = 3
+    // defaultRetryDelay is the backoff before the first retry; it doubles
+    // after every failed attempt
+    defaultRetryDelay = 500 * time.Millisecond
+)
+
+// retryableError marks a fetch failure as transient, e.g. a network error or
+// a 5xx response. Anything else, such as a 404 or a page without a price,
+// fails immediately.
+type retryableError struct {
+    err error
+}
+
+func (e retryableError) Error() string {
+    return e.err.Error()
+}
+
+func (e retryableError) Unwrap() error {
+    return e.err
+}
+
+func isRetryable(err error) bool {
+    var retryable retryableError
+    return errors.As(err, &retryable)
+}
+
+// SetRetryPolicy sets how many times a failed fetch is attempted in total and
+// the backoff before the first retry. Later retries back off exponentially,
+// with jitter.
+func (pt *PriceTracker) SetRetryPolicy(maxAttempts int, baseDelay time.Duration) {
+    pt.statusMu.Lock()
+    defer pt.statusMu.Unlock()
+
+    pt.fetchAttempts = maxAttempts
+    pt.retryDelay = baseDelay
+}
+
+// tierAttempts adjusts a fetch's attempts to its product's priority tier:
+// high priority products get one more and low priority ones one fewer, so
+// retries go where they matter when workers are scarce. There is always at
+// least one attempt.
+func tierAttempts(priority string, attempts int) int {
+    switch priority {
+    case PriorityHigh:
+        return attempts + 1
+    case PriorityLow:
+        return max(attempts-1, 1)
+    }
+    return attempts
+}
+
+func (pt *PriceTracker) retryPolicy() (int, time.Duration) {
+    pt.statusMu.Lock()
+    defer pt.statusMu.Unlock()
+
+    attempts, delay := pt.fetchAttempts, pt.retryDelay
+    if attempts <= 0 {
+        attempts = defaultFetchAttempts
+    }
+    if delay <= 0 {
+        delay = defaultRetryDelay
+    }
+    return attempts, delay
+}
+
+// fetchReadingWithRetry fetches a product's price and availability, giving
+// each attempt its own timeout and retrying transient failures with
+// exponential backoff until the attempts run out or ctx is done. Every
+// attempt waits its turn under the per-host rate limit first, which doesn't
+// count against its timeout, and isn't made at all while the host's circuit
+// breaker is open. It returns the number of attempts made.
+func (pt *PriceTracker) fetchReadingWithRetry(ctx context.Context, product Product, timeout time.Duration) (PriceReading, int, error) {
+    maxAttempts, delay := pt.retryPolicy()
+    maxAttempts = tierAttempts(product.Priority, maxAttempts)
+    host := urlHost(product.URL)
+
+    for attempt := 1; ; attempt++ {
+        if err := pt.breakers.allow(host); err != nil {
+            return PriceReading{}, attempt - 1, err
+        }
+        if err := pt.hostLimiter.wait(ctx, product.URL); err != nil {
+            return PriceReading{}, attempt - 1, err
+        }
+
+        attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+        reading, err := pt.fetchReading(attemptCtx, product)
+        cancel()
+        // a cancelled cycle says nothing about the host
+        if ctx.Err() == nil {
+            pt.breakers.record(host, err)
+        }
+
+        if err == nil {
+            return reading, attempt, nil
+        }
+        if attempt >= maxAttempts || ctx.Err() != nil || !isRetryable(err) {
+            return PriceReading{}, attempt, err
+        }
+
+        // the current backoff plus up to 50% jitter so retries from several
+        // workers don't hit a struggling site in lockstep
+        wait := delay + time.Duration(rand.Int63n(int64(delay)/2+1))
+        log.Printf("Fetching %s failed (attempt %d of %d), retrying in %v: %v",
+            product.ID, attempt, maxAttempts, wait.Round(time.Millisecond), err)
+
+        timer := time.NewTimer(wait)
+        select {
+        case <-timer.C:
+        case <-ctx.Done():
+            timer.Stop()
+            return PriceReading{}, attempt, err
+        }
+        delay *= 2
+    }
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFlakyServer serves productPage after answering the first failures
+// requests with the given status
+func newFlakyServer(t *testing.T, failures int32, status int) (*httptest.Server, *int32) {
+    t.Helper()
+    var hits int32
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/robots.txt" {
+            http.NotFound(w, r)
+            return
+        }
+        if atomic.AddInt32(&hits, 1) <= failures {
+            http.Error(w, "try again", status)
+            return
+        }
+        w.Header().Set("Content-Type", "text/html; charset=utf-8")
+        w.Write([]byte(productPage))
+    }))
+    t.Cleanup(server.Close)
+    return server, &hits
+}
+
+func TestRetryTransientFailures(t *testing.T) {
+    pages, hits := newFlakyServer(t, 2, http.StatusServiceUnavailable)
+    tracker := newTestTracker(t, nil)
+    tracker.SetRetryPolicy(3, 10*time.Millisecond)
+    if _, err := tracker.CreateProduct(Product{ID: "retry-flaky", Name: "Flaky", URL: pages.URL + "/flaky", PriceSelector: ".price"}); err != nil {
+        t.Fatal(err)
+    }
+
+    tracker.trackProducts(context.Background(), tracker.snapshotProducts(), nil)
+
+    if n := atomic.LoadInt32(hits); n != 3 {
+        t.Errorf("the page was fetched %d times, want 3", n)
+    }
+    history, err := tracker.GetPriceHistory("retry-flaky", 10, true)
+    if err != nil || len(history) != 1 || history[0].Price != 1299.99 {
+        t.Errorf("history = %v, %v; want the price from the third attempt", history, err)
+    }
+    if errs, _ := tracker.GetScrapeErrors("retry-flaky", 10); len(errs) != 0 {
+        t.Errorf("scrape errors = %+v, want none once a retry succeeded", errs)
+    }
+}
+
+func TestRetryGivesUp(t *testing.T) {
+    tests := []struct {
+        name     string
+        status   int
+        attempts int
+    }{
+        {"still failing", http.StatusBadGateway, 3},
+        {"not found", http.StatusNotFound, 1},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            pages, hits := newFlakyServer(t, 10, tt.status)
+            tracker := newTestTracker(t, nil)
+            tracker.SetRetryPolicy(3, 10*time.Millisecond)
+            if _, err := tracker.CreateProduct(Product{ID: "retry-broken", Name: "Broken", URL: pages.URL + "/broken", PriceSelector: ".price"}); err != nil {
+                t.Fatal(err)
+            }
+
+            tracker.trackProducts(context.Background(), tracker.snapshotProducts(), nil)
+
+            if n := atomic.LoadInt32(hits); n != int32(tt.attempts) {
+                t.Errorf("the page was fetched %d times, want %d", n, tt.attempts)
+            }
+            errs, _ := tracker.GetScrapeErrors("retry-broken", 10)
+            if len(errs) != 1 || errs[0].Attempts != tt.attempts {
+                t.Errorf("scrape errors = %+v, want one after %d attempts", errs, tt.attempts)
+            }
+            if history, _ := tracker.GetPriceHistory("retry-broken", 10, true); len(history) != 0 {
+                t.Errorf("history = %v, want nothing stored", history)
+            }
+        })
+    }
+}
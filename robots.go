@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+    // robotsAgent is the product token matched against robots.txt
+    // User-agent lines
+    robotsAgent = "price-tracker"
+    // robotsTTL is how long a site's robots.txt is cached
+    robotsTTL = 24 * time.Hour
+    // maxRobotsBytes caps how much of a robots.txt is read
+    maxRobotsBytes = 500 << 10
+)
+
+// errRobotsDisallowed is returned for product pages the site's robots.txt
+// doesn't allow the tracker to fetch
+var errRobotsDisallowed = errors.New("disallowed by robots.txt")
+
+// robotsRule is one Allow or Disallow line
+type robotsRule struct {
+    pattern string
+    match   *regexp.Regexp
+    allow   bool
+}
+
+// robotsRules are the rules of the robots.txt group that applies to the
+// tracker; none means everything is allowed
+type robotsRules []robotsRule
+
+// parseRobots reads the rules for agent from a robots.txt body. The group
+// naming agent wins over the * group; consecutive User-agent lines share a
+// group.
+func parseRobots(body string, agent string) robotsRules {
+    agent = strings.ToLower(agent)
+    var specific, wildcard robotsRules
+    var foundSpecific bool
+    var inSpecific, inWildcard, lastWasAgent bool
+
+    scanner := bufio.NewScanner(strings.NewReader(body))
+    for scanner.Scan() {
+        line := scanner.Text()
+        if i := strings.IndexByte(line, '#'); i >= 0 {
+            line = line[:i]
+        }
+        key, value, ok := strings.Cut(line, ":")
+        if !ok {
+            continue
+        }
+        key = strings.ToLower(strings.TrimSpace(key))
+        value = strings.TrimSpace(value)
+
+        switch key {
+        case "user-agent":
+            if !lastWasAgent {
+                inSpecific, inWildcard = false, false
+            }
+            name := strings.ToLower(value)
+            if name == "*" {
+                inWildcard = true
+            } else if name != "" && strings.Contains(agent, name) {
+                inSpecific, foundSpecific = true, true
+            }
+            lastWasAgent = true
+        case "allow", "disallow":
+            lastWasAgent = false
+            // an empty Disallow allows everything
+            if value == "" {
+                continue
+            }
+            rule := robotsRule{pattern: value, match: robotsPattern(value), allow: key == "allow"}
+            if inSpecific {
+                specific = append(specific, rule)
+            }
+            if inWildcard {
+                wildcard = append(wildcard, rule)
+            }
+        default:
+            lastWasAgent = false
+        }
+    }
+
+    if foundSpecific {
+        return specific
+    }
+    return wildcard
+}
+
+// allowed reports whether path may be fetched. The longest matching rule
+// wins, and Allow wins a tie.
+func (rules robotsRules) allowed(path string) bool {
+    best, allow := -1, true
+    for _, rule := range rules {
+        if !rule.match.MatchString(path) {
+            continue
+        }
+        if n := len(rule.pattern); n > best || (n == best && rule.allow) {
+            best, allow = n, rule.allow
+        }
+    }
+    return allow
+}
+
+// robotsPattern compiles a robots.txt path pattern, where * matches any run
+// of characters and a trailing $ anchors the end
+func robotsPattern(pattern string) *regexp.Regexp {
+    anchored := strings.HasSuffix(pattern, "$")
+    pattern = strings.TrimSuffix(pattern, "$")
+
+    expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+    if anchored {
+        expr += "$"
+    }
+    return regexp.MustCompile(expr)
+}
+
+type robotsEntry struct {
+    rules   robotsRules
+    fetched time.Time
+}
+
+// robotsCache holds each site's robots.txt rules, keyed by scheme and host
+type robotsCache struct {
+    mu      sync.Mutex
+    entries map[string]robotsEntry
+}
+
+var robots = &robotsCache{entries: make(map[string]robotsEntry)}
+
+// checkRobots returns an error wrapping errRobotsDisallowed if the product's
+// site disallows its page
+func checkRobots(ctx context.Context, product Product) error {
+    pageURL, err := url.Parse(product.URL)
+    if err != nil {
+        return err
+    }
+    rules, err := robots.rules(ctx, pageURL)
+    if err != nil {
+        return err
+    }
+
+    path := pageURL.EscapedPath()
+    if path == "" {
+        path = "/"
+    }
+    if pageURL.RawQuery != "" {
+        path += "?" + pageURL.RawQuery
+    }
+    if !rules.allowed(path) {
+        return fmt.Errorf("%w: %s", errRobotsDisallowed, path)
+    }
+    return nil
+}
+
+// rules returns the cached rules for a site, fetching its robots.txt when
+// they are missing or stale
+func (c *robotsCache) rules(ctx context.Context, pageURL *url.URL) (robotsRules, error) {
+    site := pageURL.Scheme + "://" + pageURL.Host
+
+    c.mu.Lock()
+    entry, ok := c.entries[site]
+    c.mu.Unlock()
+    if ok && time.Since(entry.fetched) < robotsTTL {
+        return entry.rules, nil
+    }
+
+    rules, err := fetchRobots(ctx, site)
+    if err != nil {
+        return nil, err
+    }
+
+    c.mu.Lock()
+    c.entries[site] = robotsEntry{rules: rules, fetched: time.Now()}
+    c.mu.Unlock()
+    return rules, nil
+}
+
+// fetchRobots downloads and parses a site's robots.txt. A missing file
+// (any 4xx) allows everything; server and network errors are retryable and
+// nothing is fetched until the file can be read.
+func fetchRobots(ctx context.Context, site string) (robotsRules, error) {
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, site+"/robots.txt", nil)
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("User-Agent", scraperUserAgent)
+
+    resp, err := scrapeClient.Do(req)
+    if err != nil {
+        return nil, retryableError{fmt.Errorf("fetching robots.txt: %w", err)}
+    }
+    defer resp.Body.Close()
+
+    switch {
+    case resp.StatusCode >= 500:
+        return nil, retryableError{fmt.Errorf("fetching %s/robots.txt: unexpected status %s", site, resp.Status)}
+    case resp.StatusCode >= 400:
+        return nil, nil
+    case resp.StatusCode != http.StatusOK:
+        return nil, fmt.Errorf("fetching %s/robots.txt: unexpected status %s", site, resp.Status)
+    }
+
+    body, err := io.ReadAll(io.LimitReader(resp.Body, maxRobotsBytes))
+    if err != nil {
+        return nil, retryableError{fmt.Errorf("reading %s/robots.txt: %w", site, err)}
+    }
+    return parseRobots(string(body), robotsAgent), nil
+}
+
+// noteRobots records whether a product's last fetch was blocked by
+// robots.txt, so the API can show why it has no new prices
+func (pt *PriceTracker) noteRobots(product Product, err error) {
+    pt.robotsMu.Lock()
+    defer pt.robotsMu.Unlock()
+
+    if !errors.Is(err, errRobotsDisallowed) {
+        // only a successful fetch proves the page is allowed again
+        if err == nil {
+            delete(pt.robotsBlocked, product.ID)
+        }
+        return
+    }
+    if _, ok := pt.robotsBlocked[product.ID]; ok {
+        return
+    }
+    pt.robotsBlocked[product.ID] = RobotsBlock{
+        ProductID: product.ID,
+        Name:      product.Name,
+        URL:       product.URL,
+        Reason:    err.Error(),
+        Since:     time.Now().UTC(),
+    }
+}
+
+// RobotsBlocked lists the products whose last fetch was skipped because of
+// robots.txt, by product ID
+func (pt *PriceTracker) RobotsBlocked() []RobotsBlock {
+    pt.robotsMu.Lock()
+    defer pt.robotsMu.Unlock()
+
+    blocked := make([]RobotsBlock, 0, len(pt.robotsBlocked))
+    for _, block := range pt.robotsBlocked {
+        blocked = append(blocked, block)
+    }
+    sort.Slice(blocked, func(i, j int) bool {
+        return blocked[i].ProductID < blocked[j].ProductID
+    })
+    return blocked
+}
+
+func (pt *PriceTracker) forgetRobotsBlock(productID string) {
+    pt.robotsMu.Lock()
+    defer pt.robotsMu.Unlock()
+
+    delete(pt.robotsBlocked, productID)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const robotsTxt = `# everyone else stays out
+User-agent: *
+Disallow: /
+
+User-agent: price-tracker
+Disallow: /private/
+Allow: /private/ok$
+`
+
+func TestParseRobots(t *testing.T) {
+    rules := parseRobots(robotsTxt, robotsAgent)
+    for path, want := range map[string]bool{
+        "/":                  true,
+        "/shop/kettle":       true,
+        "/private/kettle":    false,
+        "/private/":          false,
+        "/private/ok":        true,
+        "/private/ok/deeper": false,
+    } {
+        if got := rules.allowed(path); got != want {
+            t.Errorf("allowed(%q) = %v, want %v", path, got, want)
+        }
+    }
+
+    // without its own group the tracker follows the * group
+    if parseRobots(robotsTxt, "other-bot").allowed("/shop/kettle") {
+        t.Error("the * group wasn't applied to another agent")
+    }
+
+    wildcards := parseRobots("User-agent: *\nDisallow: /*.json$\nDisallow: /search?\nDisallow:\n", robotsAgent)
+    for path, want := range map[string]bool{
+        "/api/kettle.json":     false,
+        "/api/kettle.json?v=1": true,
+        "/search?q=kettle":     false,
+        "/search":              true,
+    } {
+        if got := wildcards.allowed(path); got != want {
+            t.Errorf("allowed(%q) = %v, want %v", path, got, want)
+        }
+    }
+}
+
+func TestRobotsDisallowSkipsProduct(t *testing.T) {
+    var mu sync.Mutex
+    hits := map[string]int{}
+    site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        mu.Lock()
+        hits[r.URL.Path]++
+        mu.Unlock()
+        if r.URL.Path == "/robots.txt" {
+            w.Write([]byte(robotsTxt))
+            return
+        }
+        w.Header().Set("Content-Type", "text/html; charset=utf-8")
+        w.Write([]byte(productPage))
+    }))
+    t.Cleanup(site.Close)
+    t.Cleanup(func() {
+        robots.mu.Lock()
+        delete(robots.entries, site.URL)
+        robots.mu.Unlock()
+    })
+
+    tracker := newTestTracker(t, nil)
+    tracker.SetRetryPolicy(1, 0)
+    server := NewAPIServer(tracker)
+    for _, product := range []Product{
+        {ID: "robots-private", URL: site.URL + "/private/kettle"},
+        {ID: "robots-ignored", URL: site.URL + "/private/toaster", IgnoreRobots: true},
+        {ID: "robots-allowed", URL: site.URL + "/private/ok"},
+        {ID: "robots-public", URL: site.URL + "/shop/kettle"},
+    } {
+        product.Name = product.ID
+        product.PriceSelector = ".price"
+        if _, err := tracker.CreateProduct(product); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    tracker.trackProducts(context.Background(), tracker.snapshotProducts(), nil)
+    mu.Lock()
+    robotsFetches := hits["/robots.txt"]
+    mu.Unlock()
+    if robotsFetches == 0 {
+        t.Fatal("robots.txt wasn't fetched")
+    }
+    tracker.trackProducts(context.Background(), tracker.snapshotProducts(), nil)
+
+    mu.Lock()
+    if hits["/private/kettle"] != 0 {
+        t.Errorf("the disallowed page was fetched %d times", hits["/private/kettle"])
+    }
+    // robots.txt is cached between cycles
+    if hits["/robots.txt"] != robotsFetches {
+        t.Errorf("robots.txt was fetched again in the second cycle")
+    }
+    mu.Unlock()
+
+    for id, want := range map[string]int{"robots-private": 0, "robots-ignored": 1, "robots-allowed": 1, "robots-public": 1} {
+        if history, _ := tracker.GetPriceHistory(id, 10, true); len(history) != want {
+            t.Errorf("%s has %d prices, want %d", id, len(history), want)
+        }
+    }
+
+    var blocked struct {
+        Products []RobotsBlock `json:"products"`
+        Count    int           `json:"count"`
+    }
+    serve(t, server, "GET", "/api/v1/robots-blocked", "", &blocked)
+    if blocked.Count != 1 || len(blocked.Products) != 1 {
+        t.Fatalf("robots-blocked = %+v, want only the private product", blocked)
+    }
+    if block := blocked.Products[0]; block.ProductID != "robots-private" || block.URL != site.URL+"/private/kettle" || !strings.Contains(block.Reason, "/private/kettle") {
+        t.Errorf("block = %+v", block)
+    }
+    if errs, _ := tracker.GetScrapeErrors("robots-private", 10); len(errs) == 0 || !strings.Contains(errs[0].Error, "robots.txt") {
+        t.Errorf("scrape errors = %+v, want the skipped check recorded", errs)
+    }
+
+    // ignoring robots.txt takes it off the list and fetches the page
+    if rec := serve(t, server, "PATCH", "/api/v1/products/robots-private", `{"ignore_robots": true}`, nil); rec.Code != http.StatusOK {
+        t.Fatalf("patch = %d: %s", rec.Code, rec.Body)
+    }
+    serve(t, server, "GET", "/api/v1/robots-blocked", "", &blocked)
+    if blocked.Count != 0 {
+        t.Errorf("robots-blocked = %+v after ignore_robots", blocked)
+    }
+    tracker.trackProducts(context.Background(), tracker.snapshotProducts(), nil)
+    if history, _ := tracker.GetPriceHistory("robots-private", 10, true); len(history) != 1 || history[0].Price != 1299.99 {
+        t.Errorf("history = %v, want the page fetched despite robots.txt", history)
+    }
+}
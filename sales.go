@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// AddSaleWindow records a known sale period for a product. Times are stored in
+// UTC so windows given in different time zones compare correctly.
+func (pt *PriceTracker) AddSaleWindow(window SaleWindow) (SaleWindow, error) {
+    exists, err := pt.db.ProductExists(window.ProductID)
+    if err != nil {
+        return SaleWindow{}, err
+    }
+    if !exists {
+        return SaleWindow{}, fmt.Errorf("%w: %s", ErrProductNotFound, window.ProductID)
+    }
+
+    window.Name = strings.TrimSpace(window.Name)
+    if window.Name == "" {
+        return SaleWindow{}, errors.New("sale window name is required")
+    }
+    if window.StartsAt.IsZero() || window.EndsAt.IsZero() {
+        return SaleWindow{}, errors.New("sale window starts_at and ends_at are required")
+    }
+    if !window.EndsAt.After(window.StartsAt) {
+        return SaleWindow{}, errors.New("sale window must end after it starts")
+    }
+
+    window.StartsAt = window.StartsAt.UTC()
+    window.EndsAt = window.EndsAt.UTC()
+
+    id, err := pt.db.InsertSaleWindow(window)
+    if err != nil {
+        return SaleWindow{}, err
+    }
+    window.ID = id
+
+    log.Printf("Added sale window %q for %s: %s - %s", window.Name, window.ProductID,
+        window.StartsAt.Format(time.RFC3339), window.EndsAt.Format(time.RFC3339))
+    return window, nil
+}
+
+func (pt *PriceTracker) GetSaleWindows(productID string) ([]SaleWindow, error) {
+    exists, err := pt.db.ProductExists(productID)
+    if err != nil {
+        return nil, err
+    }
+    if !exists {
+        return nil, fmt.Errorf("%w: %s", ErrProductNotFound, productID)
+    }
+
+    return pt.db.GetSaleWindows(productID)
+}
+
+// SetSaleInterval sets how often products inside a sale window are checked.
+// Zero means a quarter of the tracking interval.
+func (pt *PriceTracker) SetSaleInterval(interval time.Duration) {
+    pt.statusMu.Lock()
+    defer pt.statusMu.Unlock()
+
+    pt.saleInterval = interval
+}
+
+func (pt *PriceTracker) saleIntervalFor(interval time.Duration) time.Duration {
+    pt.statusMu.Lock()
+    defer pt.statusMu.Unlock()
+
+    if pt.saleInterval > 0 && pt.saleInterval < interval {
+        return pt.saleInterval
+    }
+    return interval / 4
+}
+
+// markSaleEntries flags the entries captured during one of the windows
+func markSaleEntries(entries []PriceEntry, windows []SaleWindow) {
+    for i := range entries {
+        for _, window := range windows {
+            if window.Contains(entries[i].Timestamp) {
+                entries[i].InSale = true
+                break
+            }
+        }
+    }
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSaleWindowTightensInterval(t *testing.T) {
+    tracker := newTestTracker(t, nil)
+    tracker.SetSaleInterval(10 * time.Minute)
+    addTestProduct(t, tracker, "sale-tv")
+    addTestProduct(t, tracker, "sale-radio")
+
+    // two overlapping windows, one given in another time zone
+    now := time.Now()
+    est := time.FixedZone("EST", -5*60*60)
+    for _, window := range []SaleWindow{
+        {ProductID: "sale-tv", Name: "Black Friday", StartsAt: now.Add(-time.Hour).In(est), EndsAt: now.Add(time.Hour).In(est)},
+        {ProductID: "sale-tv", Name: "Weekend", StartsAt: now.Add(-30 * time.Minute), EndsAt: now.Add(2 * time.Hour)},
+    } {
+        created, err := tracker.AddSaleWindow(window)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if created.StartsAt.Location() != time.UTC || !created.StartsAt.Equal(window.StartsAt) {
+            t.Errorf("starts at %v, want %v in UTC", created.StartsAt, window.StartsAt)
+        }
+    }
+
+    // both were checked 20 minutes ago: only the one on sale is due again
+    lastChecked := map[string]time.Time{
+        "sale-tv":    now.Add(-20 * time.Minute),
+        "sale-radio": now.Add(-20 * time.Minute),
+    }
+    due := tracker.dueProducts(lastChecked, time.Hour)
+    if !due["sale-tv"] || due["sale-radio"] || len(due) != 1 {
+        t.Errorf("due = %v, want only the product on sale", due)
+    }
+    if delay := tracker.nextCheckDelay(lastChecked, time.Hour); delay > 10*time.Minute {
+        t.Errorf("next check in %v, want within the sale interval", delay)
+    }
+}
+
+func TestSaleIntervalDefault(t *testing.T) {
+    tracker := newTestTracker(t, nil)
+    product := Product{ID: "sale-default"}
+    if got := tracker.productInterval(product, time.Hour, true); got != 15*time.Minute {
+        t.Errorf("sale interval = %v, want a quarter of the tracking interval", got)
+    }
+    if got := tracker.productInterval(product, time.Hour, false); got != time.Hour {
+        t.Errorf("interval = %v, want the tracking interval outside sales", got)
+    }
+
+    // a sale interval longer than the product's own doesn't slow it down
+    tracker.SetSaleInterval(2 * time.Hour)
+    if got := tracker.productInterval(product, time.Hour, true); got != 15*time.Minute {
+        t.Errorf("sale interval = %v, want 15m", got)
+    }
+}
+
+func TestNextCheckWakesForSaleWindow(t *testing.T) {
+    tracker := newTestTracker(t, nil)
+    addTestProduct(t, tracker, "sale-soon")
+    now := time.Now()
+    if _, err := tracker.AddSaleWindow(SaleWindow{ProductID: "sale-soon", Name: "Flash sale", StartsAt: now.Add(5 * time.Minute), EndsAt: now.Add(time.Hour)}); err != nil {
+        t.Fatal(err)
+    }
+
+    lastChecked := map[string]time.Time{"sale-soon": now}
+    if due := tracker.dueProducts(lastChecked, time.Hour); len(due) != 0 {
+        t.Errorf("due = %v before the sale opens", due)
+    }
+    if delay := tracker.nextCheckDelay(lastChecked, time.Hour); delay > 5*time.Minute {
+        t.Errorf("next check in %v, want it when the sale opens", delay)
+    }
+}
+
+func TestSaleEntriesMarked(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    // entries at 3, 2 and 1 hours ago
+    addTestProduct(t, server.tracker, "sale-marked", 30, 25, 30)
+
+    now := time.Now().UTC()
+    body := `{"name": "Lunch deal", "starts_at": "` + now.Add(-150*time.Minute).Format(time.RFC3339) + `", "ends_at": "` + now.Add(-90*time.Minute).Format(time.RFC3339) + `"}`
+    if rec := serve(t, server, "POST", "/api/v1/products/sale-marked/sale-windows", body, nil); rec.Code != http.StatusCreated {
+        t.Fatalf("add window = %d: %s", rec.Code, rec.Body)
+    }
+    if rec := serve(t, server, "POST", "/api/v1/products/sale-marked/sale-windows", `{"name": "Backwards", "starts_at": "2030-01-02T00:00:00Z", "ends_at": "2030-01-01T00:00:00Z"}`, nil); rec.Code != http.StatusBadRequest {
+        t.Errorf("window ending before it starts = %d, want 400", rec.Code)
+    }
+
+    entries, err := server.tracker.GetPriceHistory("sale-marked", 10, false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var inSale []float64
+    for _, entry := range entries {
+        if entry.InSale {
+            inSale = append(inSale, entry.Price)
+        }
+    }
+    if len(entries) != 3 || len(inSale) != 1 || inSale[0] != 25 {
+        t.Errorf("entries = %+v, want only the 25 marked as in a sale", entries)
+    }
+}
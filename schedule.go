@@ -0,0 +1,109 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// productInterval returns how often a product is checked: its own interval,
+// or the default one when it has none, shortened to the sale interval while
+// one of its sale windows is open
+func (pt *PriceTracker) productInterval(product Product, interval time.Duration, onSale bool) time.Duration {
+    if product.IntervalSeconds > 0 {
+        interval = time.Duration(product.IntervalSeconds) * time.Second
+    }
+    if onSale {
+        return pt.saleIntervalFor(interval)
+    }
+    return interval
+}
+
+// dueProducts returns the IDs of products whose next check is due and records
+// them as checked now. Products missing from lastChecked are new and due
+// straight away; entries for deleted products are dropped.
+func (pt *PriceTracker) dueProducts(lastChecked map[string]time.Time, interval time.Duration) map[string]bool {
+    now := time.Now()
+    products := pt.snapshotProducts()
+    onSale := activeSaleProducts(pt.saleWindows(), now)
+
+    due := make(map[string]bool)
+    tracked := make(map[string]bool, len(products))
+    for _, product := range products {
+        tracked[product.ID] = true
+        last, ok := lastChecked[product.ID]
+        if ok && now.Sub(last) < pt.productInterval(product, interval, onSale[product.ID]) {
+            continue
+        }
+        due[product.ID] = true
+        lastChecked[product.ID] = now
+    }
+
+    for id := range lastChecked {
+        if !tracked[id] {
+            delete(lastChecked, id)
+        }
+    }
+    return due
+}
+
+// nextCheckDelay returns how long the tracking loop should wait: until the
+// next product is due, or until the next sale window opens if that comes
+// first. It never exceeds the default interval, so the loop reports a cycle
+// to the watchdog at least that often.
+func (pt *PriceTracker) nextCheckDelay(lastChecked map[string]time.Time, interval time.Duration) time.Duration {
+    now := time.Now()
+    windows := pt.saleWindows()
+    onSale := activeSaleProducts(windows, now)
+
+    delay := interval
+    for _, product := range pt.snapshotProducts() {
+        last, ok := lastChecked[product.ID]
+        if !ok {
+            return 0
+        }
+        if wait := last.Add(pt.productInterval(product, interval, onSale[product.ID])).Sub(now); wait < delay {
+            delay = wait
+        }
+    }
+
+    for _, window := range windows {
+        if window.StartsAt.After(now) && window.StartsAt.Sub(now) < delay {
+            delay = window.StartsAt.Sub(now)
+        }
+    }
+
+    if delay < 0 {
+        delay = 0
+    }
+    return delay
+}
+
+func (pt *PriceTracker) saleWindows() []SaleWindow {
+    windows, err := pt.db.GetSaleWindows("")
+    if err != nil {
+        log.Printf("Failed to load sale windows: %v", err)
+        return nil
+    }
+    return windows
+}
+
+// activeSaleProducts returns the IDs of products with a sale window open at t.
+// Overlapping windows simply count once.
+func activeSaleProducts(windows []SaleWindow, t time.Time) map[string]bool {
+    active := make(map[string]bool)
+    for _, window := range windows {
+        if window.Contains(t) {
+            active[window.ProductID] = true
+        }
+    }
+    return active
+}
+
+// notifySchedule wakes the tracking loop to reschedule. It never blocks; a
+// pending wake-up already covers this one.
+func (pt *PriceTracker) notifySchedule() {
+    select {
+    case pt.scheduleChanged <- struct{}{}:
+    default:
+    }
+}
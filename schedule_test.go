@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fetchCounter is a fetcher counting fetches per product
+type fetchCounter struct {
+    mu     sync.Mutex
+    counts map[string]int
+}
+
+func (f *fetchCounter) FetchPrice(ctx context.Context, product Product) (float64, error) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    if f.counts == nil {
+        f.counts = make(map[string]int)
+    }
+    f.counts[product.ID]++
+    return 10, nil
+}
+
+func (f *fetchCounter) count(id string) int {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    return f.counts[id]
+}
+
+func TestPerProductIntervals(t *testing.T) {
+    fetcher := &fetchCounter{}
+    tracker := newTestTracker(t, fetcher)
+    tracker.SetRetryPolicy(1, 0)
+    addTestProduct(t, tracker, "interval-fast")
+    if _, err := tracker.CreateProduct(Product{ID: "interval-slow", Name: "Slow", URL: "https://shop.test/slow", IntervalSeconds: 1}); err != nil {
+        t.Fatal(err)
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    stopped := make(chan struct{})
+    go func() {
+        tracker.StartTracking(ctx, 50*time.Millisecond)
+        close(stopped)
+    }()
+
+    // a product added while tracking runs is fetched straight away, even
+    // with a long interval
+    time.Sleep(300 * time.Millisecond)
+    if _, err := tracker.CreateProduct(Product{ID: "interval-added", Name: "Added", URL: "https://shop.test/added", IntervalSeconds: 60}); err != nil {
+        t.Fatal(err)
+    }
+    time.Sleep(1000 * time.Millisecond)
+
+    fast, slow := fetcher.count("interval-fast"), fetcher.count("interval-slow")
+    if fast < 10 || slow != 1 || fast <= 5*slow {
+        t.Errorf("fetched the fast product %d times and the slow one %d times in 1.3s", fast, slow)
+    }
+    if added := fetcher.count("interval-added"); added != 1 {
+        t.Errorf("fetched the added product %d times, want once", added)
+    }
+
+    // a deleted product is no longer scheduled
+    if err := tracker.DeleteProduct("interval-fast", true); err != nil {
+        t.Fatal(err)
+    }
+    deletedAt := fetcher.count("interval-fast")
+    time.Sleep(200 * time.Millisecond)
+    if n := fetcher.count("interval-fast"); n > deletedAt+1 {
+        t.Errorf("the deleted product was fetched %d more times", n-deletedAt)
+    }
+
+    // cancelling stops every schedule
+    cancel()
+    select {
+    case <-stopped:
+    case <-time.After(2 * time.Second):
+        t.Fatal("tracking didn't stop when cancelled")
+    }
+    slow = fetcher.count("interval-slow")
+    time.Sleep(1100 * time.Millisecond)
+    if n := fetcher.count("interval-slow"); n != slow {
+        t.Errorf("the slow product was fetched %d more times after tracking stopped", n-slow)
+    }
+}
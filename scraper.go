@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const (
+    // maxPageBytes caps how much of a product page is read
+    maxPageBytes = 5 << 20
+    // scraperUserAgent identifies the tracker to retailers
+    scraperUserAgent = "Mozilla/5.0 (compatible; price-tracker/1.0)"
+)
+
+// scrapeClient is shared by all product page fetches
+var scrapeClient = &http.Client{Timeout: 15 * time.Second}
+
+// scrapeReading downloads the product page and reads the price using the
+// product's price rule: the first element matching its selector or XPath,
+// the first regex match in the raw body, or what its price script returns.
+// Without a rule the price comes from the page's structured data.
+// Availability comes from the structured data unless a script sets it, and
+// is left unknown if the page has none. A list price is only kept when it
+// is above the price, so the product is on sale.
+func scrapeReading(ctx context.Context, product Product) (PriceReading, error) {
+    rules, err := parsePageRules(product)
+    if err != nil {
+        return PriceReading{}, err
+    }
+
+    cached, hasCached := conditional.get(product)
+    page, validators, err := loadPage(ctx, product, cached.validators)
+    if errors.Is(err, errNotModified) && hasCached {
+        reading := cached.reading
+        reading.NotModified = true
+        return reading, nil
+    }
+    if err != nil {
+        return PriceReading{}, err
+    }
+
+    root := parseHTML(page)
+    reading, err := rules.extract(page, root, product)
+    if err != nil {
+        conditional.forget(product.ID)
+        return PriceReading{}, extractionError{err: err, page: page}
+    }
+    reading.Title = discoverMetadata(root, product.URL).name
+    if rules.fingerprintSelector != nil {
+        reading.Fingerprint = pageFingerprint(root, rules.fingerprintSelector)
+    }
+    conditional.put(product, validators, reading)
+    return reading, nil
+}
+
+// pageRules are a product's parsed price rule and the selectors for the
+// rest of its reading
+type pageRules struct {
+    selector cssSelector
+    xpath    *xpathExpr
+    pattern  *regexp.Regexp
+    script   *priceScript
+
+    listSelector        cssSelector
+    shippingSelector    cssSelector
+    fingerprintSelector cssSelector
+}
+
+func parsePageRules(product Product) (pageRules, error) {
+    var rules pageRules
+    var err error
+    switch {
+    case product.PriceSelector != "":
+        if rules.selector, err = parseSelector(product.PriceSelector); err != nil {
+            return rules, fmt.Errorf("invalid price selector %q: %w", product.PriceSelector, err)
+        }
+    case product.PriceXPath != "":
+        if rules.xpath, err = parseXPath(product.PriceXPath); err != nil {
+            return rules, fmt.Errorf("invalid price XPath %q: %w", product.PriceXPath, err)
+        }
+    case product.PriceRegex != "":
+        if rules.pattern, err = regexp.Compile(product.PriceRegex); err != nil {
+            return rules, fmt.Errorf("invalid price regex %q: %w", product.PriceRegex, err)
+        }
+    case product.PriceScript != "":
+        // parsed on every fetch, so an edited script applies to the next one
+        if rules.script, err = parsePriceScript(product.PriceScript); err != nil {
+            return rules, fmt.Errorf("invalid price script: %w", err)
+        }
+    }
+
+    if product.ListPriceSelector != "" {
+        if rules.listSelector, err = parseSelector(product.ListPriceSelector); err != nil {
+            return rules, fmt.Errorf("invalid list price selector %q: %w", product.ListPriceSelector, err)
+        }
+    }
+    if product.ShippingSelector != "" {
+        if rules.shippingSelector, err = parseSelector(product.ShippingSelector); err != nil {
+            return rules, fmt.Errorf("invalid shipping selector %q: %w", product.ShippingSelector, err)
+        }
+    }
+    if product.FingerprintSelector != "" {
+        if rules.fingerprintSelector, err = parseSelector(product.FingerprintSelector); err != nil {
+            return rules, fmt.Errorf("invalid fingerprint selector %q: %w", product.FingerprintSelector, err)
+        }
+    }
+    return rules, nil
+}
+
+// extract reads the price, availability, list price and shipping cost from
+// a fetched page. What a price script sets wins over the structured data.
+func (rules pageRules) extract(page string, root *goquery.Document, product Product) (PriceReading, error) {
+    var reading PriceReading
+    var set map[string]bool
+    var err error
+    if rules.script != nil {
+        reading, set, err = runPriceScript(rules.script, page, root, product)
+    } else {
+        reading.Price, err = extractPrice(page, root, product, rules.selector, rules.xpath, rules.pattern)
+    }
+    if err != nil {
+        return PriceReading{}, err
+    }
+
+    if !set["in_stock"] {
+        reading.InStock = pageAvailability(root)
+    }
+    if !set["list_price"] {
+        if listPrice := extractListPrice(root, product, rules.listSelector); listPrice > 0 {
+            reading.ListPrice = &listPrice
+        }
+    }
+    if reading.ListPrice != nil && *reading.ListPrice <= reading.Price {
+        reading.ListPrice = nil
+    }
+    if !set["shipping"] {
+        reading.Shipping = extractShipping(root, product, rules.shippingSelector)
+    }
+    return reading, nil
+}
+
+// extractPrice reads the price from a fetched page using whichever price
+// rule is set, or the page's structured data when none is. root is the
+// parsed page; the regex rule reads the raw body instead.
+func extractPrice(page string, root *goquery.Document, product Product, selector cssSelector, xpath *xpathExpr, pattern *regexp.Regexp) (float64, error) {
+    if pattern != nil {
+        match := pattern.FindStringSubmatch(page)
+        if match == nil {
+            return 0, fmt.Errorf("price regex %q matches nothing", product.PriceRegex)
+        }
+        if len(match) > 1 {
+            return parsePagePrice(match[1], product)
+        }
+        return parsePagePrice(match[0], product)
+    }
+
+    if xpath != nil {
+        value, ok := xpath.first(root)
+        if !ok {
+            return 0, fmt.Errorf("price XPath %q matches nothing", product.PriceXPath)
+        }
+        return parsePagePrice(value, product)
+    }
+    if selector == nil {
+        return structuredDataPrice(root, product)
+    }
+
+    text, ok := firstText(root, selector)
+    if !ok {
+        return 0, fmt.Errorf("no element matches price selector %q", product.PriceSelector)
+    }
+
+    return parsePagePrice(text, product)
+}
+
+// extractListPrice reads the price before any discount, using the product's
+// list price selector or else the page's structured data. It returns 0 when
+// the page doesn't show one; a product that isn't on sale usually has none.
+func extractListPrice(root *goquery.Document, product Product, selector cssSelector) float64 {
+    if selector == nil {
+        return pageListPrice(root, product)
+    }
+    text, ok := firstText(root, selector)
+    if !ok {
+        return 0
+    }
+    listPrice, err := parsePagePrice(text, product)
+    if err != nil {
+        return 0
+    }
+    return listPrice
+}
+
+// fetchPage requests a product page, as its fetch profile describes, and
+// returns the body with its validators. Given validators from an earlier
+// fetch it makes a conditional request and returns errNotModified if the
+// page hasn't changed.
+func fetchPage(ctx context.Context, product Product, cached pageValidators) (string, pageValidators, error) {
+    pageURL := product.URL
+    req, err := product.FetchProfile.newRequest(ctx, pageURL)
+    if err != nil {
+        return "", pageValidators{}, err
+    }
+    cached.apply(req)
+
+    resp, err := scrapeClient.Do(req)
+    if err != nil {
+        // network errors and timeouts are usually transient
+        return "", pageValidators{}, retryableError{err}
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode == http.StatusNotModified && !cached.empty() {
+        return "", cached, errNotModified
+    }
+    if resp.StatusCode != http.StatusOK {
+        // challenges usually come as a 403 or 503; retrying them won't help
+        body, _ := io.ReadAll(io.LimitReader(resp.Body, maxBlockPageBytes))
+        if reason, blocked := detectBlock(resp.Header, string(body)); blocked {
+            return "", pageValidators{}, blockedError{reason: reason}
+        }
+
+        err := statusError{code: resp.StatusCode, err: fmt.Errorf("fetching %s: unexpected status %s", pageURL, resp.Status)}
+        if isRetryableStatus(resp.StatusCode) {
+            return "", pageValidators{}, retryableError{err}
+        }
+        return "", pageValidators{}, err
+    }
+
+    body, err := io.ReadAll(io.LimitReader(resp.Body, maxPageBytes))
+    if err != nil {
+        return "", pageValidators{}, retryableError{fmt.Errorf("reading %s: %w", pageURL, err)}
+    }
+    if reason, blocked := detectBlock(resp.Header, string(body)); blocked {
+        return "", pageValidators{}, blockedError{reason: reason}
+    }
+
+    return string(body), responseValidators(resp), nil
+}
+
+// statusError is a fetch that got an unexpected HTTP status
+type statusError struct {
+    code int
+    err  error
+}
+
+func (e statusError) Error() string {
+    return e.err.Error()
+}
+
+func (e statusError) Unwrap() error {
+    return e.err
+}
+
+// isRetryableStatus reports whether a response status is worth retrying:
+// server errors, rate limiting and request timeouts
+func isRetryableStatus(code int) bool {
+    return code >= 500 || code == http.StatusTooManyRequests || code == http.StatusRequestTimeout
+}
+
+// validatePriceRule checks that a product sets at most one price rule and
+// that it and the list price, shipping and fingerprint selectors parse, and that its
+// price locale is known
+func validatePriceRule(product Product) error {
+    rules := 0
+    for _, rule := range []string{product.PriceSelector, product.PriceXPath, product.PriceRegex, product.PriceScript} {
+        if rule != "" {
+            rules++
+        }
+    }
+    if rules > 1 {
+        return errors.New("set only one of price_selector, price_xpath, price_regex and price_script")
+    }
+
+    if product.PriceSelector != "" {
+        if _, err := parseSelector(product.PriceSelector); err != nil {
+            return fmt.Errorf("invalid price selector %q: %w", product.PriceSelector, err)
+        }
+    }
+    if product.PriceXPath != "" {
+        if _, err := parseXPath(product.PriceXPath); err != nil {
+            return fmt.Errorf("invalid price XPath %q: %w", product.PriceXPath, err)
+        }
+    }
+    if product.PriceRegex != "" {
+        if _, err := regexp.Compile(product.PriceRegex); err != nil {
+            return fmt.Errorf("invalid price regex %q: %w", product.PriceRegex, err)
+        }
+    }
+    if product.PriceScript != "" {
+        if _, err := parsePriceScript(product.PriceScript); err != nil {
+            return fmt.Errorf("invalid price script: %w", err)
+        }
+    }
+    if product.ListPriceSelector != "" {
+        if _, err := parseSelector(product.ListPriceSelector); err != nil {
+            return fmt.Errorf("invalid list price selector %q: %w", product.ListPriceSelector, err)
+        }
+    }
+    if product.ShippingSelector != "" {
+        if _, err := parseSelector(product.ShippingSelector); err != nil {
+            return fmt.Errorf("invalid shipping selector %q: %w", product.ShippingSelector, err)
+        }
+    }
+    if product.FingerprintSelector != "" {
+        if _, err := parseSelector(product.FingerprintSelector); err != nil {
+            return fmt.Errorf("invalid fingerprint selector %q: %w", product.FingerprintSelector, err)
+        }
+    }
+    if _, err := localeDecimal(product.PriceLocale); err != nil {
+        return err
+    }
+    return nil
+}
+
+// hasPriceRule reports whether the product says where its price is
+func (p Product) hasPriceRule() bool {
+    return p.PriceSelector != "" || p.PriceXPath != "" || p.PriceRegex != "" || p.PriceScript != ""
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const productPage = `<!DOCTYPE html>
+<html>
+<head><title>Gaming Laptop</title></head>
+<body>
+  <div class="buy-box">
+    <span class="label">Price</span>
+    <span class="price">$1,299.99</span>
+    <s class="was">$1,499.00</s>
+  </div>
+  <ul class="offers">
+    <li>Refurbished <b>$999.00</b></li>
+    <li>Used <b>$849.50</b></li>
+  </ul>
+</body>
+</html>`
+
+// newPageServer serves page at every path except /robots.txt, which is
+// missing so every page may be fetched
+func newPageServer(t *testing.T, page string) *httptest.Server {
+    t.Helper()
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/robots.txt" {
+            http.NotFound(w, r)
+            return
+        }
+        w.Header().Set("Content-Type", "text/html; charset=utf-8")
+        w.Write([]byte(page))
+    }))
+    t.Cleanup(server.Close)
+    return server
+}
+
+func TestScrapePriceWithSelector(t *testing.T) {
+    server := newPageServer(t, productPage)
+
+    tests := []struct {
+        selector string
+        want     float64
+    }{
+        {".price", 1299.99},
+        {"div.buy-box > span.price", 1299.99},
+        {".label + span", 1299.99},
+        {".offers li:nth-child(2) b", 849.50},
+        {`li:contains("Refurbished") b`, 999.00},
+    }
+    for i, tt := range tests {
+        t.Run(tt.selector, func(t *testing.T) {
+            product := Product{
+                ID:            "scrape-selector-" + string(rune('a'+i)),
+                URL:           server.URL + "/laptop",
+                PriceSelector: tt.selector,
+            }
+            price, err := ScrapingFetcher{}.FetchPrice(context.Background(), product)
+            if err != nil {
+                t.Fatalf("FetchPrice: %v", err)
+            }
+            if price != tt.want {
+                t.Errorf("price = %v, want %v", price, tt.want)
+            }
+        })
+    }
+}
+
+func TestScrapePriceNoMatch(t *testing.T) {
+    server := newPageServer(t, productPage)
+
+    product := Product{ID: "scrape-no-match", URL: server.URL + "/laptop", PriceSelector: ".sale-price"}
+    _, err := ScrapingFetcher{}.FetchPrice(context.Background(), product)
+    if err == nil || !strings.Contains(err.Error(), `no element matches price selector ".sale-price"`) {
+        t.Fatalf("err = %v, want a no-match error", err)
+    }
+}
+
+func TestValidatePriceRuleRejectsBadSelector(t *testing.T) {
+    for _, selector := range []string{"div[", "::", ".price >"} {
+        if err := validatePriceRule(Product{PriceSelector: selector}); err == nil {
+            t.Errorf("selector %q was accepted", selector)
+        }
+    }
+}
+
+func TestScrapePriceWithRegex(t *testing.T) {
+    server := newPageServer(t, `<html><body><script>
+  var productPrice = "1,049.00";
+  window.analytics = {"sku": "LAP-14", "price": 999.5, "currency": "USD"};
+</script><p>Price $1,299.99</p></body></html>`)
+
+    tests := []struct {
+        pattern string
+        want    float64
+    }{
+        // the first capture group is the price
+        {`var productPrice = "([0-9.,]+)"`, 1049},
+        {`"price":\s*([0-9.]+)`, 999.5},
+        // without a group, the whole match is
+        {`\$[0-9,]+\.[0-9]{2}`, 1299.99},
+        // the first match wins
+        {`"([0-9][0-9.,]*)"`, 1049},
+    }
+    for _, tt := range tests {
+        t.Run(tt.pattern, func(t *testing.T) {
+            product := Product{ID: "scrape-regex", URL: server.URL + "/laptop", PriceRegex: tt.pattern}
+            price, err := ScrapingFetcher{}.FetchPrice(context.Background(), product)
+            if err != nil {
+                t.Fatalf("FetchPrice: %v", err)
+            }
+            if price != tt.want {
+                t.Errorf("price = %v, want %v", price, tt.want)
+            }
+        })
+    }
+
+    product := Product{ID: "scrape-regex-none", URL: server.URL + "/laptop", PriceRegex: `"sale_price":\s*([0-9.]+)`}
+    if _, err := (ScrapingFetcher{}).FetchPrice(context.Background(), product); err == nil || !strings.Contains(err.Error(), "matches nothing") {
+        t.Errorf("err = %v, want a no-match error", err)
+    }
+}
+
+func TestValidatePriceRuleRegex(t *testing.T) {
+    for _, product := range []Product{
+        {PriceRegex: `price: ([0-9.]+`},
+        {PriceRegex: `(?P<price`},
+        {PriceRegex: `([0-9.]+)`, PriceSelector: ".price"},
+        {PriceRegex: `([0-9.]+)`, PriceXPath: "//span"},
+    } {
+        if err := validatePriceRule(product); err == nil {
+            t.Errorf("rule %+v was accepted", product)
+        }
+    }
+    if err := validatePriceRule(Product{PriceRegex: `"price":\s*([0-9.]+)`}); err != nil {
+        t.Errorf("valid regex: %v", err)
+    }
+
+    // the API turns a bad rule away when the product is added
+    server := NewAPIServer(newTestTracker(t, nil))
+    rec := serve(t, server, "POST", "/api/v1/products", `{"id": "regex-bad", "name": "Kettle", "url": "https://shop.test/kettle", "price_regex": "([0-9"}`, nil)
+    if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid price regex") {
+        t.Errorf("bad regex = %d: %s", rec.Code, rec.Body)
+    }
+}
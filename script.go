@@ -0,0 +1,513 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// maxScriptSteps caps the work one run of a price script can do, so a loop
+// over a large list can't hold up a tracking cycle
+const maxScriptSteps = 1_000_000
+
+var (
+    // ErrInvalidScript is returned for a price script that doesn't parse
+    ErrInvalidScript = errors.New("invalid price script")
+    // ErrScriptFailed is returned when a price script stops with an error
+    // or doesn't produce a price
+    ErrScriptFailed = errors.New("price script failed")
+    // ErrScriptPageUnavailable is returned when the page to try a script
+    // on can't be fetched
+    ErrScriptPageUnavailable = errors.New("could not fetch product page")
+)
+
+// priceScript is a compiled price script: a short Starlark program that
+// reads the fetched page and sets price, and optionally currency, in_stock,
+// list_price and shipping. Besides the Starlark builtins it has builtins
+// for selectors, XPath, regexes and JSON. Starlark has no while loops or
+// recursion, and a run is capped at maxScriptSteps, so a script always
+// finishes.
+type priceScript struct {
+    program *starlark.Program
+}
+
+// scriptInputs are the names a script starts with
+var scriptInputs = []string{"body", "url", "currency"}
+
+// scriptOptions are the Starlark dialect of price scripts: top-level if
+// and for statements are allowed, and a name such as price may be set
+// more than once
+var scriptOptions = &syntax.FileOptions{
+    Set:             true,
+    TopLevelControl: true,
+    GlobalReassign:  true,
+}
+
+// scriptEnvKey is the thread-local key under which builtins find the page
+const scriptEnvKey = "price-script-env"
+
+// scriptEnv is the page a script run reads
+type scriptEnv struct {
+    page    string
+    root    *goquery.Document
+    product Product
+}
+
+// scriptError reports a problem on a script line
+type scriptError struct {
+    line int
+    msg  string
+}
+
+func (e scriptError) Error() string {
+    return fmt.Sprintf("line %d: %s", e.line, e.msg)
+}
+
+// scriptLineError turns a Starlark syntax, resolve or evaluation error into
+// a scriptError naming the script line it happened on
+func scriptLineError(err error) error {
+    var syntaxErr syntax.Error
+    var resolveErrs resolve.ErrorList
+    var evalErr *starlark.EvalError
+    switch {
+    case errors.As(err, &syntaxErr):
+        return scriptError{line: int(syntaxErr.Pos.Line), msg: syntaxErr.Msg}
+    case errors.As(err, &resolveErrs):
+        return scriptError{line: int(resolveErrs[0].Pos.Line), msg: resolveErrs[0].Msg}
+    case errors.As(err, &evalErr):
+        // the innermost frame with a position is the script line; builtins
+        // have none
+        for i := range evalErr.CallStack {
+            if pos := evalErr.CallStack.At(i).Pos; pos.Line > 0 {
+                return scriptError{line: int(pos.Line), msg: evalErr.Msg}
+            }
+        }
+        return errors.New(evalErr.Msg)
+    }
+    return err
+}
+
+// runPriceScript runs a script against a fetched page. The reading's
+// optional fields are nil both when the script leaves them unknown and when
+// it doesn't set them; set holds the names of those it did set, even to
+// None, so callers can fall back to the page's structured data for others.
+func runPriceScript(script *priceScript, page string, root *goquery.Document, product Product) (reading PriceReading, set map[string]bool, err error) {
+    want := product.Currency
+    if want == "" {
+        want = DefaultCurrency
+    }
+    thread := &starlark.Thread{Name: "price script " + product.ID}
+    thread.SetMaxExecutionSteps(maxScriptSteps)
+    thread.SetLocal(scriptEnvKey, &scriptEnv{page: page, root: root, product: product})
+
+    predeclared := starlark.StringDict{
+        "body":     starlark.String(page),
+        "url":      starlark.String(product.URL),
+        "currency": starlark.String(want),
+    }
+    for name, builtin := range scriptBuiltins {
+        predeclared[name] = builtin
+    }
+    vars, err := script.program.Init(thread, predeclared)
+    if err != nil {
+        return reading, nil, fmt.Errorf("%w: %v", ErrScriptFailed, scriptLineError(err))
+    }
+    result := scriptResult{vars: vars, product: product}
+
+    // a script that finds the page showing another currency must not
+    // record its price as the product's
+    if currency, ok := vars["currency"]; ok && currency != starlark.None {
+        text, isString := starlark.AsString(currency)
+        if !isString {
+            text = currency.String()
+        }
+        if strings.ToUpper(strings.TrimSpace(text)) != want {
+            return reading, nil, fmt.Errorf("%w: currency %s doesn't match the product's %s", ErrScriptFailed, text, want)
+        }
+    }
+
+    price, err := result.price("price")
+    if err != nil {
+        return reading, nil, err
+    }
+    if price == nil {
+        return reading, nil, fmt.Errorf("%w: the script didn't set price", ErrScriptFailed)
+    }
+    reading.Price = *price
+
+    set = make(map[string]bool)
+    if value, ok := vars["in_stock"]; ok {
+        set["in_stock"] = true
+        switch v := value.(type) {
+        case starlark.NoneType:
+        case starlark.Bool:
+            inStock := bool(v)
+            reading.InStock = &inStock
+        default:
+            return reading, nil, fmt.Errorf("%w: in_stock must be True, False or None, not %s", ErrScriptFailed, value.Type())
+        }
+    }
+    if _, ok := vars["list_price"]; ok {
+        set["list_price"] = true
+        if reading.ListPrice, err = result.price("list_price"); err != nil {
+            return reading, nil, err
+        }
+    }
+    if _, ok := vars["shipping"]; ok {
+        set["shipping"] = true
+        if reading.Shipping, err = result.shipping(); err != nil {
+            return reading, nil, err
+        }
+    }
+    return reading, set, nil
+}
+
+// scriptResult is the names a script run set
+type scriptResult struct {
+    vars    starlark.StringDict
+    product Product
+}
+
+// shipping reads the shipping cost the script set as a number, or as text
+// like "Free shipping" or "$4.99". It is nil if None.
+func (r scriptResult) shipping() (*float64, error) {
+    var shipping float64
+    switch v := r.vars["shipping"].(type) {
+    case nil, starlark.NoneType:
+        return nil, nil
+    case starlark.Int, starlark.Float:
+        shipping, _ = starlark.AsFloat(v)
+    case starlark.String:
+        parsed, err := parseShippingText(string(v), r.product)
+        if err != nil {
+            return nil, fmt.Errorf("%w: shipping: %v", ErrScriptFailed, err)
+        }
+        shipping = parsed
+    default:
+        return nil, fmt.Errorf("%w: shipping must be a number or text, not %s", ErrScriptFailed, v.Type())
+    }
+    if shipping < 0 || math.IsInf(shipping, 0) || math.IsNaN(shipping) {
+        return nil, fmt.Errorf("%w: shipping must not be negative, got %v", ErrScriptFailed, shipping)
+    }
+    return &shipping, nil
+}
+
+// price reads a price the script set as a number or as price text, which
+// is parsed like a price rule's match. It is nil if unset or None.
+func (r scriptResult) price(name string) (*float64, error) {
+    var price float64
+    switch v := r.vars[name].(type) {
+    case nil, starlark.NoneType:
+        return nil, nil
+    case starlark.Int, starlark.Float:
+        price, _ = starlark.AsFloat(v)
+    case starlark.String:
+        parsed, err := parsePagePrice(string(v), r.product)
+        if err != nil {
+            return nil, fmt.Errorf("%w: %s: %v", ErrScriptFailed, name, err)
+        }
+        price = parsed
+    default:
+        return nil, fmt.Errorf("%w: %s must be a number or text, not %s", ErrScriptFailed, name, v.Type())
+    }
+    if price <= 0 || math.IsInf(price, 0) || math.IsNaN(price) {
+        return nil, fmt.Errorf("%w: %s must be positive, got %v", ErrScriptFailed, name, price)
+    }
+    return &price, nil
+}
+
+// SetPriceScript replaces a product's price rule with a price script, or
+// with none when script is empty. The next check uses it; nothing needs
+// restarting.
+func (pt *PriceTracker) SetPriceScript(productID, script string) (Product, error) {
+    product, err := pt.GetProduct(productID)
+    if err != nil {
+        return Product{}, err
+    }
+    if strings.TrimSpace(script) != "" {
+        if _, err := parsePriceScript(script); err != nil {
+            return Product{}, fmt.Errorf("%w: %v", ErrInvalidScript, err)
+        }
+    }
+
+    product.PriceSelector, product.PriceXPath, product.PriceRegex = "", "", ""
+    product.PriceScript = script
+    return pt.addProduct(product, true)
+}
+
+// TryPriceScript fetches a product's page and runs a script against it
+// without saving anything, so a script can be checked before it is set.
+// An empty script tries the product's own.
+func (pt *PriceTracker) TryPriceScript(ctx context.Context, productID, script string) (PriceReading, error) {
+    product, err := pt.GetProduct(productID)
+    if err != nil {
+        return PriceReading{}, err
+    }
+    if script == "" {
+        script = product.PriceScript
+    }
+    if script == "" {
+        return PriceReading{}, fmt.Errorf("%w: %s has no price script to try", ErrInvalidScript, productID)
+    }
+    if _, err := parsePriceScript(script); err != nil {
+        return PriceReading{}, fmt.Errorf("%w: %v", ErrInvalidScript, err)
+    }
+    product.PriceSelector, product.PriceXPath, product.PriceRegex = "", "", ""
+    product.PriceScript = script
+    rules, err := parsePageRules(product)
+    if err != nil {
+        return PriceReading{}, err
+    }
+
+    ctx, cancel := context.WithTimeout(ctx, pt.fetchTimeoutOrDefault())
+    defer cancel()
+    if err := pt.hostLimiter.wait(ctx, product.URL); err != nil {
+        return PriceReading{}, fmt.Errorf("%w: %v", ErrScriptPageUnavailable, err)
+    }
+    page, _, err := loadPage(ctx, product, pageValidators{})
+    if err != nil {
+        return PriceReading{}, fmt.Errorf("%w: %v", ErrScriptPageUnavailable, err)
+    }
+    return rules.extract(page, parseHTML(page), product)
+}
+
+// parsePriceScript compiles a script, rejecting syntax errors and names
+// that are neither set by the script nor predeclared
+func parsePriceScript(src string) (*priceScript, error) {
+    file, program, err := starlark.SourceProgramOptions(scriptOptions, "price_script", src, isScriptPredeclared)
+    if err != nil {
+        return nil, scriptLineError(err)
+    }
+    for _, stmt := range file.Stmts {
+        if load, ok := stmt.(*syntax.LoadStmt); ok {
+            return nil, scriptError{line: int(load.Load.Line), msg: "scripts can't load modules"}
+        }
+    }
+    return &priceScript{program: program}, nil
+}
+
+func isScriptPredeclared(name string) bool {
+    if _, ok := scriptBuiltins[name]; ok {
+        return true
+    }
+    for _, input := range scriptInputs {
+        if name == input {
+            return true
+        }
+    }
+    return false
+}
+
+// scriptBuiltins are the functions scripts can call besides Starlark's
+// own. Page lookups return None when nothing matches, so scripts can fall
+// back with "or".
+var scriptBuiltins = map[string]*starlark.Builtin{
+    "select":      starlark.NewBuiltin("select", scriptSelect),
+    "select_all":  starlark.NewBuiltin("select_all", scriptSelectAll),
+    "xpath":       starlark.NewBuiltin("xpath", scriptXPath),
+    "regex":       starlark.NewBuiltin("regex", scriptRegex),
+    "between":     starlark.NewBuiltin("between", scriptBetween),
+    "json":        starlark.NewBuiltin("json", scriptJSON),
+    "parse_price": starlark.NewBuiltin("parse_price", scriptParsePrice),
+}
+
+func threadEnv(thread *starlark.Thread) *scriptEnv {
+    return thread.Local(scriptEnvKey).(*scriptEnv)
+}
+
+// scriptSelect is select(css), the text of the first element matching css,
+// or select(css, attr), its attribute
+func scriptSelect(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+    var css, attr string
+    if err := starlark.UnpackArgs(b.Name(), args, kwargs, "css", &css, "attr?", &attr); err != nil {
+        return nil, err
+    }
+    sel, err := parseSelector(css)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %v", b.Name(), err)
+    }
+    node := threadEnv(thread).root.FindMatcher(sel).First()
+    if node.Length() == 0 {
+        return starlark.None, nil
+    }
+    if attr == "" {
+        return starlark.String(strings.TrimSpace(node.Text())), nil
+    }
+    if value, ok := node.Attr(strings.ToLower(attr)); ok {
+        return starlark.String(value), nil
+    }
+    return starlark.None, nil
+}
+
+// scriptSelectAll is select_all(css), the text of every element matching css
+func scriptSelectAll(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+    var css string
+    if err := starlark.UnpackArgs(b.Name(), args, kwargs, "css", &css); err != nil {
+        return nil, err
+    }
+    sel, err := parseSelector(css)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %v", b.Name(), err)
+    }
+    var texts []starlark.Value
+    threadEnv(thread).root.FindMatcher(sel).Each(func(_ int, n *goquery.Selection) {
+        texts = append(texts, starlark.String(strings.TrimSpace(n.Text())))
+    })
+    return starlark.NewList(texts), nil
+}
+
+// scriptXPath is xpath(path), the first value path selects
+func scriptXPath(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+    var path string
+    if err := starlark.UnpackArgs(b.Name(), args, kwargs, "path", &path); err != nil {
+        return nil, err
+    }
+    expr, err := parseXPath(path)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %v", b.Name(), err)
+    }
+    if value, ok := expr.first(threadEnv(thread).root); ok {
+        return starlark.String(strings.TrimSpace(value)), nil
+    }
+    return starlark.None, nil
+}
+
+// scriptRegex is regex(pattern[, text]), the first group, or whole match,
+// of pattern in text, which defaults to the body
+func scriptRegex(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+    var expr string
+    var text starlark.Value = starlark.None
+    if err := starlark.UnpackArgs(b.Name(), args, kwargs, "pattern", &expr, "text?", &text); err != nil {
+        return nil, err
+    }
+    pattern, err := regexp.Compile(expr)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %v", b.Name(), err)
+    }
+    subject := threadEnv(thread).page
+    if text != starlark.None {
+        s, ok := starlark.AsString(text)
+        if !ok {
+            return nil, fmt.Errorf("%s: text must be a string, not %s", b.Name(), text.Type())
+        }
+        subject = s
+    }
+    match := pattern.FindStringSubmatch(subject)
+    switch {
+    case match == nil:
+        return starlark.None, nil
+    case len(match) > 1:
+        return starlark.String(match[1]), nil
+    }
+    return starlark.String(match[0]), nil
+}
+
+// scriptBetween is between(text, start, end), the text between the first
+// start and the end after it
+func scriptBetween(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+    var text, start, end string
+    if err := starlark.UnpackArgs(b.Name(), args, kwargs, "text", &text, "start", &start, "end", &end); err != nil {
+        return nil, err
+    }
+    i := strings.Index(text, start)
+    if i < 0 {
+        return starlark.None, nil
+    }
+    rest := text[i+len(start):]
+    j := strings.Index(rest, end)
+    if j < 0 {
+        return starlark.None, nil
+    }
+    return starlark.String(rest[:j]), nil
+}
+
+// scriptJSON is json(text), the parsed JSON as dicts, lists, strings,
+// numbers, booleans and None
+func scriptJSON(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+    var text string
+    if err := starlark.UnpackArgs(b.Name(), args, kwargs, "text", &text); err != nil {
+        return nil, err
+    }
+    decoder := json.NewDecoder(strings.NewReader(text))
+    decoder.UseNumber()
+    var value interface{}
+    if err := decoder.Decode(&value); err != nil {
+        return nil, fmt.Errorf("%s: %v", b.Name(), err)
+    }
+    return starlarkValue(value)
+}
+
+// scriptParsePrice is parse_price(text), price text read in the product's
+// locale and currency
+func scriptParsePrice(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+    var text string
+    if err := starlark.UnpackArgs(b.Name(), args, kwargs, "text", &text); err != nil {
+        return nil, err
+    }
+    price, err := parsePagePrice(text, threadEnv(thread).product)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %v", b.Name(), err)
+    }
+    return starlark.Float(price), nil
+}
+
+// starlarkValue converts decoded JSON to Starlark values. Whole numbers
+// become ints so they can index lists; object keys keep a stable order.
+func starlarkValue(v interface{}) (starlark.Value, error) {
+    switch v := v.(type) {
+    case nil:
+        return starlark.None, nil
+    case bool:
+        return starlark.Bool(v), nil
+    case string:
+        return starlark.String(v), nil
+    case json.Number:
+        if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+            return starlark.MakeInt64(n), nil
+        }
+        f, err := v.Float64()
+        if err != nil {
+            return nil, err
+        }
+        return starlark.Float(f), nil
+    case []interface{}:
+        elems := make([]starlark.Value, len(v))
+        for i, elem := range v {
+            value, err := starlarkValue(elem)
+            if err != nil {
+                return nil, err
+            }
+            elems[i] = value
+        }
+        return starlark.NewList(elems), nil
+    case map[string]interface{}:
+        keys := make([]string, 0, len(v))
+        for key := range v {
+            keys = append(keys, key)
+        }
+        sort.Strings(keys)
+        dict := starlark.NewDict(len(v))
+        for _, key := range keys {
+            value, err := starlarkValue(v[key])
+            if err != nil {
+                return nil, err
+            }
+            if err := dict.SetKey(starlark.String(key), value); err != nil {
+                return nil, err
+            }
+        }
+        return dict, nil
+    }
+    return nil, fmt.Errorf("unexpected JSON value %T", v)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const scriptPage = `<html><body>
+<span class="price">$42.00</span>
+<s class="was-price">$55.00</s>
+<a class="buy" href="/cart?sku=7">Add</a>
+<script>window.__STATE__ = {"product": {"offers": [{"price": 39.5, "availability": "InStock", "qty": 3}]}};</script>
+</body></html>`
+
+func runScript(t *testing.T, src string) (PriceReading, map[string]bool, error) {
+    t.Helper()
+    script, err := parsePriceScript(src)
+    if err != nil {
+        t.Fatalf("parsePriceScript: %v", err)
+    }
+    return runPriceScript(script, scriptPage, parseHTML(scriptPage), Product{ID: "script", URL: "https://shop.test/p", Currency: "USD"})
+}
+
+func TestPriceScriptReadsJSONState(t *testing.T) {
+    reading, set, err := runScript(t, `
+# the price sits in a JSON blob the page hydrates from
+state = json(between(body, "window.__STATE__ = ", ";</script>"))
+offer = state["product"]["offers"][0]
+price = offer["price"]
+in_stock = offer.get("availability") == "InStock"
+list_price = select(".was-price")
+`)
+    if err != nil {
+        t.Fatalf("run: %v", err)
+    }
+    if reading.Price != 39.5 {
+        t.Errorf("price = %v, want 39.5", reading.Price)
+    }
+    if reading.InStock == nil || !*reading.InStock {
+        t.Errorf("in stock = %v, want true", reading.InStock)
+    }
+    if reading.ListPrice == nil || *reading.ListPrice != 55 {
+        t.Errorf("list price = %v, want 55", reading.ListPrice)
+    }
+    if !set["in_stock"] || !set["list_price"] || set["shipping"] {
+        t.Errorf("set = %v", set)
+    }
+}
+
+func TestPriceScriptBuiltins(t *testing.T) {
+    tests := []struct {
+        name string
+        src  string
+        want float64
+    }{
+        {"select", `price = select(".price")`, 42},
+        {"select fallback", `price = select(".sale") or select(".price")`, 42},
+        {"select attr", `price = float(regex(r"sku=(\d+)", select("a.buy", "href")))`, 7},
+        {"select_all", `price = len(select_all("span, s")) * 10`, 20},
+        {"xpath", `price = xpath("//s[@class='was-price']")`, 55},
+        {"regex", `price = regex(r'"price": ([0-9.]+)')`, 39.5},
+        {"parse_price", `price = parse_price(select(".price")) + 1`, 43},
+        {"json int", `price = json('{"items": [5, 9]}')["items"][json("1")]`, 9},
+        {"control flow", "total = 0\nfor p in [1, 2, 3]:\n    total += p\nif total > 5:\n    price = total\n", 6},
+        {"def", "def double(x):\n    return x * 2\nprice = double(4)\n", 8},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            reading, _, err := runScript(t, tt.src)
+            if err != nil {
+                t.Fatalf("run: %v", err)
+            }
+            if reading.Price != tt.want {
+                t.Errorf("price = %v, want %v", reading.Price, tt.want)
+            }
+        })
+    }
+}
+
+func TestPriceScriptFailures(t *testing.T) {
+    tests := []struct {
+        name string
+        src  string
+        want string
+    }{
+        {"fail", "x = 1\nfail(\"sold out page\")", "line 2: fail: sold out page"},
+        {"no price", `x = select(".price")`, "didn't set price"},
+        {"nothing matched", `price = select(".missing")`, "didn't set price"},
+        {"other currency", "currency = \"EUR\"\nprice = 10", "currency EUR doesn't match the product's USD"},
+        {"bad in_stock", "price = 1\nin_stock = \"yes\"", "in_stock must be True, False or None, not string"},
+        {"negative", "price = -3", "price must be positive"},
+        {"runaway loop", "n = 0\nfor i in range(10000000):\n    n += i\nprice = 1", "line 2"},
+        {"bad selector", `price = select("div[")`, "line 1: select:"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, _, err := runScript(t, tt.src)
+            if !errors.Is(err, ErrScriptFailed) || !strings.Contains(err.Error(), tt.want) {
+                t.Fatalf("err = %v, want %q", err, tt.want)
+            }
+        })
+    }
+}
+
+func TestParsePriceScriptRejectsInvalid(t *testing.T) {
+    tests := []struct {
+        src  string
+        want string
+    }{
+        {"price = select(\".price\"", "line 1"},
+        {"price = 1\nprice = nope(2)", "line 2: undefined: nope"},
+        {"while True:\n    pass", "line 1"},
+        {`load("x.star", "y")`, "line 1"},
+    }
+    for _, tt := range tests {
+        _, err := parsePriceScript(tt.src)
+        if err == nil || !strings.Contains(err.Error(), tt.want) {
+            t.Errorf("%q: err = %v, want %q", tt.src, err, tt.want)
+        }
+    }
+}
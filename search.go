@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+    // maxTags caps how many tags a product can have
+    maxTags = 20
+    // maxTagLength caps the length of one tag
+    maxTagLength = 50
+)
+
+// SortByRelevance orders search results best match first
+const SortByRelevance = "relevance"
+
+// normalizeTags trims a product's tags and drops repeats, keeping their
+// order; repeats are matched ignoring case
+func normalizeTags(tags []string) ([]string, error) {
+    if len(tags) == 0 {
+        return nil, nil
+    }
+
+    seen := make(map[string]bool)
+    var normalized []string
+    for _, tag := range tags {
+        tag = strings.TrimSpace(tag)
+        if tag == "" {
+            return nil, errors.New("invalid tag: must not be empty")
+        }
+        if len(tag) > maxTagLength {
+            return nil, fmt.Errorf("invalid tag %q: longer than %d characters", tag, maxTagLength)
+        }
+        if key := strings.ToLower(tag); !seen[key] {
+            seen[key] = true
+            normalized = append(normalized, tag)
+        }
+    }
+    if len(normalized) > maxTags {
+        return nil, fmt.Errorf("too many tags: at most %d are allowed", maxTags)
+    }
+    return normalized, nil
+}
+
+// searchMatchQuery turns search text into an FTS5 query matching every word
+// as a prefix, so "lap pro" finds "Laptop Pro 14". Words are quoted, which
+// keeps FTS5 operators and punctuation in them from being interpreted.
+func searchMatchQuery(text string) string {
+    words := strings.Fields(text)
+    terms := make([]string, len(words))
+    for i, word := range words {
+        terms[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"*`
+    }
+    return strings.Join(terms, " ")
+}
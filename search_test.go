@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func searchIDs(t *testing.T, server *APIServer, query string) []string {
+    t.Helper()
+    var page ProductPage
+    if rec := serve(t, server, "GET", "/api/v1/products/search?"+query, "", &page); rec.Code != http.StatusOK {
+        t.Fatalf("search %s = %d: %s", query, rec.Code, rec.Body)
+    }
+    ids := make([]string, len(page.Items))
+    for i, item := range page.Items {
+        ids[i] = item.ID
+    }
+    return ids
+}
+
+func TestProductSearch(t *testing.T) {
+    tracker := newTestTracker(t, nil)
+    server := NewAPIServer(tracker)
+    for _, product := range []Product{
+        {ID: "search-kettle", Name: "Steel Kettle", URL: "https://shop.test/kitchen/kettle", Tags: []string{"kettle", "kitchen"}, Notes: "a kettle for the office kettle corner"},
+        {ID: "search-toaster", Name: "Toaster", URL: "https://shop.test/kitchen/toaster", Notes: "goes with the blue kettle and a great many other things on the counter"},
+        {ID: "search-laptop", Name: "Laptop Pro 14", URL: "https://laptops.test/pro-14", Tags: []string{"work"}},
+        {ID: "search-mug", Name: "Mug", URL: "https://shop.test/kitchen/mug", Tags: []string{"gift"}},
+    } {
+        if _, err := tracker.CreateProduct(product); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    tests := []struct {
+        query string
+        want  []string
+    }{
+        // the product named, tagged and noted as a kettle ranks above the
+        // one that mentions it once
+        {"q=kettle", []string{"search-kettle", "search-toaster"}},
+        // words are prefixes, and all of them must match
+        {"q=lap+pro", []string{"search-laptop"}},
+        {"q=kitchen+gift", []string{"search-mug"}},
+        // the URL is searched
+        {"q=laptops", []string{"search-laptop"}},
+        {"q=kettle&sort=name", []string{"search-kettle", "search-toaster"}},
+        {"q=kitchen&sort=name&order=desc", []string{"search-toaster", "search-kettle", "search-mug"}},
+        {"q=kettle&sort=relevance&order=desc", []string{"search-toaster", "search-kettle"}},
+        // FTS5 syntax in the text is searched for literally
+        {"q=" + url.QueryEscape(`"kettle OR mug*`), nil},
+        {"q=dishwasher", nil},
+    }
+    for _, tt := range tests {
+        got := searchIDs(t, server, tt.query)
+        if len(got) != len(tt.want) {
+            t.Errorf("search %s = %v, want %v", tt.query, got, tt.want)
+            continue
+        }
+        for i := range got {
+            if got[i] != tt.want[i] {
+                t.Errorf("search %s = %v, want %v", tt.query, got, tt.want)
+                break
+            }
+        }
+    }
+
+    // the index follows renames and deletes
+    if rec := serve(t, server, "PATCH", "/api/v1/products/search-mug", `{"name": "Travel Mug"}`, nil); rec.Code != http.StatusOK {
+        t.Fatalf("patch = %d: %s", rec.Code, rec.Body)
+    }
+    if got := searchIDs(t, server, "q=travel"); len(got) != 1 || got[0] != "search-mug" {
+        t.Errorf("after a rename: %v", got)
+    }
+    if err := tracker.DeleteProduct("search-toaster", false); err != nil {
+        t.Fatal(err)
+    }
+    if got := searchIDs(t, server, "q=kettle"); len(got) != 1 || got[0] != "search-kettle" {
+        t.Errorf("after a delete: %v", got)
+    }
+
+    for _, query := range []string{"", "q=+", "q=kettle&sort=price"} {
+        if rec := serve(t, server, "GET", "/api/v1/products/search?"+query, "", nil); rec.Code != http.StatusBadRequest {
+            t.Errorf("search %q = %d, want 400", query, rec.Code)
+        }
+    }
+    // relevance only means something for a search
+    if rec := serve(t, server, "GET", "/api/v1/products?sort=relevance", "", nil); rec.Code != http.StatusBadRequest {
+        t.Errorf("listing by relevance = %d, want 400", rec.Code)
+    }
+}
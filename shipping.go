@@ -0,0 +1,105 @@
+package main
+
+import (
+	"math"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// freeShippingWords mark shipping text that means no charge
+var freeShippingWords = []string{"free", "gratis", "kostenlos", "gratuit", "gratuita", "gratuito"}
+
+// totalPrice is a price with its shipping cost, rounded to the cent; nil
+// when the shipping cost isn't known
+func totalPrice(price float64, shipping *float64) *float64 {
+    if shipping == nil {
+        return nil
+    }
+    total := math.Round((price+*shipping)*100) / 100
+    return &total
+}
+
+// extractShipping reads the shipping cost from the element matching the
+// product's shipping selector, or else the page's structured data. It
+// returns nil when the page doesn't show one.
+func extractShipping(root *goquery.Document, product Product, selector cssSelector) *float64 {
+    if selector == nil {
+        return pageShipping(root, product)
+    }
+    text, ok := firstText(root, selector)
+    if !ok {
+        return nil
+    }
+    shipping, err := parseShippingText(text, product)
+    if err != nil {
+        return nil
+    }
+    return &shipping
+}
+
+// parseShippingText reads shipping text such as "+ $4.99 shipping" or
+// "Free delivery"; words for free win over any number, as in "Free
+// shipping over $35"
+func parseShippingText(text string, product Product) (float64, error) {
+    lower := strings.ToLower(text)
+    for _, word := range freeShippingWords {
+        if strings.Contains(lower, word) {
+            return 0, nil
+        }
+    }
+    return parsePagePrice(text, product)
+}
+
+// pageShipping reads the cheapest shipping rate in the product's currency
+// from the shippingDetails of the page's JSON-LD offers
+func pageShipping(root *goquery.Document, product Product) *float64 {
+    want := product.Currency
+    if want == "" {
+        want = DefaultCurrency
+    }
+
+    var found *float64
+    for _, data := range jsonLDBlocks(root) {
+        for _, rate := range findShippingRates(data) {
+            if (rate.currency == "" || strings.EqualFold(rate.currency, want)) && (found == nil || rate.price < *found) {
+                price := rate.price
+                found = &price
+            }
+        }
+    }
+    return found
+}
+
+// findShippingRates collects the shippingRate of every shippingDetails in
+// a JSON-LD document
+func findShippingRates(value interface{}) []structuredPrice {
+    var rates []structuredPrice
+    switch v := value.(type) {
+    case []interface{}:
+        for _, item := range v {
+            rates = append(rates, findShippingRates(item)...)
+        }
+    case map[string]interface{}:
+        for _, key := range sortedKeys(v) {
+            if key != "shippingDetails" {
+                rates = append(rates, findShippingRates(v[key])...)
+                continue
+            }
+            details := v[key]
+            if detail, ok := details.(map[string]interface{}); ok {
+                details = []interface{}{detail}
+            }
+            list, _ := details.([]interface{})
+            for _, item := range list {
+                detail, _ := item.(map[string]interface{})
+                rate, _ := detail["shippingRate"].(map[string]interface{})
+                if price, ok := jsonLDNumber(rate["value"]); ok && price >= 0 {
+                    currency, _ := rate["currency"].(string)
+                    rates = append(rates, structuredPrice{price: price, currency: currency})
+                }
+            }
+        }
+    }
+    return rates
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// shopifyNegativeTTL is how long a host whose /products/<handle>.json isn't
+// Shopify's is scraped before it is tried again
+const shopifyNegativeTTL = 24 * time.Hour
+
+// shopifyProductPath matches Shopify product page paths, optionally under a
+// locale and a collection: /products/<handle>, /fr/products/<handle>,
+// /collections/<collection>/products/<handle>
+var shopifyProductPath = regexp.MustCompile(`^((?:/[a-z]{2}(?:-[a-zA-Z]{2})?)?)(?:/collections/[^/]+)?/products/([^/.]+)/?$`)
+
+// ShopifyFetcher reads prices from a Shopify storefront's product JSON
+// (/products/<handle>.json) instead of parsing the page. Any URL with a
+// Shopify-style product path is tried; hosts that turn out not to be
+// Shopify are remembered and scraped instead. The variant is the one named
+// by the URL's ?variant= parameter, or else the first.
+type ShopifyFetcher struct {
+    mu         sync.Mutex
+    notShopify map[string]time.Time
+}
+
+// shopifyProductURL returns the product JSON URL for a Shopify-style
+// product page URL
+func shopifyProductURL(rawURL string) (string, bool) {
+    parsed, err := url.Parse(rawURL)
+    if err != nil {
+        return "", false
+    }
+    match := shopifyProductPath.FindStringSubmatch(parsed.EscapedPath())
+    if match == nil {
+        return "", false
+    }
+    jsonURL := url.URL{Scheme: parsed.Scheme, Host: parsed.Host, Path: match[1] + "/products/" + match[2] + ".json"}
+    return jsonURL.String(), true
+}
+
+// handles reports whether a product should be fetched from its product
+// JSON: it has no price rule of its own, is fetched with a GET and its host
+// isn't known not to be Shopify
+func (f *ShopifyFetcher) handles(product Product) bool {
+    if product.hasPriceRule() || product.RenderJS {
+        return false
+    }
+    if product.FetchProfile != nil && product.FetchProfile.Method == http.MethodPost {
+        return false
+    }
+    if _, ok := shopifyProductURL(product.URL); !ok {
+        return false
+    }
+
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    until, ok := f.notShopify[urlHost(product.URL)]
+    return !ok || time.Now().After(until)
+}
+
+func (f *ShopifyFetcher) markNotShopify(host string) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    if f.notShopify == nil {
+        f.notShopify = make(map[string]time.Time)
+    }
+    f.notShopify[host] = time.Now().Add(shopifyNegativeTTL)
+}
+
+func (f *ShopifyFetcher) FetchPrice(ctx context.Context, product Product) (float64, error) {
+    reading, err := f.FetchReading(ctx, product)
+    return reading.Price, err
+}
+
+// FetchReading prices the product from its product JSON, falling back to
+// scraping the page when the store doesn't serve one
+func (f *ShopifyFetcher) FetchReading(ctx context.Context, product Product) (PriceReading, error) {
+    jsonURL, ok := shopifyProductURL(product.URL)
+    if !ok {
+        return ScrapingFetcher{}.FetchReading(ctx, product)
+    }
+    if !product.IgnoreRobots {
+        if err := checkRobots(ctx, product); err != nil {
+            return PriceReading{}, err
+        }
+    }
+
+    req, err := product.FetchProfile.newRequest(ctx, jsonURL)
+    if err != nil {
+        return PriceReading{}, err
+    }
+    req.Header.Set("Accept", "application/json")
+
+    resp, err := scrapeClient.Do(req)
+    if err != nil {
+        return PriceReading{}, retryableError{err}
+    }
+    defer resp.Body.Close()
+
+    switch {
+    case resp.StatusCode == http.StatusNotFound:
+        // Shopify answers a missing product in JSON; an HTML 404 means the
+        // store isn't Shopify
+        if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+            f.markNotShopify(urlHost(product.URL))
+        }
+        return ScrapingFetcher{}.FetchReading(ctx, product)
+    case resp.StatusCode != http.StatusOK:
+        err := statusError{code: resp.StatusCode, err: fmt.Errorf("fetching %s: unexpected status %s", jsonURL, resp.Status)}
+        if isRetryableStatus(resp.StatusCode) {
+            return PriceReading{}, retryableError{err}
+        }
+        return PriceReading{}, err
+    }
+
+    body, err := io.ReadAll(io.LimitReader(resp.Body, maxPageBytes))
+    if err != nil {
+        return PriceReading{}, retryableError{err}
+    }
+    var doc shopifyProductResponse
+    if err := json.Unmarshal(body, &doc); err != nil || doc.Product == nil {
+        // a page that isn't product JSON: not a Shopify store
+        if reason, blocked := detectBlock(resp.Header, string(body)); blocked {
+            return PriceReading{}, blockedError{reason: reason}
+        }
+        f.markNotShopify(urlHost(product.URL))
+        return ScrapingFetcher{}.FetchReading(ctx, product)
+    }
+
+    variant, err := doc.Product.variant(product.URL)
+    if err != nil {
+        return PriceReading{}, err
+    }
+    price, err := parsePrice(variant.Price)
+    if err != nil {
+        return PriceReading{}, fmt.Errorf("Shopify variant %d of %q: %w", variant.ID, doc.Product.Handle, err)
+    }
+
+    reading := PriceReading{Price: price}
+    if variant.CompareAtPrice != nil {
+        if listPrice, err := parsePrice(*variant.CompareAtPrice); err == nil && listPrice > price {
+            reading.ListPrice = &listPrice
+        }
+    }
+    if variant.Available != nil {
+        reading.InStock = variant.Available
+    }
+    return reading, nil
+}
+
+// variant returns the variant the product URL selects with ?variant=, or
+// the first variant when it doesn't select one
+func (p *shopifyProduct) variant(productURL string) (shopifyVariant, error) {
+    if len(p.Variants) == 0 {
+        return shopifyVariant{}, fmt.Errorf("Shopify product %q has no variants", p.Handle)
+    }
+
+    var want string
+    if parsed, err := url.Parse(productURL); err == nil {
+        want = parsed.Query().Get("variant")
+    }
+    if want == "" {
+        return p.Variants[0], nil
+    }
+    id, err := strconv.ParseInt(want, 10, 64)
+    if err != nil {
+        return shopifyVariant{}, fmt.Errorf("invalid Shopify variant ID %q", want)
+    }
+    for _, variant := range p.Variants {
+        if variant.ID == id {
+            return variant, nil
+        }
+    }
+    return shopifyVariant{}, fmt.Errorf("Shopify product %q has no variant %d", p.Handle, id)
+}
+
+// shopifyProductResponse is the part of /products/<handle>.json the fetcher
+// reads
+type shopifyProductResponse struct {
+    Product *shopifyProduct `json:"product"`
+}
+
+type shopifyProduct struct {
+    Handle   string           `json:"handle"`
+    Variants []shopifyVariant `json:"variants"`
+}
+
+// shopifyVariant prices are decimal strings in the store's currency.
+// Available is only present in some storefronts' JSON.
+type shopifyVariant struct {
+    ID             int64   `json:"id"`
+    Price          string  `json:"price"`
+    CompareAtPrice *string `json:"compare_at_price"`
+    Available      *bool   `json:"available"`
+}
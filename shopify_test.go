@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const shopifyProductJSON = `{"product": {"handle": "espresso-machine", "variants": [
+    {"id": 101, "price": "349.00", "compare_at_price": "429.00", "available": true},
+    {"id": 102, "price": "389.00", "compare_at_price": null, "available": false},
+    {"id": 103, "price": "389.00", "compare_at_price": "300.00"}
+]}}`
+
+// structuredPage is a product page priced only by its structured data
+const structuredPage = `<html><head><script type="application/ld+json">{"@type": "Product", "offers": {"price": "319.00", "priceCurrency": "USD"}}</script></head><body></body></html>`
+
+// newShopifyStore serves shopifyProductJSON for the espresso-machine handle
+// and a JSON 404 for any other handle, with the product pages themselves
+// priced by structured data
+func newShopifyStore(t *testing.T) *httptest.Server {
+    t.Helper()
+    store := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch {
+        case r.URL.Path == "/robots.txt":
+            http.NotFound(w, r)
+        case strings.HasSuffix(r.URL.Path, ".json"):
+            w.Header().Set("Content-Type", "application/json; charset=utf-8")
+            if r.URL.Path != "/products/espresso-machine.json" && r.URL.Path != "/fr/products/espresso-machine.json" {
+                w.WriteHeader(http.StatusNotFound)
+                w.Write([]byte(`{"errors": "Not Found"}`))
+                return
+            }
+            w.Write([]byte(shopifyProductJSON))
+        default:
+            w.Header().Set("Content-Type", "text/html; charset=utf-8")
+            w.Write([]byte(structuredPage))
+        }
+    }))
+    t.Cleanup(store.Close)
+    return store
+}
+
+func TestShopifyFetchReading(t *testing.T) {
+    store := newShopifyStore(t)
+    f := &ShopifyFetcher{}
+
+    tests := []struct {
+        path      string
+        price     float64
+        listPrice float64
+        inStock   string
+    }{
+        {"/products/espresso-machine", 349, 429, "true"},
+        {"/collections/coffee/products/espresso-machine?variant=102", 389, 0, "false"},
+        // a compare-at price below the price isn't a list price, and
+        // availability is unknown when the store leaves it out
+        {"/fr/products/espresso-machine?variant=103", 389, 0, "unknown"},
+    }
+    for _, tt := range tests {
+        product := Product{ID: "espresso", URL: store.URL + tt.path}
+        if !f.handles(product) {
+            t.Fatalf("%s isn't handled", tt.path)
+        }
+        reading, err := f.FetchReading(context.Background(), product)
+        if err != nil {
+            t.Fatalf("%s: %v", tt.path, err)
+        }
+        listPrice := 0.0
+        if reading.ListPrice != nil {
+            listPrice = *reading.ListPrice
+        }
+        inStock := "unknown"
+        if reading.InStock != nil {
+            inStock = map[bool]string{true: "true", false: "false"}[*reading.InStock]
+        }
+        if reading.Price != tt.price || listPrice != tt.listPrice || inStock != tt.inStock {
+            t.Errorf("%s: price %v, list price %v, in stock %s; want %v, %v, %s", tt.path, reading.Price, listPrice, inStock, tt.price, tt.listPrice, tt.inStock)
+        }
+    }
+
+    if _, err := f.FetchReading(context.Background(), Product{URL: store.URL + "/products/espresso-machine?variant=999"}); err == nil || !strings.Contains(err.Error(), "no variant 999") {
+        t.Errorf("unknown variant = %v", err)
+    }
+
+    // a product with a price rule of its own is scraped
+    if f.handles(Product{URL: store.URL + "/products/espresso-machine", PriceSelector: ".price"}) {
+        t.Error("a product with a price selector was handled")
+    }
+}
+
+func TestShopifyNotFound(t *testing.T) {
+    store := newShopifyStore(t)
+    f := &ShopifyFetcher{}
+
+    // a missing product on a Shopify store falls back to the page, and the
+    // store is still read as Shopify
+    gone := Product{URL: store.URL + "/products/discontinued"}
+    reading, err := f.FetchReading(context.Background(), gone)
+    if err != nil || reading.Price != 319 {
+        t.Fatalf("JSON 404 = %+v, %v; want the page's price", reading, err)
+    }
+    if !f.handles(Product{URL: store.URL + "/products/espresso-machine"}) {
+        t.Error("a JSON 404 marked a Shopify store as not Shopify")
+    }
+
+    // an HTML 404 means the store isn't Shopify: it is scraped from then on
+    other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if strings.HasSuffix(r.URL.Path, ".json") || r.URL.Path == "/robots.txt" {
+            http.NotFound(w, r)
+            return
+        }
+        w.Header().Set("Content-Type", "text/html; charset=utf-8")
+        w.Write([]byte(structuredPage))
+    }))
+    t.Cleanup(other.Close)
+
+    product := Product{URL: other.URL + "/products/espresso-machine"}
+    reading, err = f.FetchReading(context.Background(), product)
+    if err != nil || reading.Price != 319 {
+        t.Fatalf("HTML 404 = %+v, %v; want the page's price", reading, err)
+    }
+    if f.handles(product) {
+        t.Error("a store without product JSON is still handled")
+    }
+}
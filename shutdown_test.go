@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShutdownWaitsForInFlightCycle(t *testing.T) {
+    // the fetch is still running when tracking is cancelled, and finishes
+    // with a price a moment later
+    fetching := make(chan struct{})
+    var finished atomic.Bool
+    fetcher := PriceFetcherFunc(func(ctx context.Context, product Product) (float64, error) {
+        close(fetching)
+        time.Sleep(100 * time.Millisecond)
+        finished.Store(true)
+        return 7.5, nil
+    })
+    tracker := newTestTracker(t, fetcher)
+    tracker.SetRetryPolicy(1, 0)
+    tracker.SetFetchTimeout(time.Minute)
+    addTestProduct(t, tracker, "shutdown-kettle")
+
+    ctx, cancel := context.WithCancel(context.Background())
+    stopped := make(chan struct{})
+    go func() {
+        tracker.StartTracking(ctx, 20*time.Millisecond)
+        close(stopped)
+    }()
+
+    <-fetching
+    cancel()
+    select {
+    case <-stopped:
+    case <-time.After(2 * time.Second):
+        t.Fatal("StartTracking didn't return after cancellation")
+    }
+
+    // nothing is left writing when StartTracking returns, so the database
+    // can be closed
+    if !finished.Load() {
+        t.Fatal("StartTracking returned while a fetch was still running")
+    }
+    history, err := tracker.GetPriceHistory("shutdown-kettle", 10, true)
+    if err != nil || len(history) != 1 || history[0].Price != 7.5 {
+        t.Errorf("history = %v, %v; want the in-flight price stored before returning", history, err)
+    }
+    if err := tracker.db.Close(); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestCancelledCycleReturnsPromptly(t *testing.T) {
+    // fetches that honour cancellation end as soon as the cycle does
+    fetcher := PriceFetcherFunc(func(ctx context.Context, product Product) (float64, error) {
+        <-ctx.Done()
+        return 0, ctx.Err()
+    })
+    tracker := newTestTracker(t, fetcher)
+    tracker.SetRetryPolicy(1, 0)
+    tracker.SetFetchTimeout(time.Minute)
+    for _, id := range []string{"shutdown-a", "shutdown-b", "shutdown-c"} {
+        addTestProduct(t, tracker, id)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+    defer cancel()
+    start := time.Now()
+    tracker.trackProducts(ctx, tracker.snapshotProducts(), nil)
+    if elapsed := time.Since(start); elapsed > time.Second {
+        t.Errorf("the cancelled cycle took %v to return", elapsed)
+    }
+    for _, id := range []string{"shutdown-a", "shutdown-b", "shutdown-c"} {
+        if history, _ := tracker.GetPriceHistory(id, 10, true); len(history) != 0 {
+            t.Errorf("%s stored %v from a cancelled fetch", id, history)
+        }
+    }
+}
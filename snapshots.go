@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+const (
+    // maxSnapshotBytes caps how much of a page is kept in a snapshot
+    maxSnapshotBytes = 1 << 20
+    // maxSnapshotsPerProduct is how many snapshots are kept per product;
+    // older ones are deleted as new ones are taken
+    maxSnapshotsPerProduct = 5
+)
+
+// ErrSnapshotNotFound is returned when a snapshot ID doesn't exist
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+// extractionError is a page that was fetched but had no readable price. It
+// carries the page so the failure can be snapshotted for debugging.
+type extractionError struct {
+    err  error
+    page string
+}
+
+func (e extractionError) Error() string {
+    return e.err.Error()
+}
+
+func (e extractionError) Unwrap() error {
+    return e.err
+}
+
+// saveSnapshot stores the page behind a failed extraction, gzipped and
+// capped at maxSnapshotBytes
+func (pt *PriceTracker) saveSnapshot(product Product, extractErr extractionError) {
+    page := extractErr.page
+    snapshot := PageSnapshot{
+        ProductID: product.ID,
+        URL:       product.URL,
+        Error:     extractErr.Error(),
+        Size:      len(page),
+        TakenAt:   time.Now().UTC(),
+    }
+    if len(page) > maxSnapshotBytes {
+        page = page[:maxSnapshotBytes]
+        snapshot.Truncated = true
+    }
+
+    var body bytes.Buffer
+    zw := gzip.NewWriter(&body)
+    if _, err := io.WriteString(zw, page); err != nil {
+        log.Printf("Failed to compress snapshot for %s: %v", product.ID, err)
+        return
+    }
+    if err := zw.Close(); err != nil {
+        log.Printf("Failed to compress snapshot for %s: %v", product.ID, err)
+        return
+    }
+
+    if err := pt.db.InsertSnapshot(snapshot, body.Bytes(), maxSnapshotsPerProduct); err != nil {
+        log.Printf("Failed to save snapshot for %s: %v", product.ID, err)
+    }
+}
+
+// GetSnapshots lists a product's page snapshots, newest first
+func (pt *PriceTracker) GetSnapshots(productID string) ([]PageSnapshot, error) {
+    if _, err := pt.GetProduct(productID); err != nil {
+        return nil, err
+    }
+    return pt.db.GetSnapshots(productID)
+}
+
+// GetSnapshotPage returns a snapshot and the page it captured
+func (pt *PriceTracker) GetSnapshotPage(id int64) (PageSnapshot, []byte, error) {
+    snapshot, body, found, err := pt.db.GetSnapshot(id)
+    if err != nil {
+        return PageSnapshot{}, nil, err
+    }
+    if !found {
+        return PageSnapshot{}, nil, fmt.Errorf("%w: %d", ErrSnapshotNotFound, id)
+    }
+
+    zr, err := gzip.NewReader(bytes.NewReader(body))
+    if err != nil {
+        return PageSnapshot{}, nil, fmt.Errorf("reading snapshot %d: %w", id, err)
+    }
+    page, err := io.ReadAll(zr)
+    if err != nil {
+        return PageSnapshot{}, nil, fmt.Errorf("reading snapshot %d: %w", id, err)
+    }
+    return snapshot, page, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"time"
+)
+
+// defaultStatsDays is the stats window when none is given
+const defaultStatsDays = 30
+
+// statsChangeDays are the periods, in days, over which stats report the
+// latest price's change
+var statsChangeDays = []int{7, 30, 90}
+
+// GetPriceStats summarizes a product's prices over the last days days, along
+// with its latest price and how that has changed over statsChangeDays
+func (pt *PriceTracker) GetPriceStats(productID string, days int) (PriceStats, error) {
+    product, err := pt.GetProduct(productID)
+    if err != nil {
+        return PriceStats{}, err
+    }
+
+    if days <= 0 {
+        days = defaultStatsDays
+    }
+    now := time.Now()
+    since := now.AddDate(0, 0, -days)
+
+    stats, err := pt.db.GetPriceStats(productID, since, now, statsChangeDays)
+    if err != nil {
+        return PriceStats{}, err
+    }
+    stats.Currency = product.Currency
+
+    return stats, nil
+}
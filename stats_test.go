@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// addPricesAt adds a product with prices stored the given time ago
+func addPricesAt(t *testing.T, tracker *PriceTracker, id string, prices map[time.Duration]float64) {
+    t.Helper()
+    addTestProduct(t, tracker, id)
+    now := time.Now().UTC()
+    for ago, price := range prices {
+        entry := PriceEntry{ProductID: id, Price: price, Currency: DefaultCurrency, Timestamp: now.Add(-ago)}
+        if _, err := tracker.db.InsertPriceEntry(entry); err != nil {
+            t.Fatal(err)
+        }
+    }
+}
+
+func TestPriceStats(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    day := 24 * time.Hour
+    addPricesAt(t, server.tracker, "stats-kettle", map[time.Duration]float64{
+        time.Hour: 10,
+        2 * day:   20,
+        5 * day:   30,
+        10 * day:  100,
+    })
+
+    tests := []struct {
+        days          string
+        count         int
+        min, max, avg float64
+    }{
+        {"3", 2, 10, 20, 15},
+        {"7", 3, 10, 30, 20},
+        {"30", 4, 10, 100, 40},
+    }
+    for _, tt := range tests {
+        var stats PriceStats
+        if rec := serve(t, server, "GET", "/api/v1/products/stats-kettle/stats?days="+tt.days, "", &stats); rec.Code != http.StatusOK {
+            t.Fatalf("stats = %d: %s", rec.Code, rec.Body)
+        }
+        if stats.Count != tt.count || stats.MinPrice != tt.min || stats.MaxPrice != tt.max || stats.AvgPrice != tt.avg || stats.LatestPrice != 10 {
+            t.Errorf("%s days = %+v, want %d prices from %v to %v averaging %v", tt.days, stats, tt.count, tt.min, tt.max, tt.avg)
+        }
+    }
+}
+
+func TestPriceStatsEmpty(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    addTestProduct(t, server.tracker, "stats-new")
+    addPricesAt(t, server.tracker, "stats-old", map[time.Duration]float64{20 * 24 * time.Hour: 50})
+
+    // nothing in the window is zero stats, not an error
+    for _, path := range []string{"/api/v1/products/stats-new/stats", "/api/v1/products/stats-old/stats?days=7"} {
+        var stats PriceStats
+        if rec := serve(t, server, "GET", path, "", &stats); rec.Code != http.StatusOK {
+            t.Fatalf("%s = %d: %s", path, rec.Code, rec.Body)
+        }
+        if stats.Count != 0 || stats.MinPrice != 0 || stats.MaxPrice != 0 || stats.AvgPrice != 0 || stats.PercentFromLow != nil {
+            t.Errorf("%s = %+v, want empty stats", path, stats)
+        }
+    }
+
+    for path, want := range map[string]int{
+        "/api/v1/products/no-such-product/stats":  http.StatusNotFound,
+        "/api/v1/products/stats-new/stats?days=0": http.StatusBadRequest,
+        "/api/v1/products/stats-new/stats?days=a": http.StatusBadRequest,
+    } {
+        if rec := serve(t, server, "GET", path, "", nil); rec.Code != want {
+            t.Errorf("%s = %d, want %d", path, rec.Code, want)
+        }
+    }
+}
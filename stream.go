@@ -0,0 +1,48 @@
+package main
+
+import (
+	"log"
+	"sync"
+)
+
+// subscriberBuffer is how many unread entries a subscriber may fall behind
+// before further entries are dropped for it
+const subscriberBuffer = 64
+
+// Subscribe registers for every price entry saved from now on. The returned
+// function unsubscribes and closes the channel; it is safe to call more than
+// once. A subscriber that doesn't keep up misses entries rather than
+// stalling price collection.
+func (pt *PriceTracker) Subscribe() (<-chan PriceEntry, func()) {
+    ch := make(chan PriceEntry, subscriberBuffer)
+
+    pt.subsMu.Lock()
+    pt.subscribers[ch] = struct{}{}
+    pt.subsMu.Unlock()
+
+    var once sync.Once
+    unsubscribe := func() {
+        once.Do(func() {
+            pt.subsMu.Lock()
+            delete(pt.subscribers, ch)
+            pt.subsMu.Unlock()
+            close(ch)
+        })
+    }
+
+    return ch, unsubscribe
+}
+
+// publish fans a saved entry out to every subscriber without blocking
+func (pt *PriceTracker) publish(entry PriceEntry) {
+    pt.subsMu.Lock()
+    defer pt.subsMu.Unlock()
+
+    for ch := range pt.subscribers {
+        select {
+        case ch <- entry:
+        default:
+            log.Printf("Dropped price update for %s: subscriber is falling behind", entry.ProductID)
+        }
+    }
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func subscriberCount(tracker *PriceTracker) int {
+    tracker.subsMu.Lock()
+    defer tracker.subsMu.Unlock()
+
+    return len(tracker.subscribers)
+}
+
+func TestSubscribe(t *testing.T) {
+    tracker := newTestTracker(t, cyclePrices(19.99))
+    tracker.SetRetryPolicy(1, 0)
+    addTestProduct(t, tracker, "stream-kettle")
+
+    entries, unsubscribe := tracker.Subscribe()
+    tracker.trackProducts(context.Background(), tracker.snapshotProducts(), nil)
+
+    select {
+    case entry := <-entries:
+        if entry.ProductID != "stream-kettle" || entry.Price != 19.99 || entry.ID == 0 {
+            t.Errorf("entry = %+v", entry)
+        }
+    case <-time.After(2 * time.Second):
+        t.Fatal("no entry was published")
+    }
+
+    unsubscribe()
+    unsubscribe()
+    if n := subscriberCount(tracker); n != 0 {
+        t.Errorf("%d subscribers left after unsubscribing", n)
+    }
+}
+
+func TestStreamEndpoint(t *testing.T) {
+    tracker := newTestTracker(t, cyclePrices(42.5))
+    tracker.SetRetryPolicy(1, 0)
+    addTestProduct(t, tracker, "stream-mug")
+    server := httptest.NewServer(NewAPIServer(tracker).router)
+    t.Cleanup(server.Close)
+
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/api/v1/stream", nil)
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer resp.Body.Close()
+    if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+        t.Fatalf("content type = %q", got)
+    }
+
+    // the ": connected" comment means the handler has subscribed
+    lines := bufio.NewScanner(resp.Body)
+    if !lines.Scan() || lines.Text() != ": connected" {
+        t.Fatalf("first line = %q", lines.Text())
+    }
+    tracker.trackProducts(context.Background(), tracker.snapshotProducts(), nil)
+
+    var event string
+    for lines.Scan() {
+        line := lines.Text()
+        if strings.HasPrefix(line, "event: ") {
+            event = strings.TrimPrefix(line, "event: ")
+        }
+        if data, ok := strings.CutPrefix(line, "data: "); ok {
+            var entry PriceEntry
+            if err := json.Unmarshal([]byte(data), &entry); err != nil {
+                t.Fatal(err)
+            }
+            if event != "price" || entry.ProductID != "stream-mug" || entry.Price != 42.5 {
+                t.Errorf("event %q = %+v", event, entry)
+            }
+            break
+        }
+    }
+
+    // disconnecting unsubscribes
+    cancel()
+    deadline := time.Now().Add(2 * time.Second)
+    for subscriberCount(tracker) != 0 && time.Now().Before(deadline) {
+        time.Sleep(10 * time.Millisecond)
+    }
+    if n := subscriberCount(tracker); n != 0 {
+        t.Errorf("%d subscribers left after the client went away", n)
+    }
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// structuredPrice is an offer price found in a page's structured data
+type structuredPrice struct {
+    price    float64
+    currency string
+}
+
+// jsonLDPrices returns the offer prices in a page's schema.org JSON-LD
+// blocks, in document order. Blocks that aren't valid JSON are skipped.
+func jsonLDPrices(root *goquery.Document) []structuredPrice {
+    var prices []structuredPrice
+    for _, data := range jsonLDBlocks(root) {
+        prices = append(prices, findOffers(data)...)
+    }
+    return prices
+}
+
+// findOffers collects the prices of every "offers" value in a JSON-LD
+// document, including products nested in @graph or other properties
+func findOffers(value interface{}) []structuredPrice {
+    var prices []structuredPrice
+    switch v := value.(type) {
+    case []interface{}:
+        for _, item := range v {
+            prices = append(prices, findOffers(item)...)
+        }
+    case map[string]interface{}:
+        // visit keys in a fixed order so the same page always yields the
+        // same price
+        for _, key := range sortedKeys(v) {
+            if key == "offers" {
+                prices = append(prices, offerPrices(v[key])...)
+            } else {
+                prices = append(prices, findOffers(v[key])...)
+            }
+        }
+    }
+    return prices
+}
+
+// sortedKeys returns a JSON object's keys in order
+func sortedKeys(object map[string]interface{}) []string {
+    keys := make([]string, 0, len(object))
+    for key := range object {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// offerPrices reads an Offer, an AggregateOffer or a list of them
+func offerPrices(value interface{}) []structuredPrice {
+    switch v := value.(type) {
+    case []interface{}:
+        var prices []structuredPrice
+        for _, item := range v {
+            prices = append(prices, offerPrices(item)...)
+        }
+        return prices
+    case map[string]interface{}:
+        currency, _ := v["priceCurrency"].(string)
+        for _, key := range []string{"price", "lowPrice"} {
+            if price, ok := jsonLDNumber(v[key]); ok {
+                return []structuredPrice{{price: price, currency: currency}}
+            }
+        }
+        // a specification can be one object or a list, and one marked as the
+        // list price isn't what the product sells for
+        specs, _ := v["priceSpecification"].([]interface{})
+        if spec, ok := v["priceSpecification"].(map[string]interface{}); ok {
+            specs = []interface{}{spec}
+        }
+        for _, item := range specs {
+            spec, ok := item.(map[string]interface{})
+            if !ok || isListPriceType(spec["priceType"]) {
+                continue
+            }
+            if price, ok := jsonLDNumber(spec["price"]); ok {
+                if specCurrency, ok := spec["priceCurrency"].(string); ok {
+                    currency = specCurrency
+                }
+                return []structuredPrice{{price: price, currency: currency}}
+            }
+        }
+        // an AggregateOffer may only list its individual offers
+        if offers, ok := v["offers"]; ok {
+            return offerPrices(offers)
+        }
+    }
+    return nil
+}
+
+// jsonLDNumber reads a price given as a JSON number or a string such as
+// "1,299.00"
+func jsonLDNumber(value interface{}) (float64, bool) {
+    switch v := value.(type) {
+    case float64:
+        return v, true
+    case string:
+        price, err := parsePrice(v)
+        return price, err == nil
+    }
+    return 0, false
+}
+
+// metaPrices returns the prices in a page's Open Graph / product meta tags
+// (og:price:amount, product:price:amount) and schema.org microdata
+// (itemprop="price"), in that order
+func metaPrices(root *goquery.Document) []structuredPrice {
+    var prices []structuredPrice
+    for _, prefix := range []string{"og:price:", "product:price:"} {
+        amount := metaContent(root, prefix+"amount")
+        if amount == "" {
+            continue
+        }
+        if price, err := parsePrice(amount); err == nil {
+            prices = append(prices, structuredPrice{price: price, currency: metaContent(root, prefix+"currency")})
+        }
+    }
+
+    root.Find(`[itemprop="price"]`).Each(func(_ int, n *goquery.Selection) {
+        if price, err := parsePrice(itemValue(n)); err == nil {
+            prices = append(prices, structuredPrice{price: price, currency: itemCurrency(n)})
+        }
+    })
+    return prices
+}
+
+// metaContent returns the content of the first <meta> tag with the given
+// property (or name)
+func metaContent(root *goquery.Document, property string) string {
+    meta := root.Find("meta").FilterFunction(func(_ int, n *goquery.Selection) bool {
+        return n.AttrOr("property", "") == property || n.AttrOr("name", "") == property
+    }).First()
+    return strings.TrimSpace(meta.AttrOr("content", ""))
+}
+
+// itemValue returns a microdata property's value: its content attribute if
+// it has one, otherwise its text
+func itemValue(n *goquery.Selection) string {
+    if content, ok := n.Attr("content"); ok {
+        return strings.TrimSpace(content)
+    }
+    return strings.TrimSpace(n.Text())
+}
+
+// itemCurrency finds the priceCurrency property in the same itemscope as a
+// price property
+func itemCurrency(price *goquery.Selection) string {
+    scope := price.ParentsFiltered("[itemscope]").First()
+    if scope.Length() == 0 {
+        // outside any itemscope the whole page is the scope
+        scope = price.Parents().Last()
+    }
+    currency := scope.Find(`[itemprop="priceCurrency"]`).First()
+    if currency.Length() == 0 {
+        return ""
+    }
+    return itemValue(currency)
+}
+
+// structuredDataPrice picks the first price quoted in the product's
+// currency, preferring JSON-LD over meta tags and microdata; prices without
+// a currency are assumed to match
+func structuredDataPrice(root *goquery.Document, product Product) (float64, error) {
+    prices := append(jsonLDPrices(root), metaPrices(root)...)
+    if len(prices) == 0 {
+        return 0, fmt.Errorf("no price selector configured and no structured data or meta tag price found on the page")
+    }
+
+    want := product.Currency
+    if want == "" {
+        want = DefaultCurrency
+    }
+    for _, p := range prices {
+        if p.currency == "" || strings.EqualFold(p.currency, want) {
+            return p.price, nil
+        }
+    }
+    return 0, fmt.Errorf("structured data only lists prices in %s, but the product is tracked in %s", prices[0].currency, want)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestStructuredDataPrice(t *testing.T) {
+    tests := []struct {
+        name string
+        page string
+        want float64
+    }{
+        {
+            name: "json-ld offer",
+            page: `<script type="application/ld+json">{"@type":"Product","offers":{"price":"1,299.00","priceCurrency":"USD"}}</script>`,
+            want: 1299,
+        },
+        {
+            name: "json-ld graph skips other currencies",
+            page: `<script type=" Application/LD+JSON ">{"@graph":[{"offers":{"price":10,"priceCurrency":"EUR"}},{"offers":{"price":12,"priceCurrency":"USD"}}]}</script>`,
+            want: 12,
+        },
+        {
+            name: "meta tags",
+            page: `<meta property="og:price:amount" content="24.50"><meta property="og:price:currency" content="USD">`,
+            want: 24.5,
+        },
+        {
+            name: "microdata in itemscope",
+            page: `<div itemscope><span itemprop="price" content="7.25"></span><meta itemprop="priceCurrency" content="USD"></div>`,
+            want: 7.25,
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            price, err := structuredDataPrice(parseHTML(tt.page), Product{Currency: "USD"})
+            if err != nil {
+                t.Fatalf("structuredDataPrice: %v", err)
+            }
+            if price != tt.want {
+                t.Errorf("price = %v, want %v", price, tt.want)
+            }
+        })
+    }
+}
+
+func TestStructuredDataPriceWrongCurrency(t *testing.T) {
+    page := `<div itemscope><span itemprop="price">9.99</span><span itemprop="priceCurrency">GBP</span></div>`
+    if _, err := structuredDataPrice(parseHTML(page), Product{Currency: "USD"}); err == nil {
+        t.Fatal("a GBP-only page was priced in USD")
+    }
+}
+
+func TestPageReadingFromStructuredData(t *testing.T) {
+    page := `<html><body>
+<script type="application/ld+json">
+{"@type": "Product", "name": "Kettle",
+ "offers": {"@type": "Offer", "price": 39.99, "priceCurrency": "USD",
+   "availability": "https://schema.org/OutOfStock",
+   "priceSpecification": [{"@type": "UnitPriceSpecification", "priceType": "https://schema.org/ListPrice", "price": 49.99}],
+   "shippingDetails": [
+     {"shippingRate": {"value": 5.99, "currency": "USD"}},
+     {"shippingRate": {"value": 3.5, "currency": "USD"}}
+   ]}}
+</script>
+</body></html>`
+
+    rules, err := parsePageRules(Product{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    reading, err := rules.extract(page, parseHTML(page), Product{Currency: "USD"})
+    if err != nil {
+        t.Fatalf("extract: %v", err)
+    }
+    if reading.Price != 39.99 {
+        t.Errorf("price = %v, want 39.99", reading.Price)
+    }
+    if reading.InStock == nil || *reading.InStock {
+        t.Errorf("in stock = %v, want false", reading.InStock)
+    }
+    if reading.ListPrice == nil || *reading.ListPrice != 49.99 {
+        t.Errorf("list price = %v, want 49.99", reading.ListPrice)
+    }
+    if reading.Shipping == nil || *reading.Shipping != 3.5 {
+        t.Errorf("shipping = %v, want the cheapest rate 3.5", reading.Shipping)
+    }
+}
+
+func TestPageAvailabilityFromMicrodata(t *testing.T) {
+    page := `<div itemscope><link itemprop="availability" href="https://schema.org/InStock"></div>`
+    if inStock := pageAvailability(parseHTML(page)); inStock == nil || !*inStock {
+        t.Errorf("in stock = %v, want true", inStock)
+    }
+    if inStock := pageAvailability(parseHTML(`<p>no data</p>`)); inStock != nil {
+        t.Errorf("in stock = %v, want unknown", *inStock)
+    }
+}
+
+func TestDiscoverMetadata(t *testing.T) {
+    page := `<html><head><title>  Fallback
+  title </title><meta property="og:image" content="/img/kettle.png"></head>
+<body><script type="application/ld+json">{"@type":"Product","name":"Steel Kettle","offers":{"price":"39.99","priceCurrency":"eur"}}</script></body></html>`
+
+    meta := discoverMetadata(parseHTML(page), "https://shop.test/kettle")
+    if meta.name != "Steel Kettle" {
+        t.Errorf("name = %q, want Steel Kettle", meta.name)
+    }
+    if meta.currency != "EUR" {
+        t.Errorf("currency = %q, want EUR", meta.currency)
+    }
+    if meta.imageURL != "https://shop.test/img/kettle.png" {
+        t.Errorf("image = %q", meta.imageURL)
+    }
+
+    meta = discoverMetadata(parseHTML(`<title>  Fallback
+  title </title>`), "https://shop.test/")
+    if meta.name != "Fallback title" {
+        t.Errorf("name = %q, want the title", meta.name)
+    }
+}
+
+func TestPageLinks(t *testing.T) {
+    page := `<div class="card"><a href="/p/1" title="First">ignored</a></div>
+<div class="card"><h2>Second</h2><a href="/p/2">Second product</a></div>
+<div class="card">no link</div>`
+
+    selector, err := parseSelector(".card")
+    if err != nil {
+        t.Fatal(err)
+    }
+    links := pageLinks(parseHTML(page), selector)
+    if len(links) != 2 {
+        t.Fatalf("got %d links, want 2: %+v", len(links), links)
+    }
+    if links[0].URL != "/p/1" || links[0].Name != "First" {
+        t.Errorf("first link = %+v", links[0])
+    }
+    if links[1].URL != "/p/2" || links[1].Name != "Second product" {
+        t.Errorf("second link = %+v", links[1])
+    }
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// tenantNamePattern is what a tenant name may look like
+var tenantNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// parseTenantKeys reads TENANT_API_KEYS, a comma-separated list of
+// tenant:key pairs such as "alice:k1,bob:k2", into keys by tenant
+func parseTenantKeys(spec string) (map[string]string, error) {
+    keys := make(map[string]string)
+    seen := make(map[string]bool)
+    for _, pair := range strings.Split(spec, ",") {
+        pair = strings.TrimSpace(pair)
+        if pair == "" {
+            continue
+        }
+        tenant, key, ok := strings.Cut(pair, ":")
+        tenant, key = strings.TrimSpace(tenant), strings.TrimSpace(key)
+        if !ok || key == "" {
+            return nil, fmt.Errorf("%q must be tenant:key", pair)
+        }
+        if !tenantNamePattern.MatchString(tenant) {
+            return nil, fmt.Errorf("invalid tenant name %q: use letters, digits, '.', '_' or '-'", tenant)
+        }
+        if _, ok := keys[tenant]; ok {
+            return nil, fmt.Errorf("tenant %q is listed twice", tenant)
+        }
+        if seen[key] {
+            return nil, fmt.Errorf("tenant %q shares its key with another tenant", tenant)
+        }
+        keys[tenant] = key
+        seen[key] = true
+    }
+    return keys, nil
+}
+
+// tenantContextKey is the request context key holding the caller's tenant
+type tenantContextKey struct{}
+
+// requestTenant returns the tenant a request was authenticated as. Requests
+// without a tenant's key act in the default namespace, "".
+func requestTenant(r *http.Request) string {
+    return contextTenant(r.Context())
+}
+
+// contextTenant is requestTenant for code that only has the request's
+// context, such as GraphQL resolvers
+func contextTenant(ctx context.Context) string {
+    tenant, _ := ctx.Value(tenantContextKey{}).(string)
+    return tenant
+}
+
+// SetTenantKeys gives each tenant, by name, its own API key. A request with
+// a tenant's key only sees and manages that tenant's products; requests
+// with the key from SetAPIKey, or with none, use the default namespace.
+func (s *APIServer) SetTenantKeys(keys map[string]string) {
+    s.tenantKeys = keys
+}
+
+// keyTenant returns the tenant an API key belongs to, "" for the key from
+// SetAPIKey. Every key is compared in constant time; ok is false for a key
+// that matches none.
+func (s *APIServer) keyTenant(key string) (tenant string, ok bool) {
+    if s.apiKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(s.apiKey)) == 1 {
+        ok = true
+    }
+    for name, tenantKey := range s.tenantKeys {
+        if subtle.ConstantTimeCompare([]byte(key), []byte(tenantKey)) == 1 {
+            tenant, ok = name, true
+        }
+    }
+    return tenant, ok
+}
+
+// productRoutes are the route templates whose {id} is a product ID
+var productRoutes = []string{"/api/v1/products/{id}", "/api/v1/admin/products/{id}"}
+
+// tenantMiddleware answers requests naming another tenant's product as if
+// the product didn't exist, so other namespaces can't be read, changed or
+// probed
+func (s *APIServer) tenantMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        productID := mux.Vars(r)["id"]
+        if route := mux.CurrentRoute(r); route != nil && productID != "" {
+            template, _ := route.GetPathTemplate()
+            for _, prefix := range productRoutes {
+                if strings.HasPrefix(template, prefix) && s.foreignProduct(r, productID) {
+                    s.writeError(w, http.StatusNotFound, fmt.Sprintf("%v: %s", ErrProductNotFound, productID))
+                    return
+                }
+            }
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// foreignProduct reports whether a product belongs to a tenant other than
+// the request's. What a deleted product leaves behind stays its tenant's;
+// a product whose owner isn't known is only the default namespace's.
+func (s *APIServer) foreignProduct(r *http.Request, productID string) bool {
+    tenant, ok := s.tracker.productOwner(productID)
+    if !ok {
+        return requestTenant(r) != ""
+    }
+    return tenant != requestTenant(r)
+}
+
+// productOwner returns the tenant a product belongs to, whether or not it
+// is still tracked
+func (pt *PriceTracker) productOwner(productID string) (string, bool) {
+    if tenant, ok := pt.productTenant(productID); ok {
+        return tenant, true
+    }
+    tenant, ok, err := pt.db.ProductOwner(productID)
+    if err != nil {
+        log.Printf("Failed to look up the owner of %s: %v", productID, err)
+        return "", false
+    }
+    return tenant, ok
+}
+
+// productTenant returns the tenant a tracked product belongs to
+func (pt *PriceTracker) productTenant(productID string) (string, bool) {
+    pt.mu.RLock()
+    defer pt.mu.RUnlock()
+
+    product, ok := pt.products[productID]
+    return product.Tenant, ok
+}
+
+// ownsProduct reports whether a product is tracked for tenant
+func (pt *PriceTracker) ownsProduct(tenant, productID string) bool {
+    owner, ok := pt.productTenant(productID)
+    return ok && owner == tenant
+}
+
+// tenantProducts returns a snapshot of a tenant's products
+func (pt *PriceTracker) tenantProducts(tenant string) []Product {
+    pt.mu.RLock()
+    defer pt.mu.RUnlock()
+
+    var products []Product
+    for _, product := range pt.products {
+        if product.Tenant == tenant {
+            products = append(products, product)
+        }
+    }
+    return products
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTenantServer returns a server with an admin key and keys for the
+// tenants alice and bob, with a product of alice's, "alice-kettle", that has
+// a price history
+func newTenantServer(t *testing.T) *APIServer {
+    t.Helper()
+    tracker := newTestTracker(t, nil)
+    server := NewAPIServer(tracker)
+    server.SetAPIKey("admin-key")
+    server.SetTenantKeys(map[string]string{"alice": "alice-key", "bob": "bob-key"})
+
+    var created Product
+    body := `{"id": "alice-kettle", "name": "Kettle", "url": "https://shop.test/kettle"}`
+    if rec := serveWithKey(t, server, "alice-key", "POST", "/api/v1/products", body, &created); rec.Code != http.StatusCreated {
+        t.Fatalf("create = %d: %s", rec.Code, rec.Body)
+    }
+    if created.Tenant != "alice" {
+        t.Fatalf("created product's tenant = %q, want alice", created.Tenant)
+    }
+    for i, price := range []float64{30, 25} {
+        timestamp := time.Now().UTC().Add(time.Duration(i-2) * time.Hour)
+        entry := PriceEntry{ProductID: "alice-kettle", Price: price, Currency: DefaultCurrency, Timestamp: timestamp}
+        if _, err := tracker.db.InsertPriceEntry(entry); err != nil {
+            t.Fatalf("InsertPriceEntry: %v", err)
+        }
+    }
+    return server
+}
+
+func TestTenantCannotReachAnotherTenantsProduct(t *testing.T) {
+    server := newTenantServer(t)
+
+    requests := []struct {
+        method, path, body string
+    }{
+        {"GET", "/api/v1/products/alice-kettle", ""},
+        {"PUT", "/api/v1/products/alice-kettle", `{"name": "Mine now", "url": "https://shop.test/kettle"}`},
+        {"PATCH", "/api/v1/products/alice-kettle", `{"name": "Mine now"}`},
+        {"GET", "/api/v1/products/alice-kettle/history", ""},
+        {"GET", "/api/v1/products/alice-kettle/history.csv", ""},
+        {"GET", "/api/v1/products/alice-kettle/stats", ""},
+        {"POST", "/api/v1/products/alice-kettle/check", ""},
+        {"GET", "/api/v1/admin/products/alice-kettle/snapshots", ""},
+        {"DELETE", "/api/v1/products/alice-kettle", ""},
+    }
+    for _, key := range []string{"bob-key", "admin-key"} {
+        for _, req := range requests {
+            rec := serveWithKey(t, server, key, req.method, req.path, req.body, nil)
+            if rec.Code != http.StatusNotFound {
+                t.Errorf("%s %s with %s = %d, want 404", req.method, req.path, key, rec.Code)
+            }
+        }
+    }
+
+    // the product is untouched
+    var product Product
+    if rec := serveWithKey(t, server, "alice-key", "GET", "/api/v1/products/alice-kettle", "", &product); rec.Code != http.StatusOK {
+        t.Fatalf("owner's GET = %d: %s", rec.Code, rec.Body)
+    }
+    if product.Name != "Kettle" {
+        t.Errorf("name = %q, want it unchanged", product.Name)
+    }
+}
+
+func TestTenantListingsOnlyShowOwnProducts(t *testing.T) {
+    server := newTenantServer(t)
+    addTestProduct(t, server.tracker, "shared-mug", 10, 8)
+
+    for key, want := range map[string]string{"alice-key": "alice-kettle", "bob-key": "", "admin-key": "shared-mug", "": "shared-mug"} {
+        var page ProductPage
+        serveWithKey(t, server, key, "GET", "/api/v1/products", "", &page)
+        var ids []string
+        for _, item := range page.Items {
+            ids = append(ids, item.ID)
+        }
+        if want == "" && len(ids) != 0 || want != "" && (len(ids) != 1 || ids[0] != want) || page.Total != len(ids) {
+            t.Errorf("products listed with %q = %v (total %d), want [%s]", key, ids, page.Total, want)
+        }
+
+        var batch struct {
+            Results []map[string]interface{} `json:"products"`
+        }
+        serveWithKey(t, server, key, "GET", "/api/v1/history/batch?ids=alice-kettle", "", &batch)
+        if len(batch.Results) != 1 {
+            t.Fatalf("batch results = %v", batch.Results)
+        }
+        _, failed := batch.Results[0]["error"]
+        if failed != (key != "alice-key") {
+            t.Errorf("batch history with %q = %v", key, batch.Results[0])
+        }
+    }
+
+    var drops struct {
+        Products []PriceDrop `json:"products"`
+    }
+    serveWithKey(t, server, "bob-key", "GET", "/api/v1/deals", "", &drops)
+    if len(drops.Products) != 0 {
+        t.Errorf("bob sees alice's price drops: %+v", drops.Products)
+    }
+    serveWithKey(t, server, "alice-key", "GET", "/api/v1/deals", "", &drops)
+    if len(drops.Products) != 1 || drops.Products[0].ProductID != "alice-kettle" {
+        t.Errorf("alice's price drops = %+v", drops.Products)
+    }
+}
+
+func TestTenantOutliersAndJobsAreScoped(t *testing.T) {
+    server := newTenantServer(t)
+    entries, err := server.tracker.GetPriceHistory("alice-kettle", 1, true)
+    if err != nil || len(entries) != 1 {
+        t.Fatalf("GetPriceHistory = %v, %v", entries, err)
+    }
+    path := fmt.Sprintf("/api/v1/entries/%d/outlier", entries[0].ID)
+
+    if rec := serveWithKey(t, server, "bob-key", "PUT", path, `{"is_outlier": true}`, nil); rec.Code != http.StatusNotFound {
+        t.Errorf("bob flagging alice's entry = %d, want 404", rec.Code)
+    }
+    if rec := serveWithKey(t, server, "alice-key", "PUT", path, `{"is_outlier": true}`, nil); rec.Code != http.StatusOK {
+        t.Errorf("alice flagging her entry = %d: %s", rec.Code, rec.Body)
+    }
+
+    var job Job
+    if rec := serveWithKey(t, server, "bob-key", "POST", "/api/v1/validate-all", "", &job); rec.Code != http.StatusAccepted {
+        t.Fatalf("validate-all = %d: %s", rec.Code, rec.Body)
+    }
+    if job.Total != 0 {
+        t.Errorf("bob's job covers %d products, want none of alice's", job.Total)
+    }
+    if rec := serveWithKey(t, server, "alice-key", "GET", "/api/v1/jobs/"+job.ID, "", nil); rec.Code != http.StatusNotFound {
+        t.Errorf("alice reading bob's job = %d, want 404", rec.Code)
+    }
+    if rec := serveWithKey(t, server, "bob-key", "GET", "/api/v1/jobs/"+job.ID, "", nil); rec.Code != http.StatusOK {
+        t.Errorf("bob reading his job = %d", rec.Code)
+    }
+}
+
+func TestTenantKeyAuth(t *testing.T) {
+    server := newTenantServer(t)
+
+    if rec := serveWithKey(t, server, "wrong-key", "GET", "/api/v1/products", "", nil); rec.Code != http.StatusUnauthorized {
+        t.Errorf("GET with an invalid key = %d, want 401", rec.Code)
+    }
+    body := `{"id": "anon", "name": "Anon", "url": "https://shop.test/anon"}`
+    if rec := serve(t, server, "POST", "/api/v1/products", body, nil); rec.Code != http.StatusUnauthorized {
+        t.Errorf("POST without a key = %d, want 401", rec.Code)
+    }
+
+    // IDs are unique across tenants
+    body = `{"id": "alice-kettle", "name": "Kettle", "url": "https://shop.test/kettle"}`
+    if rec := serveWithKey(t, server, "bob-key", "POST", "/api/v1/products", body, nil); rec.Code != http.StatusConflict {
+        t.Errorf("bob reusing alice's ID = %d, want 409", rec.Code)
+    }
+}
+
+func TestParseTenantKeys(t *testing.T) {
+    keys, err := parseTenantKeys(" alice:k1, bob : k2 ,")
+    if err != nil {
+        t.Fatalf("parseTenantKeys: %v", err)
+    }
+    if len(keys) != 2 || keys["alice"] != "k1" || keys["bob"] != "k2" {
+        t.Errorf("keys = %v", keys)
+    }
+
+    for _, spec := range []string{"alice", "alice:", ":k1", "al ice:k1", "alice:k1,alice:k2", "alice:k1,bob:k1"} {
+        if _, err := parseTenantKeys(spec); err == nil {
+            t.Errorf("parseTenantKeys(%q) succeeded", spec)
+        }
+    }
+}
+
+// leaveLeftovers records what a fetch still in flight when a product is
+// deleted, and its alerts, leave behind: a scrape error, a page snapshot
+// and a notification. It returns the snapshot's ID.
+func leaveLeftovers(t *testing.T, tracker *PriceTracker, product Product) int64 {
+    t.Helper()
+    tracker.recordScrapeError(context.Background(), product, 1, extractionError{err: errors.New("no price on the page"), page: "<html>sold out</html>"})
+    now := time.Now().UTC()
+    notification := Notification{Kind: "target", ProductID: product.ID, DedupeKey: "target:" + product.ID, URL: "https://hooks.test/alerts",
+        Payload: json.RawMessage(`{"product_id": "` + product.ID + `"}`), CreatedAt: now, NextAttemptAt: now}
+    if _, _, err := tracker.db.EnqueueNotification(notification); err != nil {
+        t.Fatal(err)
+    }
+    snapshots, err := tracker.db.GetSnapshots(product.ID)
+    if err != nil || len(snapshots) == 0 {
+        t.Fatalf("snapshots = %v, %v", snapshots, err)
+    }
+    return snapshots[0].ID
+}
+
+func TestTenantCannotReadDeletedProductsLeftovers(t *testing.T) {
+    server := newTenantServer(t)
+    tracker := server.tracker
+    kettle, _ := tracker.GetProduct("alice-kettle")
+    if rec := serveWithKey(t, server, "alice-key", "DELETE", "/api/v1/products/alice-kettle", "", nil); rec.Code != http.StatusNoContent {
+        t.Fatalf("delete = %d: %s", rec.Code, rec.Body)
+    }
+    kettleSnapshot := leaveLeftovers(t, tracker, kettle)
+
+    // a purged product leaves no record of its owner
+    var mug Product
+    body := `{"id": "alice-mug", "name": "Mug", "url": "https://shop.test/mug"}`
+    if rec := serveWithKey(t, server, "alice-key", "POST", "/api/v1/products", body, &mug); rec.Code != http.StatusCreated {
+        t.Fatalf("create = %d: %s", rec.Code, rec.Body)
+    }
+    if rec := serveWithKey(t, server, "alice-key", "DELETE", "/api/v1/products/alice-mug?purge=true", "", nil); rec.Code != http.StatusNoContent {
+        t.Fatalf("purge = %d: %s", rec.Code, rec.Body)
+    }
+    mugSnapshot := leaveLeftovers(t, tracker, mug)
+
+    notified := func(key string) map[string]bool {
+        var result struct {
+            Notifications []Notification `json:"notifications"`
+        }
+        serveWithKey(t, server, key, "GET", "/api/v1/admin/notifications", "", &result)
+        ids := map[string]bool{}
+        for _, n := range result.Notifications {
+            ids[n.ProductID] = true
+        }
+        return ids
+    }
+    snapshotCode := func(key string, id int64) int {
+        return serveWithKey(t, server, key, "GET", fmt.Sprintf("/api/v1/admin/snapshots/%d", id), "", nil).Code
+    }
+
+    // the deleted product's leftovers stay alice's
+    for _, key := range []string{"bob-key", "admin-key"} {
+        for _, path := range []string{
+            "/api/v1/products/alice-kettle/scrape-errors",
+            "/api/v1/products/alice-kettle/history",
+            "/api/v1/admin/products/alice-kettle/snapshots",
+        } {
+            rec := serveWithKey(t, server, key, "GET", path, "", nil)
+            if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), ErrProductNotFound.Error()) {
+                t.Errorf("GET %s with %s = %d: %s, want 404", path, key, rec.Code, rec.Body)
+            }
+        }
+        if code := snapshotCode(key, kettleSnapshot); code != http.StatusNotFound {
+            t.Errorf("deleted product's snapshot with %s = %d, want 404", key, code)
+        }
+        if notified(key)["alice-kettle"] {
+            t.Errorf("%s sees the deleted product's notification", key)
+        }
+    }
+    if code := snapshotCode("alice-key", kettleSnapshot); code != http.StatusOK {
+        t.Errorf("owner's snapshot = %d, want 200", code)
+    }
+    if !notified("alice-key")["alice-kettle"] {
+        t.Error("the owner doesn't see the deleted product's notification")
+    }
+
+    // leftovers with no known owner are only the default namespace's
+    for _, key := range []string{"bob-key", "alice-key"} {
+        if code := snapshotCode(key, mugSnapshot); code != http.StatusNotFound {
+            t.Errorf("purged product's snapshot with %s = %d, want 404", key, code)
+        }
+        if notified(key)["alice-mug"] {
+            t.Errorf("%s sees the purged product's notification", key)
+        }
+    }
+    if code := snapshotCode("admin-key", mugSnapshot); code != http.StatusOK || !notified("admin-key")["alice-mug"] {
+        t.Errorf("admin can't see the purged product's leftovers: snapshot %d", code)
+    }
+
+    // a product of the same ID tracked again belongs to its new tenant
+    body = `{"id": "alice-kettle", "name": "Kettle", "url": "https://shop.test/kettle"}`
+    if rec := serveWithKey(t, server, "bob-key", "POST", "/api/v1/products", body, nil); rec.Code != http.StatusCreated {
+        t.Fatalf("recreate = %d: %s", rec.Code, rec.Body)
+    }
+    if rec := serveWithKey(t, server, "bob-key", "GET", "/api/v1/products/alice-kettle", "", nil); rec.Code != http.StatusOK {
+        t.Errorf("new owner's GET = %d", rec.Code)
+    }
+    if rec := serveWithKey(t, server, "alice-key", "GET", "/api/v1/products/alice-kettle", "", nil); rec.Code != http.StatusNotFound {
+        t.Errorf("old owner's GET = %d, want 404", rec.Code)
+    }
+}
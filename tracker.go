@@ -2,25 +2,130 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"math/rand"
+	"math"
+	"net/url"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
+var (
+    // ErrProductNotFound is returned when a product ID isn't tracked
+    ErrProductNotFound = errors.New("product not found")
+    // ErrEntryNotFound is returned when a price entry ID doesn't exist
+    ErrEntryNotFound = errors.New("price entry not found")
+    // ErrProductExists is returned when creating a product whose ID is taken
+    ErrProductExists = errors.New("product already exists")
+    // ErrInvalidSort is returned when listing products in an unknown order
+    ErrInvalidSort = errors.New("invalid sort")
+    // ErrCheckFailed is returned when an on-demand price check can't fetch
+    // the product's price
+    ErrCheckFailed = errors.New("price check failed")
+)
+
 type PriceTracker struct {
     db       *Database
+    fetcher  PriceFetcher
     products map[string]Product
     mu       sync.RWMutex
+    cycleMu  sync.Mutex
+
+    // signalled when a product is added or changed so the tracking loop
+    // reschedules instead of sleeping through its first check
+    scheduleChanged chan struct{}
+
+    // Prometheus collectors for fetches and tracked products
+    metrics *trackerMetrics
+
+    // on-demand jobs, guarded by jobsMu
+    jobsMu sync.Mutex
+    jobs   map[string]*Job
+
+    // whether each product's last price was at or below its target, guarded
+    // by alertsMu
+    alertsMu    sync.Mutex
+    belowTarget map[string]bool
+
+    // the product name each product's page first showed, guarded by
+    // alertsMu
+    listings map[string]listingState
+
+    // live price entry subscribers, guarded by subsMu
+    subsMu      sync.Mutex
+    subscribers map[chan PriceEntry]struct{}
+
+    // products skipped because of robots.txt, guarded by robotsMu
+    robotsMu      sync.Mutex
+    robotsBlocked map[string]RobotsBlock
+
+    // blocked fetch counts by product, guarded by blocksMu
+    blocksMu sync.Mutex
+    blocks   map[string]*BlockStats
+
+    // per-host fetch rate limit, concurrency cap and circuit breakers
+    hostLimiter *hostLimiter
+    hostSlots   *hostSlots
+    breakers    *breakerSet
+
+    // proxied product images keyed by image URL, guarded by imagesMu
+    imagesMu sync.Mutex
+    images   map[string]cachedImage
+
+    // scheduling settings and watchdog state, guarded by statusMu
+    statusMu          sync.Mutex
+    watchdogThreshold time.Duration
+    saleInterval      time.Duration
+    medianSamples     int
+    medianWindow      time.Duration
+    medianAlerts      bool
+    minChange         float64
+    minChangePercent  float64
+    lastCycle         time.Time
+    restarts          int
+    watchdogEvents    []WatchdogEvent
+    webhookURL        string
+    notifyAttempts    int
+    notifyRetryDelay  time.Duration
+    listingSimilarity float64
+    numWorkers        int
+    duplicateEpsilon  float64
+    fetchTimeout      time.Duration
+    fetchAttempts     int
+    retryDelay        time.Duration
 }
 
-func NewPriceTracker(db *Database) *PriceTracker {
+// NewPriceTracker creates a tracker that gets prices from fetcher. A nil
+// fetcher uses DefaultFetcher.
+func NewPriceTracker(db *Database, fetcher PriceFetcher) *PriceTracker {
+    if fetcher == nil {
+        fetcher = DefaultFetcher{}
+    }
+
     tracker := &PriceTracker{
         db:       db,
+        fetcher:  fetcher,
         products: make(map[string]Product),
+        jobs:     make(map[string]*Job),
+        images:   make(map[string]cachedImage),
+
+        robotsBlocked: make(map[string]RobotsBlock),
+        hostLimiter:   newHostLimiter(),
+        hostSlots:     newHostSlots(),
+        breakers:      newBreakerSet(),
+        blocks:        make(map[string]*BlockStats),
+
+        scheduleChanged: make(chan struct{}, 1),
+        belowTarget:     make(map[string]bool),
+        listings:        make(map[string]listingState),
+        subscribers:     make(map[chan PriceEntry]struct{}),
     }
 
+    tracker.metrics = newTrackerMetrics(tracker)
+
     // load existing products from database
     if err := tracker.loadProducts(); err != nil {
         log.Printf("Failed to load products: %v", err)
@@ -46,93 +151,698 @@ func (pt *PriceTracker) loadProducts() error {
     return nil
 }
 
+// AddProduct adds a product or replaces the existing one with the same ID
 func (pt *PriceTracker) AddProduct(product Product) error {
+    _, err := pt.addProduct(product, true)
+    return err
+}
+
+// CreateProduct adds a new product, failing with ErrProductExists rather than
+// overwriting a product that is already tracked. It returns the product as
+// stored, with defaults filled in.
+func (pt *PriceTracker) CreateProduct(product Product) (Product, error) {
+    product.ID = strings.TrimSpace(product.ID)
+    product.Name = strings.TrimSpace(product.Name)
+    product.URL = strings.TrimSpace(product.URL)
+
+    if product.ID == "" || product.Name == "" || product.URL == "" {
+        return Product{}, errors.New("id, name and url are required")
+    }
+    if err := validateProductURL(product.URL); err != nil {
+        return Product{}, err
+    }
+
+    return pt.addProduct(product, false)
+}
+
+// UpdateProduct replaces a tracked product's settings, failing with
+// ErrProductNotFound if it isn't tracked. Its price history is kept, and
+// the next check uses the new settings.
+func (pt *PriceTracker) UpdateProduct(product Product) (Product, error) {
+    product.Name = strings.TrimSpace(product.Name)
+    product.URL = strings.TrimSpace(product.URL)
+
+    if product.Name == "" || product.URL == "" {
+        return Product{}, errors.New("name and url are required")
+    }
+    if err := validateProductURL(product.URL); err != nil {
+        return Product{}, err
+    }
+    current, err := pt.GetProduct(product.ID)
+    if err != nil {
+        return Product{}, err
+    }
+    product.Tenant = current.Tenant
+
+    return pt.addProduct(product, true)
+}
+
+func (pt *PriceTracker) addProduct(product Product, replace bool) (Product, error) {
+    if product.Priority == "" {
+        product.Priority = PriorityNormal
+    }
+    product.Currency = strings.ToUpper(strings.TrimSpace(product.Currency))
+    if product.Currency == "" {
+        product.Currency = DefaultCurrency
+    }
+    if !isCurrencyCode(product.Currency) {
+        return Product{}, fmt.Errorf("invalid currency %q: must be a three-letter ISO 4217 code", product.Currency)
+    }
+    if _, ok := priorityRank[product.Priority]; !ok {
+        return Product{}, fmt.Errorf("invalid priority %q: must be high, normal or low", product.Priority)
+    }
+    if product.IntervalSeconds < 0 {
+        return Product{}, fmt.Errorf("invalid interval %ds: must not be negative", product.IntervalSeconds)
+    }
+    if product.TargetPrice != nil && *product.TargetPrice <= 0 {
+        return Product{}, fmt.Errorf("invalid target price %v: must be positive", *product.TargetPrice)
+    }
+    tags, err := normalizeTags(product.Tags)
+    if err != nil {
+        return Product{}, err
+    }
+    product.Tags = tags
+    product.Notes = strings.TrimSpace(product.Notes)
+    if err := validatePriceRule(product); err != nil {
+        return Product{}, err
+    }
+    if product.FetchProfile != nil {
+        if err := product.FetchProfile.validate(); err != nil {
+            return Product{}, fmt.Errorf("invalid fetch profile: %w", err)
+        }
+    }
+    if product.RenderJS && pageRenderer == nil {
+        return Product{}, errNoRenderer
+    }
+    if product.Fetcher != "" {
+        if _, err := namedFetcher(product.Fetcher); err != nil {
+            return Product{}, err
+        }
+    }
+
     pt.mu.Lock()
     defer pt.mu.Unlock()
 
+    if !replace {
+        exists, err := pt.db.ProductExists(product.ID)
+        if err != nil {
+            return Product{}, err
+        }
+        if exists {
+            return Product{}, fmt.Errorf("%w: %s", ErrProductExists, product.ID)
+        }
+    }
+
     // save to database
     if err := pt.db.InsertProduct(product); err != nil {
-        return err
+        return Product{}, err
     }
 
     // add to in-memory map
+    if current, ok := pt.products[product.ID]; !ok || current.URL != product.URL {
+        pt.forgetListing(product.ID)
+    }
     pt.products[product.ID] = product
+    pt.resetTargetAlert(product.ID)
+    pt.forgetRobotsBlock(product.ID)
+    pt.notifySchedule()
     log.Printf("Added product: %s (%s)", product.Name, product.ID)
 
+    return product, nil
+}
+
+// validateProductURL checks that a product URL is an absolute http(s) URL
+func validateProductURL(rawURL string) error {
+    parsed, err := url.Parse(rawURL)
+    if err != nil {
+        return fmt.Errorf("invalid url: %w", err)
+    }
+    if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+        return fmt.Errorf("invalid url %q: must be an absolute http or https URL", rawURL)
+    }
     return nil
 }
 
-func (pt *PriceTracker) GetProducts() []ProductWithLatestPrice {
-    products, err := pt.db.GetProductsWithLatestPrices()
+// DeleteProduct stops tracking a product. Its price history is archived, or
+// removed for good when purge is set.
+func (pt *PriceTracker) DeleteProduct(productID string, purge bool) error {
+    pt.mu.Lock()
+    defer pt.mu.Unlock()
+
+    found, err := pt.db.DeleteProduct(productID, purge)
     if err != nil {
-        log.Printf("Failed to get products with prices: %v", err)
-        return []ProductWithLatestPrice{}
+        return err
     }
-    return products
+    if _, tracked := pt.products[productID]; !found && !tracked {
+        return fmt.Errorf("%w: %s", ErrProductNotFound, productID)
+    }
+
+    delete(pt.products, productID)
+    pt.resetTargetAlert(productID)
+    pt.forgetListing(productID)
+    pt.metrics.fetchFailures.DeleteLabelValues(productID)
+    pt.metrics.fetchBlocks.DeleteLabelValues(productID)
+    pt.forgetRobotsBlock(productID)
+    pt.forgetBlocks(productID)
+    conditional.forget(productID)
+    if purge {
+        log.Printf("Deleted product %s and its price history", productID)
+    } else {
+        log.Printf("Deleted product %s, archiving its price history", productID)
+    }
+
+    return nil
 }
 
-func (pt *PriceTracker) GetPriceHistory(productID string, limit int) ([]PriceEntry, error) {
+// GetArchivedHistory returns the price history archived when one of a
+// tenant's products was deleted, newest first. It is empty for products that
+// were never deleted, or were purged.
+func (pt *PriceTracker) GetArchivedHistory(productID, tenant string, limit int) ([]ArchivedPriceEntry, error) {
+    return pt.db.GetArchivedPriceEntries(productID, tenant, limit)
+}
+
+func (pt *PriceTracker) GetProduct(productID string) (Product, error) {
+    pt.mu.RLock()
+    defer pt.mu.RUnlock()
+
+    product, ok := pt.products[productID]
+    if !ok {
+        return Product{}, fmt.Errorf("%w: %s", ErrProductNotFound, productID)
+    }
+    return product, nil
+}
+
+// GetProducts returns one page of the products matching a filter with their
+// latest prices, and the total number that match
+func (pt *PriceTracker) GetProducts(filter ProductFilter, limit, offset int) (ProductPage, error) {
+    products, err := pt.db.GetProductsWithLatestPrices(filter, limit, offset)
+    if err != nil {
+        return ProductPage{}, err
+    }
+    total, err := pt.db.CountProducts(filter)
+    if err != nil {
+        return ProductPage{}, err
+    }
+
+    for i := range products {
+        if products[i].LatestPrice == nil {
+            continue
+        }
+        median, err := pt.medianPrice(products[i].ID)
+        if err != nil {
+            log.Printf("Failed to compute median price for %s: %v", products[i].ID, err)
+            continue
+        }
+        products[i].MedianPrice = median
+    }
+
+    return ProductPage{Items: products, Total: total, Limit: limit, Offset: offset}, nil
+}
+
+const (
+    // defaultMedianSamples and defaultMedianWindow make up the de-noised
+    // price when no sampling is configured: the median of the last 5
+    // fetches within the last hour
+    defaultMedianSamples = 5
+    defaultMedianWindow  = time.Hour
+)
+
+// SetMedianSampling configures the de-noised price: the median of the last
+// samples fetches within window. Zero values keep the defaults.
+func (pt *PriceTracker) SetMedianSampling(samples int, window time.Duration) {
+    pt.statusMu.Lock()
+    defer pt.statusMu.Unlock()
+
+    pt.medianSamples = samples
+    pt.medianWindow = window
+}
+
+// medianPrice returns the median of the product's recent fetches, smoothing
+// over one-off blips from A/B pricing or personalization. It returns nil when
+// there were no fetches in the window.
+func (pt *PriceTracker) medianPrice(productID string) (*float64, error) {
+    pt.statusMu.Lock()
+    samples, window := pt.medianSamples, pt.medianWindow
+    pt.statusMu.Unlock()
+
+    if samples <= 0 {
+        samples = defaultMedianSamples
+    }
+    if window <= 0 {
+        window = defaultMedianWindow
+    }
+
+    now := time.Now()
+    entries, err := pt.db.GetPriceHistoryRange(productID, now.Add(-window), now, samples, false)
+    if err != nil {
+        return nil, err
+    }
+    if len(entries) == 0 {
+        return nil, nil
+    }
+
+    prices := make([]float64, len(entries))
+    for i, entry := range entries {
+        prices[i] = entry.Price
+    }
+    median := medianOf(prices)
+    return &median, nil
+}
+
+// medianOf returns the median of values, sorting them in place
+func medianOf(values []float64) float64 {
+    sort.Float64s(values)
+    mid := len(values) / 2
+    if len(values)%2 == 0 {
+        return (values[mid-1] + values[mid]) / 2
+    }
+    return values[mid]
+}
+
+func (pt *PriceTracker) GetPriceHistory(productID string, limit int, includeOutliers bool) ([]PriceEntry, error) {
     // check if product exists
     exists, err := pt.db.ProductExists(productID)
     if err != nil {
         return nil, err
     }
     if !exists {
-        return nil, fmt.Errorf("product not found: %s", productID)
+        return nil, fmt.Errorf("%w: %s", ErrProductNotFound, productID)
+    }
+
+    entries, err := pt.db.GetPriceHistory(productID, limit, includeOutliers)
+    if err != nil {
+        return nil, err
+    }
+
+    return entries, pt.markSales(productID, entries)
+}
+
+// GetPriceHistoryBuckets returns a product's prices between from and to
+// grouped by interval; see Database.GetPriceHistoryBuckets
+func (pt *PriceTracker) GetPriceHistoryBuckets(productID, interval string, from, to time.Time, limit int, includeOutliers bool) ([]HistoryBucket, error) {
+    exists, err := pt.db.ProductExists(productID)
+    if err != nil {
+        return nil, err
+    }
+    if !exists {
+        return nil, fmt.Errorf("%w: %s", ErrProductNotFound, productID)
+    }
+    return pt.db.GetPriceHistoryBuckets(productID, interval, from, to, limit, includeOutliers)
+}
+
+// GetPriceHistoryRange returns a product's history between from and to,
+// inclusive, newest first
+func (pt *PriceTracker) GetPriceHistoryRange(productID string, from, to time.Time, limit int, includeOutliers bool) ([]PriceEntry, error) {
+    exists, err := pt.db.ProductExists(productID)
+    if err != nil {
+        return nil, err
+    }
+    if !exists {
+        return nil, fmt.Errorf("%w: %s", ErrProductNotFound, productID)
+    }
+
+    entries, err := pt.db.GetPriceHistoryRange(productID, from, to, limit, includeOutliers)
+    if err != nil {
+        return nil, err
+    }
+
+    return entries, pt.markSales(productID, entries)
+}
+
+// exportPageSize is how many entries ExportPriceHistory reads per query
+const exportPageSize = 500
+
+// ExportPriceHistory calls write for each of a product's entries between
+// from and to, oldest recorded first, reading them a page at a time. An
+// empty productID exports every product's entries. It stops at the first
+// error from write.
+func (pt *PriceTracker) ExportPriceHistory(productID string, from, to time.Time, includeOutliers bool, write func(PriceEntry) error) error {
+    afterID := 0
+    for {
+        page, err := pt.db.GetPriceEntriesAfter(productID, from, to, afterID, exportPageSize, includeOutliers)
+        if err != nil {
+            return err
+        }
+
+        for _, entry := range page {
+            if err := write(entry); err != nil {
+                return err
+            }
+        }
+
+        if len(page) < exportPageSize {
+            return nil
+        }
+        afterID = page[len(page)-1].ID
+    }
+}
+
+// markSales flags the entries recorded during one of the product's sale windows
+func (pt *PriceTracker) markSales(productID string, entries []PriceEntry) error {
+    windows, err := pt.db.GetSaleWindows(productID)
+    if err != nil {
+        return err
+    }
+    markSaleEntries(entries, windows)
+    return nil
+}
+
+// QueryTimings returns database latency statistics per query type
+func (pt *PriceTracker) QueryTimings() map[string]QueryStats {
+    return pt.db.QueryTimings()
+}
+
+// SetOutlier manually flags or unflags a price entry of one of a tenant's
+// products as an outlier. Outliers are kept for auditing but left out of
+// listings, history and analytics.
+func (pt *PriceTracker) SetOutlier(entryID int, tenant string, outlier bool) error {
+    found, err := pt.db.SetPriceEntryOutlier(entryID, tenant, outlier)
+    if err != nil {
+        return err
+    }
+    if !found {
+        return fmt.Errorf("%w: %d", ErrEntryNotFound, entryID)
+    }
+
+    log.Printf("Set outlier=%v on price entry %d", outlier, entryID)
+    return nil
+}
+
+// minBestDealEntries is how much history a product needs before it is ranked
+// in the best deals listing
+const minBestDealEntries = 5
+
+func (pt *PriceTracker) GetBestDeal(productID string) (*BestDeal, error) {
+    exists, err := pt.db.ProductExists(productID)
+    if err != nil {
+        return nil, err
+    }
+    if !exists {
+        return nil, fmt.Errorf("%w: %s", ErrProductNotFound, productID)
+    }
+
+    deals, err := pt.db.GetBestDeals(productID)
+    if err != nil {
+        return nil, err
+    }
+    if len(deals) == 0 {
+        return nil, fmt.Errorf("no price history for product: %s", productID)
+    }
+
+    return &deals[0], nil
+}
+
+// GetPriceExtremes returns a product's all-time lowest and highest prices
+// and its current one
+func (pt *PriceTracker) GetPriceExtremes(productID string) (PriceExtremes, error) {
+    product, err := pt.GetProduct(productID)
+    if err != nil {
+        return PriceExtremes{}, err
+    }
+
+    extremes, err := pt.db.GetPriceExtremes(productID)
+    if err != nil {
+        return PriceExtremes{}, err
+    }
+    extremes.Currency = product.Currency
+    return extremes, nil
+}
+
+// GetPriceDrops ranks a tenant's products by how far their price fell over
+// the window ending now, largest percentage drop first
+func (pt *PriceTracker) GetPriceDrops(tenant string, window time.Duration, limit int) ([]PriceDrop, error) {
+    if window <= 0 {
+        return nil, errors.New("window must be positive")
+    }
+    return pt.db.GetPriceDrops(tenant, time.Now().Add(-window), limit)
+}
+
+// GetBestDeals ranks a tenant's products by how close their current price is
+// to the lowest price ever recorded, skipping products with too little
+// history
+func (pt *PriceTracker) GetBestDeals(tenant string) ([]BestDeal, error) {
+    deals, err := pt.db.GetBestDeals("")
+    if err != nil {
+        return nil, err
+    }
+
+    ranked := make([]BestDeal, 0, len(deals))
+    for _, deal := range deals {
+        if deal.EntryCount >= minBestDealEntries && pt.ownsProduct(tenant, deal.ProductID) {
+            ranked = append(ranked, deal)
+        }
     }
 
-    return pt.db.GetPriceHistory(productID, limit)
+    sort.SliceStable(ranked, func(i, j int) bool {
+        return ranked[i].AboveLowPercent < ranked[j].AboveLowPercent
+    })
+
+    return ranked, nil
 }
 
+// StartTracking runs the tracking loop until ctx is cancelled. A watchdog
+// restarts the loop if no cycle completes within the watchdog threshold.
+// After cancellation it returns only once every loop it started has exited,
+// so an in-flight cycle has finished storing the prices it already fetched.
 func (pt *PriceTracker) StartTracking(ctx context.Context, interval time.Duration) {
-    ticker := time.NewTicker(interval)
-    defer ticker.Stop()
+    threshold := pt.watchdogThresholdFor(interval)
+
+    log.Printf("Starting price tracking with default interval: %v", interval)
 
-    log.Printf("Starting price tracking with interval: %v", interval)
+    var loops sync.WaitGroup
+    startLoop := func() context.CancelFunc {
+        loopCtx, stopLoop := context.WithCancel(ctx)
+        pt.markCycle()
+        loops.Add(1)
+        go func() {
+            defer loops.Done()
+            pt.trackingLoop(loopCtx, interval)
+        }()
+        return stopLoop
+    }
+    stopLoop := startLoop()
+
+    check := time.NewTicker(interval)
+    defer check.Stop()
 
     for {
         select {
         case <-ctx.Done():
+            stopLoop()
+            loops.Wait()
             log.Println("Price tracking stopped")
             return
-        case <-ticker.C:
-            pt.trackAllProducts()
+        case <-check.C:
+            stalled := time.Since(pt.lastCycleTime())
+            if stalled <= threshold {
+                continue
+            }
+
+            // abandon the stuck loop and start a fresh one
+            log.Printf("ERROR: no tracking cycle completed in %v, restarting tracking loop", stalled.Round(time.Second))
+            stopLoop()
+            pt.recordRestart(stalled)
+            stopLoop = startLoop()
         }
     }
 }
 
-func (pt *PriceTracker) trackAllProducts() {
+// trackingLoop checks each product on its own schedule (see
+// nextCheckDelay), waking early when products are added so new ones are
+// fetched straight away. Products deleted while it runs are simply forgotten.
+func (pt *PriceTracker) trackingLoop(ctx context.Context, interval time.Duration) {
+    // products tracked at startup wait one interval, as they always have
+    lastChecked := make(map[string]time.Time)
+    start := time.Now()
+    for _, product := range pt.snapshotProducts() {
+        lastChecked[product.ID] = start
+    }
+
+    timer := time.NewTimer(pt.nextCheckDelay(lastChecked, interval))
+    defer timer.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-pt.scheduleChanged:
+            timer.Stop()
+        case <-timer.C:
+        }
+        // select picks at random when a wake-up races cancellation
+        if ctx.Err() != nil {
+            return
+        }
+
+        // an empty cycle still counts as one, so products with long
+        // intervals don't trip the watchdog
+        pt.runCycle(ctx, pt.dueProducts(lastChecked, interval))
+        timer.Reset(pt.nextCheckDelay(lastChecked, interval))
+    }
+}
+
+// runCycle runs a single tracking cycle over the given products (all of them
+// when only is nil), recovering from panics so one bad cycle doesn't kill the
+// loop. Only cycles that finish without being cancelled count as successful.
+func (pt *PriceTracker) runCycle(ctx context.Context, only map[string]bool) {
+    defer func() {
+        if r := recover(); r != nil {
+            log.Printf("Recovered from panic in tracking cycle: %v", r)
+            pt.recordWatchdogEvent(fmt.Sprintf("tracking cycle panicked: %v", r))
+        }
+    }()
+
+    if only == nil {
+        pt.trackAllProducts(ctx)
+    } else {
+        var products []Product
+        for _, product := range pt.snapshotProducts() {
+            if only[product.ID] {
+                products = append(products, product)
+            }
+        }
+        pt.trackProducts(ctx, products, nil)
+    }
+    if ctx.Err() == nil {
+        pt.markCycle()
+    }
+}
+
+func (pt *PriceTracker) trackAllProducts(ctx context.Context) {
+    pt.trackProducts(ctx, pt.snapshotProducts(), nil)
+}
+
+// snapshotProducts copies the tracked products so a cycle can run without
+// holding the lock
+func (pt *PriceTracker) snapshotProducts() []Product {
     pt.mu.RLock()
+    defer pt.mu.RUnlock()
+
     products := make([]Product, 0, len(pt.products))
     for _, product := range pt.products {
         products = append(products, product)
     }
-    pt.mu.RUnlock()
+    return products
+}
+
+const (
+    // defaultNumWorkers is the worker pool size when none is configured
+    defaultNumWorkers = 5
+    // defaultFetchTimeout caps a single product fetch when no timeout is
+    // configured
+    defaultFetchTimeout = 20 * time.Second
+    // defaultDuplicateEpsilon is how far a price must move from the last
+    // stored one to be stored again when no epsilon is configured
+    defaultDuplicateEpsilon = 0.01
+)
+
+// SetNumWorkers sets how many products are fetched concurrently per cycle
+func (pt *PriceTracker) SetNumWorkers(n int) {
+    pt.statusMu.Lock()
+    defer pt.statusMu.Unlock()
+
+    pt.numWorkers = n
+}
+
+// SetDuplicateEpsilon sets how far a fetched price must move from the last
+// stored price before it is stored again. Unchanged prices are still counted
+// as successful checks; they just don't add rows. A product's first price is
+// always stored.
+func (pt *PriceTracker) SetDuplicateEpsilon(epsilon float64) {
+    pt.statusMu.Lock()
+    defer pt.statusMu.Unlock()
+
+    pt.duplicateEpsilon = epsilon
+}
+
+// SetFetchTimeout caps how long fetching a single product's price may take
+// before it is abandoned
+func (pt *PriceTracker) SetFetchTimeout(timeout time.Duration) {
+    pt.statusMu.Lock()
+    defer pt.statusMu.Unlock()
+
+    pt.fetchTimeout = timeout
+}
+
+func (pt *PriceTracker) fetchTimeoutOrDefault() time.Duration {
+    pt.statusMu.Lock()
+    defer pt.statusMu.Unlock()
+
+    if pt.fetchTimeout <= 0 {
+        return defaultFetchTimeout
+    }
+    return pt.fetchTimeout
+}
+
+// trackProducts fetches and stores prices for the given products. If report
+// is non-nil it is called once per product with whether the check succeeded.
+// Cancelling ctx stops dispatching products; those not yet dispatched are
+// reported as failed and in-flight fetches are abandoned.
+func (pt *PriceTracker) trackProducts(ctx context.Context, products []Product, report func(ok bool)) {
+    if report == nil {
+        report = func(bool) {}
+    }
+
+    // only one cycle runs at a time, whether scheduled or forced
+    pt.cycleMu.Lock()
+    defer pt.cycleMu.Unlock()
 
     if len(products) == 0 {
         return
     }
 
+    // dispatch higher priority tiers first so they stay fresh when workers
+    // are busy
+    sort.SliceStable(products, func(i, j int) bool {
+        ri, rj := priorityRank[products[i].Priority], priorityRank[products[j].Priority]
+        if ri != rj {
+            return ri < rj
+        }
+        return products[i].ID < products[j].ID
+    })
+
     log.Printf("Tracking prices for %d products", len(products))
 
+    // one query for every product's last stored price, used to skip
+    // unchanged prices below
+    pt.statusMu.Lock()
+    epsilon := pt.duplicateEpsilon
+    pt.statusMu.Unlock()
+    latest, err := pt.db.GetLatestPrices()
+    if err != nil {
+        log.Printf("Failed to load latest prices, storing every price this cycle: %v", err)
+    }
+
     // use worker pool pattern with goroutines
-    const numWorkers = 5
-    productChan := make(chan Product, len(products))
+    pt.statusMu.Lock()
+    numWorkers := pt.numWorkers
+    pt.statusMu.Unlock()
+    if numWorkers <= 0 {
+        numWorkers = defaultNumWorkers
+    }
+    productChan := make(chan Product)
     resultChan := make(chan PriceEntry, len(products))
 
     // start workers
+    fetchTimeout := pt.fetchTimeoutOrDefault()
     var wg sync.WaitGroup
     for i := 0; i < numWorkers; i++ {
         wg.Add(1)
-        go pt.priceWorker(&wg, productChan, resultChan)
+        go pt.priceWorker(ctx, fetchTimeout, &wg, productChan, resultChan, report)
     }
 
-    // send products to workers
+    // send products to workers, holding back those whose host already has
+    // its share of fetches in flight and stopping early if the cycle is
+    // cancelled. productChan is unbuffered so a product counts as dispatched
+    // only once a worker has picked it up.
     go func() {
-        for _, product := range products {
-            productChan <- product
+        defer close(productChan)
+        if skipped := pt.hostSlots.dispatch(ctx, products, productChan); len(skipped) > 0 {
+            log.Printf("Tracking cycle cancelled, skipping %d remaining products", len(skipped))
+            for range skipped {
+                report(false)
+            }
         }
-        close(productChan)
     }()
 
     // wait for workers to finish
@@ -143,51 +853,173 @@ func (pt *PriceTracker) trackAllProducts() {
 
     // collect results and save to database
     for entry := range resultChan {
-        if err := pt.db.InsertPriceEntry(entry.ProductID, entry.Price, entry.Timestamp); err != nil {
+        var last *PriceEntry
+        if l, ok := latest[entry.ProductID]; ok {
+            last = &l
+        }
+        if _, err := pt.storePrice(&entry, last, epsilon); err != nil {
             log.Printf("Failed to save price entry for %s: %v", entry.ProductID, err)
-        } else {
-            log.Printf("Saved price for %s: $%.2f", entry.ProductID, entry.Price)
+            report(false)
+            continue
         }
+        report(true)
     }
 }
 
-func (pt *PriceTracker) priceWorker(wg *sync.WaitGroup, productChan <-chan Product, resultChan chan<- PriceEntry) {
+// storePrice saves a fetched price and runs the product's price alerts. A
+// price that hasn't moved from last, the latest stored entry (nil if there
+// is none), isn't stored unless the product came into or went out of stock
+// or its shipping cost changed, or its page answered 304 Not Modified, which
+// re-records the last known price with a new timestamp. It reports whether
+// the price was stored, setting entry's ID if so.
+func (pt *PriceTracker) storePrice(entry *PriceEntry, last *PriceEntry, epsilon float64) (bool, error) {
+    if last != nil && !entry.NotModified && math.Abs(entry.Price-last.Price) <= epsilon && !stockChanged(last.InStock, entry.InStock) && !shippingChanged(last.Shipping, entry.Shipping, epsilon) {
+        log.Printf("Price for %s unchanged at %s, not stored", entry.ProductID, formatPrice(entry.Price, entry.Currency))
+        if product, err := pt.GetProduct(entry.ProductID); err == nil {
+            pt.checkTargetPrice(product, entry.Price)
+        }
+        return false, nil
+    }
+
+    product, saved, err := pt.savePriceEntry(entry)
+    if err != nil {
+        return false, err
+    }
+    if !saved {
+        return false, fmt.Errorf("%w: %s was deleted while its price was fetched", ErrProductNotFound, entry.ProductID)
+    }
+    log.Printf("Saved price for %s: %s", entry.ProductID, formatPrice(entry.Price, entry.Currency))
+    pt.publish(*entry)
+    pt.checkTargetPrice(product, entry.Price)
+    return true, nil
+}
+
+// savePriceEntry stores a fetched price and sets its ID, unless the product
+// was deleted while it was being fetched. It returns the current product. The
+// read lock is held across the check and the insert so a concurrent
+// DeleteProduct can't leave an orphaned entry behind.
+func (pt *PriceTracker) savePriceEntry(entry *PriceEntry) (Product, bool, error) {
+    pt.mu.RLock()
+    defer pt.mu.RUnlock()
+
+    product, ok := pt.products[entry.ProductID]
+    if !ok {
+        return Product{}, false, nil
+    }
+
+    id, err := pt.db.InsertPriceEntry(*entry)
+    if err != nil {
+        return product, true, err
+    }
+    entry.ID = id
+    return product, true, nil
+}
+
+// shippingChanged reports whether a fetch's shipping cost differs from the
+// last stored one by more than epsilon. A page that stops showing shipping
+// isn't a change.
+func shippingChanged(last, current *float64, epsilon float64) bool {
+    if current == nil {
+        return false
+    }
+    return last == nil || math.Abs(*last-*current) > epsilon
+}
+
+// stockChanged reports whether a fetch's availability differs from the
+// last stored one. A page that stops reporting availability isn't a change.
+func stockChanged(last, current *bool) bool {
+    if current == nil {
+        return false
+    }
+    return last == nil || *last != *current
+}
+
+func (pt *PriceTracker) priceWorker(ctx context.Context, fetchTimeout time.Duration, wg *sync.WaitGroup, productChan <-chan Product, resultChan chan<- PriceEntry, report func(ok bool)) {
     defer wg.Done()
 
     for product := range productChan {
-        price := pt.fetchPrice(product)
-        if price > 0 {
-            entry := PriceEntry{
-                ProductID: product.ID,
-                Price:     price,
-                Timestamp: time.Now(),
-            }
-            resultChan <- entry
+        reading, attempts, err := pt.fetchReadingWithRetry(ctx, product, fetchTimeout)
+        pt.hostSlots.release(product)
+
+        if errors.Is(err, context.DeadlineExceeded) {
+            log.Printf("Abandoned price fetch for %s after %d attempts: no response within %v", product.ID, attempts, fetchTimeout)
+            pt.recordScrapeError(ctx, product, attempts, err)
+            report(false)
+        } else if err != nil {
+            log.Printf("Failed to fetch price for %s after %d attempts: %v", product.ID, attempts, err)
+            pt.recordScrapeError(ctx, product, attempts, err)
+            report(false)
+        } else {
+            pt.checkListing(product, reading.Title)
+            pt.checkFingerprint(product, reading.Fingerprint)
+            resultChan <- newPriceEntry(product, reading)
         }
     }
 }
 
-// fetchPrice simulates fetching price from a URL
-// in a real implementation, this would make HTTP requests to scrape or call APIs
-func (pt *PriceTracker) fetchPrice(product Product) float64 {
-    // simulate network delay
-    time.Sleep(time.Duration(rand.Intn(1000)) * time.Millisecond)
+// newPriceEntry makes the entry to store for a price fetched now
+func newPriceEntry(product Product, reading PriceReading) PriceEntry {
+    return PriceEntry{
+        ProductID:   product.ID,
+        Price:       reading.Price,
+        Currency:    product.Currency,
+        InStock:     reading.InStock,
+        ListPrice:   reading.ListPrice,
+        ListingType: reading.ListingType,
+        Shipping:    reading.Shipping,
+        TotalPrice:  totalPrice(reading.Price, reading.Shipping),
+        Timestamp:   time.Now().UTC(),
+        NotModified: reading.NotModified,
+    }
+}
+
+// recordScrapeError stores a failed fetch so it can be looked at later,
+// with a snapshot of the page if it was fetched but had no price, unless the
+// cycle was cancelled
+func (pt *PriceTracker) recordScrapeError(ctx context.Context, product Product, attempts int, err error) {
+    if ctx.Err() != nil {
+        return
+    }
+    scrapeErr := ScrapeError{
+        ProductID:  product.ID,
+        Error:      err.Error(),
+        Attempts:   attempts,
+        OccurredAt: time.Now().UTC(),
+    }
+    if err := pt.db.InsertScrapeError(scrapeErr); err != nil {
+        log.Printf("Failed to record scrape error for %s: %v", product.ID, err)
+    }
+
+    var extractErr extractionError
+    if errors.As(err, &extractErr) {
+        pt.saveSnapshot(product, extractErr)
+    }
+}
 
-    // simulate price fetching with random prices
-    // in reality, you'd parse HTML or call an API
-    basePrice := 100.0
-    switch product.ID {
-    case "laptop-1":
-        basePrice = 1200.0
-    case "phone-1":
-        basePrice = 800.0
-    case "tablet-1":
-        basePrice = 500.0
+// GetScrapeErrors returns a product's most recent failed fetches, newest
+// first
+func (pt *PriceTracker) GetScrapeErrors(productID string, limit int) ([]ScrapeError, error) {
+    if _, err := pt.GetProduct(productID); err != nil {
+        return nil, err
     }
+    return pt.db.GetScrapeErrors(productID, limit)
+}
 
-    // add some random variation (±10%)
-    variation := (rand.Float64() - 0.5) * 0.2
-    price := basePrice * (1 + variation)
+// fetchReading gets a product's current price, and its availability if the
+// fetcher reports it, from the tracker's fetcher, recording the attempt in
+// the fetch metrics. It gives up when ctx is done.
+func (pt *PriceTracker) fetchReading(ctx context.Context, product Product) (reading PriceReading, err error) {
+    defer func(start time.Time) {
+        pt.metrics.observeFetch(product.ID, start, err)
+        pt.noteRobots(product, err)
+        pt.noteBlock(product, err)
+    }(time.Now())
 
-    return price
+    fetcher := pt.fetcher
+    if product.Fetcher != "" {
+        if fetcher, err = namedFetcher(product.Fetcher); err != nil {
+            return reading, err
+        }
+    }
+    return readWith(ctx, fetcher, product)
 }
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultUserAgentStickiness is how long a domain keeps its User-Agent when
+// no stickiness is configured
+const defaultUserAgentStickiness = 30 * time.Minute
+
+// userAgentPool rotates through a list of User-Agent strings. Each domain
+// keeps the one it was given for the stickiness period, so a retailer sees a
+// consistent browser for a while rather than a new one on every request.
+type userAgentPool struct {
+    mu         sync.Mutex
+    agents     []string
+    stickiness time.Duration
+    next       int
+    assigned   map[string]stickyAgent
+}
+
+type stickyAgent struct {
+    agent string
+    until time.Time
+}
+
+// userAgents is the pool page fetches draw from; nil sends scraperUserAgent
+var userAgents *userAgentPool
+
+// SetUserAgents makes page fetches rotate through agents, keeping each
+// domain on the same one for stickiness. An empty list restores the default
+// User-Agent.
+func SetUserAgents(agents []string, stickiness time.Duration) {
+    if len(agents) == 0 {
+        userAgents = nil
+        return
+    }
+    userAgents = &userAgentPool{
+        agents:     agents,
+        stickiness: stickiness,
+        assigned:   make(map[string]stickyAgent),
+    }
+}
+
+// pick returns the User-Agent to send to host, moving it to the next agent
+// in the pool once its current one has expired
+func (p *userAgentPool) pick(host string) string {
+    if p == nil {
+        return scraperUserAgent
+    }
+
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    domain := strings.TrimPrefix(strings.ToLower(host), "www.")
+    now := time.Now()
+    if sticky, ok := p.assigned[domain]; ok && now.Before(sticky.until) {
+        return sticky.agent
+    }
+
+    agent := p.agents[p.next]
+    p.next = (p.next + 1) % len(p.agents)
+    p.assigned[domain] = stickyAgent{agent: agent, until: now.Add(p.stickiness)}
+    return agent
+}
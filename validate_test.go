@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForJob polls a job until it is done
+func waitForJob(t *testing.T, server *APIServer, id string) Job {
+    t.Helper()
+    var job Job
+    deadline := time.Now().Add(5 * time.Second)
+    for !job.Done && time.Now().Before(deadline) {
+        serve(t, server, "GET", "/api/v1/jobs/"+id, "", &job)
+        if !job.Done {
+            time.Sleep(10 * time.Millisecond)
+        }
+    }
+    if !job.Done {
+        t.Fatalf("job never finished: %+v", job)
+    }
+    return job
+}
+
+func TestValidateAllReport(t *testing.T) {
+    pages := newPageServer(t, productPage)
+    tracker := newTestTracker(t, nil)
+    tracker.SetRetryPolicy(1, 0)
+    server := NewAPIServer(tracker)
+
+    for _, product := range []Product{
+        {ID: "validate-price", PriceSelector: ".price"},
+        {ID: "validate-offer", PriceSelector: ".offers li:nth-child(2) b"},
+        {ID: "validate-missing", PriceSelector: ".sale-price"},
+    } {
+        product.Name = product.ID
+        product.URL = pages.URL + "/" + product.ID
+        if _, err := tracker.CreateProduct(product); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    var started Job
+    if rec := serve(t, server, "POST", "/api/v1/validate-all", "", &started); rec.Code != http.StatusAccepted {
+        t.Fatalf("validate-all = %d: %s", rec.Code, rec.Body)
+    }
+    if started.Type != "validate-all" || started.Total != 3 {
+        t.Fatalf("started job = %+v", started)
+    }
+
+    job := waitForJob(t, server, started.ID)
+    if job.Checked != 3 || job.Succeeded != 2 || job.Failed != 1 || len(job.Results) != 3 {
+        t.Fatalf("report = %+v, want 2 of 3 succeeded", job)
+    }
+    results := map[string]ValidationResult{}
+    for _, result := range job.Results {
+        results[result.ProductID] = result
+    }
+    for id, want := range map[string]float64{"validate-price": 1299.99, "validate-offer": 849.50} {
+        if got := results[id]; !got.OK || got.Price != want || got.Error != "" || got.URL != pages.URL+"/"+id {
+            t.Errorf("%s = %+v, want %v", id, got, want)
+        }
+    }
+    if got := results["validate-missing"]; got.OK || got.Price != 0 || !strings.Contains(got.Error, ".sale-price") {
+        t.Errorf("validate-missing = %+v, want it failed naming the selector", got)
+    }
+
+    // a dry run stores nothing
+    for id := range results {
+        history, err := tracker.GetPriceHistory(id, 10, true)
+        if err != nil || len(history) != 0 {
+            t.Errorf("%s history = %v, %v; want nothing stored", id, history, err)
+        }
+        errs, err := tracker.GetScrapeErrors(id, 10)
+        if err != nil || len(errs) != 0 {
+            t.Errorf("%s scrape errors = %v, %v; want none recorded", id, errs, err)
+        }
+    }
+}
+
+func TestValidateAllNeedsKey(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    server.SetAPIKey("secret")
+
+    if rec := serve(t, server, "POST", "/api/v1/validate-all", "", nil); rec.Code != http.StatusUnauthorized {
+        t.Errorf("validate-all without a key = %d, want 401", rec.Code)
+    }
+    var job Job
+    if rec := serveWithKey(t, server, "secret", "POST", "/api/v1/validate-all", "", &job); rec.Code != http.StatusAccepted || job.Total != 0 {
+        t.Errorf("validate-all with the key = %d %+v", rec.Code, job)
+    }
+    if job := waitForJob(t, server, job.ID); job.Checked != 0 || len(job.Results) != 0 {
+        t.Errorf("report for an empty catalog = %+v", job)
+    }
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// minVelocityEntries is how many prices a product needs inside the window
+// before its velocity is ranked
+const minVelocityEntries = 3
+
+// GetVelocity ranks a tenant's products by how fast their price moved over the window,
+// using a least-squares slope through the window's prices. Ranking is by
+// percent per hour (or dollars per hour when byDollars is set), fastest
+// drops first unless rising is set.
+func (pt *PriceTracker) GetVelocity(tenant string, window time.Duration, rising, byDollars bool) ([]PriceVelocity, error) {
+    if window <= 0 {
+        return nil, errors.New("window must be positive")
+    }
+
+    to := time.Now()
+    from := to.Add(-window)
+
+    var velocities []PriceVelocity
+    for _, product := range pt.tenantProducts(tenant) {
+        history, err := pt.db.GetPriceHistoryRange(product.ID, from, to, 0, false)
+        if err != nil {
+            return nil, err
+        }
+        if len(history) < minVelocityEntries {
+            continue
+        }
+
+        slope, mean := priceSlope(history)
+        velocity := PriceVelocity{
+            ProductID:      product.ID,
+            Name:           product.Name,
+            CurrentPrice:   history[0].Price,
+            DollarsPerHour: slope,
+            EntryCount:     len(history),
+        }
+        if mean != 0 {
+            velocity.PercentPerHour = slope / mean * 100
+        }
+        velocities = append(velocities, velocity)
+    }
+
+    rate := func(v PriceVelocity) float64 {
+        if byDollars {
+            return v.DollarsPerHour
+        }
+        return v.PercentPerHour
+    }
+    sort.SliceStable(velocities, func(i, j int) bool {
+        if rising {
+            return rate(velocities[i]) > rate(velocities[j])
+        }
+        return rate(velocities[i]) < rate(velocities[j])
+    })
+
+    return velocities, nil
+}
+
+// priceSlope fits a least-squares line through the entries and returns its
+// slope in dollars per hour along with the mean price
+func priceSlope(entries []PriceEntry) (slope, mean float64) {
+    origin := entries[len(entries)-1].Timestamp
+
+    var sumX, sumY float64
+    for _, entry := range entries {
+        sumX += entry.Timestamp.Sub(origin).Hours()
+        sumY += entry.Price
+    }
+    n := float64(len(entries))
+    meanX, meanY := sumX/n, sumY/n
+
+    var covariance, variance float64
+    for _, entry := range entries {
+        dx := entry.Timestamp.Sub(origin).Hours() - meanX
+        covariance += dx * (entry.Price - meanY)
+        variance += dx * dx
+    }
+    if variance == 0 {
+        return 0, meanY
+    }
+
+    return covariance / variance, meanY
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestVelocityRanking(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    // hourly prices: known slopes in dollars per hour
+    addTestProduct(t, server.tracker, "velocity-flash", 100, 90, 80, 70)     // -10/h, about -11.8%/h
+    addTestProduct(t, server.tracker, "velocity-drift", 1000, 980, 960, 940) // -20/h, about -2.1%/h
+    addTestProduct(t, server.tracker, "velocity-riser", 50, 55, 60, 65)      // +5/h, about +8.7%/h
+    addTestProduct(t, server.tracker, "velocity-sparse", 10, 5)              // too few prices
+
+    type ranking struct {
+        Window   string          `json:"window"`
+        Products []PriceVelocity `json:"products"`
+        Count    int             `json:"count"`
+    }
+    rank := func(query string) ranking {
+        t.Helper()
+        var out ranking
+        if rec := serve(t, server, "GET", "/api/v1/velocity"+query, "", &out); rec.Code != http.StatusOK {
+            t.Fatalf("velocity%s = %d: %s", query, rec.Code, rec.Body)
+        }
+        return out
+    }
+    order := func(r ranking) []string {
+        var ids []string
+        for _, v := range r.Products {
+            ids = append(ids, v.ProductID)
+        }
+        return ids
+    }
+    expectOrder := func(query string, want ...string) ranking {
+        t.Helper()
+        r := rank(query)
+        ids := order(r)
+        if len(ids) != len(want) || r.Count != len(want) {
+            t.Fatalf("velocity%s = %v, want %v", query, ids, want)
+        }
+        for i := range want {
+            if ids[i] != want[i] {
+                t.Fatalf("velocity%s = %v, want %v", query, ids, want)
+            }
+        }
+        return r
+    }
+
+    r := expectOrder("", "velocity-flash", "velocity-drift", "velocity-riser")
+    if r.Window != "6h0m0s" {
+        t.Errorf("window = %q, want the 6h default", r.Window)
+    }
+    slopes := map[string]struct{ dollars, percent float64 }{
+        "velocity-flash": {-10, -10.0 / 85 * 100},
+        "velocity-drift": {-20, -20.0 / 970 * 100},
+        "velocity-riser": {5, 5.0 / 57.5 * 100},
+    }
+    for _, v := range r.Products {
+        want := slopes[v.ProductID]
+        if math.Abs(v.DollarsPerHour-want.dollars) > 1e-6 || math.Abs(v.PercentPerHour-want.percent) > 1e-6 {
+            t.Errorf("%s moves %v/h (%v%%/h), want %v/h (%v%%/h)", v.ProductID, v.DollarsPerHour, v.PercentPerHour, want.dollars, want.percent)
+        }
+        if v.EntryCount != 4 {
+            t.Errorf("%s has %d entries, want 4", v.ProductID, v.EntryCount)
+        }
+    }
+
+    expectOrder("?by=dollars", "velocity-drift", "velocity-flash", "velocity-riser")
+    expectOrder("?order=rising", "velocity-riser", "velocity-drift", "velocity-flash")
+
+    // a window holding only two prices per product ranks nothing
+    expectOrder("?window=150m")
+
+    if rec := serve(t, server, "GET", "/api/v1/velocity?window=soon", "", nil); rec.Code != http.StatusBadRequest {
+        t.Errorf("invalid window = %d, want 400", rec.Code)
+    }
+}
+
+func TestPriceSlope(t *testing.T) {
+    // newest first, two hours apart: falling 2 an hour
+    now := time.Now()
+    entries := []PriceEntry{
+        {Price: 4, Timestamp: now},
+        {Price: 8, Timestamp: now.Add(-2 * time.Hour)},
+        {Price: 12, Timestamp: now.Add(-4 * time.Hour)},
+    }
+    if slope, mean := priceSlope(entries); math.Abs(slope+2) > 1e-9 || mean != 8 {
+        t.Errorf("slope %v, mean %v; want -2 and 8", slope, mean)
+    }
+
+    // prices all taken at once have no slope
+    same := []PriceEntry{{Price: 4, Timestamp: now}, {Price: 6, Timestamp: now}}
+    if slope, mean := priceSlope(same); slope != 0 || mean != 5 {
+        t.Errorf("slope %v, mean %v; want 0 and 5", slope, mean)
+    }
+}
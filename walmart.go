@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const walmartAPIBase = "https://developer.api.walmart.com"
+
+// walmartItemPattern finds the item ID in walmart.com product paths:
+// /ip/<id> and /ip/<slug>/<id>
+var walmartItemPattern = regexp.MustCompile(`^/ip/(?:[^/]+/)?(\d{4,15})/?$`)
+
+// errNotWalmartItem is returned for products whose URL isn't a Walmart
+// product page
+var errNotWalmartItem = errors.New("not a Walmart product URL")
+
+// WalmartFetcher gets prices from the Walmart Affiliate API for products
+// whose URL is a walmart.com product page. Requests are signed with the
+// consumer's RSA key. Prices are in US dollars.
+type WalmartFetcher struct {
+    ConsumerID string
+    KeyVersion string
+    key        *rsa.PrivateKey
+
+    // baseURL replaces the API host, for pointing the fetcher at a stub
+    baseURL string
+}
+
+// NewWalmartFetcher reads the consumer's private key, PEM encoded as PKCS#8
+// or PKCS#1, from keyFile
+func NewWalmartFetcher(consumerID, keyVersion, keyFile string) (*WalmartFetcher, error) {
+    data, err := os.ReadFile(keyFile)
+    if err != nil {
+        return nil, fmt.Errorf("reading Walmart private key: %w", err)
+    }
+    block, _ := pem.Decode(data)
+    if block == nil {
+        return nil, fmt.Errorf("Walmart private key %s is not PEM encoded", keyFile)
+    }
+
+    var key *rsa.PrivateKey
+    if parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+        rsaKey, ok := parsed.(*rsa.PrivateKey)
+        if !ok {
+            return nil, fmt.Errorf("Walmart private key %s is not an RSA key", keyFile)
+        }
+        key = rsaKey
+    } else if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+        return nil, fmt.Errorf("parsing Walmart private key %s: %w", keyFile, err)
+    }
+
+    return &WalmartFetcher{ConsumerID: consumerID, KeyVersion: keyVersion, key: key}, nil
+}
+
+// walmartItemID returns the item ID of a walmart.com product URL
+func walmartItemID(rawURL string) (string, bool) {
+    parsed, err := url.Parse(rawURL)
+    if err != nil {
+        return "", false
+    }
+    if strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.") != "walmart.com" {
+        return "", false
+    }
+    match := walmartItemPattern.FindStringSubmatch(parsed.Path)
+    if match == nil {
+        return "", false
+    }
+    return match[1], true
+}
+
+func (f *WalmartFetcher) handles(product Product) bool {
+    if f == nil {
+        return false
+    }
+    _, ok := walmartItemID(product.URL)
+    return ok
+}
+
+func (f *WalmartFetcher) FetchPrice(ctx context.Context, product Product) (float64, error) {
+    reading, err := f.FetchReading(ctx, product)
+    return reading.Price, err
+}
+
+func (f *WalmartFetcher) FetchReading(ctx context.Context, product Product) (PriceReading, error) {
+    itemID, ok := walmartItemID(product.URL)
+    if !ok {
+        return PriceReading{}, fmt.Errorf("%w: %s", errNotWalmartItem, product.URL)
+    }
+    if product.Currency != "" && product.Currency != "USD" {
+        return PriceReading{}, fmt.Errorf("Walmart prices are in USD, but the product is tracked in %s", product.Currency)
+    }
+
+    header, err := f.signedHeader(time.Now())
+    if err != nil {
+        return PriceReading{}, err
+    }
+    base := walmartAPIBase
+    if f.baseURL != "" {
+        base = f.baseURL
+    }
+    var item struct {
+        SalePrice       *float64 `json:"salePrice"`
+        MSRP            float64  `json:"msrp"`
+        Stock           string   `json:"stock"`
+        AvailableOnline *bool    `json:"availableOnline"`
+    }
+    if err := getAPIJSON(ctx, base+"/api-proxy/service/affil/product/v2/items/"+itemID, header, &item); err != nil {
+        return PriceReading{}, fmt.Errorf("Walmart item %s: %w", itemID, err)
+    }
+    if item.SalePrice == nil {
+        return PriceReading{}, fmt.Errorf("Walmart item %s has no price", itemID)
+    }
+
+    reading := PriceReading{Price: *item.SalePrice, InStock: item.AvailableOnline}
+    if item.MSRP > reading.Price {
+        reading.ListPrice = &item.MSRP
+    }
+    switch item.Stock {
+    case "Available", "Limited Supply", "Last few items":
+        inStock := true
+        reading.InStock = &inStock
+    case "Not available":
+        inStock := false
+        reading.InStock = &inStock
+    }
+    return reading, nil
+}
+
+// signedHeader returns the authentication headers for a request made at
+// now: the consumer ID, timestamp and key version, signed with SHA-256 RSA
+func (f *WalmartFetcher) signedHeader(now time.Time) (http.Header, error) {
+    timestamp := strconv.FormatInt(now.UnixMilli(), 10)
+    digest := sha256.Sum256([]byte(f.ConsumerID + "\n" + timestamp + "\n" + f.KeyVersion + "\n"))
+    signature, err := rsa.SignPKCS1v15(rand.Reader, f.key, crypto.SHA256, digest[:])
+    if err != nil {
+        return nil, fmt.Errorf("signing Walmart request: %w", err)
+    }
+
+    // set directly rather than with Set, which would change the names' case
+    return http.Header{
+        "WM_CONSUMER.ID":          {f.ConsumerID},
+        "WM_CONSUMER.INTIMESTAMP": {timestamp},
+        "WM_SEC.KEY_VERSION":      {f.KeyVersion},
+        "WM_SEC.AUTH_SIGNATURE":   {base64.StdEncoding.EncodeToString(signature)},
+    }, nil
+}
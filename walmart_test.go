@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newWalmartFetcher writes a fresh RSA key to a PEM file and loads it the
+// way main does, returning the fetcher and the key's public half
+func newWalmartFetcher(t *testing.T) (*WalmartFetcher, *rsa.PublicKey) {
+    t.Helper()
+    key, err := rsa.GenerateKey(rand.Reader, 2048)
+    if err != nil {
+        t.Fatal(err)
+    }
+    der, err := x509.MarshalPKCS8PrivateKey(key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    keyFile := filepath.Join(t.TempDir(), "walmart.pem")
+    if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+        t.Fatal(err)
+    }
+
+    f, err := NewWalmartFetcher("consumer-1", "2", keyFile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return f, &key.PublicKey
+}
+
+func TestWalmartFetchReading(t *testing.T) {
+    f, public := newWalmartFetcher(t)
+    api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        // only correctly signed requests get an answer
+        signed := r.Header.Get("WM_CONSUMER.ID") + "\n" + r.Header.Get("WM_CONSUMER.INTIMESTAMP") + "\n" + r.Header.Get("WM_SEC.KEY_VERSION") + "\n"
+        digest := sha256.Sum256([]byte(signed))
+        signature, _ := base64.StdEncoding.DecodeString(r.Header.Get("WM_SEC.AUTH_SIGNATURE"))
+        if r.Header.Get("WM_CONSUMER.ID") != "consumer-1" || r.Header.Get("WM_SEC.KEY_VERSION") != "2" || rsa.VerifyPKCS1v15(public, crypto.SHA256, digest[:], signature) != nil {
+            w.WriteHeader(http.StatusUnauthorized)
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        switch strings.TrimPrefix(r.URL.Path, "/api-proxy/service/affil/product/v2/items/") {
+        case "123456789":
+            w.Write([]byte(`{"itemId": 123456789, "salePrice": 89.97, "msrp": 119.00, "stock": "Limited Supply"}`))
+        case "223456789":
+            w.Write([]byte(`{"itemId": 223456789, "salePrice": 14.88, "msrp": 0, "stock": "Not available", "availableOnline": true}`))
+        case "323456789":
+            w.Write([]byte(`{"itemId": 323456789, "salePrice": 5.00, "availableOnline": false}`))
+        default:
+            w.WriteHeader(http.StatusNotFound)
+            w.Write([]byte(`{"errors": [{"code": 4002, "message": "Invalid itemId"}]}`))
+        }
+    }))
+    t.Cleanup(api.Close)
+    f.baseURL = api.URL
+    ctx := context.Background()
+
+    tests := []struct {
+        url       string
+        price     float64
+        listPrice float64
+        inStock   bool
+    }{
+        {"https://www.walmart.com/ip/Instant-Pot-Duo/123456789", 89.97, 119, true},
+        // the stock string wins over availableOnline
+        {"https://walmart.com/ip/223456789", 14.88, 0, false},
+        {"https://www.walmart.com/ip/Mug/323456789?athbdg=L1600", 5, 0, false},
+    }
+    for _, tt := range tests {
+        reading, err := f.FetchReading(ctx, Product{URL: tt.url, Currency: "USD"})
+        if err != nil {
+            t.Fatalf("%s: %v", tt.url, err)
+        }
+        listPrice := 0.0
+        if reading.ListPrice != nil {
+            listPrice = *reading.ListPrice
+        }
+        if reading.Price != tt.price || listPrice != tt.listPrice || reading.InStock == nil || *reading.InStock != tt.inStock {
+            t.Errorf("%s: reading %+v, want %v from %v, in stock %v", tt.url, reading, tt.price, tt.listPrice, tt.inStock)
+        }
+    }
+
+    if _, err := f.FetchReading(ctx, Product{URL: tests[0].url, Currency: "GBP"}); err == nil || !strings.Contains(err.Error(), "USD") {
+        t.Errorf("GBP product = %v", err)
+    }
+
+    _, err := f.FetchReading(ctx, Product{URL: "https://www.walmart.com/ip/999999999"})
+    var status statusError
+    if !errors.As(err, &status) || status.code != http.StatusNotFound || isRetryable(err) {
+        t.Errorf("missing item = %v, want a permanent 404", err)
+    }
+
+    // a request signed with another key is refused
+    other, _ := newWalmartFetcher(t)
+    other.baseURL = api.URL
+    if _, err := other.FetchReading(ctx, Product{URL: tests[0].url}); !errors.As(err, &status) || status.code != http.StatusUnauthorized {
+        t.Errorf("wrongly signed request = %v, want a 401", err)
+    }
+
+    if _, err := f.FetchReading(ctx, Product{URL: "https://www.walmart.com/browse/kitchen/123456789"}); !errors.Is(err, errNotWalmartItem) {
+        t.Errorf("browse page = %v", err)
+    }
+}
+
+func TestNewWalmartFetcherBadKey(t *testing.T) {
+    keyFile := filepath.Join(t.TempDir(), "walmart.pem")
+    if err := os.WriteFile(keyFile, []byte("not a key"), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := NewWalmartFetcher("consumer-1", "2", keyFile); err == nil || !strings.Contains(err.Error(), "not PEM encoded") {
+        t.Errorf("NewWalmartFetcher = %v", err)
+    }
+    if _, err := NewWalmartFetcher("consumer-1", "2", filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+        t.Error("a missing key file was accepted")
+    }
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+    // maxWatchdogEvents caps how many watchdog events are kept for the
+    // status report
+    maxWatchdogEvents = 20
+    // defaultWatchdogThreshold is how long tracking may stall before it is
+    // restarted when no threshold is configured
+    defaultWatchdogThreshold = 3 * time.Minute
+)
+
+// SetWatchdogThreshold sets how long the tracker may go without completing a
+// cycle before the tracking loop is restarted. Zero means three intervals.
+func (pt *PriceTracker) SetWatchdogThreshold(threshold time.Duration) {
+    pt.statusMu.Lock()
+    defer pt.statusMu.Unlock()
+
+    pt.watchdogThreshold = threshold
+}
+
+// watchdogThresholdFor returns the watchdog threshold for a tracking loop
+// running every interval
+func (pt *PriceTracker) watchdogThresholdFor(interval time.Duration) time.Duration {
+    pt.statusMu.Lock()
+    defer pt.statusMu.Unlock()
+
+    if pt.watchdogThreshold <= 0 {
+        return 3 * interval
+    }
+    return pt.watchdogThreshold
+}
+
+// TrackingStatus reports when the last cycle completed and what the watchdog
+// has had to do
+func (pt *PriceTracker) TrackingStatus() TrackingStatus {
+    pt.statusMu.Lock()
+    defer pt.statusMu.Unlock()
+
+    events := make([]WatchdogEvent, len(pt.watchdogEvents))
+    copy(events, pt.watchdogEvents)
+
+    return TrackingStatus{
+        LastCycle: pt.lastCycle,
+        Restarts:  pt.restarts,
+        Events:    events,
+    }
+}
+
+// Ping checks that the database is usable
+func (pt *PriceTracker) Ping(ctx context.Context) error {
+    return pt.db.Ping(ctx)
+}
+
+// ProductCount returns how many products are tracked
+func (pt *PriceTracker) ProductCount() int {
+    pt.mu.RLock()
+    defer pt.mu.RUnlock()
+
+    return len(pt.products)
+}
+
+// LastPriceTime returns when the most recent price was stored, or nil if none
+// has been
+func (pt *PriceTracker) LastPriceTime() (*time.Time, error) {
+    return pt.db.LatestEntryTime()
+}
+
+func (pt *PriceTracker) markCycle() {
+    pt.statusMu.Lock()
+    defer pt.statusMu.Unlock()
+
+    pt.lastCycle = time.Now()
+}
+
+func (pt *PriceTracker) lastCycleTime() time.Time {
+    pt.statusMu.Lock()
+    defer pt.statusMu.Unlock()
+
+    return pt.lastCycle
+}
+
+func (pt *PriceTracker) recordRestart(stalled time.Duration) {
+    pt.statusMu.Lock()
+    pt.restarts++
+    pt.statusMu.Unlock()
+
+    pt.recordWatchdogEvent(fmt.Sprintf("tracking loop restarted after %v without a completed cycle", stalled.Round(time.Second)))
+}
+
+func (pt *PriceTracker) recordWatchdogEvent(message string) {
+    pt.statusMu.Lock()
+    defer pt.statusMu.Unlock()
+
+    pt.watchdogEvents = append(pt.watchdogEvents, WatchdogEvent{Time: time.Now(), Message: message})
+    if len(pt.watchdogEvents) > maxWatchdogEvents {
+        pt.watchdogEvents = pt.watchdogEvents[len(pt.watchdogEvents)-maxWatchdogEvents:]
+    }
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWatchdogRestartsStalledLoop(t *testing.T) {
+    // the first fetch hangs until its loop is abandoned; later ones answer
+    var fetches atomic.Int32
+    fetcher := PriceFetcherFunc(func(ctx context.Context, product Product) (float64, error) {
+        if fetches.Add(1) == 1 {
+            <-ctx.Done()
+            return 0, ctx.Err()
+        }
+        return 9.99, nil
+    })
+    tracker := newTestTracker(t, fetcher)
+    tracker.SetFetchTimeout(time.Minute)
+    tracker.SetWatchdogThreshold(100 * time.Millisecond)
+    addTestProduct(t, tracker, "watchdog-stall")
+
+    ctx, cancel := context.WithCancel(context.Background())
+    stopped := make(chan struct{})
+    go func() {
+        tracker.StartTracking(ctx, 20*time.Millisecond)
+        close(stopped)
+    }()
+
+    // wait for the restarted loop to store a price
+    stored := func() bool {
+        history, err := tracker.GetPriceHistory("watchdog-stall", 1, false)
+        return err == nil && len(history) == 1
+    }
+    deadline := time.Now().Add(5 * time.Second)
+    for !stored() && time.Now().Before(deadline) {
+        time.Sleep(10 * time.Millisecond)
+    }
+    cancel()
+    <-stopped
+
+    status := tracker.TrackingStatus()
+    if status.Restarts < 1 {
+        t.Fatalf("restarts = %d, want the stalled loop restarted", status.Restarts)
+    }
+    if len(status.Events) == 0 || !strings.Contains(status.Events[0].Message, "restarted") {
+        t.Errorf("events = %+v, want a restart", status.Events)
+    }
+    history, err := tracker.GetPriceHistory("watchdog-stall", 1, false)
+    if err != nil || len(history) != 1 || history[0].Price != 9.99 {
+        t.Errorf("history = %+v, %v; want the restarted loop's price", history, err)
+    }
+}
+
+func TestWatchdogThresholdDefault(t *testing.T) {
+    tracker := newTestTracker(t, nil)
+    if got := tracker.watchdogThresholdFor(time.Minute); got != 3*time.Minute {
+        t.Errorf("default threshold = %v, want three intervals", got)
+    }
+    tracker.SetWatchdogThreshold(time.Second)
+    if got := tracker.watchdogThresholdFor(time.Minute); got != time.Second {
+        t.Errorf("threshold = %v, want 1s", got)
+    }
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+)
+
+// widgetHistoryPoints is how many recent prices the widget chart shows
+const widgetHistoryPoints = 50
+
+var widgetTemplate = template.Must(template.New("widget").Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <title>{{.Name}} price</title>
+    <style>
+        body { margin: 0; padding: 12px; font-family: Arial, sans-serif; background: {{.Background}}; color: {{.Foreground}}; }
+        .name { font-size: 14px; margin-bottom: 4px; }
+        .price { font-size: 28px; font-weight: bold; }
+        .updated { font-size: 11px; opacity: 0.7; margin-top: 4px; }
+        svg { display: block; margin-top: 8px; }
+        a { color: inherit; }
+    </style>
+</head>
+<body>
+    <div class="name"><a href="{{.URL}}" target="_blank" rel="noopener">{{.Name}}</a></div>
+    {{if .HasPrice}}
+    <div class="price">{{.Price}}</div>
+    <svg width="{{.Width}}" height="{{.Height}}" viewBox="0 0 {{.Width}} {{.Height}}">
+        <polyline fill="none" stroke="{{.Accent}}" stroke-width="2" points="{{.Points}}"/>
+    </svg>
+    <div class="updated">Updated {{.Updated}}</div>
+    {{else}}
+    <div class="price">No price yet</div>
+    {{end}}
+</body>
+</html>`))
+
+type widgetData struct {
+    Name       string
+    URL        string
+    HasPrice   bool
+    Price      string
+    Updated    string
+    Points     string
+    Width      int
+    Height     int
+    Background string
+    Foreground string
+    Accent     string
+}
+
+// RenderWidget writes a small self-contained HTML widget showing the
+// product's current price and a sparkline of its recent history
+func (pt *PriceTracker) RenderWidget(productID, theme string, w io.Writer) error {
+    product, err := pt.GetProduct(productID)
+    if err != nil {
+        return err
+    }
+
+    history, err := pt.db.GetPriceHistory(productID, widgetHistoryPoints, false)
+    if err != nil {
+        return err
+    }
+
+    data := widgetData{
+        Name:       product.Name,
+        URL:        product.URL,
+        Width:      200,
+        Height:     40,
+        Background: "#ffffff",
+        Foreground: "#222222",
+        Accent:     "#2a7ae2",
+    }
+    if theme == "dark" {
+        data.Background = "#1e1e1e"
+        data.Foreground = "#eeeeee"
+        data.Accent = "#6cb4ff"
+    }
+
+    if len(history) > 0 {
+        data.HasPrice = true
+        data.Price = formatPrice(history[0].Price, history[0].Currency)
+        data.Updated = history[0].Timestamp.Format(time.RFC1123)
+        data.Points = sparklinePoints(history, data.Width, data.Height)
+    }
+
+    return widgetTemplate.Execute(w, data)
+}
+
+// sparklinePoints scales a newest-first history into SVG polyline points,
+// oldest on the left
+func sparklinePoints(history []PriceEntry, width, height int) string {
+    low, high := history[0].Price, history[0].Price
+    for _, entry := range history {
+        if entry.Price < low {
+            low = entry.Price
+        }
+        if entry.Price > high {
+            high = entry.Price
+        }
+    }
+
+    spread := high - low
+    if spread == 0 {
+        spread = 1
+    }
+
+    points := make([]string, 0, len(history))
+    for i := len(history) - 1; i >= 0; i-- {
+        x := 0.0
+        if len(history) > 1 {
+            x = float64(len(history)-1-i) / float64(len(history)-1) * float64(width)
+        }
+        // leave a pixel of padding so the line isn't clipped
+        y := 1 + (high-history[i].Price)/spread*float64(height-2)
+        points = append(points, fmt.Sprintf("%.1f,%.1f", x, y))
+    }
+
+    return strings.Join(points, " ")
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestWidgetRendersProduct(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    product := addTestProduct(t, server.tracker, "widget-lamp", 49.99, 44.5, 39.99)
+    product.Name = `Desk Lamp <Deluxe>`
+    if _, err := server.tracker.UpdateProduct(product); err != nil {
+        t.Fatal(err)
+    }
+
+    rec := serve(t, server, "GET", "/api/v1/products/widget-lamp/widget.html", "", nil)
+    if rec.Code != http.StatusOK {
+        t.Fatalf("widget = %d: %s", rec.Code, rec.Body)
+    }
+    if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/html") {
+        t.Errorf("content type = %q, want HTML", got)
+    }
+    if got := rec.Header().Get("Content-Security-Policy"); got != "frame-ancestors *" {
+        t.Errorf("CSP = %q, want any site allowed to frame the widget", got)
+    }
+
+    page := rec.Body.String()
+    for _, want := range []string{
+        "$39.99",                   // the current price
+        "Desk Lamp &lt;Deluxe&gt;", // the name, escaped
+        "https://shop.test/widget-lamp",
+        "Updated ",
+        "<polyline",
+        "background: #ffffff",
+    } {
+        if !strings.Contains(page, want) {
+            t.Errorf("widget is missing %q:\n%s", want, page)
+        }
+    }
+    if strings.Contains(page, "<Deluxe>") {
+        t.Error("the product name wasn't escaped")
+    }
+}
+
+func TestWidgetThemes(t *testing.T) {
+    server := NewAPIServer(newTestTracker(t, nil))
+    addTestProduct(t, server.tracker, "widget-dark", 10)
+    addTestProduct(t, server.tracker, "widget-empty")
+
+    rec := serve(t, server, "GET", "/api/v1/products/widget-dark/widget.html?theme=dark", "", nil)
+    if page := rec.Body.String(); !strings.Contains(page, "background: #1e1e1e") {
+        t.Errorf("dark widget:\n%s", page)
+    }
+
+    rec = serve(t, server, "GET", "/api/v1/products/widget-empty/widget.html", "", nil)
+    if page := rec.Body.String(); rec.Code != http.StatusOK || !strings.Contains(page, "No price yet") || strings.Contains(page, "<polyline") {
+        t.Errorf("widget without prices = %d:\n%s", rec.Code, page)
+    }
+
+    if rec := serve(t, server, "GET", "/api/v1/products/no-such-product/widget.html", "", nil); rec.Code != http.StatusNotFound {
+        t.Errorf("unknown product = %d, want 404", rec.Code)
+    }
+}
+
+func TestSparklinePoints(t *testing.T) {
+    // newest first: the line runs from 10 on the left up to 20 on the right
+    history := []PriceEntry{{Price: 20}, {Price: 15}, {Price: 10}}
+    if got, want := sparklinePoints(history, 100, 42), "0.0,41.0 50.0,21.0 100.0,1.0"; got != want {
+        t.Errorf("points = %q, want %q", got, want)
+    }
+    if got, want := sparklinePoints([]PriceEntry{{Price: 5}}, 100, 42), "0.0,1.0"; got != want {
+        t.Errorf("single point = %q, want %q", got, want)
+    }
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// cycleReport counts a cycle's successful and failed checks
+type cycleReport struct {
+    ok, failed int32
+}
+
+func (c *cycleReport) report(ok bool) {
+    if ok {
+        atomic.AddInt32(&c.ok, 1)
+    } else {
+        atomic.AddInt32(&c.failed, 1)
+    }
+}
+
+func TestFetchTimeoutAbandonsFetch(t *testing.T) {
+    // the slow product's fetch only ends when it is abandoned
+    fetcher := PriceFetcherFunc(func(ctx context.Context, product Product) (float64, error) {
+        if product.ID == "timeout-slow" {
+            <-ctx.Done()
+            return 0, ctx.Err()
+        }
+        return 20, nil
+    })
+    tracker := newTestTracker(t, fetcher)
+    tracker.SetRetryPolicy(1, 0)
+    tracker.SetFetchTimeout(50 * time.Millisecond)
+    addTestProduct(t, tracker, "timeout-slow")
+    addTestProduct(t, tracker, "timeout-fast")
+
+    var logs bytes.Buffer
+    log.SetOutput(&logs)
+    t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+    var counts cycleReport
+    start := time.Now()
+    tracker.trackProducts(context.Background(), tracker.snapshotProducts(), counts.report)
+    if elapsed := time.Since(start); elapsed > 2*time.Second {
+        t.Errorf("the cycle took %v, want the slow fetch cut off", elapsed)
+    }
+
+    if counts.ok != 1 || counts.failed != 1 {
+        t.Errorf("reported %d ok and %d failed, want 1 of each", counts.ok, counts.failed)
+    }
+    if history, _ := tracker.GetPriceHistory("timeout-slow", 10, true); len(history) != 0 {
+        t.Errorf("the abandoned fetch stored %v", history)
+    }
+    if history, _ := tracker.GetPriceHistory("timeout-fast", 10, true); len(history) != 1 {
+        t.Errorf("the fast product has %d entries, want 1", len(history))
+    }
+    if !strings.Contains(logs.String(), "Abandoned price fetch for timeout-slow") {
+        t.Errorf("the timeout wasn't logged: %s", logs.String())
+    }
+    if errs, _ := tracker.GetScrapeErrors("timeout-slow", 10); len(errs) != 1 || !strings.Contains(errs[0].Error, "deadline exceeded") {
+        t.Errorf("scrape errors = %+v, want the timeout recorded", errs)
+    }
+}
+
+func TestCycleCancelStopsDispatch(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+
+    // the first fetch cancels the cycle
+    var mu sync.Mutex
+    var fetched []string
+    fetcher := PriceFetcherFunc(func(fetchCtx context.Context, product Product) (float64, error) {
+        mu.Lock()
+        fetched = append(fetched, product.ID)
+        mu.Unlock()
+        cancel()
+        <-fetchCtx.Done()
+        return 0, fetchCtx.Err()
+    })
+    tracker := newTestTracker(t, fetcher)
+    tracker.SetRetryPolicy(1, 0)
+    tracker.SetNumWorkers(1)
+    for _, id := range []string{"cancel-a", "cancel-b", "cancel-c", "cancel-d"} {
+        addTestProduct(t, tracker, id)
+    }
+
+    var counts cycleReport
+    tracker.trackProducts(ctx, tracker.snapshotProducts(), counts.report)
+
+    if len(fetched) != 1 {
+        t.Errorf("fetched %v after the cycle was cancelled, want only the first", fetched)
+    }
+    if counts.ok != 0 || counts.failed != 4 {
+        t.Errorf("reported %d ok and %d failed, want all 4 failed", counts.ok, counts.failed)
+    }
+    // a cancelled fetch says nothing about the product
+    if errs, _ := tracker.GetScrapeErrors("cancel-a", 10); len(errs) != 0 {
+        t.Errorf("scrape errors = %+v, want none for a cancelled cycle", errs)
+    }
+}
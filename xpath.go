@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xpath"
+)
+
+// xpathExpr is a compiled XPath 1.0 expression such as
+// "//div[@id='price']/span[1]", "//meta[@itemprop='price']/@content" or
+// "normalize-space(//span[@class='price'])". Every axis and function of
+// XPath 1.0 is supported.
+type xpathExpr struct {
+    expr *xpath.Expr
+}
+
+func parseXPath(input string) (*xpathExpr, error) {
+    if strings.TrimSpace(input) == "" {
+        return nil, errors.New("empty XPath")
+    }
+    expr, err := xpath.Compile(input)
+    if err != nil {
+        return nil, err
+    }
+    return &xpathExpr{expr: expr}, nil
+}
+
+// first returns the string value of what the expression selects on a page:
+// the text of the first node, or its value for an attribute, or the result
+// of an expression that computes a string or number. Empty strings, NaN and
+// true/false results count as no match.
+func (x *xpathExpr) first(root *goquery.Document) (string, bool) {
+    nav := htmlquery.CreateXPathNavigator(root.Nodes[0])
+    switch result := x.expr.Evaluate(nav).(type) {
+    case *xpath.NodeIterator:
+        if !result.MoveNext() {
+            return "", false
+        }
+        return result.Current().Value(), true
+    case string:
+        return result, result != ""
+    case float64:
+        if math.IsNaN(result) {
+            return "", false
+        }
+        return strconv.FormatFloat(result, 'f', -1, 64), true
+    }
+    return "", false
+}
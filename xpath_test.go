@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestXPathFirst(t *testing.T) {
+    page := `<html><body>
+<div id="buy-box"><span class="label">Price</span><span>$19.99</span></div>
+<meta itemprop="price" content="17.50">
+<dl><dt>Sale</dt><dd>  $15.00  </dd></dl>
+<p class="note">Total: 21.00</p>
+</body></html>`
+    root := parseHTML(page)
+
+    tests := []struct {
+        expr string
+        want string
+    }{
+        {"//div[@id='buy-box']/span[2]", "$19.99"},
+        {"//meta[@itemprop='price']/@content", "17.50"},
+        {"//span[@class='label']/following-sibling::span", "$19.99"},
+        {"//dt[.='Sale']/following-sibling::dd[1]/text()", "  $15.00  "},
+        {"normalize-space(//dd)", "$15.00"},
+        {"substring-after(//p[contains(@class, 'note')], ': ')", "21.00"},
+        {"count(//span)", "2"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.expr, func(t *testing.T) {
+            expr, err := parseXPath(tt.expr)
+            if err != nil {
+                t.Fatalf("parseXPath: %v", err)
+            }
+            got, ok := expr.first(root)
+            if !ok || got != tt.want {
+                t.Errorf("first = %q, %v; want %q", got, ok, tt.want)
+            }
+        })
+    }
+}
+
+func TestXPathNoMatch(t *testing.T) {
+    root := parseHTML(`<p>no price</p>`)
+    for _, input := range []string{"//span[@class='price']", "string(//span)", "boolean(//p)"} {
+        expr, err := parseXPath(input)
+        if err != nil {
+            t.Fatalf("parseXPath(%q): %v", input, err)
+        }
+        if got, ok := expr.first(root); ok {
+            t.Errorf("%q matched %q", input, got)
+        }
+    }
+}
+
+func TestParseXPathRejectsInvalid(t *testing.T) {
+    for _, input := range []string{"", "  ", "//div[", "//span/@", "foo("} {
+        if _, err := parseXPath(input); err == nil {
+            t.Errorf("%q was accepted", input)
+        }
+    }
+}